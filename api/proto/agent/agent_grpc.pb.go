@@ -0,0 +1,559 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.3.0
+// - protoc             (unknown)
+// source: agent.proto
+
+package agent
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+const (
+	AgentService_Register_FullMethodName         = "/agent.AgentService/Register"
+	AgentService_Heartbeat_FullMethodName        = "/agent.AgentService/Heartbeat"
+	AgentService_ExecuteTask_FullMethodName      = "/agent.AgentService/ExecuteTask"
+	AgentService_GetTaskStatus_FullMethodName    = "/agent.AgentService/GetTaskStatus"
+	AgentService_CancelTask_FullMethodName       = "/agent.AgentService/CancelTask"
+	AgentService_RenewCertificate_FullMethodName = "/agent.AgentService/RenewCertificate"
+	AgentService_StreamOutput_FullMethodName     = "/agent.AgentService/StreamOutput"
+	AgentService_Queries_FullMethodName          = "/agent.AgentService/Queries"
+	AgentService_ReceiveTasks_FullMethodName     = "/agent.AgentService/ReceiveTasks"
+)
+
+// AgentServiceClient is the client API for AgentService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type AgentServiceClient interface {
+	// Register registers an agent with the server
+	Register(ctx context.Context, in *RegisterRequest, opts ...grpc.CallOption) (*RegisterResponse, error)
+	// Heartbeat sends a heartbeat to the server
+	Heartbeat(ctx context.Context, in *HeartbeatRequest, opts ...grpc.CallOption) (*HeartbeatResponse, error)
+	// ExecuteTask executes a task on the agent
+	ExecuteTask(ctx context.Context, in *ExecuteTaskRequest, opts ...grpc.CallOption) (AgentService_ExecuteTaskClient, error)
+	// GetTaskStatus gets the status of a task
+	GetTaskStatus(ctx context.Context, in *TaskStatusRequest, opts ...grpc.CallOption) (*TaskStatusResponse, error)
+	// CancelTask cancels a running task
+	CancelTask(ctx context.Context, in *CancelTaskRequest, opts ...grpc.CallOption) (*CancelTaskResponse, error)
+	// RenewCertificate issues a new client certificate to an agent before its current one expires
+	RenewCertificate(ctx context.Context, in *RenewCertificateRequest, opts ...grpc.CallOption) (*RenewCertificateResponse, error)
+	// StreamOutput delivers the task output an agent has spooled. The server acknowledges each
+	// response once it is stored and asks for the status of tasks it believes are running, so that
+	// tasks that finished while the agent was disconnected are reconciled.
+	StreamOutput(ctx context.Context, opts ...grpc.CallOption) (AgentService_StreamOutputClient, error)
+	// Queries lets the server ask an agent about its cluster, e.g. for the choices of a template
+	// parameter. The agent keeps the stream open; the server sends queries and the agent answers
+	// each one, by ID, without running a task.
+	Queries(ctx context.Context, opts ...grpc.CallOption) (AgentService_QueriesClient, error)
+	// ReceiveTasks delivers the tasks the server dispatches to an agent, signed and with their
+	// secret params opened. The agent keeps the stream open; each task's output comes back on
+	// StreamOutput.
+	ReceiveTasks(ctx context.Context, opts ...grpc.CallOption) (AgentService_ReceiveTasksClient, error)
+}
+
+type agentServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewAgentServiceClient(cc grpc.ClientConnInterface) AgentServiceClient {
+	return &agentServiceClient{cc}
+}
+
+func (c *agentServiceClient) Register(ctx context.Context, in *RegisterRequest, opts ...grpc.CallOption) (*RegisterResponse, error) {
+	out := new(RegisterResponse)
+	err := c.cc.Invoke(ctx, AgentService_Register_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *agentServiceClient) Heartbeat(ctx context.Context, in *HeartbeatRequest, opts ...grpc.CallOption) (*HeartbeatResponse, error) {
+	out := new(HeartbeatResponse)
+	err := c.cc.Invoke(ctx, AgentService_Heartbeat_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *agentServiceClient) ExecuteTask(ctx context.Context, in *ExecuteTaskRequest, opts ...grpc.CallOption) (AgentService_ExecuteTaskClient, error) {
+	stream, err := c.cc.NewStream(ctx, &AgentService_ServiceDesc.Streams[0], AgentService_ExecuteTask_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &agentServiceExecuteTaskClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type AgentService_ExecuteTaskClient interface {
+	Recv() (*ExecuteTaskResponse, error)
+	grpc.ClientStream
+}
+
+type agentServiceExecuteTaskClient struct {
+	grpc.ClientStream
+}
+
+func (x *agentServiceExecuteTaskClient) Recv() (*ExecuteTaskResponse, error) {
+	m := new(ExecuteTaskResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *agentServiceClient) GetTaskStatus(ctx context.Context, in *TaskStatusRequest, opts ...grpc.CallOption) (*TaskStatusResponse, error) {
+	out := new(TaskStatusResponse)
+	err := c.cc.Invoke(ctx, AgentService_GetTaskStatus_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *agentServiceClient) CancelTask(ctx context.Context, in *CancelTaskRequest, opts ...grpc.CallOption) (*CancelTaskResponse, error) {
+	out := new(CancelTaskResponse)
+	err := c.cc.Invoke(ctx, AgentService_CancelTask_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *agentServiceClient) RenewCertificate(ctx context.Context, in *RenewCertificateRequest, opts ...grpc.CallOption) (*RenewCertificateResponse, error) {
+	out := new(RenewCertificateResponse)
+	err := c.cc.Invoke(ctx, AgentService_RenewCertificate_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *agentServiceClient) StreamOutput(ctx context.Context, opts ...grpc.CallOption) (AgentService_StreamOutputClient, error) {
+	stream, err := c.cc.NewStream(ctx, &AgentService_ServiceDesc.Streams[1], AgentService_StreamOutput_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &agentServiceStreamOutputClient{stream}
+	return x, nil
+}
+
+type AgentService_StreamOutputClient interface {
+	Send(*OutputMessage) error
+	Recv() (*OutputControl, error)
+	grpc.ClientStream
+}
+
+type agentServiceStreamOutputClient struct {
+	grpc.ClientStream
+}
+
+func (x *agentServiceStreamOutputClient) Send(m *OutputMessage) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *agentServiceStreamOutputClient) Recv() (*OutputControl, error) {
+	m := new(OutputControl)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *agentServiceClient) Queries(ctx context.Context, opts ...grpc.CallOption) (AgentService_QueriesClient, error) {
+	stream, err := c.cc.NewStream(ctx, &AgentService_ServiceDesc.Streams[2], AgentService_Queries_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &agentServiceQueriesClient{stream}
+	return x, nil
+}
+
+type AgentService_QueriesClient interface {
+	Send(*QueryResult) error
+	Recv() (*Query, error)
+	grpc.ClientStream
+}
+
+type agentServiceQueriesClient struct {
+	grpc.ClientStream
+}
+
+func (x *agentServiceQueriesClient) Send(m *QueryResult) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *agentServiceQueriesClient) Recv() (*Query, error) {
+	m := new(Query)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *agentServiceClient) ReceiveTasks(ctx context.Context, opts ...grpc.CallOption) (AgentService_ReceiveTasksClient, error) {
+	stream, err := c.cc.NewStream(ctx, &AgentService_ServiceDesc.Streams[3], AgentService_ReceiveTasks_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &agentServiceReceiveTasksClient{stream}
+	return x, nil
+}
+
+type AgentService_ReceiveTasksClient interface {
+	Send(*TaskStreamMessage) error
+	Recv() (*ExecuteTaskRequest, error)
+	grpc.ClientStream
+}
+
+type agentServiceReceiveTasksClient struct {
+	grpc.ClientStream
+}
+
+func (x *agentServiceReceiveTasksClient) Send(m *TaskStreamMessage) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *agentServiceReceiveTasksClient) Recv() (*ExecuteTaskRequest, error) {
+	m := new(ExecuteTaskRequest)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// AgentServiceServer is the server API for AgentService service.
+// All implementations must embed UnimplementedAgentServiceServer
+// for forward compatibility
+type AgentServiceServer interface {
+	// Register registers an agent with the server
+	Register(context.Context, *RegisterRequest) (*RegisterResponse, error)
+	// Heartbeat sends a heartbeat to the server
+	Heartbeat(context.Context, *HeartbeatRequest) (*HeartbeatResponse, error)
+	// ExecuteTask executes a task on the agent
+	ExecuteTask(*ExecuteTaskRequest, AgentService_ExecuteTaskServer) error
+	// GetTaskStatus gets the status of a task
+	GetTaskStatus(context.Context, *TaskStatusRequest) (*TaskStatusResponse, error)
+	// CancelTask cancels a running task
+	CancelTask(context.Context, *CancelTaskRequest) (*CancelTaskResponse, error)
+	// RenewCertificate issues a new client certificate to an agent before its current one expires
+	RenewCertificate(context.Context, *RenewCertificateRequest) (*RenewCertificateResponse, error)
+	// StreamOutput delivers the task output an agent has spooled. The server acknowledges each
+	// response once it is stored and asks for the status of tasks it believes are running, so that
+	// tasks that finished while the agent was disconnected are reconciled.
+	StreamOutput(AgentService_StreamOutputServer) error
+	// Queries lets the server ask an agent about its cluster, e.g. for the choices of a template
+	// parameter. The agent keeps the stream open; the server sends queries and the agent answers
+	// each one, by ID, without running a task.
+	Queries(AgentService_QueriesServer) error
+	// ReceiveTasks delivers the tasks the server dispatches to an agent, signed and with their
+	// secret params opened. The agent keeps the stream open; each task's output comes back on
+	// StreamOutput.
+	ReceiveTasks(AgentService_ReceiveTasksServer) error
+	mustEmbedUnimplementedAgentServiceServer()
+}
+
+// UnimplementedAgentServiceServer must be embedded to have forward compatible implementations.
+type UnimplementedAgentServiceServer struct {
+}
+
+func (UnimplementedAgentServiceServer) Register(context.Context, *RegisterRequest) (*RegisterResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Register not implemented")
+}
+func (UnimplementedAgentServiceServer) Heartbeat(context.Context, *HeartbeatRequest) (*HeartbeatResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Heartbeat not implemented")
+}
+func (UnimplementedAgentServiceServer) ExecuteTask(*ExecuteTaskRequest, AgentService_ExecuteTaskServer) error {
+	return status.Errorf(codes.Unimplemented, "method ExecuteTask not implemented")
+}
+func (UnimplementedAgentServiceServer) GetTaskStatus(context.Context, *TaskStatusRequest) (*TaskStatusResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetTaskStatus not implemented")
+}
+func (UnimplementedAgentServiceServer) CancelTask(context.Context, *CancelTaskRequest) (*CancelTaskResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CancelTask not implemented")
+}
+func (UnimplementedAgentServiceServer) RenewCertificate(context.Context, *RenewCertificateRequest) (*RenewCertificateResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RenewCertificate not implemented")
+}
+func (UnimplementedAgentServiceServer) StreamOutput(AgentService_StreamOutputServer) error {
+	return status.Errorf(codes.Unimplemented, "method StreamOutput not implemented")
+}
+func (UnimplementedAgentServiceServer) Queries(AgentService_QueriesServer) error {
+	return status.Errorf(codes.Unimplemented, "method Queries not implemented")
+}
+func (UnimplementedAgentServiceServer) ReceiveTasks(AgentService_ReceiveTasksServer) error {
+	return status.Errorf(codes.Unimplemented, "method ReceiveTasks not implemented")
+}
+func (UnimplementedAgentServiceServer) mustEmbedUnimplementedAgentServiceServer() {}
+
+// UnsafeAgentServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to AgentServiceServer will
+// result in compilation errors.
+type UnsafeAgentServiceServer interface {
+	mustEmbedUnimplementedAgentServiceServer()
+}
+
+func RegisterAgentServiceServer(s grpc.ServiceRegistrar, srv AgentServiceServer) {
+	s.RegisterService(&AgentService_ServiceDesc, srv)
+}
+
+func _AgentService_Register_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RegisterRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AgentServiceServer).Register(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: AgentService_Register_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AgentServiceServer).Register(ctx, req.(*RegisterRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _AgentService_Heartbeat_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(HeartbeatRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AgentServiceServer).Heartbeat(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: AgentService_Heartbeat_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AgentServiceServer).Heartbeat(ctx, req.(*HeartbeatRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _AgentService_ExecuteTask_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(ExecuteTaskRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(AgentServiceServer).ExecuteTask(m, &agentServiceExecuteTaskServer{stream})
+}
+
+type AgentService_ExecuteTaskServer interface {
+	Send(*ExecuteTaskResponse) error
+	grpc.ServerStream
+}
+
+type agentServiceExecuteTaskServer struct {
+	grpc.ServerStream
+}
+
+func (x *agentServiceExecuteTaskServer) Send(m *ExecuteTaskResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _AgentService_GetTaskStatus_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(TaskStatusRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AgentServiceServer).GetTaskStatus(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: AgentService_GetTaskStatus_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AgentServiceServer).GetTaskStatus(ctx, req.(*TaskStatusRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _AgentService_CancelTask_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CancelTaskRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AgentServiceServer).CancelTask(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: AgentService_CancelTask_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AgentServiceServer).CancelTask(ctx, req.(*CancelTaskRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _AgentService_RenewCertificate_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RenewCertificateRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AgentServiceServer).RenewCertificate(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: AgentService_RenewCertificate_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AgentServiceServer).RenewCertificate(ctx, req.(*RenewCertificateRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _AgentService_StreamOutput_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(AgentServiceServer).StreamOutput(&agentServiceStreamOutputServer{stream})
+}
+
+type AgentService_StreamOutputServer interface {
+	Send(*OutputControl) error
+	Recv() (*OutputMessage, error)
+	grpc.ServerStream
+}
+
+type agentServiceStreamOutputServer struct {
+	grpc.ServerStream
+}
+
+func (x *agentServiceStreamOutputServer) Send(m *OutputControl) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *agentServiceStreamOutputServer) Recv() (*OutputMessage, error) {
+	m := new(OutputMessage)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func _AgentService_Queries_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(AgentServiceServer).Queries(&agentServiceQueriesServer{stream})
+}
+
+type AgentService_QueriesServer interface {
+	Send(*Query) error
+	Recv() (*QueryResult, error)
+	grpc.ServerStream
+}
+
+type agentServiceQueriesServer struct {
+	grpc.ServerStream
+}
+
+func (x *agentServiceQueriesServer) Send(m *Query) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *agentServiceQueriesServer) Recv() (*QueryResult, error) {
+	m := new(QueryResult)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func _AgentService_ReceiveTasks_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(AgentServiceServer).ReceiveTasks(&agentServiceReceiveTasksServer{stream})
+}
+
+type AgentService_ReceiveTasksServer interface {
+	Send(*ExecuteTaskRequest) error
+	Recv() (*TaskStreamMessage, error)
+	grpc.ServerStream
+}
+
+type agentServiceReceiveTasksServer struct {
+	grpc.ServerStream
+}
+
+func (x *agentServiceReceiveTasksServer) Send(m *ExecuteTaskRequest) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *agentServiceReceiveTasksServer) Recv() (*TaskStreamMessage, error) {
+	m := new(TaskStreamMessage)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// AgentService_ServiceDesc is the grpc.ServiceDesc for AgentService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var AgentService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "agent.AgentService",
+	HandlerType: (*AgentServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Register",
+			Handler:    _AgentService_Register_Handler,
+		},
+		{
+			MethodName: "Heartbeat",
+			Handler:    _AgentService_Heartbeat_Handler,
+		},
+		{
+			MethodName: "GetTaskStatus",
+			Handler:    _AgentService_GetTaskStatus_Handler,
+		},
+		{
+			MethodName: "CancelTask",
+			Handler:    _AgentService_CancelTask_Handler,
+		},
+		{
+			MethodName: "RenewCertificate",
+			Handler:    _AgentService_RenewCertificate_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "ExecuteTask",
+			Handler:       _AgentService_ExecuteTask_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "StreamOutput",
+			Handler:       _AgentService_StreamOutput_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+		{
+			StreamName:    "Queries",
+			Handler:       _AgentService_Queries_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+		{
+			StreamName:    "ReceiveTasks",
+			Handler:       _AgentService_ReceiveTasks_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+	},
+	Metadata: "agent.proto",
+}
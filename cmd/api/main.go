@@ -4,9 +4,13 @@ import (
 	"log"
 	"os"
 
+	"github.com/BogdanDolia/ops-butler/internal/agentserver"
 	"github.com/BogdanDolia/ops-butler/internal/api"
+	"github.com/BogdanDolia/ops-butler/internal/chatops"
 	"github.com/BogdanDolia/ops-butler/internal/config"
 	"github.com/BogdanDolia/ops-butler/internal/database"
+	"github.com/BogdanDolia/ops-butler/internal/scheduler"
+	"github.com/BogdanDolia/ops-butler/internal/secrets"
 	"github.com/BogdanDolia/ops-butler/pkg/logger"
 	"go.uber.org/zap"
 	"gorm.io/driver/postgres"
@@ -40,8 +44,41 @@ func main() {
 	// Create repository
 	repo := database.NewGormRepository(db)
 
+	// Create scheduler for reminder operations; its polling loops run in the scheduler service
+	sched, err := scheduler.NewScheduler(scheduler.NewConfig(), l, db)
+	if err != nil {
+		l.Fatal("Failed to create scheduler", zap.Error(err))
+		os.Exit(1)
+	}
+
+	// Create ChatOps service for interactive messages
+	chat, err := chatops.NewService(chatops.NewConfig(), l)
+	if err != nil {
+		l.Fatal("Failed to create ChatOps service", zap.Error(err))
+		os.Exit(1)
+	}
+
+	// Load the keys secret parameters are sealed with
+	keyring, err := secrets.LoadKeyring(cfg.Secrets.KEKFile, cfg.Secrets.PreviousKEKFiles)
+	if err != nil {
+		l.Fatal("Failed to load secrets KEK", zap.Error(err))
+		os.Exit(1)
+	}
+
+	// Create and start the gRPC server agents connect to
+	agents, err := agentserver.NewServer(cfg.GRPC, l, db, keyring)
+	if err != nil {
+		l.Fatal("Failed to create agent server", zap.Error(err))
+		os.Exit(1)
+	}
+	if err := agents.Start(); err != nil {
+		l.Fatal("Failed to start agent server", zap.Error(err))
+		os.Exit(1)
+	}
+	defer agents.Stop()
+
 	// Create and start server
-	server := api.NewServer(cfg, l, repo)
+	server := api.NewServer(cfg, l, repo, sched, chat, keyring, agents)
 	if err := server.Run(); err != nil {
 		l.Fatal("Server error", zap.Error(err))
 		os.Exit(1)
@@ -0,0 +1,53 @@
+// Command approve signs a template's script with an approver's private key, which never leaves the
+// approver's machine. The output is the body of PUT /api/v1/templates/:id/approval:
+//
+//	approve -key key.pem -name restart-deployment -script restart.sh |
+//	  curl -X PUT -H 'Content-Type: application/json' --data @- $PORTAL/api/v1/templates/7/approval
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"io"
+	"log"
+	"os"
+	"time"
+
+	"github.com/BogdanDolia/ops-butler/internal/signing"
+)
+
+func main() {
+	keyFile := flag.String("key", "", "approver's Ed25519 private key (PKCS #8 PEM)")
+	name := flag.String("name", "", "template name")
+	scriptFile := flag.String("script", "-", "file holding the template's script, - for stdin")
+	ttl := flag.Duration("ttl", 90*24*time.Hour, "how long the approval stays valid")
+	flag.Parse()
+
+	if *keyFile == "" || *name == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	signer, err := signing.LoadSigner(*keyFile, *ttl)
+	if err != nil {
+		log.Fatalf("Failed to load key: %v", err)
+	}
+
+	var script []byte
+	if *scriptFile == "-" {
+		script, err = io.ReadAll(os.Stdin)
+	} else {
+		script, err = os.ReadFile(*scriptFile)
+	}
+	if err != nil {
+		log.Fatalf("Failed to read script: %v", err)
+	}
+
+	// Review what is being approved; the approval covers exactly these bytes
+	log.Printf("Approving template %q (%d bytes of script) with key %s", *name, len(script), signer.KeyID())
+
+	approval := signer.Approve(*name, "", string(script), time.Now())
+	if err := json.NewEncoder(os.Stdout).Encode(approval); err != nil {
+		log.Fatalf("Failed to write approval: %v", err)
+	}
+}
@@ -2,7 +2,12 @@ package agent
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"fmt"
+	"os"
+	"path/filepath"
 	"sync"
 	"time"
 
@@ -10,56 +15,119 @@ import (
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/credentials/insecure"
-	// This import would be generated from the proto file
-	// pb "github.com/BogdanDolia/ops-butler/api/proto/agent"
+
+	pb "github.com/BogdanDolia/ops-butler/api/proto/agent"
+	"github.com/BogdanDolia/ops-butler/internal/redact"
 )
 
+// version is the agent version reported to the server
+const version = "1.0.0"
+
 // Agent represents a cluster agent
 type Agent struct {
-	config *Config
-	logger *zap.Logger
-	conn   *grpc.ClientConn
-	// client     pb.AgentServiceClient
-	agentID    string
-	tasks      map[string]*Task
-	tasksMutex sync.RWMutex
-	stopCh     chan struct{}
-	wg         sync.WaitGroup
+	config      *Config
+	logger      *zap.Logger
+	conn        *grpc.ClientConn
+	client      pb.AgentServiceClient
+	connMutex   sync.RWMutex // guards conn and client, which are replaced when the certificate is renewed
+	agentID     string
+	policy      *policyLoader
+	keys        *keyLoader
+	replays     *replayGuard
+	redactRules []redact.Rule
+	spool       *spool // task output not yet stored by the server
+	queue       *taskQueue
+	cgroups     *cgroups // nil if tasks run without limits of their own
+	// Capabilities reported to the server, probed again every CapabilitiesInterval
+	capabilities       *pb.Capabilities
+	capabilitiesProbed time.Time
+	capabilitiesMutex  sync.Mutex
+	tasks              map[string]*Task
+	tasksMutex         sync.RWMutex
+	stopCh             chan struct{}
+	wg                 sync.WaitGroup
 }
 
 // Task represents a task being executed by the agent
 type Task struct {
-	ID        string
-	Script    string
-	Params    map[string]string
-	Status    string
-	StartTime time.Time
-	EndTime   time.Time
-	ExitCode  int
-	Error     string
-	Cancel    context.CancelFunc
+	ID            string
+	Script        string
+	Params        map[string]string
+	Status        string // queued, running, completed, failed or cancelled
+	Priority      int32
+	QueuePosition int // 1-based while queued, set by GetTaskStatus
+	StartTime     time.Time
+	EndTime       time.Time
+	ExitCode      int
+	Error         string
+	Cancel        context.CancelFunc
 }
 
 // NewAgent creates a new agent
 func NewAgent(config *Config, logger *zap.Logger) *Agent {
 	return &Agent{
-		config:     config,
-		logger:     logger,
-		tasks:      make(map[string]*Task),
-		tasksMutex: sync.RWMutex{},
-		stopCh:     make(chan struct{}),
+		config:      config,
+		logger:      logger,
+		policy:      &policyLoader{path: config.PolicyFile},
+		keys:        &keyLoader{path: config.ApprovalKeysFile},
+		replays:     newReplayGuard(config.StateDir, config.ReplayWindow, logger),
+		redactRules: redact.DefaultRules(),
+		queue:       newTaskQueue(config.MaxConcurrent, config.MaxQueued),
+		tasks:       make(map[string]*Task),
+		tasksMutex:  sync.RWMutex{},
+		stopCh:      make(chan struct{}),
 	}
 }
 
 // Start starts the agent
 func (a *Agent) Start() error {
 	a.logger.Info("Starting agent", zap.String("name", a.config.Name))
+	if a.config.PolicyFile == "" {
+		a.logger.Warn("No execution policy configured (AGENT_POLICY_FILE); any task the server sends will run")
+	}
+	if a.config.ApprovalKeysFile == "" {
+		if a.config.AllowUnsignedTasks {
+			a.logger.Warn("No approval keys pinned (TASK_APPROVAL_KEYS_FILE) and AGENT_ALLOW_UNSIGNED_TASKS is set; unapproved scripts will run")
+		} else {
+			a.logger.Warn("No approval keys pinned (TASK_APPROVAL_KEYS_FILE); every task will be refused")
+		}
+	} else if _, err := a.keys.current(); err != nil {
+		return fmt.Errorf("failed to load approval keys: %w", err)
+	}
+
+	rules, err := redact.LoadRules(a.config.RedactRulesFile)
+	if err != nil {
+		return err
+	}
+	a.redactRules = rules
+
+	spool, err := openSpool(filepath.Join(a.config.StateDir, "spool"), int64(a.config.SpoolMaxMB)<<20)
+	if err != nil {
+		return err
+	}
+	a.spool = spool
+
+	if a.config.TaskLimits.enabled() {
+		cgroups, err := newCgroups(a.config.TaskLimits)
+		if err != nil {
+			a.logger.Warn("Task resource limits are not enforced; tasks share the agent's limits", zap.Error(err))
+		} else {
+			a.cgroups = cgroups
+		}
+	}
 
 	// Connect to the server
 	if err := a.connect(); err != nil {
 		return fmt.Errorf("failed to connect to server: %w", err)
 	}
 
+	// Obtain a client certificate on first start
+	if a.needsEnrollment() {
+		if err := a.enroll(); err != nil {
+			return fmt.Errorf("failed to enroll with server: %w", err)
+		}
+	}
+
 	// Register with the server
 	if err := a.register(); err != nil {
 		return fmt.Errorf("failed to register with server: %w", err)
@@ -69,6 +137,24 @@ func (a *Agent) Start() error {
 	a.wg.Add(1)
 	go a.heartbeatLoop()
 
+	// Deliver spooled task output, including any left over from before a restart
+	a.wg.Add(1)
+	go a.deliveryLoop()
+
+	// Answer the server's queries about the cluster, e.g. for parameter choices
+	a.wg.Add(1)
+	go a.queryLoop()
+
+	// Receive the tasks the server dispatches to this agent
+	a.wg.Add(1)
+	go a.taskLoop()
+
+	// Renew the client certificate before it expires
+	if a.config.TLSEnabled {
+		a.wg.Add(1)
+		go a.certificateLoop()
+	}
+
 	return nil
 }
 
@@ -82,21 +168,23 @@ func (a *Agent) Stop() error {
 	// Wait for all goroutines to finish
 	a.wg.Wait()
 
-	// Cancel all running tasks
+	// Cancel all running and queued tasks
 	a.tasksMutex.Lock()
 	for _, task := range a.tasks {
-		if task.Status == "running" && task.Cancel != nil {
+		if (task.Status == "running" || task.Status == "queued") && task.Cancel != nil {
 			task.Cancel()
 		}
 	}
 	a.tasksMutex.Unlock()
 
 	// Close the connection
+	a.connMutex.Lock()
 	if a.conn != nil {
 		if err := a.conn.Close(); err != nil {
 			a.logger.Error("Failed to close connection", zap.Error(err))
 		}
 	}
+	a.connMutex.Unlock()
 
 	a.logger.Info("Agent stopped")
 	return nil
@@ -108,7 +196,7 @@ func (a *Agent) connect() error {
 
 	var opts []grpc.DialOption
 	if a.config.TLSEnabled {
-		creds, err := credentials.NewClientTLSFromFile(a.config.TLSCertFile, "")
+		creds, err := a.credentials()
 		if err != nil {
 			return fmt.Errorf("failed to create TLS credentials: %w", err)
 		}
@@ -122,37 +210,81 @@ func (a *Agent) connect() error {
 		return fmt.Errorf("failed to dial server: %w", err)
 	}
 
+	a.connMutex.Lock()
+	old := a.conn
 	a.conn = conn
-	// a.client = pb.NewAgentServiceClient(conn)
+	a.client = pb.NewAgentServiceClient(conn)
+	a.connMutex.Unlock()
+
+	// A connection replaced after renewing the certificate still carries the old one
+	if old != nil {
+		if err := old.Close(); err != nil {
+			a.logger.Warn("Failed to close previous connection", zap.Error(err))
+		}
+	}
 
 	return nil
 }
 
+// agentClient returns the client of the current connection
+func (a *Agent) agentClient() pb.AgentServiceClient {
+	a.connMutex.RLock()
+	defer a.connMutex.RUnlock()
+	return a.client
+}
+
+// credentials returns mutual TLS credentials: the agent presents its client certificate, whose
+// URI SAN identifies it to the server, and verifies the server against the configured CA. An agent
+// that has yet to enroll connects without a certificate.
+func (a *Agent) credentials() (credentials.TransportCredentials, error) {
+	tlsConfig := &tls.Config{
+		ServerName: a.config.TLSServerName,
+		MinVersion: tls.VersionTLS12,
+	}
+	if !a.needsEnrollment() {
+		cert, err := tls.LoadX509KeyPair(a.config.TLSCertFile, a.config.TLSKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	// Without a CA file the system roots are used
+	if a.config.TLSCAFile != "" {
+		pem, err := os.ReadFile(a.config.TLSCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", a.config.TLSCAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	return credentials.NewTLS(tlsConfig), nil
+}
+
 // register registers the agent with the server
 func (a *Agent) register() error {
 	a.logger.Info("Registering with server")
 
-	// TODO: Implement registration with the server
-	// req := &pb.RegisterRequest{
-	//     Name:    a.config.Name,
-	//     Labels:  a.config.Labels,
-	//     Version: "1.0.0",
-	// }
-	//
-	// resp, err := a.client.Register(context.Background(), req)
-	// if err != nil {
-	//     return fmt.Errorf("failed to register: %w", err)
-	// }
-	//
-	// if !resp.Success {
-	//     return fmt.Errorf("registration failed: %s", resp.Error)
-	// }
-	//
-	// a.agentID = resp.AgentId
-	// a.logger.Info("Registered with server", zap.String("agent_id", a.agentID))
-
-	// For now, just use the name as the ID
-	a.agentID = a.config.Name
+	req := &pb.RegisterRequest{
+		Name:         a.config.Name,
+		Labels:       a.config.Labels,
+		Version:      version,
+		Capabilities: a.currentCapabilities(),
+	}
+
+	resp, err := a.agentClient().Register(context.Background(), req)
+	if err != nil {
+		return fmt.Errorf("failed to register: %w", err)
+	}
+
+	if !resp.Success {
+		return fmt.Errorf("registration failed: %s", resp.Error)
+	}
+
+	a.agentID = resp.AgentId
 	a.logger.Info("Registered with server", zap.String("agent_id", a.agentID))
 
 	return nil
@@ -181,89 +313,196 @@ func (a *Agent) heartbeatLoop() {
 func (a *Agent) sendHeartbeat() error {
 	a.logger.Debug("Sending heartbeat")
 
-	// TODO: Implement heartbeat
-	// req := &pb.HeartbeatRequest{
-	//     AgentId: a.agentID,
-	//     Labels:  a.config.Labels,
-	//     Status:  "healthy",
-	// }
-	//
-	// resp, err := a.client.Heartbeat(context.Background(), req)
-	// if err != nil {
-	//     return fmt.Errorf("failed to send heartbeat: %w", err)
-	// }
-	//
-	// if !resp.Success {
-	//     return fmt.Errorf("heartbeat failed: %s", resp.Error)
-	// }
+	running, queued := a.queue.load()
+	req := &pb.HeartbeatRequest{
+		AgentId:      a.agentID,
+		Labels:       a.config.Labels,
+		Status:       "healthy",
+		Capacity:     int32(a.queue.slots),
+		RunningTasks: int32(running),
+		QueuedTasks:  int32(queued),
+		Capabilities: a.currentCapabilities(),
+	}
+
+	resp, err := a.agentClient().Heartbeat(context.Background(), req)
+	if err != nil {
+		return fmt.Errorf("failed to send heartbeat: %w", err)
+	}
+
+	if !resp.Success {
+		return fmt.Errorf("heartbeat failed: %s", resp.Error)
+	}
 
 	return nil
 }
 
-// ExecuteTask executes a task
-func (a *Agent) ExecuteTask(taskID, script string, params map[string]string, timeout int32) error {
-	a.logger.Info("Executing task", zap.String("task_id", taskID))
+// SendFunc passes on a task's output and final status
+type SendFunc func(*pb.ExecuteTaskResponse) error
+
+// ExecuteTask verifies a task's signature, checks it against the local policy and runs it in the
+// background. Its output is spooled and delivered to the server by the delivery loop, so the task
+// keeps running while the agent is disconnected. A task that is refused is reported with a
+// rejection response and a *PolicyError.
+func (a *Agent) ExecuteTask(req *pb.ExecuteTaskRequest) error {
+	a.logger.Info("Executing task",
+		zap.String("task_id", req.TaskId),
+		zap.String("template", req.TemplateName))
+
+	// A redelivered request must not mix a rejection into the output of the original
+	if a.knowsTask(req.TaskId) {
+		return fmt.Errorf("task already executed: %s", req.TaskId)
+	}
 
-	// Create a cancellable context
-	ctx, cancel := context.WithCancel(context.Background())
-	if timeout > 0 {
-		ctx, cancel = context.WithTimeout(ctx, time.Duration(timeout)*time.Second)
+	// Signed requests are verified before anything in them is trusted
+	var policy *Policy
+	err := a.verify(req)
+	if err == nil {
+		policy, err = a.policy.current()
+	}
+	if err == nil && policy != nil {
+		err = policy.Check(req)
+	}
+	if err == nil {
+		err = checkReferences(policy, req)
+	}
+	if err != nil {
+		var rejection *PolicyError
+		if !errors.As(err, &rejection) {
+			rejection = rejectf("%v", err)
+		}
+		a.logger.Warn("Task rejected",
+			zap.String("task_id", req.TaskId),
+			zap.String("reason", rejection.Reason))
+		if sendErr := a.spool.append(rejection.Response(req.TaskId)); sendErr != nil {
+			a.logger.Error("Failed to report rejection", zap.String("task_id", req.TaskId), zap.Error(sendErr))
+		}
+		return rejection
 	}
 
-	// Create a new task
+	// Cancelling a task also takes it out of the queue; its timeout starts once it runs
+	ctx, cancel := context.WithCancel(context.Background())
 	task := &Task{
-		ID:        taskID,
-		Script:    script,
-		Params:    params,
-		Status:    "running",
-		StartTime: time.Now(),
-		Cancel:    cancel,
+		ID:       req.TaskId,
+		Script:   req.Script,
+		Params:   req.Params,
+		Status:   "queued",
+		Priority: req.Priority,
+		Cancel:   cancel,
+	}
+
+	out := newStream(req.TaskId, a.spool.append)
+	slot := a.queue.enqueue(req.TaskId, req.Priority)
+	if slot == nil {
+		cancel()
+		running, queued := a.queue.load()
+		err := fmt.Errorf("agent is at capacity: %d tasks running, %d queued", running, queued)
+		a.logger.Warn("Task refused", zap.String("task_id", req.TaskId), zap.Error(err))
+		if sendErr := out.emit(&pb.ExecuteTaskResponse{Completed: true, ExitCode: -1, Error: err.Error()}); sendErr != nil {
+			a.logger.Error("Failed to report task result", zap.String("task_id", req.TaskId), zap.Error(sendErr))
+		}
+		return err
 	}
 
 	// Store the task
 	a.tasksMutex.Lock()
-	a.tasks[taskID] = task
+	a.tasks[req.TaskId] = task
 	a.tasksMutex.Unlock()
 
-	// Execute the task in a goroutine
+	// Execute the task in a goroutine once it has a slot
 	go func() {
 		defer cancel()
 
-		// TODO: Implement task execution
-		// This would involve:
-		// 1. Creating a temporary script file
-		// 2. Setting up environment variables from params
-		// 3. Executing the script
-		// 4. Streaming the output back to the server
-		// 5. Updating the task status
+		var exitCode int
+		var runErr error
+		select {
+		case <-slot.ready:
+			a.tasksMutex.Lock()
+			if task.Status == "queued" {
+				task.Status = "running"
+			}
+			task.StartTime = time.Now()
+			a.tasksMutex.Unlock()
 
-		// For now, just simulate a task
-		time.Sleep(5 * time.Second)
+			runCtx, cancelRun := ctx, context.CancelFunc(func() {})
+			if req.TimeoutSeconds > 0 {
+				runCtx, cancelRun = context.WithTimeout(ctx, time.Duration(req.TimeoutSeconds)*time.Second)
+			}
+			exitCode, runErr = a.run(runCtx, req, policy, out)
+			cancelRun()
+			a.queue.release()
+		case <-ctx.Done():
+			a.queue.leave(slot)
+			exitCode, runErr = -1, errors.New("task cancelled")
+		}
+
+		// The final response is spooled before the status changes, so it is delivered ahead of any
+		// status reply that reports the task finished
+		final := &pb.ExecuteTaskResponse{
+			Completed:       true,
+			ExitCode:        int32(exitCode),
+			Redactions:      out.redactions(),
+			CpuUsageMs:      out.cpuUsage.Milliseconds(),
+			PeakMemoryBytes: out.peakMemory,
+		}
+		if runErr != nil {
+			final.Error = runErr.Error()
+		}
+		if err := out.emit(final); err != nil {
+			a.logger.Error("Failed to report task result", zap.String("task_id", req.TaskId), zap.Error(err))
+		}
 
-		// Update the task status
+		// Update the task status, unless it was cancelled
 		a.tasksMutex.Lock()
-		task.Status = "completed"
+		if task.Status == "running" {
+			task.Status = "completed"
+			if exitCode != 0 || runErr != nil {
+				task.Status = "failed"
+			}
+		}
 		task.EndTime = time.Now()
-		task.ExitCode = 0
+		task.ExitCode = exitCode
+		if runErr != nil {
+			task.Error = runErr.Error()
+		}
+		status := task.Status
 		a.tasksMutex.Unlock()
 
-		a.logger.Info("Task completed", zap.String("task_id", taskID))
+		a.logger.Info("Task finished",
+			zap.String("task_id", req.TaskId),
+			zap.String("status", status),
+			zap.Int("exit_code", exitCode))
 	}()
 
 	return nil
 }
 
-// GetTaskStatus gets the status of a task
+// GetTaskStatus returns a snapshot of a task's status, including its place in the queue while it
+// waits for a slot
 func (a *Agent) GetTaskStatus(taskID string) (*Task, error) {
 	a.tasksMutex.RLock()
-	defer a.tasksMutex.RUnlock()
-
 	task, ok := a.tasks[taskID]
+	var snapshot Task
+	if ok {
+		snapshot = *task
+	}
+	a.tasksMutex.RUnlock()
+
 	if !ok {
 		return nil, fmt.Errorf("task not found: %s", taskID)
 	}
+	if snapshot.Status == "queued" {
+		snapshot.QueuePosition = a.queue.position(taskID)
+	}
 
-	return task, nil
+	return &snapshot, nil
+}
+
+// knowsTask reports whether a task has run since the agent started or still has spooled output
+func (a *Agent) knowsTask(taskID string) bool {
+	a.tasksMutex.RLock()
+	_, ok := a.tasks[taskID]
+	a.tasksMutex.RUnlock()
+	return ok || a.spool.has(taskID)
 }
 
 // CancelTask cancels a running task
@@ -276,7 +515,7 @@ func (a *Agent) CancelTask(taskID string) error {
 		return fmt.Errorf("task not found: %s", taskID)
 	}
 
-	if task.Status != "running" {
+	if task.Status != "running" && task.Status != "queued" {
 		return fmt.Errorf("task is not running: %s", taskID)
 	}
 
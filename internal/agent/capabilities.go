@@ -0,0 +1,136 @@
+package agent
+
+import (
+	"context"
+	"os/exec"
+	"regexp"
+	"time"
+
+	"go.uber.org/zap"
+
+	pb "github.com/BogdanDolia/ops-butler/api/proto/agent"
+)
+
+// probeTimeout bounds each command and API call made to find the agent's capabilities
+const probeTimeout = 5 * time.Second
+
+// binaryProbes are the tools reported to the server, with the arguments that print their version
+var binaryProbes = map[string][]string{
+	"kubectl":   {"version", "--client"},
+	"helm":      {"version", "--short"},
+	"terraform": {"version"},
+	"jq":        {"--version"},
+}
+
+// backendProbes are the interpreters reported as execution backends
+var backendProbes = []string{"sh", "bash", "python3"}
+
+// probedVersion finds the version in a tool's output, e.g. "Client Version: v1.28.3" or "jq-1.6"
+var probedVersion = regexp.MustCompile(`\d+\.\d+(?:\.\d+)?`)
+
+// currentCapabilities returns the agent's capabilities, probing them again once they are older
+// than the configured interval
+func (a *Agent) currentCapabilities() *pb.Capabilities {
+	a.capabilitiesMutex.Lock()
+	defer a.capabilitiesMutex.Unlock()
+
+	if a.capabilities == nil || time.Since(a.capabilitiesProbed) >= a.config.CapabilitiesInterval {
+		a.capabilities = a.probeCapabilities()
+		a.capabilitiesProbed = time.Now()
+	}
+	return a.capabilities
+}
+
+// probeCapabilities finds the tools and interpreters tasks can use and facts about the cluster.
+// With a policy, only what the policy lets tasks use is reported.
+func (a *Agent) probeCapabilities() *pb.Capabilities {
+	caps := &pb.Capabilities{
+		Binaries:  make(map[string]string),
+		Namespace: a.config.Namespace,
+	}
+
+	policy, err := a.policy.current()
+	if err != nil {
+		// Every task is refused until the policy loads, so nothing is reported
+		a.logger.Warn("Reporting no capabilities while the policy is unavailable", zap.Error(err))
+		return caps
+	}
+
+	for name, args := range binaryProbes {
+		path, err := exec.LookPath(name)
+		if policy != nil && len(policy.Binaries) > 0 {
+			path, err = policy.links[name], nil
+		}
+		if err != nil || path == "" {
+			continue
+		}
+		if version := probeVersion(path, args); version != "" {
+			caps.Binaries[name] = version
+		}
+	}
+
+	for _, name := range backendProbes {
+		if policy != nil && len(policy.Interpreters) > 0 && !containsString(policy.Interpreters, name) {
+			continue
+		}
+		if _, err := exec.LookPath(name); err == nil {
+			caps.Backends = append(caps.Backends, name)
+		}
+	}
+
+	if inCluster() {
+		ctx, cancel := context.WithTimeout(context.Background(), probeTimeout)
+		defer cancel()
+
+		if version, err := kubernetesVersion(ctx); err != nil {
+			a.logger.Warn("Failed to get Kubernetes version", zap.Error(err))
+		} else {
+			caps.KubernetesVersion = version
+		}
+		if count, err := nodeCount(ctx); err != nil {
+			a.logger.Warn("Failed to count nodes; the agent's service account needs to list nodes", zap.Error(err))
+		} else {
+			caps.NodeCount = int32(count)
+		}
+	}
+
+	a.logger.Debug("Probed capabilities",
+		zap.Any("binaries", caps.Binaries),
+		zap.Strings("backends", caps.Backends),
+		zap.String("kubernetes_version", caps.KubernetesVersion),
+		zap.Int32("node_count", caps.NodeCount))
+	return caps
+}
+
+// probeVersion runs a tool to find its version. It returns "" if the tool fails or prints no
+// version.
+func probeVersion(path string, args []string) string {
+	ctx, cancel := context.WithTimeout(context.Background(), probeTimeout)
+	defer cancel()
+
+	output, err := exec.CommandContext(ctx, path, args...).CombinedOutput()
+	if err != nil {
+		return ""
+	}
+	return probedVersion.FindString(string(output))
+}
+
+// kubernetesVersion returns the server version of the agent's cluster
+func kubernetesVersion(ctx context.Context) (string, error) {
+	var info struct {
+		GitVersion string `json:"gitVersion"`
+	}
+	if err := kubernetesGet(ctx, "/version", &info); err != nil {
+		return "", err
+	}
+	return info.GitVersion, nil
+}
+
+// nodeCount returns the number of nodes in the agent's cluster
+func nodeCount(ctx context.Context) (int, error) {
+	names, err := listNames(ctx, "/api/v1/nodes", "", 0)
+	if err != nil {
+		return 0, err
+	}
+	return len(names), nil
+}
@@ -0,0 +1,177 @@
+package agent
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"go.uber.org/zap"
+
+	pb "github.com/BogdanDolia/ops-butler/api/proto/agent"
+)
+
+// certificateCheckInterval is how often the agent checks whether its certificate is due for renewal
+const certificateCheckInterval = time.Minute
+
+// needsEnrollment reports whether the agent has an enrollment token but no client certificate yet
+func (a *Agent) needsEnrollment() bool {
+	if !a.config.TLSEnabled || a.config.EnrollmentToken == "" {
+		return false
+	}
+	_, err := os.Stat(a.config.TLSCertFile)
+	return errors.Is(err, os.ErrNotExist)
+}
+
+// enroll exchanges the enrollment token for a client certificate, saves it and reconnects with it
+func (a *Agent) enroll() error {
+	a.logger.Info("Enrolling with server")
+
+	keyPEM, csrPEM, err := newCSR(a.config.Name)
+	if err != nil {
+		return err
+	}
+
+	resp, err := a.agentClient().Register(context.Background(), &pb.RegisterRequest{
+		Name:            a.config.Name,
+		Labels:          a.config.Labels,
+		Version:         version,
+		EnrollmentToken: a.config.EnrollmentToken,
+		Csr:             csrPEM,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to enroll: %w", err)
+	}
+	if !resp.Success || len(resp.Certificate) == 0 {
+		return fmt.Errorf("enrollment failed: %s", resp.Error)
+	}
+
+	if err := a.saveCertificate(keyPEM, resp.Certificate); err != nil {
+		return err
+	}
+	a.logger.Info("Enrolled with server", zap.String("agent_id", resp.AgentId))
+
+	return a.connect()
+}
+
+// certificateLoop renews the client certificate before it expires
+func (a *Agent) certificateLoop() {
+	defer a.wg.Done()
+
+	ticker := time.NewTicker(certificateCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := a.renewIfDue(); err != nil {
+				a.logger.Error("Failed to renew certificate", zap.Error(err))
+			}
+		case <-a.stopCh:
+			return
+		}
+	}
+}
+
+// renewIfDue renews the client certificate once less than a third of its lifetime is left, then
+// reconnects with the new one
+func (a *Agent) renewIfDue() error {
+	cert, err := loadCertificate(a.config.TLSCertFile)
+	if err != nil {
+		return err
+	}
+	lifetime := cert.NotAfter.Sub(cert.NotBefore)
+	if time.Until(cert.NotAfter) > lifetime/3 {
+		return nil
+	}
+
+	a.logger.Info("Renewing client certificate", zap.Time("expires_at", cert.NotAfter))
+
+	keyPEM, csrPEM, err := newCSR(a.config.Name)
+	if err != nil {
+		return err
+	}
+
+	resp, err := a.agentClient().RenewCertificate(context.Background(), &pb.RenewCertificateRequest{
+		AgentId: a.agentID,
+		Csr:     csrPEM,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to renew certificate: %w", err)
+	}
+	if !resp.Success || len(resp.Certificate) == 0 {
+		return fmt.Errorf("certificate renewal failed: %s", resp.Error)
+	}
+
+	if err := a.saveCertificate(keyPEM, resp.Certificate); err != nil {
+		return err
+	}
+
+	return a.connect()
+}
+
+// saveCertificate writes the client key and certificate, replacing the previous ones
+func (a *Agent) saveCertificate(keyPEM, certPEM []byte) error {
+	if err := writeFile(a.config.TLSKeyFile, keyPEM, 0o600); err != nil {
+		return fmt.Errorf("failed to save key: %w", err)
+	}
+	if err := writeFile(a.config.TLSCertFile, certPEM, 0o644); err != nil {
+		return fmt.Errorf("failed to save certificate: %w", err)
+	}
+	return nil
+}
+
+// newCSR generates a private key and a certificate signing request for it, both PEM-encoded
+func newCSR(name string) ([]byte, []byte, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to generate key: %w", err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	csrDER, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject: pkix.Name{CommonName: name},
+	}, key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create csr: %w", err)
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}),
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csrDER}), nil
+}
+
+// loadCertificate reads a PEM certificate file
+func loadCertificate(path string) (*x509.Certificate, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read certificate: %w", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM certificate in %s", path)
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+// writeFile writes a file atomically, creating its directory if needed
+func writeFile(path string, data []byte, perm os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, perm); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,172 @@
+package agent
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// cgroupRoot is where the cgroup v2 hierarchy is mounted
+const cgroupRoot = "/sys/fs/cgroup"
+
+// cgroups creates a cgroup v2 for each task below the agent's own cgroup, limiting the task's CPU,
+// memory and number of processes
+type cgroups struct {
+	dir    string
+	limits TaskLimits
+}
+
+// newCgroups prepares the agent's cgroup for per-task child cgroups. cgroup v2 only lets a cgroup
+// delegate controllers to its children if it has no processes of its own, so the agent first
+// moves itself into a leaf cgroup. It fails where cgroup v2 isn't mounted writable, e.g. in
+// containers that aren't allowed to manage their cgroups.
+func newCgroups(limits TaskLimits) (*cgroups, error) {
+	if _, err := os.Stat(filepath.Join(cgroupRoot, "cgroup.controllers")); err != nil {
+		return nil, errors.New("cgroup v2 is not available")
+	}
+	self, err := ownCgroup()
+	if err != nil {
+		return nil, err
+	}
+	dir := filepath.Join(cgroupRoot, self)
+
+	leaf := filepath.Join(dir, "agent")
+	if err := os.MkdirAll(leaf, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create agent cgroup: %w", err)
+	}
+	if err := writeCgroupFile(leaf, "cgroup.procs", strconv.Itoa(os.Getpid())); err != nil {
+		return nil, err
+	}
+	if err := writeCgroupFile(dir, "cgroup.subtree_control", "+cpu +memory +pids"); err != nil {
+		return nil, err
+	}
+
+	return &cgroups{dir: dir, limits: limits}, nil
+}
+
+// ownCgroup returns the agent's cgroup v2 path from /proc/self/cgroup
+func ownCgroup() (string, error) {
+	f, err := os.Open("/proc/self/cgroup")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if path, ok := strings.CutPrefix(scanner.Text(), "0::"); ok {
+			// A cgroup is moved into by the agent itself, so a restart finds it there already
+			return strings.TrimSuffix(path, "/agent"), nil
+		}
+	}
+	return "", errors.New("agent is not in a cgroup v2")
+}
+
+// taskCgroup is the cgroup of a single task
+type taskCgroup struct {
+	dir string
+	fd  *os.File
+}
+
+// create creates a task's cgroup with the configured limits
+func (c *cgroups) create(taskID string) (*taskCgroup, error) {
+	dir := filepath.Join(c.dir, strings.TrimSuffix(spoolFileName(taskID), ".jsonl"))
+	if err := os.Mkdir(dir, 0o755); err != nil && !os.IsExist(err) {
+		return nil, fmt.Errorf("failed to create task cgroup: %w", err)
+	}
+
+	settings := map[string]string{}
+	if c.limits.CPUMillicores > 0 {
+		// A quota of CPUMillicores/1000 CPUs per 100ms period
+		settings["cpu.max"] = fmt.Sprintf("%d 100000", c.limits.CPUMillicores*100)
+	}
+	if c.limits.MemoryMB > 0 {
+		settings["memory.max"] = strconv.FormatInt(int64(c.limits.MemoryMB)<<20, 10)
+		settings["memory.swap.max"] = "0"
+	}
+	if c.limits.MaxPids > 0 {
+		settings["pids.max"] = strconv.Itoa(c.limits.MaxPids)
+	}
+	for name, value := range settings {
+		// memory.swap.max is missing without swap accounting
+		if err := writeCgroupFile(dir, name, value); err != nil && name != "memory.swap.max" {
+			os.Remove(dir)
+			return nil, err
+		}
+	}
+
+	fd, err := os.Open(dir)
+	if err != nil {
+		os.Remove(dir)
+		return nil, err
+	}
+	return &taskCgroup{dir: dir, fd: fd}, nil
+}
+
+// attach makes a command start inside the cgroup, so no process of the task escapes its limits
+func (t *taskCgroup) attach(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{UseCgroupFD: true, CgroupFD: int(t.fd.Fd())}
+}
+
+// usage returns the CPU time and peak memory the task used
+func (t *taskCgroup) usage() (cpu time.Duration, peakMemory int64) {
+	if usec, ok := readCgroupKey(t.dir, "cpu.stat", "usage_usec"); ok {
+		cpu = time.Duration(usec) * time.Microsecond
+	}
+	// memory.peak exists from Linux 5.19
+	if data, err := os.ReadFile(filepath.Join(t.dir, "memory.peak")); err == nil {
+		peakMemory, _ = strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+	}
+	return cpu, peakMemory
+}
+
+// oomKilled reports whether a process of the task was killed for exceeding the memory limit
+func (t *taskCgroup) oomKilled() bool {
+	n, ok := readCgroupKey(t.dir, "memory.events", "oom_kill")
+	return ok && n > 0
+}
+
+// remove kills what is left of the task, e.g. processes it left running in the background, and
+// removes its cgroup
+func (t *taskCgroup) remove() {
+	t.fd.Close()
+	_ = writeCgroupFile(t.dir, "cgroup.kill", "1")
+	// The cgroup can only be removed once the killed processes are gone
+	for i := 0; i < 50; i++ {
+		if err := os.Remove(t.dir); err == nil || os.IsNotExist(err) {
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+// writeCgroupFile writes a cgroup interface file
+func writeCgroupFile(dir, name, value string) error {
+	if err := os.WriteFile(filepath.Join(dir, name), []byte(value), 0o644); err != nil {
+		return fmt.Errorf("failed to set %s: %w", name, err)
+	}
+	return nil
+}
+
+// readCgroupKey reads a value from a flat keyed cgroup file such as cpu.stat
+func readCgroupKey(dir, name, key string) (int64, bool) {
+	data, err := os.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		return 0, false
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 && fields[0] == key {
+			n, err := strconv.ParseInt(fields[1], 10, 64)
+			return n, err == nil
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,32 @@
+//go:build !linux
+
+package agent
+
+import (
+	"errors"
+	"os/exec"
+	"time"
+)
+
+// cgroups is only supported on Linux
+type cgroups struct{}
+
+// newCgroups fails outside Linux, so tasks run without limits of their own
+func newCgroups(TaskLimits) (*cgroups, error) {
+	return nil, errors.New("cgroups are only supported on Linux")
+}
+
+// taskCgroup is the cgroup of a single task
+type taskCgroup struct{}
+
+func (c *cgroups) create(string) (*taskCgroup, error) {
+	return nil, errors.New("cgroups are not supported")
+}
+
+func (t *taskCgroup) attach(*exec.Cmd) {}
+
+func (t *taskCgroup) usage() (time.Duration, int64) { return 0, 0 }
+
+func (t *taskCgroup) oomKilled() bool { return false }
+
+func (t *taskCgroup) remove() {}
@@ -3,6 +3,7 @@ package agent
 import (
 	"fmt"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"time"
@@ -19,24 +20,73 @@ type Config struct {
 	TLSCertFile       string
 	TLSKeyFile        string
 	TLSCAFile         string
-	LogLevel          string
-	LogFormat         string
+	TLSServerName     string
+	EnrollmentToken   string        // single-use token to obtain a client certificate when TLSCertFile doesn't exist yet
+	StateDir          string        // where an enrolled agent keeps its key and certificate by default
+	PolicyFile        string        // local execution policy, e.g. a mounted ConfigMap; tasks are unrestricted without one
+	ApprovalKeysFile  string        // pinned PEM public keys of the template approvers; scripts must be approved by one of them
+	ApprovalMaxTTL    time.Duration // approvals that expire further ahead are refused
+	ReplayWindow      time.Duration // how long task IDs are remembered to refuse replayed requests
+	// Runs tasks without an approval when no keys are pinned. Insecure: a compromised portal or
+	// database can run any script in the cluster.
+	AllowUnsignedTasks bool
+	RedactRulesFile    string // extra name/pattern rules masked in task output besides the built-in ones
+	SpoolMaxMB         int    // task output kept on disk while the server is unreachable
+	MaxConcurrent      int    // tasks run at once; further tasks wait in a priority queue
+	MaxQueued          int    // tasks waiting for a slot; tasks beyond that fail right away
+	TaskLimits         TaskLimits
+	// How often the tools, interpreters and cluster facts reported to the server are probed again
+	CapabilitiesInterval time.Duration
+	LogLevel             string
+	LogFormat            string
+}
+
+// TaskLimits are the resources each task may use, enforced with cgroup v2 where available. Zero
+// leaves a resource unlimited.
+type TaskLimits struct {
+	CPUMillicores int
+	MemoryMB      int
+	MaxPids       int
+}
+
+// enabled reports whether any limit is set
+func (l TaskLimits) enabled() bool {
+	return l.CPUMillicores > 0 || l.MemoryMB > 0 || l.MaxPids > 0
 }
 
 // NewConfig creates a new agent configuration from environment variables
 func NewConfig() *Config {
+	stateDir := getEnv("AGENT_STATE_DIR", "/var/lib/ops-butler-agent")
 	return &Config{
-		Name:              getEnv("AGENT_NAME", getHostname()),
-		Labels:            getEnvAsMap("AGENT_LABELS", map[string]string{}),
-		ServerAddress:     getEnv("SERVER_ADDRESS", "localhost:8080"),
-		HeartbeatInterval: getEnvAsDuration("HEARTBEAT_INTERVAL", 30*time.Second),
-		Namespace:         getEnv("KUBERNETES_NAMESPACE", "default"),
-		TLSEnabled:        getEnvAsBool("TLS_ENABLED", false),
-		TLSCertFile:       getEnv("TLS_CERT_FILE", ""),
-		TLSKeyFile:        getEnv("TLS_KEY_FILE", ""),
-		TLSCAFile:         getEnv("TLS_CA_FILE", ""),
-		LogLevel:          getEnv("LOG_LEVEL", "info"),
-		LogFormat:         getEnv("LOG_FORMAT", "json"),
+		Name:               getEnv("AGENT_NAME", getHostname()),
+		Labels:             getEnvAsMap("AGENT_LABELS", map[string]string{}),
+		ServerAddress:      getEnv("SERVER_ADDRESS", "localhost:9090"),
+		HeartbeatInterval:  getEnvAsDuration("HEARTBEAT_INTERVAL", 30*time.Second),
+		Namespace:          getEnv("KUBERNETES_NAMESPACE", "default"),
+		TLSEnabled:         getEnvAsBool("TLS_ENABLED", false),
+		TLSCertFile:        getEnv("TLS_CERT_FILE", filepath.Join(stateDir, "agent.crt")),
+		TLSKeyFile:         getEnv("TLS_KEY_FILE", filepath.Join(stateDir, "agent.key")),
+		TLSCAFile:          getEnv("TLS_CA_FILE", ""),
+		TLSServerName:      getEnv("TLS_SERVER_NAME", ""),
+		EnrollmentToken:    getEnv("ENROLLMENT_TOKEN", ""),
+		StateDir:           stateDir,
+		PolicyFile:         getEnv("AGENT_POLICY_FILE", ""),
+		ApprovalKeysFile:   getEnv("TASK_APPROVAL_KEYS_FILE", ""),
+		ApprovalMaxTTL:     getEnvAsDuration("TASK_APPROVAL_MAX_TTL", 366*24*time.Hour),
+		ReplayWindow:       getEnvAsDuration("TASK_REPLAY_WINDOW", 24*time.Hour),
+		AllowUnsignedTasks: getEnvAsBool("AGENT_ALLOW_UNSIGNED_TASKS", false),
+		RedactRulesFile:    getEnv("REDACT_RULES_FILE", ""),
+		SpoolMaxMB:         getEnvAsInt("AGENT_SPOOL_MAX_MB", 64),
+		MaxConcurrent:      getEnvAsInt("AGENT_MAX_CONCURRENT_TASKS", 4),
+		MaxQueued:          getEnvAsInt("AGENT_MAX_QUEUED_TASKS", 100),
+		TaskLimits: TaskLimits{
+			CPUMillicores: getEnvAsInt("AGENT_TASK_CPU_MILLICORES", 0),
+			MemoryMB:      getEnvAsInt("AGENT_TASK_MEMORY_MB", 0),
+			MaxPids:       getEnvAsInt("AGENT_TASK_MAX_PIDS", 0),
+		},
+		CapabilitiesInterval: getEnvAsDuration("AGENT_CAPABILITIES_INTERVAL", 10*time.Minute),
+		LogLevel:             getEnv("LOG_LEVEL", "info"),
+		LogFormat:            getEnv("LOG_FORMAT", "json"),
 	}
 }
 
@@ -76,6 +126,19 @@ func getEnvAsMap(key string, defaultValue map[string]string) map[string]string {
 	return result
 }
 
+// getEnvAsInt gets an environment variable as an integer or returns a default value
+func getEnvAsInt(key string, defaultValue int) int {
+	valueStr := getEnv(key, "")
+	if valueStr == "" {
+		return defaultValue
+	}
+	value, err := strconv.Atoi(valueStr)
+	if err != nil {
+		return defaultValue
+	}
+	return value
+}
+
 // getEnvAsBool gets an environment variable as a boolean or returns a default value
 func getEnvAsBool(key string, defaultValue bool) bool {
 	valueStr := getEnv(key, "")
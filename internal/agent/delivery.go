@@ -0,0 +1,133 @@
+package agent
+
+import (
+	"context"
+	"time"
+
+	"go.uber.org/zap"
+
+	pb "github.com/BogdanDolia/ops-butler/api/proto/agent"
+)
+
+// deliveryRetryInterval is how long the agent waits before reopening a broken output stream
+const deliveryRetryInterval = 5 * time.Second
+
+// deliveryLoop keeps an output stream to the server open and delivers spooled task output through
+// it, reconnecting whenever the stream breaks
+func (a *Agent) deliveryLoop() {
+	defer a.wg.Done()
+
+	for {
+		if err := a.deliver(); err != nil {
+			a.logger.Warn("Output stream interrupted; task output stays spooled until it reconnects", zap.Error(err))
+		}
+
+		select {
+		case <-time.After(deliveryRetryInterval):
+		case <-a.stopCh:
+			return
+		}
+	}
+}
+
+// deliver streams spooled output until the stream breaks or the agent stops. Every response not
+// yet acknowledged is sent again on a new stream; the server skips the ones it already stored.
+func (a *Agent) deliver() error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	stream, err := a.agentClient().StreamOutput(ctx)
+	if err != nil {
+		return err
+	}
+	if err := stream.Send(&pb.OutputMessage{AgentId: a.agentID}); err != nil {
+		return err
+	}
+
+	statusRequests := make(chan string, 16)
+	recvErr := make(chan error, 1)
+	go func() {
+		for {
+			control, err := stream.Recv()
+			if err != nil {
+				recvErr <- err
+				return
+			}
+			if control.StatusRequest != nil {
+				select {
+				case statusRequests <- control.StatusRequest.TaskId:
+				case <-ctx.Done():
+					return
+				}
+				continue
+			}
+			a.spool.ack(control.TaskId, control.AckedSequence)
+		}
+	}()
+
+	sent := make(map[string]int32)
+	flush := func() error {
+		for _, resp := range a.spool.unsent(sent) {
+			if err := stream.Send(&pb.OutputMessage{AgentId: a.agentID, Response: resp}); err != nil {
+				return err
+			}
+			sent[resp.TaskId] = resp.Sequence
+		}
+		return nil
+	}
+
+	for {
+		if err := flush(); err != nil {
+			return err
+		}
+
+		select {
+		case <-a.spool.notify:
+		case taskID := <-statusRequests:
+			// Output goes out ahead of the status, so the final response of a task that finished
+			// reaches the server before the status saying so
+			if err := flush(); err != nil {
+				return err
+			}
+			if err := stream.Send(&pb.OutputMessage{AgentId: a.agentID, Status: a.taskStatus(taskID)}); err != nil {
+				return err
+			}
+		case err := <-recvErr:
+			return err
+		case <-a.stopCh:
+			return stream.CloseSend()
+		}
+	}
+}
+
+// taskStatus returns the status of a task for the server. A task the agent no longer knows, e.g.
+// after a restart, is reported as "unknown".
+func (a *Agent) taskStatus(taskID string) *pb.TaskStatusResponse {
+	resp := &pb.TaskStatusResponse{TaskId: taskID, Status: "unknown"}
+
+	if task, err := a.GetTaskStatus(taskID); err == nil {
+		resp.Status = task.Status
+		resp.QueuePosition = int32(task.QueuePosition)
+		if !task.StartTime.IsZero() {
+			resp.StartTime = task.StartTime.Unix()
+		}
+		if !task.EndTime.IsZero() {
+			resp.EndTime = task.EndTime.Unix()
+		}
+		resp.ExitCode = int32(task.ExitCode)
+		resp.Error = task.Error
+		return resp
+	}
+
+	// The final response of a task run before a restart may still be spooled
+	if final := a.spool.final(taskID); final != nil {
+		resp.Status = "completed"
+		if final.ExitCode != 0 || final.Error != "" || final.Rejected {
+			resp.Status = "failed"
+		}
+		resp.EndTime = final.Timestamp
+		resp.ExitCode = final.ExitCode
+		resp.Error = final.Error
+	}
+	return resp
+}
@@ -0,0 +1,65 @@
+package agent
+
+import (
+	"context"
+	"time"
+
+	"go.uber.org/zap"
+
+	pb "github.com/BogdanDolia/ops-butler/api/proto/agent"
+)
+
+// taskLoop keeps a task stream to the server open and executes the tasks dispatched on it,
+// reconnecting whenever the stream breaks
+func (a *Agent) taskLoop() {
+	defer a.wg.Done()
+
+	for {
+		if err := a.receiveTasks(); err != nil {
+			a.logger.Warn("Task stream interrupted", zap.Error(err))
+		}
+
+		select {
+		case <-time.After(deliveryRetryInterval):
+		case <-a.stopCh:
+			return
+		}
+	}
+}
+
+// receiveTasks executes the tasks the server sends until the stream breaks or the agent stops.
+// Rejections and results go back through the spool like any other output.
+func (a *Agent) receiveTasks() error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	stream, err := a.agentClient().ReceiveTasks(ctx)
+	if err != nil {
+		return err
+	}
+	if err := stream.Send(&pb.TaskStreamMessage{AgentId: a.agentID}); err != nil {
+		return err
+	}
+
+	go func() {
+		select {
+		case <-a.stopCh:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	for {
+		req, err := stream.Recv()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+
+		if err := a.ExecuteTask(req); err != nil {
+			a.logger.Debug("Dispatched task not run", zap.String("task_id", req.TaskId), zap.Error(err))
+		}
+	}
+}
@@ -0,0 +1,217 @@
+package agent
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	pb "github.com/BogdanDolia/ops-butler/api/proto/agent"
+	"github.com/BogdanDolia/ops-butler/internal/redact"
+)
+
+// outputStream numbers a task's responses and sends them one at a time
+type outputStream struct {
+	taskID   string
+	send     SendFunc
+	mu       sync.Mutex
+	sequence int32
+	redactor *redact.Redactor // set once the task's secrets are known
+
+	// Resources used by the task, set once it has run in a cgroup
+	cpuUsage   time.Duration
+	peakMemory int64
+}
+
+// newStream creates an output stream for a task
+func newStream(taskID string, send SendFunc) *outputStream {
+	return &outputStream{taskID: taskID, send: send}
+}
+
+// emit sends a response with the next sequence number
+func (s *outputStream) emit(resp *pb.ExecuteTaskResponse) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sequence++
+	resp.TaskId = s.taskID
+	resp.Sequence = s.sequence
+	if resp.Timestamp == 0 {
+		resp.Timestamp = time.Now().Unix()
+	}
+	return s.send(resp)
+}
+
+// copyLines sends each line read from r as a redacted chunk of the named stream. Lines longer than
+// the read buffer arrive in parts, which the redaction stream joins up to its own limit.
+func (s *outputStream) copyLines(name string, r io.Reader) {
+	stream := redact.NewStream(s.redactor)
+	reader := bufio.NewReaderSize(r, 64*1024)
+	for {
+		line, isPrefix, err := reader.ReadLine()
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				_ = s.emit(&pb.ExecuteTaskResponse{
+					Chunk:  stream.Write(fmt.Sprintf("\n[failed to read %s: %v]\n", name, err)),
+					Stream: name,
+				})
+				// Keep draining the pipe, so the script doesn't block on a full one
+				_, _ = io.Copy(io.Discard, r)
+			}
+			break
+		}
+
+		text := string(line)
+		if !isPrefix {
+			text += "\n"
+		}
+		chunk := stream.Write(text)
+		if chunk == "" {
+			continue
+		}
+		// Keep draining the pipe even if the server is gone, so the script doesn't block
+		_ = s.emit(&pb.ExecuteTaskResponse{Chunk: chunk, Stream: name})
+	}
+	if chunk := stream.Flush(); chunk != "" {
+		_ = s.emit(&pb.ExecuteTaskResponse{Chunk: chunk, Stream: name})
+	}
+}
+
+// redactions returns the number of secrets masked in the output by rule
+func (s *outputStream) redactions() map[string]int32 {
+	if s.redactor == nil {
+		return nil
+	}
+	counts := make(map[string]int32)
+	for rule, n := range s.redactor.Counts() {
+		counts[rule] = int32(n)
+	}
+	return counts
+}
+
+// inheritedEnv are the variables of the agent's environment that tasks see. Everything else, such
+// as DB_PASSWORD or ENROLLMENT_TOKEN, stays with the agent; scripts get secrets through secret
+// params. The Kubernetes variables let kubectl find the API server in a pod.
+var inheritedEnv = []string{"HOME", "LANG", "LC_ALL", "TZ", "TMPDIR", "KUBECONFIG", "KUBERNETES_SERVICE_HOST", "KUBERNETES_SERVICE_PORT"}
+
+// taskEnv returns the environment of a task's script: PATH, the inherited variables that are set
+// and the params as PARAM_<NAME>
+func taskEnv(path string, params map[string]string) []string {
+	env := []string{"PATH=" + path}
+	for _, name := range inheritedEnv {
+		if value, ok := os.LookupEnv(name); ok {
+			env = append(env, name+"="+value)
+		}
+	}
+	for name, value := range params {
+		env = append(env, "PARAM_"+strings.ToUpper(name)+"="+value)
+	}
+	return env
+}
+
+// run executes a task's script with its interpreter and returns the exit code. Parameters are
+// passed as PARAM_<NAME> environment variables in an environment of their own; if the policy
+// restricts binaries, PATH only contains the allowed ones. With task limits configured the script runs in a cgroup of its own,
+// which is removed along with anything the script left running.
+func (a *Agent) run(ctx context.Context, req *pb.ExecuteTaskRequest, policy *Policy, out *outputStream) (exitCode int, err error) {
+	// The interpreter is looked up before PATH is restricted
+	interpreter, err := exec.LookPath(interpreterOf(req))
+	if err != nil {
+		return -1, fmt.Errorf("interpreter not found: %w", err)
+	}
+
+	script, err := os.CreateTemp("", "task-*.sh")
+	if err != nil {
+		return -1, fmt.Errorf("failed to create script file: %w", err)
+	}
+	defer os.Remove(script.Name())
+	if _, err := script.WriteString(req.Script); err != nil {
+		script.Close()
+		return -1, fmt.Errorf("failed to write script file: %w", err)
+	}
+	if err := script.Close(); err != nil {
+		return -1, fmt.Errorf("failed to write script file: %w", err)
+	}
+
+	path := os.Getenv("PATH")
+	if policy != nil {
+		dir, err := policy.binDir()
+		if err != nil {
+			return -1, fmt.Errorf("failed to prepare allowed binaries: %w", err)
+		}
+		if dir != "" {
+			defer os.RemoveAll(dir)
+			path = dir
+		}
+	}
+
+	// Secret references are resolved last, so their values are held for as short as possible
+	params, err := resolveParams(ctx, req)
+	if err != nil {
+		return -1, fmt.Errorf("failed to resolve secret params: %w", err)
+	}
+	secretValues := make([]string, 0, len(req.SecretParams))
+	for _, name := range req.SecretParams {
+		secretValues = append(secretValues, params[name])
+	}
+	out.redactor = redact.New(a.redactRules, secretValues)
+
+	cmd := exec.CommandContext(ctx, interpreter, script.Name())
+	cmd.Env = taskEnv(path, params)
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return -1, err
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return -1, err
+	}
+	if a.cgroups != nil {
+		cgroup, cgroupErr := a.cgroups.create(req.TaskId)
+		if cgroupErr != nil {
+			return -1, cgroupErr
+		}
+		defer func() {
+			out.cpuUsage, out.peakMemory = cgroup.usage()
+			cgroup.remove()
+		}()
+		cgroup.attach(cmd)
+		defer func() {
+			if err == nil && cgroup.oomKilled() {
+				err = fmt.Errorf("task exceeded its memory limit of %d MB", a.config.TaskLimits.MemoryMB)
+			}
+		}()
+	}
+	if err := cmd.Start(); err != nil {
+		return -1, fmt.Errorf("failed to start script: %w", err)
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() { defer wg.Done(); out.copyLines("stdout", stdout) }()
+	go func() { defer wg.Done(); out.copyLines("stderr", stderr) }()
+	wg.Wait()
+
+	err = cmd.Wait()
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return -1, fmt.Errorf("task timed out after %d seconds", req.TimeoutSeconds)
+	}
+	if errors.Is(ctx.Err(), context.Canceled) {
+		return -1, errors.New("task cancelled")
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode(), nil
+	}
+	if err != nil {
+		return -1, err
+	}
+	return 0, nil
+}
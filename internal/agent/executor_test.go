@@ -0,0 +1,91 @@
+package agent
+
+import (
+	"strings"
+	"testing"
+
+	pb "github.com/BogdanDolia/ops-butler/api/proto/agent"
+	"github.com/BogdanDolia/ops-butler/internal/redact"
+)
+
+func TestCopyLines(t *testing.T) {
+	tests := []struct {
+		name    string
+		secrets []string
+		input   string
+		want    string
+	}{
+		{
+			name:  "lines",
+			input: "one\ntwo\n",
+			want:  "one\ntwo\n",
+		},
+		{
+			name:  "final line without newline",
+			input: "one\ntwo",
+			want:  "one\ntwo\n",
+		},
+		{
+			name:  "line longer than the scanner limit used to be",
+			input: strings.Repeat("x", 2<<20) + "\nafter\n",
+			want:  strings.Repeat("x", 2<<20) + "\nafter\n",
+		},
+		{
+			name:    "secrets are redacted",
+			secrets: []string{"hunter22"},
+			input:   "pass hunter22\n",
+			want:    "pass [REDACTED:secret_param]\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got strings.Builder
+			out := newStream("1", func(resp *pb.ExecuteTaskResponse) error {
+				got.WriteString(resp.Chunk)
+				return nil
+			})
+			out.redactor = redact.New(redact.DefaultRules(), tt.secrets)
+
+			out.copyLines("stdout", strings.NewReader(tt.input))
+			if got.String() != tt.want {
+				t.Errorf("got %d bytes %.40q, want %d bytes %.40q", got.Len(), got.String(), len(tt.want), tt.want)
+			}
+		})
+	}
+}
+
+func TestTaskEnv(t *testing.T) {
+	t.Setenv("HOME", "/home/agent")
+	t.Setenv("DB_PASSWORD", "hunter22")
+	t.Setenv("ENROLLMENT_TOKEN", "token")
+	t.Setenv("KUBERNETES_SERVICE_HOST", "10.0.0.1")
+
+	env := make(map[string]string)
+	for _, entry := range taskEnv("/tmp/agent-bin", map[string]string{"app": "web"}) {
+		name, value, _ := strings.Cut(entry, "=")
+		env[name] = value
+	}
+
+	tests := []struct {
+		name  string
+		want  string
+		isSet bool
+	}{
+		{name: "PATH", want: "/tmp/agent-bin", isSet: true},
+		{name: "HOME", want: "/home/agent", isSet: true},
+		{name: "KUBERNETES_SERVICE_HOST", want: "10.0.0.1", isSet: true},
+		{name: "PARAM_APP", want: "web", isSet: true},
+		{name: "DB_PASSWORD"},
+		{name: "ENROLLMENT_TOKEN"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			value, ok := env[tt.name]
+			if ok != tt.isSet || value != tt.want {
+				t.Errorf("%s = %q (set %t), want %q (set %t)", tt.name, value, ok, tt.want, tt.isSet)
+			}
+		})
+	}
+}
@@ -0,0 +1,312 @@
+package agent
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	pb "github.com/BogdanDolia/ops-butler/api/proto/agent"
+	"github.com/BogdanDolia/ops-butler/internal/secrets"
+)
+
+// defaultInterpreter runs scripts whose task names no interpreter
+const defaultInterpreter = "sh"
+
+// Policy is the agent's local execution policy. It lets cluster owners veto what the portal asks
+// the agent to run; an empty list or zero value leaves that aspect unrestricted, except for
+// secret references, which tasks may only use if they are listed.
+//
+//	templates:
+//	  - name: restart-deployment
+//	    sha256: 3a7bd3e2...        # optional, pins the script
+//	interpreters: [bash, sh]
+//	binaries: [kubectl, jq]      # the only commands on PATH
+//	max_timeout_seconds: 900
+//	forbidden_params:
+//	  - name: namespace
+//	    pattern: ^kube-system$
+//	secret_references:            # secrets tasks may read, as path.Match patterns
+//	  - k8s://payments/*/*
+//	  - env://DB_*
+type Policy struct {
+	Templates         []TemplateRule    `yaml:"templates"`
+	Interpreters      []string          `yaml:"interpreters"`
+	Binaries          []string          `yaml:"binaries"`
+	MaxTimeoutSeconds int32             `yaml:"max_timeout_seconds"`
+	ForbiddenParams   []ParamRule       `yaml:"forbidden_params"`
+	SecretReferences  []string          `yaml:"secret_references"`
+	links             map[string]string // Binaries by name, resolved to their paths
+	allowedPaths      map[string]bool   // resolved paths of Binaries
+	interpreterPaths  map[string]bool   // resolved paths of the Interpreters installed
+}
+
+// TemplateRule allows a template by name, optionally only with a script of the given SHA-256
+type TemplateRule struct {
+	Name   string `yaml:"name"`
+	SHA256 string `yaml:"sha256"`
+}
+
+// ParamRule forbids values of a parameter that match a pattern; name "*" applies to all parameters
+type ParamRule struct {
+	Name    string `yaml:"name"`
+	Pattern string `yaml:"pattern"`
+	regexp  *regexp.Regexp
+}
+
+// PolicyError is returned when the agent's policy refuses to run a task
+type PolicyError struct {
+	Reason string
+}
+
+// Error implements the error interface
+func (e *PolicyError) Error() string {
+	return "rejected by agent policy: " + e.Reason
+}
+
+// Response returns the final response reporting the rejection of a task
+func (e *PolicyError) Response(taskID string) *pb.ExecuteTaskResponse {
+	return &pb.ExecuteTaskResponse{
+		TaskId:    taskID,
+		Timestamp: time.Now().Unix(),
+		Sequence:  1,
+		Completed: true,
+		ExitCode:  -1,
+		Error:     e.Error(),
+		Rejected:  true,
+	}
+}
+
+// rejectf returns a PolicyError
+func rejectf(format string, args ...interface{}) *PolicyError {
+	return &PolicyError{Reason: fmt.Sprintf(format, args...)}
+}
+
+// LoadPolicy reads and compiles a policy file
+func LoadPolicy(file string) (*Policy, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read policy: %w", err)
+	}
+
+	var policy Policy
+	if err := yaml.Unmarshal(data, &policy); err != nil {
+		return nil, fmt.Errorf("failed to parse policy: %w", err)
+	}
+
+	for i := range policy.ForbiddenParams {
+		rule := &policy.ForbiddenParams[i]
+		if rule.Name == "" {
+			return nil, fmt.Errorf("forbidden_params[%d]: name is required", i)
+		}
+		re, err := regexp.Compile(rule.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("forbidden_params[%d]: %w", i, err)
+		}
+		rule.regexp = re
+	}
+
+	for i, pattern := range policy.SecretReferences {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("secret_references[%d]: %w", i, err)
+		}
+	}
+
+	// An interpreter that isn't installed can't run anything, so it is left out rather than
+	// failing the whole policy
+	if len(policy.Interpreters) > 0 {
+		policy.interpreterPaths = make(map[string]bool)
+		for _, name := range policy.Interpreters {
+			if resolved, err := resolve(name); err == nil {
+				policy.interpreterPaths[resolved] = true
+			}
+		}
+	}
+
+	if len(policy.Binaries) > 0 {
+		policy.links = make(map[string]string)
+		policy.allowedPaths = make(map[string]bool)
+		for _, name := range policy.Binaries {
+			resolved, err := resolve(name)
+			if err != nil {
+				return nil, fmt.Errorf("binaries: %w", err)
+			}
+			policy.links[filepath.Base(name)] = resolved
+			policy.allowedPaths[resolved] = true
+		}
+	}
+
+	return &policy, nil
+}
+
+// absolutePath matches absolute paths in a script, which could bypass the restricted PATH
+var absolutePath = regexp.MustCompile("(?:^|[\\s;|&(`$=])(/[\\w.+-]+(?:/[\\w.+-]+)*)")
+
+// Check refuses a task that the policy doesn't allow
+func (p *Policy) Check(req *pb.ExecuteTaskRequest) error {
+	if len(p.Templates) > 0 {
+		sum := sha256.Sum256([]byte(req.Script))
+		hash := hex.EncodeToString(sum[:])
+		allowed := false
+		for _, rule := range p.Templates {
+			if rule.Name == req.TemplateName && (rule.SHA256 == "" || rule.SHA256 == hash) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return rejectf("template %q with script sha256 %s is not allowed", req.TemplateName, hash)
+		}
+	}
+
+	// Interpreters are compared by the binary they resolve to, the way the executor looks them up,
+	// so that a path such as /tmp/x/sh doesn't pass for sh
+	if p.interpreterPaths != nil {
+		interpreter := interpreterOf(req)
+		if resolved, err := resolve(interpreter); err != nil || !p.interpreterPaths[resolved] {
+			return rejectf("interpreter %q is not allowed", interpreter)
+		}
+	}
+
+	if p.MaxTimeoutSeconds > 0 && (req.TimeoutSeconds <= 0 || req.TimeoutSeconds > p.MaxTimeoutSeconds) {
+		return rejectf("timeout must be between 1 and %d seconds", p.MaxTimeoutSeconds)
+	}
+
+	for _, rule := range p.ForbiddenParams {
+		for name, value := range req.Params {
+			if (rule.Name == "*" || rule.Name == name) && rule.regexp.MatchString(value) {
+				return rejectf("value of parameter %q is forbidden", name)
+			}
+		}
+	}
+
+	// Scripts may only name allowed binaries by absolute path. This is a best-effort check; the
+	// restricted PATH is what keeps other commands out of reach of plain invocations.
+	if p.allowedPaths != nil {
+		for _, match := range absolutePath.FindAllStringSubmatch(req.Script, -1) {
+			path := match[1]
+			if info, err := os.Stat(path); err != nil || info.IsDir() || info.Mode()&0o111 == 0 {
+				continue
+			}
+			if resolved, err := filepath.EvalSymlinks(path); err == nil && !p.allowedPaths[resolved] {
+				return rejectf("binary %s is not allowed", path)
+			}
+		}
+	}
+
+	return nil
+}
+
+// checkReferences refuses secret references the policy doesn't list. References are denied by
+// default: without a policy, or without secret_references in it, tasks can't read any secret.
+func checkReferences(p *Policy, req *pb.ExecuteTaskRequest) error {
+	for _, name := range req.SecretParams {
+		ref, ok, err := secrets.ParseReference(req.Params[name])
+		if err != nil || !ok {
+			continue
+		}
+		if p == nil || !p.allowsReference(ref.String()) {
+			return rejectf("secret reference %s is not allowed by the policy's secret_references", ref)
+		}
+	}
+	return nil
+}
+
+// allowsReference reports whether a secret reference matches one of the allowed patterns
+func (p *Policy) allowsReference(ref string) bool {
+	for _, pattern := range p.SecretReferences {
+		if ok, _ := path.Match(pattern, ref); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// binDir creates a directory with links to the allowed binaries, to be used as the task's PATH. It
+// returns "" if binaries are unrestricted.
+func (p *Policy) binDir() (string, error) {
+	if p.links == nil {
+		return "", nil
+	}
+
+	dir, err := os.MkdirTemp("", "agent-bin-")
+	if err != nil {
+		return "", err
+	}
+	for name, path := range p.links {
+		if err := os.Symlink(path, filepath.Join(dir, name)); err != nil {
+			os.RemoveAll(dir)
+			return "", err
+		}
+	}
+	return dir, nil
+}
+
+// policyLoader reloads the policy file when it changes, so an updated ConfigMap takes effect
+// without restarting the agent
+type policyLoader struct {
+	path    string
+	mu      sync.Mutex
+	modTime time.Time
+	policy  *Policy
+	err     error
+}
+
+// current returns the current policy, or nil if no policy file is configured. If the file can't be
+// loaded every task is refused rather than run unchecked.
+func (l *policyLoader) current() (*Policy, error) {
+	if l.path == "" {
+		return nil, nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	info, err := os.Stat(l.path)
+	if err != nil {
+		return nil, rejectf("policy file is unavailable: %v", err)
+	}
+	if l.policy == nil && l.err == nil || !info.ModTime().Equal(l.modTime) {
+		l.policy, l.err = LoadPolicy(l.path)
+		l.modTime = info.ModTime()
+	}
+	if l.err != nil {
+		return nil, rejectf("policy file is invalid: %v", l.err)
+	}
+	return l.policy, nil
+}
+
+// interpreterOf returns the interpreter a task asks for
+func interpreterOf(req *pb.ExecuteTaskRequest) string {
+	if req.Interpreter == "" {
+		return defaultInterpreter
+	}
+	return req.Interpreter
+}
+
+// resolve returns the absolute path of a binary with symlinks resolved
+func resolve(name string) (string, error) {
+	path, err := exec.LookPath(name)
+	if err != nil {
+		return "", err
+	}
+	return filepath.EvalSymlinks(path)
+}
+
+// containsString checks if a string is present in a slice
+func containsString(slice []string, item string) bool {
+	for _, s := range slice {
+		if s == item {
+			return true
+		}
+	}
+	return false
+}
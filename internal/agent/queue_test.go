@@ -0,0 +1,136 @@
+package agent
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTaskQueue(t *testing.T) {
+	type task struct {
+		id       string
+		priority int32
+	}
+	tests := []struct {
+		name     string
+		slots    int
+		maxQueue int
+		tasks    []task
+		running  []string // got a slot right away
+		refused  []string
+		order    []string // got a slot as slots were released, in order
+	}{
+		{
+			name:     "within capacity",
+			slots:    2,
+			maxQueue: 10,
+			tasks:    []task{{"a", 0}, {"b", 0}},
+			running:  []string{"a", "b"},
+		},
+		{
+			name:     "higher priority first",
+			slots:    1,
+			maxQueue: 10,
+			tasks:    []task{{"a", 0}, {"scheduled", 0}, {"web", 1}, {"break-glass", 2}},
+			running:  []string{"a"},
+			order:    []string{"break-glass", "web", "scheduled"},
+		},
+		{
+			name:     "arrival order within a priority",
+			slots:    1,
+			maxQueue: 10,
+			tasks:    []task{{"a", 1}, {"b", 1}, {"c", 1}, {"d", 1}},
+			running:  []string{"a"},
+			order:    []string{"b", "c", "d"},
+		},
+		{
+			name:     "full queue refuses",
+			slots:    1,
+			maxQueue: 1,
+			tasks:    []task{{"a", 0}, {"b", 0}, {"c", 2}},
+			running:  []string{"a"},
+			refused:  []string{"c"},
+			order:    []string{"b"},
+		},
+		{
+			name:     "zero slots run one task",
+			slots:    0,
+			maxQueue: 10,
+			tasks:    []task{{"a", 0}, {"b", 0}},
+			running:  []string{"a"},
+			order:    []string{"b"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := newTaskQueue(tt.slots, tt.maxQueue)
+			var running, refused []string
+			var waiting []*queuedTask
+			for _, task := range tt.tasks {
+				queued := q.enqueue(task.id, task.priority)
+				switch {
+				case queued == nil:
+					refused = append(refused, task.id)
+				case isReady(queued):
+					running = append(running, task.id)
+				default:
+					waiting = append(waiting, queued)
+				}
+			}
+			if !reflect.DeepEqual(running, tt.running) {
+				t.Errorf("running = %v, want %v", running, tt.running)
+			}
+			if !reflect.DeepEqual(refused, tt.refused) {
+				t.Errorf("refused = %v, want %v", refused, tt.refused)
+			}
+
+			var order []string
+			for range waiting {
+				q.release()
+				for _, queued := range waiting {
+					if isReady(queued) && !containsString(order, queued.id) {
+						order = append(order, queued.id)
+					}
+				}
+			}
+			if !reflect.DeepEqual(order, tt.order) {
+				t.Errorf("order = %v, want %v", order, tt.order)
+			}
+		})
+	}
+}
+
+func TestTaskQueueLeave(t *testing.T) {
+	q := newTaskQueue(1, 10)
+	a := q.enqueue("a", 0)
+	b := q.enqueue("b", 0)
+	c := q.enqueue("c", 0)
+	if got := q.position("c"); got != 2 {
+		t.Fatalf("position(c) = %d, want 2", got)
+	}
+
+	// A waiting task that leaves gives up its place
+	q.leave(b)
+	if got := q.position("c"); got != 1 {
+		t.Errorf("position(c) after b left = %d, want 1", got)
+	}
+
+	// A running task that leaves releases its slot
+	q.leave(a)
+	if !isReady(c) {
+		t.Error("c didn't get the slot a released")
+	}
+	if running, queued := q.load(); running != 1 || queued != 0 {
+		t.Errorf("load() = %d, %d, want 1, 0", running, queued)
+	}
+}
+
+// isReady reports whether a queued task has been given a slot
+func isReady(t *queuedTask) bool {
+	select {
+	case <-t.ready:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,133 @@
+package agent
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	pb "github.com/BogdanDolia/ops-butler/api/proto/agent"
+	"github.com/BogdanDolia/ops-butler/internal/secrets"
+)
+
+// serviceAccountDir holds the in-cluster service account token and CA certificate
+const serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+// resolveParams returns a task's params with secret references replaced by the values they point
+// to. Only params the server marked secret are resolved, so an ordinary param can't read secrets.
+func resolveParams(ctx context.Context, req *pb.ExecuteTaskRequest) (map[string]string, error) {
+	resolved := make(map[string]string, len(req.Params))
+	for name, value := range req.Params {
+		resolved[name] = value
+	}
+
+	for _, name := range req.SecretParams {
+		ref, ok, err := secrets.ParseReference(req.Params[name])
+		if err != nil {
+			return nil, fmt.Errorf("param %s: %w", name, err)
+		}
+		if !ok {
+			continue
+		}
+
+		var value string
+		switch ref.Scheme {
+		case secrets.SchemeEnv:
+			v, found := os.LookupEnv(ref.Name)
+			if !found {
+				return nil, fmt.Errorf("param %s: environment variable %s is not set", name, ref.Name)
+			}
+			value = v
+		case secrets.SchemeKubernetes:
+			value, err = kubernetesSecret(ctx, ref)
+			if err != nil {
+				return nil, fmt.Errorf("param %s: %w", name, err)
+			}
+		}
+		resolved[name] = value
+	}
+
+	return resolved, nil
+}
+
+// kubernetesSecret reads a key of a Secret through the Kubernetes API with the agent's service
+// account
+func kubernetesSecret(ctx context.Context, ref secrets.Reference) (string, error) {
+	if !inCluster() {
+		return "", fmt.Errorf("%s can only be resolved in a cluster", ref)
+	}
+
+	var secret struct {
+		Data map[string]string `json:"data"`
+	}
+	path := fmt.Sprintf("/api/v1/namespaces/%s/secrets/%s", url.PathEscape(ref.Namespace), url.PathEscape(ref.Name))
+	if err := kubernetesGet(ctx, path, &secret); err != nil {
+		return "", fmt.Errorf("failed to get secret %s/%s: %w", ref.Namespace, ref.Name, err)
+	}
+	encoded, ok := secret.Data[ref.Key]
+	if !ok {
+		return "", fmt.Errorf("secret %s/%s has no key %s", ref.Namespace, ref.Name, ref.Key)
+	}
+	value, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", fmt.Errorf("failed to decode secret %s/%s: %w", ref.Namespace, ref.Name, err)
+	}
+	return string(value), nil
+}
+
+// inCluster reports whether the agent runs in a Kubernetes pod
+func inCluster() bool {
+	return os.Getenv("KUBERNETES_SERVICE_HOST") != "" && os.Getenv("KUBERNETES_SERVICE_PORT") != ""
+}
+
+// kubernetesGet gets a path of the Kubernetes API with the agent's service account and decodes
+// the JSON response into out
+func kubernetesGet(ctx context.Context, path string, out interface{}) error {
+	token, err := os.ReadFile(filepath.Join(serviceAccountDir, "token"))
+	if err != nil {
+		return fmt.Errorf("failed to read service account token: %w", err)
+	}
+	caPEM, err := os.ReadFile(filepath.Join(serviceAccountDir, "ca.crt"))
+	if err != nil {
+		return fmt.Errorf("failed to read cluster CA: %w", err)
+	}
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(caPEM)
+
+	client := &http.Client{
+		Timeout: 10 * time.Second,
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12},
+		},
+	}
+
+	host := net.JoinHostPort(os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT"))
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodGet, "https://"+host+path, nil)
+	if err != nil {
+		return err
+	}
+	httpReq.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return errors.New(resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	return nil
+}
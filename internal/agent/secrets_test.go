@@ -0,0 +1,79 @@
+package agent
+
+import (
+	"context"
+	"testing"
+
+	pb "github.com/BogdanDolia/ops-butler/api/proto/agent"
+)
+
+func TestResolveParams(t *testing.T) {
+	t.Setenv("DB_PASSWORD", "hunter22")
+	t.Setenv("KUBERNETES_SERVICE_HOST", "")
+
+	tests := []struct {
+		name         string
+		params       map[string]string
+		secretParams []string
+		want         map[string]string
+		wantErr      bool
+	}{
+		{
+			name:   "plain params",
+			params: map[string]string{"app": "web"},
+			want:   map[string]string{"app": "web"},
+		},
+		{
+			name:         "env reference of a secret param",
+			params:       map[string]string{"password": "env://DB_PASSWORD"},
+			secretParams: []string{"password"},
+			want:         map[string]string{"password": "hunter22"},
+		},
+		{
+			name:   "reference in a param that isn't secret",
+			params: map[string]string{"note": "env://DB_PASSWORD"},
+			want:   map[string]string{"note": "env://DB_PASSWORD"},
+		},
+		{
+			name:         "secret param without a reference",
+			params:       map[string]string{"password": "sealed-by-server"},
+			secretParams: []string{"password"},
+			want:         map[string]string{"password": "sealed-by-server"},
+		},
+		{
+			name:         "unset environment variable",
+			params:       map[string]string{"password": "env://NOT_SET_ANYWHERE"},
+			secretParams: []string{"password"},
+			wantErr:      true,
+		},
+		{
+			name:         "malformed reference",
+			params:       map[string]string{"password": "k8s://payments/db"},
+			secretParams: []string{"password"},
+			wantErr:      true,
+		},
+		{
+			name:         "kubernetes reference outside a cluster",
+			params:       map[string]string{"password": "k8s://payments/db/password"},
+			secretParams: []string{"password"},
+			wantErr:      true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := resolveParams(context.Background(), &pb.ExecuteTaskRequest{Params: tt.params, SecretParams: tt.secretParams})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("resolveParams() error = %v, wantErr %t", err, tt.wantErr)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("resolveParams() = %v, want %v", got, tt.want)
+			}
+			for name, value := range tt.want {
+				if got[name] != value {
+					t.Errorf("resolveParams()[%s] = %q, want %q", name, got[name], value)
+				}
+			}
+		})
+	}
+}
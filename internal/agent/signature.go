@@ -0,0 +1,132 @@
+package agent
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	pb "github.com/BogdanDolia/ops-butler/api/proto/agent"
+	"github.com/BogdanDolia/ops-butler/internal/signing"
+)
+
+// seenTasksFile is where the IDs of accepted tasks are kept, within the state directory
+const seenTasksFile = "seen-tasks.json"
+
+// keyLoader reloads the pinned approval keys when the file changes, so keys can be rotated without
+// restarting the agent
+type keyLoader struct {
+	path    string
+	mu      sync.Mutex
+	modTime time.Time
+	keys    signing.KeySet
+	err     error
+}
+
+// current returns the pinned keys, or nil if none are pinned. If the file can't be loaded every
+// task is refused.
+func (l *keyLoader) current() (signing.KeySet, error) {
+	if l.path == "" {
+		return nil, nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	info, err := os.Stat(l.path)
+	if err != nil {
+		return nil, fmt.Errorf("approval keys are unavailable: %w", err)
+	}
+	if l.keys == nil && l.err == nil || !info.ModTime().Equal(l.modTime) {
+		l.keys, l.err = signing.LoadKeySet(l.path)
+		l.modTime = info.ModTime()
+	}
+	return l.keys, l.err
+}
+
+// replayGuard remembers the IDs of accepted tasks for a while, so a captured request can't be run
+// twice. The IDs are saved so that a restart doesn't forget them.
+type replayGuard struct {
+	path   string
+	window time.Duration
+	mu     sync.Mutex
+	seen   map[string]int64 // task ID to when it is forgotten, unix time
+}
+
+// newReplayGuard loads the task IDs saved in the state directory
+func newReplayGuard(stateDir string, window time.Duration, logger *zap.Logger) *replayGuard {
+	g := &replayGuard{path: filepath.Join(stateDir, seenTasksFile), window: window, seen: make(map[string]int64)}
+
+	data, err := os.ReadFile(g.path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		logger.Error("Failed to read seen tasks", zap.String("path", g.path), zap.Error(err))
+	} else if err == nil {
+		if err := json.Unmarshal(data, &g.seen); err != nil {
+			logger.Error("Failed to parse seen tasks", zap.String("path", g.path), zap.Error(err))
+		}
+	}
+
+	return g
+}
+
+// accept records a task ID, refusing one that was accepted within the window
+func (g *replayGuard) accept(taskID string, now time.Time) error {
+	if taskID == "" {
+		return errors.New("task has no ID")
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	for id, expiry := range g.seen {
+		if expiry <= now.Unix() {
+			delete(g.seen, id)
+		}
+	}
+	if _, ok := g.seen[taskID]; ok {
+		return fmt.Errorf("task %s was already received", taskID)
+	}
+
+	g.seen[taskID] = now.Add(g.window).Unix()
+	data, err := json.Marshal(g.seen)
+	if err != nil {
+		return err
+	}
+	// Refuse the task rather than risk accepting it again after a restart
+	if err := writeFile(g.path, data, 0o600); err != nil {
+		delete(g.seen, taskID)
+		return fmt.Errorf("failed to record task: %w", err)
+	}
+
+	return nil
+}
+
+// verify checks that a task request's script was approved with one of the pinned keys and refuses
+// replays. Without pinned keys every task is refused, unless unsigned tasks are explicitly allowed.
+func (a *Agent) verify(req *pb.ExecuteTaskRequest) error {
+	keys, err := a.keys.current()
+	if err != nil {
+		return rejectf("%v", err)
+	}
+	if keys == nil {
+		if a.config.AllowUnsignedTasks {
+			return nil
+		}
+		return rejectf("no approval keys are pinned (TASK_APPROVAL_KEYS_FILE) and unsigned tasks are not allowed")
+	}
+
+	now := time.Now()
+	if err := keys.VerifyRequest(req, now, a.config.ApprovalMaxTTL); err != nil {
+		return rejectf("%v", err)
+	}
+	if err := a.replays.accept(req.TaskId, now); err != nil {
+		return rejectf("%v", err)
+	}
+
+	return nil
+}
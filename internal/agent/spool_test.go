@@ -0,0 +1,101 @@
+package agent
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	pb "github.com/BogdanDolia/ops-butler/api/proto/agent"
+)
+
+// sequences returns the sequences of responses
+func sequences(responses []*pb.ExecuteTaskResponse) []int32 {
+	var result []int32
+	for _, resp := range responses {
+		result = append(result, resp.Sequence)
+	}
+	return result
+}
+
+func TestSpoolResumesAfterRestart(t *testing.T) {
+	dir := t.TempDir()
+	s, err := openSpool(dir, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, resp := range []*pb.ExecuteTaskResponse{
+		{TaskId: "7", Sequence: 1, Stream: "stdout", Chunk: "one\n"},
+		{TaskId: "7", Sequence: 2, Stream: "stdout", Chunk: "two\n"},
+		{TaskId: "7", Sequence: 3, Completed: true},
+	} {
+		if err := s.append(resp); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s.ack("7", 1)
+	if got := sequences(s.unsent(map[string]int32{"7": 2})); !slices.Equal(got, []int32{3}) {
+		t.Errorf("unsent() after sending 2 = %v, want [3]", got)
+	}
+
+	// A crash in the middle of a write leaves a line cut short
+	path := filepath.Join(dir, spoolFileName("7"))
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteString(`{"taskId":"7","seq`); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	// After a restart the whole file is delivered again, as the server ignores what it has stored
+	restarted, err := openSpool(dir, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := sequences(restarted.unsent(nil))
+	if !slices.Equal(got, []int32{1, 2, 3}) {
+		t.Errorf("unsent() after restart = %v, want [1 2 3]", got)
+	}
+	if final := restarted.final("7"); final == nil || final.Sequence != 3 {
+		t.Errorf("final() after restart = %v, want sequence 3", final)
+	}
+
+	restarted.ack("7", 3)
+	if restarted.has("7") {
+		t.Error("task is still spooled after its final response was acknowledged")
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("spool file still exists after the final ack: %v", err)
+	}
+}
+
+func TestSpoolFull(t *testing.T) {
+	s, err := openSpool(t.TempDir(), 200)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := int32(1); i <= 5; i++ {
+		if err := s.append(&pb.ExecuteTaskResponse{TaskId: "7", Sequence: i, Stream: "stdout", Chunk: "a line of output that fills the spool\n"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.append(&pb.ExecuteTaskResponse{TaskId: "7", Sequence: 6, Completed: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	unsent := s.unsent(nil)
+	notices := 0
+	for _, resp := range unsent {
+		if resp.Chunk == spoolDroppedNotice {
+			notices++
+		}
+	}
+	if notices != 1 {
+		t.Errorf("spooled %d drop notices, want one for the output dropped", notices)
+	}
+	if last := unsent[len(unsent)-1]; !last.Completed || last.Sequence != 6 {
+		t.Errorf("last spooled response = %v, want the final one kept", last)
+	}
+}
@@ -0,0 +1,258 @@
+package agentserver
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	pb "github.com/BogdanDolia/ops-butler/api/proto/agent"
+	"github.com/BogdanDolia/ops-butler/internal/config"
+	"github.com/BogdanDolia/ops-butler/internal/database"
+)
+
+// errCertificateRequired is returned to callers without a client certificate
+var errCertificateRequired = status.Error(codes.Unauthenticated, "a verified client certificate is required")
+
+// Identity is the agent identity taken from a verified client certificate
+type Identity struct {
+	Name        string
+	Fingerprint string // hex SHA-256 of the DER certificate
+	Serial      string
+	ExpiresAt   time.Time
+}
+
+// identityKey is the context key of the caller's Identity
+type identityKey struct{}
+
+// IdentityFromContext returns the identity of the agent making a call, or nil if TLS is disabled
+func IdentityFromContext(ctx context.Context) *Identity {
+	identity, _ := ctx.Value(identityKey{}).(*Identity)
+	return identity
+}
+
+// agentIDRequest is implemented by requests that claim to come from a registered agent
+type agentIDRequest interface {
+	GetAgentId() string
+}
+
+// nameRequest is implemented by requests that carry the agent name, such as Register
+type nameRequest interface {
+	GetName() string
+}
+
+// serverCredentials returns mutual TLS credentials that accept client certificates signed by the
+// built-in CA or the configured client CA. Clients without a certificate may connect, but only to
+// enroll.
+func serverCredentials(cfg config.GRPCConfig, ca *CA) (credentials.TransportCredentials, error) {
+	if cfg.TLSCertFile == "" || cfg.TLSKeyFile == "" {
+		return nil, fmt.Errorf("GRPC_TLS_CERT_FILE and GRPC_TLS_KEY_FILE are required when TLS is enabled")
+	}
+
+	cert, err := tls.LoadX509KeyPair(cfg.TLSCertFile, cfg.TLSKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load server certificate: %w", err)
+	}
+
+	pool := x509.NewCertPool()
+	if ca != nil {
+		pool.AddCert(ca.Certificate())
+	}
+	if cfg.TLSClientCAFile != "" {
+		pem, err := os.ReadFile(cfg.TLSClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read client CA: %w", err)
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", cfg.TLSClientCAFile)
+		}
+	}
+	if ca == nil && cfg.TLSClientCAFile == "" {
+		return nil, fmt.Errorf("GRPC_CA_CERT_FILE or GRPC_TLS_CLIENT_CA_FILE is required when TLS is enabled")
+	}
+
+	return credentials.NewTLS(&tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   tls.VerifyClientCertIfGiven,
+		ClientCAs:    pool,
+		MinVersion:   tls.VersionTLS12,
+	}), nil
+}
+
+// identityFromCert derives the agent identity from the URI SAN spiffe://<trustDomain>/agent/<name>
+// of a verified client certificate
+func identityFromCert(cert *x509.Certificate, trustDomain string) (*Identity, error) {
+	for _, uri := range cert.URIs {
+		if uri.Scheme != "spiffe" || uri.Host != trustDomain {
+			continue
+		}
+		name := strings.TrimPrefix(uri.Path, "/agent/")
+		if name == uri.Path || name == "" || strings.Contains(name, "/") {
+			continue
+		}
+
+		sum := sha256.Sum256(cert.Raw)
+		return &Identity{
+			Name:        name,
+			Fingerprint: hex.EncodeToString(sum[:]),
+			Serial:      cert.SerialNumber.Text(16),
+			ExpiresAt:   cert.NotAfter,
+		}, nil
+	}
+
+	return nil, fmt.Errorf("certificate has no spiffe://%s/agent/<name> URI SAN", trustDomain)
+}
+
+// authenticate returns the identity of the caller's client certificate, rejecting revoked ones.
+// It returns nil if the caller has no certificate.
+func (s *Server) authenticate(ctx context.Context) (*Identity, error) {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return nil, status.Error(codes.Unauthenticated, "no peer information")
+	}
+	info, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(info.State.VerifiedChains) == 0 || len(info.State.VerifiedChains[0]) == 0 {
+		return nil, nil
+	}
+
+	identity, err := identityFromCert(info.State.VerifiedChains[0][0], s.config.TrustDomain)
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+
+	revoked, err := s.agents.IsCertificateRevoked(ctx, identity.Fingerprint)
+	if err != nil {
+		s.logger.Error("Failed to check certificate revocation", zap.Error(err))
+		return nil, status.Error(codes.Internal, "failed to check certificate")
+	}
+	if revoked {
+		s.logger.Warn("Rejected revoked agent certificate",
+			zap.String("agent", identity.Name),
+			zap.String("serial", identity.Serial))
+		return nil, status.Error(codes.Unauthenticated, "certificate has been revoked")
+	}
+
+	return identity, nil
+}
+
+// authorize checks that a request only claims the caller's own identity: the name it registers
+// with must be the certificate's, and the agent_id it claims must be an agent bound to the
+// certificate by an earlier registration
+func (s *Server) authorize(ctx context.Context, identity *Identity, req interface{}) error {
+	if r, ok := req.(nameRequest); ok && r.GetName() != identity.Name {
+		return status.Errorf(codes.PermissionDenied, "certificate is for agent %q, not %q", identity.Name, r.GetName())
+	}
+
+	r, ok := req.(agentIDRequest)
+	if !ok {
+		return nil
+	}
+
+	id, err := strconv.ParseUint(r.GetAgentId(), 10, 64)
+	if err != nil || id == 0 {
+		return status.Errorf(codes.InvalidArgument, "invalid agent_id %q", r.GetAgentId())
+	}
+	agent, err := s.agents.GetByID(ctx, uint(id))
+	if err != nil {
+		if errors.Is(err, database.ErrNotFound) {
+			return status.Errorf(codes.PermissionDenied, "agent %d is not registered", id)
+		}
+		return status.Error(codes.Internal, "failed to get agent")
+	}
+	if agent.Name != identity.Name || agent.CertFingerprint != identity.Fingerprint {
+		s.logger.Warn("Rejected call for another agent",
+			zap.String("caller", identity.Name),
+			zap.Uint("agent_id", agent.ID))
+		return status.Errorf(codes.PermissionDenied, "certificate is not bound to agent %d", id)
+	}
+
+	return nil
+}
+
+// unaryInterceptor authenticates the caller of unary calls and authorizes their requests. Callers
+// without a certificate may only enroll.
+func (s *Server) unaryInterceptor(ctx context.Context, req interface{}, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	identity, err := s.authenticate(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if identity == nil {
+		if r, ok := req.(*pb.RegisterRequest); ok && r.EnrollmentToken != "" {
+			return handler(ctx, req)
+		}
+		return nil, errCertificateRequired
+	}
+	if err := s.authorize(ctx, identity, req); err != nil {
+		return nil, err
+	}
+
+	return handler(context.WithValue(ctx, identityKey{}, identity), req)
+}
+
+// streamInterceptor authenticates the caller of streaming calls and authorizes every message
+// they send. The agent a stream's messages act for is looked up once; the certificate is checked
+// for revocation when the stream opens.
+func (s *Server) streamInterceptor(srv interface{}, stream grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	identity, err := s.authenticate(stream.Context())
+	if err != nil {
+		return err
+	}
+	if identity == nil {
+		return errCertificateRequired
+	}
+
+	return handler(srv, &authorizedStream{
+		ServerStream: stream,
+		server:       s,
+		identity:     identity,
+		ctx:          context.WithValue(stream.Context(), identityKey{}, identity),
+	})
+}
+
+// authorizedStream authorizes each message received on a stream
+type authorizedStream struct {
+	grpc.ServerStream
+	server   *Server
+	identity *Identity
+	ctx      context.Context
+	agentID  string // agent_id already authorized on this stream
+}
+
+// Context returns the stream context carrying the caller's identity
+func (s *authorizedStream) Context() context.Context {
+	return s.ctx
+}
+
+// RecvMsg receives a message and rejects it if it claims another agent's identity. Messages for
+// the agent authorized earlier on the stream aren't looked up again.
+func (s *authorizedStream) RecvMsg(m interface{}) error {
+	if err := s.ServerStream.RecvMsg(m); err != nil {
+		return err
+	}
+
+	r, ok := m.(agentIDRequest)
+	if _, named := m.(nameRequest); ok && !named && s.agentID != "" && r.GetAgentId() == s.agentID {
+		return nil
+	}
+	if err := s.server.authorize(s.ctx, s.identity, m); err != nil {
+		return err
+	}
+	if ok {
+		s.agentID = r.GetAgentId()
+	}
+	return nil
+}
@@ -0,0 +1,253 @@
+package agentserver
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"math/big"
+	"net/url"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	pb "github.com/BogdanDolia/ops-butler/api/proto/agent"
+	"github.com/BogdanDolia/ops-butler/internal/config"
+	"github.com/BogdanDolia/ops-butler/internal/models"
+)
+
+const testTrustDomain = "ops-butler.test"
+
+// newAgentCert creates a self-signed client certificate for the agent name
+func newAgentCert(t *testing.T, name string) *x509.Certificate {
+	t.Helper()
+	pub, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(time.Hour),
+		URIs:         []*url.URL{{Scheme: "spiffe", Host: testTrustDomain, Path: "/agent/" + name}},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, pub, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+// peerContext returns a context of a caller that presented the verified certificate, if any
+func peerContext(cert *x509.Certificate) context.Context {
+	info := credentials.TLSInfo{}
+	if cert != nil {
+		info.State = tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}
+	}
+	return peer.NewContext(context.Background(), &peer.Peer{AuthInfo: info})
+}
+
+// newAuthServer creates a server whose agents web-1 and web-2 are bound to their own certificates
+func newAuthServer(t *testing.T) (*Server, *fakeAgents, map[string]*x509.Certificate) {
+	t.Helper()
+	agents := &fakeAgents{}
+	certs := make(map[string]*x509.Certificate)
+	for _, name := range []string{"web-1", "web-2"} {
+		certs[name] = newAgentCert(t, name)
+		identity, err := identityFromCert(certs[name], testTrustDomain)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := agents.Create(context.Background(), &models.ClusterAgent{Name: name, CertFingerprint: identity.Fingerprint}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s := &Server{
+		config: config.GRPCConfig{TrustDomain: testTrustDomain},
+		logger: zap.NewNop(),
+		agents: agents,
+	}
+	return s, agents, certs
+}
+
+func TestAuthenticate(t *testing.T) {
+	s, agents, certs := newAuthServer(t)
+	revoked := newAgentCert(t, "web-1")
+	revokedIdentity, err := identityFromCert(revoked, testTrustDomain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	agents.revoked = map[string]bool{revokedIdentity.Fingerprint: true}
+	otherDomain := newAgentCert(t, "web-1")
+	otherDomain.URIs[0].Host = "elsewhere.test"
+
+	tests := []struct {
+		name     string
+		cert     *x509.Certificate
+		wantName string
+		wantCode codes.Code
+	}{
+		{name: "bound certificate", cert: certs["web-1"], wantName: "web-1"},
+		{name: "no certificate", cert: nil},
+		{name: "revoked fingerprint", cert: revoked, wantCode: codes.Unauthenticated},
+		{name: "other trust domain", cert: otherDomain, wantCode: codes.Unauthenticated},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			identity, err := s.authenticate(peerContext(tt.cert))
+			if status.Code(err) != tt.wantCode {
+				t.Fatalf("authenticate() error = %v, want code %s", err, tt.wantCode)
+			}
+			var name string
+			if identity != nil {
+				name = identity.Name
+			}
+			if name != tt.wantName {
+				t.Errorf("authenticate() name = %q, want %q", name, tt.wantName)
+			}
+		})
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	s, agents, certs := newAuthServer(t)
+	identity, err := identityFromCert(certs["web-1"], testTrustDomain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// web-1's new certificate, before it registered with it
+	renewed, err := identityFromCert(newAgentCert(t, "web-1"), testTrustDomain)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		identity *Identity
+		req      interface{}
+		wantCode codes.Code
+	}{
+		{name: "own name", identity: identity, req: &pb.RegisterRequest{Name: "web-1"}},
+		{name: "name differs from SAN", identity: identity, req: &pb.RegisterRequest{Name: "web-2"}, wantCode: codes.PermissionDenied},
+		{name: "own agent_id", identity: identity, req: &pb.HeartbeatRequest{AgentId: "1"}},
+		{name: "mismatched agent_id", identity: identity, req: &pb.HeartbeatRequest{AgentId: "2"}, wantCode: codes.PermissionDenied},
+		{name: "unregistered agent_id", identity: identity, req: &pb.HeartbeatRequest{AgentId: "3"}, wantCode: codes.PermissionDenied},
+		{name: "invalid agent_id", identity: identity, req: &pb.HeartbeatRequest{AgentId: "web-1"}, wantCode: codes.InvalidArgument},
+		{name: "certificate not bound to the agent", identity: renewed, req: &pb.HeartbeatRequest{AgentId: "1"}, wantCode: codes.PermissionDenied},
+		{name: "no identity claimed", identity: identity, req: &pb.TaskStatusRequest{TaskId: "1"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := s.authorize(context.Background(), tt.identity, tt.req)
+			if status.Code(err) != tt.wantCode {
+				t.Errorf("authorize() error = %v, want code %s", err, tt.wantCode)
+			}
+		})
+	}
+
+	t.Run("revoked after binding", func(t *testing.T) {
+		agents.revoked = map[string]bool{identity.Fingerprint: true}
+		defer func() { agents.revoked = nil }()
+		if _, err := s.authenticate(peerContext(certs["web-1"])); status.Code(err) != codes.Unauthenticated {
+			t.Errorf("authenticate() error = %v, want code %s", err, codes.Unauthenticated)
+		}
+	})
+}
+
+// fakeServerStream receives the queued messages
+type fakeServerStream struct {
+	grpc.ServerStream
+	messages []*pb.OutputMessage
+}
+
+func (s *fakeServerStream) Context() context.Context {
+	return context.Background()
+}
+
+func (s *fakeServerStream) RecvMsg(m interface{}) error {
+	if len(s.messages) == 0 {
+		return io.EOF
+	}
+	m.(*pb.OutputMessage).AgentId = s.messages[0].AgentId
+	s.messages = s.messages[1:]
+	return nil
+}
+
+func TestAuthorizedStreamRecvMsg(t *testing.T) {
+	s, agents, certs := newAuthServer(t)
+	identity, err := identityFromCert(certs["web-1"], testTrustDomain)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		agentIDs    []string
+		wantCodes   []codes.Code
+		wantLookups int
+	}{
+		{name: "own agent", agentIDs: []string{"1", "1", "1"}, wantCodes: []codes.Code{codes.OK, codes.OK, codes.OK}, wantLookups: 1},
+		{name: "another agent first", agentIDs: []string{"2"}, wantCodes: []codes.Code{codes.PermissionDenied}, wantLookups: 1},
+		{name: "switches to another agent", agentIDs: []string{"1", "2"}, wantCodes: []codes.Code{codes.OK, codes.PermissionDenied}, wantLookups: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			agents.lookups = 0
+			inner := &fakeServerStream{}
+			for _, id := range tt.agentIDs {
+				inner.messages = append(inner.messages, &pb.OutputMessage{AgentId: id})
+			}
+			stream := &authorizedStream{ServerStream: inner, server: s, identity: identity, ctx: context.Background()}
+
+			for i, want := range tt.wantCodes {
+				if err := stream.RecvMsg(&pb.OutputMessage{}); status.Code(err) != want {
+					t.Fatalf("RecvMsg() #%d error = %v, want code %s", i+1, err, want)
+				}
+			}
+			if agents.lookups != tt.wantLookups {
+				t.Errorf("agent lookups = %d, want %d", agents.lookups, tt.wantLookups)
+			}
+		})
+	}
+}
+
+func TestNewServerRequiresTLSOrInsecure(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     config.GRPCConfig
+		wantErr bool
+	}{
+		{name: "neither TLS nor insecure", cfg: config.GRPCConfig{}, wantErr: true},
+		{name: "explicitly insecure", cfg: config.GRPCConfig{Insecure: true}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewServer(tt.cfg, zap.NewNop(), nil, nil)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("NewServer() error = %v, wantErr %t", err, tt.wantErr)
+			}
+		})
+	}
+}
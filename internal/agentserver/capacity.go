@@ -0,0 +1,76 @@
+package agentserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/BogdanDolia/ops-butler/internal/capabilities"
+	"github.com/BogdanDolia/ops-butler/internal/models"
+)
+
+// ErrNoAgent is returned when no online agent carries the labels and meets the requirements a task
+// needs
+var ErrNoAgent = errors.New("no online agent matches")
+
+// SelectAgent picks the agent to run a task on among the online agents carrying all the given
+// labels and meeting all requirements of the task's template: the least loaded one relative to its
+// capacity, as of their last heartbeats. Busy agents still queue tasks, so one is only avoided
+// while another has room.
+func (s *Server) SelectAgent(ctx context.Context, selector map[string]string, requirements []string) (*models.ClusterAgent, error) {
+	if err := capabilities.Validate(requirements); err != nil {
+		return nil, err
+	}
+
+	const pageSize = 100
+	cutoff := time.Now().Add(-s.config.AgentTimeout)
+
+	var best *models.ClusterAgent
+	var bestLoad float64
+	for offset := 0; ; offset += pageSize {
+		agents, err := s.agents.List(ctx, offset, pageSize)
+		if err != nil {
+			return nil, err
+		}
+		for _, agent := range agents {
+			if agent.LastHeartbeat.Before(cutoff) || !hasLabels(agent.Labels, selector) {
+				continue
+			}
+			if unmet, _ := capabilities.Unmet(requirements, capabilities.FromMap(agent.Capabilities)); len(unmet) > 0 {
+				continue
+			}
+			if load := agentLoad(agent); best == nil || load < bestLoad {
+				best, bestLoad = agent, load
+			}
+		}
+		if len(agents) < pageSize {
+			break
+		}
+	}
+
+	if best == nil {
+		return nil, fmt.Errorf("%w %v %v", ErrNoAgent, selector, requirements)
+	}
+	return best, nil
+}
+
+// agentLoad returns an agent's running and queued tasks per slot. Agents that don't report their
+// capacity count as having one slot.
+func agentLoad(agent *models.ClusterAgent) float64 {
+	capacity := agent.Capacity
+	if capacity < 1 {
+		capacity = 1
+	}
+	return float64(agent.RunningTasks+agent.QueuedTasks) / float64(capacity)
+}
+
+// hasLabels reports whether an agent carries all labels of a selector
+func hasLabels(labels models.JSONSchema, selector map[string]string) bool {
+	for key, want := range selector {
+		if value, ok := labels[key]; !ok || fmt.Sprint(value) != want {
+			return false
+		}
+	}
+	return true
+}
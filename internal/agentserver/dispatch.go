@@ -0,0 +1,247 @@
+package agentserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"time"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pb "github.com/BogdanDolia/ops-butler/api/proto/agent"
+	"github.com/BogdanDolia/ops-butler/internal/database"
+	"github.com/BogdanDolia/ops-butler/internal/models"
+)
+
+// calendarRecheckInterval is how long a task blocked by a calendar without a known end waits
+// before it is checked again
+const calendarRecheckInterval = time.Minute
+
+// taskStream is an agent's open task stream
+type taskStream struct {
+	stream pb.AgentService_ReceiveTasksServer
+}
+
+// ReceiveTasks holds an agent's task stream open so the server can dispatch tasks to the agent.
+// An agent that reconnects replaces its previous stream.
+func (s *Server) ReceiveTasks(stream pb.AgentService_ReceiveTasksServer) error {
+	hello, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	id, err := strconv.ParseUint(hello.AgentId, 10, 64)
+	if err != nil || id == 0 {
+		return status.Errorf(codes.InvalidArgument, "invalid agent_id %q", hello.AgentId)
+	}
+	agentID := uint(id)
+
+	ts := &taskStream{stream: stream}
+	s.taskStreamsMutex.Lock()
+	s.taskStreams[agentID] = ts
+	s.taskStreamsMutex.Unlock()
+	defer func() {
+		s.taskStreamsMutex.Lock()
+		if s.taskStreams[agentID] == ts {
+			delete(s.taskStreams, agentID)
+		}
+		s.taskStreamsMutex.Unlock()
+	}()
+
+	// Agents send nothing after the hello; this only notices the stream ending
+	for {
+		if _, err := stream.Recv(); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+	}
+}
+
+// dispatchLoop sends ready tasks to agents every GRPCConfig.DispatchInterval until the server stops
+func (s *Server) dispatchLoop() {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(s.config.DispatchInterval)
+	defer ticker.Stop()
+
+	held := make(map[uint]time.Time) // tasks blocked by a calendar, until they are checked again
+	for {
+		select {
+		case <-ticker.C:
+			s.dispatchReady(held)
+		case <-s.stopCh:
+			return
+		}
+	}
+}
+
+// dispatchReady dispatches every task that is ready, i.e. pending without a due time, by
+// priority. Tasks that no agent can take yet stay ready and are tried again on the next round.
+func (s *Server) dispatchReady(held map[uint]time.Time) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.config.DispatchInterval*10)
+	defer cancel()
+
+	const pageSize = 100
+	var ready []*models.TaskInstance
+	for offset := 0; ; offset += pageSize {
+		tasks, err := s.tasks.ListReady(ctx, offset, pageSize)
+		if err != nil {
+			s.logger.Error("Failed to list ready tasks", zap.Error(err))
+			return
+		}
+		ready = append(ready, tasks...)
+		if len(tasks) < pageSize {
+			break
+		}
+	}
+
+	// Break-glass tasks go out first, then interactive ones, then the rest, oldest first
+	sort.SliceStable(ready, func(i, j int) bool { return priority(ready[i]) > priority(ready[j]) })
+
+	now := time.Now()
+	stillReady := make(map[uint]bool, len(ready))
+	for _, task := range ready {
+		stillReady[task.ID] = true
+		if until, ok := held[task.ID]; ok && now.Before(until) {
+			continue
+		}
+		delete(held, task.ID)
+
+		next, err := s.dispatch(ctx, task)
+		if err != nil {
+			s.logger.Error("Failed to dispatch task", zap.Uint("task_id", task.ID), zap.Error(err))
+			continue
+		}
+		if next != nil {
+			held[task.ID] = *next
+		}
+	}
+	for id := range held {
+		if !stillReady[id] {
+			delete(held, id)
+		}
+	}
+}
+
+// dispatch sends a ready task to the least loaded agent that may run it, as a request built by
+// NewTaskRequest. A task that a calendar blocks is held back; the time it should be checked again
+// is returned. A task whose template's script doesn't match its approval fails.
+func (s *Server) dispatch(ctx context.Context, task *models.TaskInstance) (*time.Time, error) {
+	template, err := s.templates.GetByID(ctx, task.TemplateID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get template: %w", err)
+	}
+
+	// A script changed after it was approved, e.g. in the database, must not reach an agent
+	if err := s.CheckApproval(template, time.Now()); err != nil {
+		return nil, s.refuse(ctx, task, fmt.Errorf("template %s: %w", template.Name, err))
+	}
+
+	agent, err := s.SelectAgent(ctx, stringLabels(template.AgentSelector), template.Requirements)
+	if errors.Is(err, ErrNoAgent) {
+		s.logger.Debug("No agent for ready task", zap.Uint("task_id", task.ID), zap.Error(err))
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to select agent: %w", err)
+	}
+
+	s.taskStreamsMutex.Lock()
+	ts := s.taskStreams[agent.ID]
+	s.taskStreamsMutex.Unlock()
+	if ts == nil {
+		s.logger.Debug("Selected agent has no task stream open",
+			zap.Uint("task_id", task.ID),
+			zap.Uint("agent_id", agent.ID))
+		return nil, nil
+	}
+
+	// Calendars are checked against the agent the task would run on; break-glass runs were
+	// authorized when they were started
+	if task.BreakGlassBy == nil {
+		candidate := *task
+		candidate.AgentID = &agent.ID
+		now := time.Now()
+		decision, err := s.guard.Check(ctx, &candidate, now)
+		if err != nil {
+			return nil, fmt.Errorf("failed to check maintenance calendars: %w", err)
+		}
+		if !decision.Allowed {
+			next := now.Add(calendarRecheckInterval)
+			if decision.NextAllowed != nil {
+				next = *decision.NextAllowed
+			}
+			s.logger.Info("Holding ready task outside allowed window",
+				zap.Uint("task_id", task.ID),
+				zap.String("calendar", decision.Calendar),
+				zap.String("reason", decision.Reason),
+				zap.Time("recheck_at", next))
+			return &next, nil
+		}
+	}
+
+	req, err := s.NewTaskRequest(task, template, int32(s.config.TaskTimeout/time.Second))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.tasks.Claim(ctx, task, agent.ID, time.Now()); err != nil {
+		if errors.Is(err, database.ErrNotFound) {
+			return nil, nil // dispatched or cancelled meanwhile
+		}
+		return nil, fmt.Errorf("failed to claim task: %w", err)
+	}
+
+	if err := ts.stream.Send(req); err != nil {
+		s.logger.Warn("Failed to send task to agent; releasing it",
+			zap.Uint("task_id", task.ID),
+			zap.Uint("agent_id", agent.ID),
+			zap.Error(err))
+		if err := s.tasks.Release(ctx, task); err != nil {
+			return nil, fmt.Errorf("failed to release task: %w", err)
+		}
+		return nil, nil
+	}
+
+	// Until its next heartbeat, count the task against the agent so the next one goes elsewhere
+	// if this agent is now the busier one
+	if err := s.agents.AddQueuedTasks(ctx, agent.ID, 1); err != nil {
+		s.logger.Warn("Failed to count dispatched task", zap.Uint("agent_id", agent.ID), zap.Error(err))
+	}
+
+	s.logger.Info("Dispatched task",
+		zap.Uint("task_id", task.ID),
+		zap.String("template", template.Name),
+		zap.Uint("agent_id", agent.ID),
+		zap.Int32("priority", req.Priority),
+		zap.Bool("approved", len(req.Signature) > 0))
+	return nil, nil
+}
+
+// refuse fails a ready task that must not be dispatched
+func (s *Server) refuse(ctx context.Context, task *models.TaskInstance, reason error) error {
+	s.logger.Error("Refusing to dispatch task", zap.Uint("task_id", task.ID), zap.Error(reason))
+
+	now := time.Now()
+	task.State = models.TaskStateFailed
+	task.CompletedAt = &now
+	if err := s.tasks.Update(ctx, task); err != nil {
+		return fmt.Errorf("failed to fail task: %w", err)
+	}
+	return nil
+}
+
+// stringLabels converts an agent selector stored on a template to labels
+func stringLabels(selector models.JSONSchema) map[string]string {
+	labels := make(map[string]string, len(selector))
+	for key, value := range selector {
+		labels[key] = fmt.Sprint(value)
+	}
+	return labels
+}
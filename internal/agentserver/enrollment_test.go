@@ -0,0 +1,278 @@
+package agentserver
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pb "github.com/BogdanDolia/ops-butler/api/proto/agent"
+	"github.com/BogdanDolia/ops-butler/internal/config"
+	"github.com/BogdanDolia/ops-butler/internal/models"
+)
+
+// newTestCA creates a built-in CA in a temporary directory
+func newTestCA(t *testing.T) *CA {
+	t.Helper()
+	dir := t.TempDir()
+	ca, err := createCA(filepath.Join(dir, "ca.pem"), filepath.Join(dir, "ca-key.pem"), testTrustDomain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ca
+}
+
+// newTestCSR returns a PEM certificate signing request for the agent name
+func newTestCSR(t *testing.T, name string) []byte {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{Subject: pkix.Name{CommonName: name}}, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der})
+}
+
+// issue signs a certificate for the agent name with the CA and returns its identity
+func issue(t *testing.T, ca *CA, name string) *Identity {
+	t.Helper()
+	_, cert, err := ca.Sign(newTestCSR(t, name), name, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	identity, err := identityFromCert(cert, testTrustDomain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return identity
+}
+
+// parseIdentity returns the identity of a PEM certificate
+func parseIdentity(t *testing.T, certPEM []byte) *Identity {
+	t.Helper()
+	block, _ := pem.Decode(certPEM)
+	if block == nil {
+		t.Fatal("no PEM certificate")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	identity, err := identityFromCert(cert, testTrustDomain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return identity
+}
+
+func TestRegisterBindsOneCertificate(t *testing.T) {
+	ca := newTestCA(t)
+	bound := issue(t, ca, "web-1")
+	other := issue(t, ca, "web-1")
+
+	tests := []struct {
+		name     string
+		calls    []*Identity
+		revoke   bool // revoke the bound certificate before the last call
+		wantCode codes.Code
+		want     *Identity
+	}{
+		{name: "first registration binds", calls: []*Identity{bound}, want: bound},
+		{name: "same certificate again", calls: []*Identity{bound, bound}, want: bound},
+		{name: "another certificate for the name", calls: []*Identity{bound, other}, wantCode: codes.PermissionDenied, want: bound},
+		{name: "another certificate after revocation", calls: []*Identity{bound, other}, revoke: true, want: other},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			agents := &fakeAgents{}
+			s := &Server{config: config.GRPCConfig{TrustDomain: testTrustDomain}, logger: zap.NewNop(), agents: agents}
+
+			var err error
+			for i, identity := range tt.calls {
+				if tt.revoke && i == len(tt.calls)-1 {
+					agent := agents.agents[0]
+					if err := agents.RevokeCertificate(context.Background(), &models.RevokedCertificate{AgentID: agent.ID, Fingerprint: agent.CertFingerprint}); err != nil {
+						t.Fatal(err)
+					}
+				}
+				ctx := context.WithValue(context.Background(), identityKey{}, identity)
+				_, err = s.Register(ctx, &pb.RegisterRequest{Name: "web-1"})
+			}
+			if status.Code(err) != tt.wantCode {
+				t.Fatalf("Register() error = %v, want code %s", err, tt.wantCode)
+			}
+			if got := agents.agents[0].CertFingerprint; got != tt.want.Fingerprint {
+				t.Errorf("bound fingerprint = %s, want %s", got, tt.want.Fingerprint)
+			}
+		})
+	}
+}
+
+func TestRenewCertificateRevokesPrevious(t *testing.T) {
+	ca := newTestCA(t)
+	old := issue(t, ca, "web-1")
+	agents := &fakeAgents{}
+	s := &Server{config: config.GRPCConfig{TrustDomain: testTrustDomain, AgentCertTTL: time.Hour}, logger: zap.NewNop(), agents: agents, ca: ca}
+
+	ctx := context.WithValue(context.Background(), identityKey{}, old)
+	if _, err := s.Register(ctx, &pb.RegisterRequest{Name: "web-1"}); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := s.RenewCertificate(ctx, &pb.RenewCertificateRequest{AgentId: "1", Csr: newTestCSR(t, "web-1")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	renewed := parseIdentity(t, resp.Certificate)
+
+	if got := agents.agents[0].CertFingerprint; got != renewed.Fingerprint {
+		t.Errorf("bound fingerprint = %s, want the renewed %s", got, renewed.Fingerprint)
+	}
+	if !agents.revoked[old.Fingerprint] {
+		t.Error("previous certificate was not revoked")
+	}
+	if agents.revoked[renewed.Fingerprint] {
+		t.Error("renewed certificate was revoked")
+	}
+	// The old certificate can neither authorize calls nor register again
+	if err := s.authorize(ctx, old, &pb.HeartbeatRequest{AgentId: "1"}); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("authorize() with the old certificate error = %v, want code %s", err, codes.PermissionDenied)
+	}
+	if _, err := s.Register(ctx, &pb.RegisterRequest{Name: "web-1"}); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("Register() with the old certificate error = %v, want code %s", err, codes.PermissionDenied)
+	}
+}
+
+func TestCheckLabels(t *testing.T) {
+	allowed := models.JSONSchema{"env": "prod", "team": AnyLabelValue}
+
+	tests := []struct {
+		name    string
+		allowed models.JSONSchema
+		claimed map[string]string
+		wantErr bool
+	}{
+		{"not enrolled", nil, map[string]string{"env": "anything"}, false},
+		{"allowed value", allowed, map[string]string{"env": "prod"}, false},
+		{"any value", allowed, map[string]string{"team": "payments"}, false},
+		{"fewer labels", allowed, nil, false},
+		{"other value", allowed, map[string]string{"env": "dev"}, true},
+		{"other label", allowed, map[string]string{"region": "eu"}, true},
+		{"enrolled without labels", models.JSONSchema{}, map[string]string{"env": "prod"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkLabels(tt.allowed, tt.claimed)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("checkLabels() error = %v, wantErr %t", err, tt.wantErr)
+			}
+			if err != nil && status.Code(err) != codes.PermissionDenied {
+				t.Errorf("checkLabels() error code = %s, want %s", status.Code(err), codes.PermissionDenied)
+			}
+		})
+	}
+}
+
+func TestEnroll(t *testing.T) {
+	ca := newTestCA(t)
+	now := time.Now()
+	used := now.Add(-time.Minute)
+
+	tests := []struct {
+		name        string
+		token       models.EnrollmentToken
+		secret      string // sent instead of the token's own secret if set
+		agent       string
+		labels      map[string]string
+		wantCode    codes.Code
+		wantConsume bool
+	}{
+		{name: "enrolls", agent: "web-1", labels: map[string]string{"env": "prod", "team": "payments"}, wantConsume: true},
+		{name: "unknown token", secret: "guess", agent: "web-1", wantCode: codes.Unauthenticated},
+		{name: "used token", token: models.EnrollmentToken{UsedAt: &used}, agent: "web-1", wantCode: codes.Unauthenticated},
+		{name: "expired token", token: models.EnrollmentToken{ExpiresAt: now.Add(-time.Second)}, agent: "web-1", wantCode: codes.Unauthenticated},
+		{name: "another agent", agent: "web-2", wantCode: codes.PermissionDenied},
+		{name: "label not allowed", agent: "web-1", labels: map[string]string{"region": "eu"}, wantCode: codes.PermissionDenied},
+		{name: "label value not allowed", agent: "web-1", labels: map[string]string{"env": "dev"}, wantCode: codes.PermissionDenied},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			secret, hash, err := NewEnrollmentToken()
+			if err != nil {
+				t.Fatal(err)
+			}
+			token := tt.token
+			token.ID = 1
+			token.TokenHash = hash
+			token.Name = "web-1"
+			token.AllowedLabels = models.JSONSchema{"env": "prod", "team": AnyLabelValue}
+			if token.ExpiresAt.IsZero() {
+				token.ExpiresAt = now.Add(time.Hour)
+			}
+			if tt.secret != "" {
+				secret = tt.secret
+			}
+			tokens := &fakeTokens{tokens: []*models.EnrollmentToken{&token}}
+			agents := &fakeAgents{}
+			s := &Server{
+				config: config.GRPCConfig{TrustDomain: testTrustDomain, AgentCertTTL: time.Hour},
+				logger: zap.NewNop(),
+				agents: agents,
+				tokens: tokens,
+				ca:     ca,
+			}
+
+			resp, err := s.Register(context.Background(), &pb.RegisterRequest{
+				Name:            tt.agent,
+				Labels:          tt.labels,
+				EnrollmentToken: secret,
+				Csr:             newTestCSR(t, tt.agent),
+			})
+			if status.Code(err) != tt.wantCode {
+				t.Fatalf("Register() error = %v, want code %s", err, tt.wantCode)
+			}
+			if consumed := token.UsedAt != nil && token.UsedAt != tt.token.UsedAt; consumed != tt.wantConsume {
+				t.Errorf("token consumed = %t, want %t", consumed, tt.wantConsume)
+			}
+			if err != nil {
+				if len(agents.agents) != 0 {
+					t.Error("rejected enrollment saved an agent")
+				}
+				return
+			}
+
+			identity := parseIdentity(t, resp.Certificate)
+			agent := agents.agents[0]
+			if agent.CertFingerprint != identity.Fingerprint {
+				t.Errorf("bound fingerprint = %s, want the issued %s", agent.CertFingerprint, identity.Fingerprint)
+			}
+
+			// The token is single-use, and the enrolled agent keeps to its labels afterwards
+			if _, err := s.Register(context.Background(), &pb.RegisterRequest{Name: "web-1", EnrollmentToken: secret, Csr: newTestCSR(t, "web-1")}); status.Code(err) != codes.Unauthenticated {
+				t.Errorf("Register() with the used token error = %v, want code %s", err, codes.Unauthenticated)
+			}
+			ctx := context.WithValue(context.Background(), identityKey{}, identity)
+			if _, err := s.Register(ctx, &pb.RegisterRequest{Name: "web-1", Labels: map[string]string{"env": "dev"}}); status.Code(err) != codes.PermissionDenied {
+				t.Errorf("Register() claiming another label value error = %v, want code %s", err, codes.PermissionDenied)
+			}
+			if _, err := s.Register(ctx, &pb.RegisterRequest{Name: "web-1", Labels: map[string]string{"team": "search"}}); err != nil {
+				t.Errorf("Register() claiming an allowed label error = %v", err)
+			}
+		})
+	}
+}
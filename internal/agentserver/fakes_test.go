@@ -0,0 +1,197 @@
+package agentserver
+
+import (
+	"context"
+	"time"
+
+	pb "github.com/BogdanDolia/ops-butler/api/proto/agent"
+	"github.com/BogdanDolia/ops-butler/internal/database"
+	"github.com/BogdanDolia/ops-butler/internal/models"
+)
+
+// fakeAgents keeps agents and revoked certificates in memory and counts lookups by ID
+type fakeAgents struct {
+	database.AgentRepository
+	agents  []*models.ClusterAgent
+	revoked map[string]bool
+	lookups int
+}
+
+func (r *fakeAgents) Create(ctx context.Context, agent *models.ClusterAgent) error {
+	agent.ID = uint(len(r.agents) + 1)
+	r.agents = append(r.agents, agent)
+	return nil
+}
+
+func (r *fakeAgents) GetByID(ctx context.Context, id uint) (*models.ClusterAgent, error) {
+	r.lookups++
+	for _, agent := range r.agents {
+		if agent.ID == id {
+			copied := *agent
+			return &copied, nil
+		}
+	}
+	return nil, database.ErrNotFound
+}
+
+func (r *fakeAgents) GetByName(ctx context.Context, name string) (*models.ClusterAgent, error) {
+	for _, agent := range r.agents {
+		if agent.Name == name {
+			copied := *agent
+			return &copied, nil
+		}
+	}
+	return nil, database.ErrNotFound
+}
+
+func (r *fakeAgents) List(ctx context.Context, offset, limit int) ([]*models.ClusterAgent, error) {
+	if offset >= len(r.agents) {
+		return nil, nil
+	}
+	return r.agents[offset:min(offset+limit, len(r.agents))], nil
+}
+
+func (r *fakeAgents) Update(ctx context.Context, agent *models.ClusterAgent) error {
+	for i, existing := range r.agents {
+		if existing.ID == agent.ID {
+			copied := *agent
+			r.agents[i] = &copied
+			return nil
+		}
+	}
+	return database.ErrNotFound
+}
+
+func (r *fakeAgents) AddQueuedTasks(ctx context.Context, id uint, n int) error {
+	for _, agent := range r.agents {
+		if agent.ID == id {
+			agent.QueuedTasks += n
+		}
+	}
+	return nil
+}
+
+func (r *fakeAgents) RevokeCertificate(ctx context.Context, revocation *models.RevokedCertificate) error {
+	if r.revoked == nil {
+		r.revoked = make(map[string]bool)
+	}
+	r.revoked[revocation.Fingerprint] = true
+	for _, agent := range r.agents {
+		if agent.ID == revocation.AgentID && agent.CertFingerprint == revocation.Fingerprint {
+			agent.CertFingerprint, agent.CertSerial, agent.CertExpiresAt = "", "", nil
+		}
+	}
+	return nil
+}
+
+func (r *fakeAgents) IsCertificateRevoked(ctx context.Context, fingerprint string) (bool, error) {
+	return r.revoked[fingerprint], nil
+}
+
+// fakeTokens keeps enrollment tokens in memory
+type fakeTokens struct {
+	database.EnrollmentTokenRepository
+	tokens []*models.EnrollmentToken
+}
+
+func (r *fakeTokens) GetByHash(ctx context.Context, tokenHash string) (*models.EnrollmentToken, error) {
+	for _, token := range r.tokens {
+		if token.TokenHash == tokenHash {
+			copied := *token
+			return &copied, nil
+		}
+	}
+	return nil, database.ErrNotFound
+}
+
+func (r *fakeTokens) Consume(ctx context.Context, id uint, now time.Time) error {
+	for _, token := range r.tokens {
+		if token.ID == id && token.UsedAt == nil && now.Before(token.ExpiresAt) {
+			token.UsedAt = &now
+			return nil
+		}
+	}
+	return database.ErrNotFound
+}
+
+// fakeTemplates serves templates by ID
+type fakeTemplates struct {
+	database.TemplateRepository
+	templates map[uint]*models.Template
+}
+
+func (r *fakeTemplates) GetByID(ctx context.Context, id uint) (*models.Template, error) {
+	template, ok := r.templates[id]
+	if !ok {
+		return nil, database.ErrNotFound
+	}
+	copied := *template
+	return &copied, nil
+}
+
+// fakeTasks records how tasks are claimed and updated
+type fakeTasks struct {
+	database.TaskRepository
+	claimed []uint
+	updated []*models.TaskInstance
+}
+
+func (r *fakeTasks) Claim(ctx context.Context, task *models.TaskInstance, agentID uint, now time.Time) error {
+	r.claimed = append(r.claimed, task.ID)
+	task.State = models.TaskStateRunning
+	task.AgentID = &agentID
+	return nil
+}
+
+func (r *fakeTasks) Release(ctx context.Context, task *models.TaskInstance) error {
+	task.State = models.TaskStatePending
+	task.AgentID = nil
+	return nil
+}
+
+func (r *fakeTasks) Update(ctx context.Context, task *models.TaskInstance) error {
+	copied := *task
+	r.updated = append(r.updated, &copied)
+	return nil
+}
+
+// fakeLogs keeps log rows in memory
+type fakeLogs struct {
+	database.ExecutionLogRepository
+	rows []*models.ExecutionLog
+}
+
+func (r *fakeLogs) Create(ctx context.Context, log *models.ExecutionLog) error {
+	r.rows = append(r.rows, log)
+	return nil
+}
+
+func (r *fakeLogs) LastSequence(ctx context.Context, taskID uint) (int, error) {
+	last := 0
+	for _, row := range r.rows {
+		if row.TaskID == taskID {
+			last = max(last, row.Sequence)
+		}
+	}
+	return last, nil
+}
+
+// fakeCalendars has no calendars
+type fakeCalendars struct {
+	database.CalendarRepository
+}
+
+func (r *fakeCalendars) ListEnabled(ctx context.Context) ([]*models.Calendar, error) {
+	return nil, nil
+}
+
+// fakeTaskStream records the task requests sent to an agent
+type fakeTaskStream struct {
+	pb.AgentService_ReceiveTasksServer
+	sent []*pb.ExecuteTaskRequest
+}
+
+func (s *fakeTaskStream) Send(req *pb.ExecuteTaskRequest) error {
+	s.sent = append(s.sent, req)
+	return nil
+}
@@ -0,0 +1,160 @@
+package agentserver
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	pb "github.com/BogdanDolia/ops-butler/api/proto/agent"
+	"github.com/BogdanDolia/ops-butler/internal/models"
+	"github.com/BogdanDolia/ops-butler/internal/params"
+	"github.com/BogdanDolia/ops-butler/internal/redact"
+	"github.com/BogdanDolia/ops-butler/internal/secrets"
+)
+
+// LogRecorder stores the output an agent streams for a task. The agent redacts its output already;
+// the recorder redacts it again before it is stored, in case the agent is outdated or
+// misconfigured, and records how many secrets both masked.
+type LogRecorder struct {
+	server   *Server
+	task     *models.TaskInstance
+	agentID  uint
+	redactor *redact.Redactor
+	streams  map[string]*redact.Stream
+	sequence int32 // last sequence recorded; agents redeliver output after reconnecting
+}
+
+// NewLogRecorder creates a recorder for a task running on an agent. The task's sealed secret params
+// are masked along with everything the redaction rules match. Output up to the last sequence
+// already stored for the task is skipped.
+func (s *Server) NewLogRecorder(ctx context.Context, task *models.TaskInstance, template *models.Template, agentID uint) (*LogRecorder, error) {
+	var values []string
+	for _, name := range params.SecretNames(template.ParamsSchema) {
+		value, ok := task.Params[name].(string)
+		if !ok || !secrets.IsSealed(value) {
+			continue
+		}
+		opened, err := s.keyring.Open(value)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open secret param %s: %w", name, err)
+		}
+		values = append(values, opened)
+	}
+
+	last, err := s.logs.LastSequence(ctx, task.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get last log sequence: %w", err)
+	}
+
+	return &LogRecorder{
+		server:   s,
+		task:     task,
+		agentID:  agentID,
+		redactor: redact.New(s.rules, values),
+		streams:  make(map[string]*redact.Stream),
+		sequence: int32(last),
+	}, nil
+}
+
+// Record redacts and stores a response streamed by the agent, ignoring ones it has recorded
+// before. On the final response it stores any partial lines held back, at most one row per stream
+// under the response's sequence, and finishes the task with its exit code and redaction counts.
+func (r *LogRecorder) Record(ctx context.Context, resp *pb.ExecuteTaskResponse) error {
+	// The final response may be redelivered after the task has finished if its ack was lost
+	if resp.Sequence <= r.sequence || (resp.Completed && r.task.ExitCode != nil) {
+		return nil
+	}
+	timestamp := time.Unix(resp.Timestamp, 0)
+
+	if r.task.State != models.TaskStateRunning && !finished(r.task.State) {
+		now := time.Now()
+		r.task.State = models.TaskStateRunning
+		r.task.StartedAt = &now
+		if err := r.server.tasks.Update(ctx, r.task); err != nil {
+			return fmt.Errorf("failed to start task: %w", err)
+		}
+	}
+
+	chunks := make(map[string]string)
+	if resp.Chunk != "" {
+		stream, ok := r.streams[resp.Stream]
+		if !ok {
+			stream = redact.NewStream(r.redactor)
+			r.streams[resp.Stream] = stream
+		}
+		chunks[resp.Stream] = stream.Write(resp.Chunk)
+	}
+	if resp.Completed {
+		for name, stream := range r.streams {
+			chunks[name] += stream.Flush()
+		}
+	}
+	for name, chunk := range chunks {
+		if err := r.store(ctx, name, chunk, int(resp.Sequence), timestamp); err != nil {
+			return err
+		}
+	}
+	if !resp.Completed {
+		r.sequence = resp.Sequence
+		return nil
+	}
+
+	counts := make(models.JSONSchema)
+	for rule, n := range resp.Redactions {
+		counts[rule] = int(n)
+	}
+	for rule, n := range r.redactor.Counts() {
+		previous, _ := counts[rule].(int)
+		counts[rule] = previous + n
+	}
+	r.task.Redactions = counts
+	if resp.CpuUsageMs > 0 || resp.PeakMemoryBytes > 0 {
+		cpu, memory := resp.CpuUsageMs, resp.PeakMemoryBytes
+		r.task.CPUUsageMs, r.task.PeakMemoryBytes = &cpu, &memory
+	}
+
+	exitCode := int(resp.ExitCode)
+	r.finish(timestamp, &exitCode, resp.ExitCode == 0 && resp.Error == "" && !resp.Rejected)
+	if err := r.server.tasks.Update(ctx, r.task); err != nil {
+		return fmt.Errorf("failed to finish task: %w", err)
+	}
+	r.sequence = resp.Sequence
+	return nil
+}
+
+// finish records the outcome of the task. A task cancelled meanwhile stays cancelled.
+func (r *LogRecorder) finish(at time.Time, exitCode *int, succeeded bool) {
+	r.task.ExitCode = exitCode
+	r.task.CompletedAt = &at
+	if r.task.State == models.TaskStateCancelled {
+		return
+	}
+	r.task.State = models.TaskStateFailed
+	if succeeded {
+		r.task.State = models.TaskStateCompleted
+	}
+}
+
+// finished reports whether a task is in a final state
+func finished(state models.TaskState) bool {
+	return state == models.TaskStateCompleted || state == models.TaskStateFailed || state == models.TaskStateCancelled
+}
+
+// store saves a redacted chunk as a log row
+func (r *LogRecorder) store(ctx context.Context, stream, chunk string, sequence int, timestamp time.Time) error {
+	if chunk == "" {
+		return nil
+	}
+	err := r.server.logs.Create(ctx, &models.ExecutionLog{
+		TaskID:    r.task.ID,
+		AgentID:   r.agentID,
+		Chunk:     chunk,
+		Timestamp: timestamp,
+		Stream:    stream,
+		Sequence:  sequence,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to store log chunk: %w", err)
+	}
+	return nil
+}
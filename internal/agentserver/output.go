@@ -0,0 +1,196 @@
+package agentserver
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strconv"
+	"time"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pb "github.com/BogdanDolia/ops-butler/api/proto/agent"
+	"github.com/BogdanDolia/ops-butler/internal/database"
+	"github.com/BogdanDolia/ops-butler/internal/models"
+)
+
+// StreamOutput receives the output an agent has spooled and acknowledges each response once it is
+// stored, so the agent can drop it. Responses are redelivered after a reconnect and deduplicated
+// by task and sequence. Each time an agent connects it is asked for the status of the tasks it is
+// believed to be running, which reconciles tasks that finished or vanished while it was away.
+func (s *Server) StreamOutput(stream pb.AgentService_StreamOutputServer) error {
+	ctx := stream.Context()
+
+	hello, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	id, err := strconv.ParseUint(hello.AgentId, 10, 64)
+	if err != nil || id == 0 {
+		return status.Errorf(codes.InvalidArgument, "invalid agent_id %q", hello.AgentId)
+	}
+	agentID := uint(id)
+
+	running, err := s.tasks.ListByAgentAndState(ctx, agentID, models.TaskStateRunning)
+	if err != nil {
+		s.logger.Error("Failed to list running tasks", zap.Uint("agent_id", agentID), zap.Error(err))
+		return status.Error(codes.Internal, "failed to list running tasks")
+	}
+	for _, task := range running {
+		req := &pb.TaskStatusRequest{TaskId: strconv.FormatUint(uint64(task.ID), 10)}
+		if err := stream.Send(&pb.OutputControl{StatusRequest: req}); err != nil {
+			return err
+		}
+	}
+
+	for {
+		msg, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case msg.Response != nil:
+			if err := s.recordOutput(ctx, agentID, msg.Response); err != nil {
+				if retryable(err) {
+					return err
+				}
+				// Output that can never be stored is acknowledged anyway, or the agent would
+				// redeliver it forever
+				s.logger.Warn("Discarded task output", zap.String("task_id", msg.Response.TaskId), zap.Error(err))
+			}
+			ack := &pb.OutputControl{TaskId: msg.Response.TaskId, AckedSequence: msg.Response.Sequence}
+			if err := stream.Send(ack); err != nil {
+				return err
+			}
+		case msg.Status != nil:
+			if err := s.reconcile(ctx, agentID, msg.Status); err != nil {
+				if retryable(err) {
+					return err
+				}
+				s.logger.Warn("Discarded task status", zap.String("task_id", msg.Status.TaskId), zap.Error(err))
+			}
+		}
+	}
+}
+
+// retryable reports whether an error storing output is temporary, so the agent should keep the
+// output and deliver it again
+func retryable(err error) bool {
+	return status.Code(err) == codes.Internal
+}
+
+// recordOutput stores a response with the recorder of its task
+func (s *Server) recordOutput(ctx context.Context, agentID uint, resp *pb.ExecuteTaskResponse) error {
+	recorder, err := s.recorder(ctx, agentID, resp.TaskId)
+	if err != nil {
+		return err
+	}
+	if err := recorder.Record(ctx, resp); err != nil {
+		s.logger.Error("Failed to record task output", zap.String("task_id", resp.TaskId), zap.Error(err))
+		return status.Error(codes.Internal, "failed to record task output")
+	}
+
+	if resp.Completed {
+		s.dropRecorder(recorder.task.ID)
+		s.logger.Info("Task finished on agent",
+			zap.Uint("task_id", recorder.task.ID),
+			zap.Uint("agent_id", agentID),
+			zap.String("state", string(recorder.task.State)))
+	}
+	return nil
+}
+
+// reconcile settles a task the server believes is running with the status the agent reports. A
+// finished task's final response has already been replayed ahead of the status, so only a task
+// whose final response was lost, or one the agent doesn't know at all, is settled here.
+func (s *Server) reconcile(ctx context.Context, agentID uint, reply *pb.TaskStatusResponse) error {
+	recorder, err := s.recorder(ctx, agentID, reply.TaskId)
+	if err != nil {
+		return err
+	}
+	if recorder.task.State != models.TaskStateRunning {
+		return nil
+	}
+
+	switch reply.Status {
+	case "queued", "running":
+		return nil
+	case "completed", "failed", "cancelled":
+		if reply.Status == "cancelled" {
+			recorder.task.State = models.TaskStateCancelled
+		}
+		exitCode := int(reply.ExitCode)
+		recorder.finish(time.Unix(reply.EndTime, 0), &exitCode, reply.Status == "completed")
+	default:
+		// The agent restarted without a trace of the task, so it will never report back
+		recorder.finish(time.Now(), nil, false)
+	}
+
+	if err := s.tasks.Update(ctx, recorder.task); err != nil {
+		s.logger.Error("Failed to reconcile task", zap.String("task_id", reply.TaskId), zap.Error(err))
+		return status.Error(codes.Internal, "failed to reconcile task")
+	}
+	s.dropRecorder(recorder.task.ID)
+
+	s.logger.Warn("Reconciled task with agent status",
+		zap.Uint("task_id", recorder.task.ID),
+		zap.Uint("agent_id", agentID),
+		zap.String("agent_status", reply.Status),
+		zap.String("state", string(recorder.task.State)))
+	return nil
+}
+
+// recorder returns the log recorder of a task, creating it on the task's first response. Recorders
+// outlive streams, so a task's partial lines and redaction counts carry over a reconnect.
+func (s *Server) recorder(ctx context.Context, agentID uint, taskID string) (*LogRecorder, error) {
+	id, err := strconv.ParseUint(taskID, 10, 64)
+	if err != nil || id == 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid task_id %q", taskID)
+	}
+
+	s.recordersMutex.Lock()
+	defer s.recordersMutex.Unlock()
+
+	if recorder, ok := s.recorders[uint(id)]; ok {
+		if recorder.agentID != agentID {
+			return nil, status.Errorf(codes.PermissionDenied, "task %d does not run on agent %d", id, agentID)
+		}
+		return recorder, nil
+	}
+
+	task, err := s.tasks.GetByID(ctx, uint(id))
+	if err != nil {
+		if errors.Is(err, database.ErrNotFound) {
+			return nil, status.Errorf(codes.NotFound, "task %d not found", id)
+		}
+		return nil, status.Error(codes.Internal, "failed to get task")
+	}
+	if task.AgentID == nil || *task.AgentID != agentID {
+		return nil, status.Errorf(codes.PermissionDenied, "task %d does not run on agent %d", id, agentID)
+	}
+	template, err := s.templates.GetByID(ctx, task.TemplateID)
+	if err != nil {
+		return nil, status.Error(codes.Internal, "failed to get task template")
+	}
+
+	recorder, err := s.NewLogRecorder(ctx, task, template, agentID)
+	if err != nil {
+		s.logger.Error("Failed to create log recorder", zap.Uint("task_id", task.ID), zap.Error(err))
+		return nil, status.Error(codes.Internal, "failed to record task output")
+	}
+	s.recorders[task.ID] = recorder
+	return recorder, nil
+}
+
+// dropRecorder forgets the recorder of a finished task
+func (s *Server) dropRecorder(taskID uint) {
+	s.recordersMutex.Lock()
+	delete(s.recorders, taskID)
+	s.recordersMutex.Unlock()
+}
@@ -0,0 +1,191 @@
+package agentserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pb "github.com/BogdanDolia/ops-butler/api/proto/agent"
+	"github.com/BogdanDolia/ops-butler/internal/models"
+	"github.com/BogdanDolia/ops-butler/internal/params"
+)
+
+var (
+	// ErrAgentUnavailable is returned when the agent to query has no query stream open
+	ErrAgentUnavailable = errors.New("agent is not connected")
+	// ErrQueryFailed is returned when an agent couldn't answer a query
+	ErrQueryFailed = errors.New("agent query failed")
+)
+
+// queryStream is an agent's open query stream with the queries waiting for an answer
+type queryStream struct {
+	stream    pb.AgentService_QueriesServer
+	sendMutex sync.Mutex
+	pending   map[string]chan *pb.QueryResult // by query ID
+	mu        sync.Mutex
+	done      chan struct{} // closed when the stream ends
+}
+
+// cachedOptions are the answer to a query, kept for GRPCConfig.OptionsCacheTTL
+type cachedOptions struct {
+	values  []string
+	expires time.Time
+}
+
+// Queries holds an agent's query stream open so the server can ask the agent about its cluster.
+// An agent that reconnects replaces its previous stream.
+func (s *Server) Queries(stream pb.AgentService_QueriesServer) error {
+	hello, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	id, err := strconv.ParseUint(hello.AgentId, 10, 64)
+	if err != nil || id == 0 {
+		return status.Errorf(codes.InvalidArgument, "invalid agent_id %q", hello.AgentId)
+	}
+	agentID := uint(id)
+
+	qs := &queryStream{
+		stream:  stream,
+		pending: make(map[string]chan *pb.QueryResult),
+		done:    make(chan struct{}),
+	}
+	s.queryStreamsMutex.Lock()
+	s.queryStreams[agentID] = qs
+	s.queryStreamsMutex.Unlock()
+	defer func() {
+		s.queryStreamsMutex.Lock()
+		if s.queryStreams[agentID] == qs {
+			delete(s.queryStreams, agentID)
+		}
+		s.queryStreamsMutex.Unlock()
+		close(qs.done)
+	}()
+
+	for {
+		result, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		qs.mu.Lock()
+		answer, ok := qs.pending[result.Id]
+		delete(qs.pending, result.Id)
+		qs.mu.Unlock()
+		if ok {
+			answer <- result
+		}
+	}
+}
+
+// query sends a query to an agent and waits for its answer, at most GRPCConfig.QueryTimeout
+func (s *Server) query(ctx context.Context, agentID uint, query *pb.Query) ([]string, error) {
+	s.queryStreamsMutex.Lock()
+	qs := s.queryStreams[agentID]
+	s.queryStreamsMutex.Unlock()
+	if qs == nil {
+		return nil, fmt.Errorf("%w: agent %d", ErrAgentUnavailable, agentID)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, s.config.QueryTimeout)
+	defer cancel()
+
+	query.Id = strconv.FormatUint(s.queryIDs.Add(1), 10)
+	answer := make(chan *pb.QueryResult, 1)
+	qs.mu.Lock()
+	qs.pending[query.Id] = answer
+	qs.mu.Unlock()
+	defer func() {
+		qs.mu.Lock()
+		delete(qs.pending, query.Id)
+		qs.mu.Unlock()
+	}()
+
+	qs.sendMutex.Lock()
+	err := qs.stream.Send(query)
+	qs.sendMutex.Unlock()
+	if err != nil {
+		return nil, fmt.Errorf("%w: agent %d: %v", ErrAgentUnavailable, agentID, err)
+	}
+
+	select {
+	case result := <-answer:
+		if result.Error != "" {
+			return nil, fmt.Errorf("%w: %s", ErrQueryFailed, result.Error)
+		}
+		return result.Values, nil
+	case <-qs.done:
+		return nil, fmt.Errorf("%w: agent %d disconnected", ErrAgentUnavailable, agentID)
+	case <-ctx.Done():
+		return nil, fmt.Errorf("%w: agent %d didn't answer in time", ErrQueryFailed, agentID)
+	}
+}
+
+// ParamOptions returns the choices for a template field with a source, listed by the given agent
+// or, with agentID 0, by an online agent carrying the source's agent labels and meeting the
+// template's requirements. values are the values chosen for the other fields so far. Answers are
+// cached briefly, so forms opened one after another don't each ask the agent.
+func (s *Server) ParamOptions(ctx context.Context, template *models.Template, field params.Field,
+	values map[string]string, agentID uint) ([]string, error) {
+	source := field.Source
+	if source == nil {
+		return nil, fmt.Errorf("%w: %s has no source", params.ErrInvalidParams, field.Name)
+	}
+	namespace, err := source.Query(values)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", field.Name, err)
+	}
+
+	if agentID == 0 {
+		agent, err := s.SelectAgent(ctx, source.AgentLabels, template.Requirements)
+		if err != nil {
+			return nil, err
+		}
+		agentID = agent.ID
+	}
+
+	key := fmt.Sprintf("%d/%s/%s/%s", agentID, source.Kind, namespace, source.LabelSelector)
+	now := time.Now()
+	s.optionsCacheMutex.Lock()
+	cached, ok := s.optionsCache[key]
+	s.optionsCacheMutex.Unlock()
+	if ok && now.Before(cached.expires) {
+		return cached.values, nil
+	}
+
+	options, err := s.query(ctx, agentID, &pb.Query{
+		Kind:          source.Kind,
+		Namespace:     namespace,
+		LabelSelector: source.LabelSelector,
+	})
+	if err != nil {
+		s.logger.Warn("Failed to list parameter options",
+			zap.String("template", template.Name),
+			zap.String("param", field.Name),
+			zap.Uint("agent_id", agentID),
+			zap.Error(err))
+		return nil, err
+	}
+
+	s.optionsCacheMutex.Lock()
+	for k, entry := range s.optionsCache {
+		if now.After(entry.expires) {
+			delete(s.optionsCache, k)
+		}
+	}
+	s.optionsCache[key] = cachedOptions{values: options, expires: now.Add(s.config.OptionsCacheTTL)}
+	s.optionsCacheMutex.Unlock()
+
+	return options, nil
+}
@@ -0,0 +1,341 @@
+package agentserver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"gorm.io/gorm"
+
+	pb "github.com/BogdanDolia/ops-butler/api/proto/agent"
+	"github.com/BogdanDolia/ops-butler/internal/calendar"
+	"github.com/BogdanDolia/ops-butler/internal/capabilities"
+	"github.com/BogdanDolia/ops-butler/internal/config"
+	"github.com/BogdanDolia/ops-butler/internal/database"
+	"github.com/BogdanDolia/ops-butler/internal/models"
+	"github.com/BogdanDolia/ops-butler/internal/params"
+	"github.com/BogdanDolia/ops-butler/internal/redact"
+	"github.com/BogdanDolia/ops-butler/internal/secrets"
+	"github.com/BogdanDolia/ops-butler/internal/signing"
+)
+
+// Server is the gRPC server cluster agents connect to
+type Server struct {
+	pb.UnimplementedAgentServiceServer
+	config            config.GRPCConfig
+	logger            *zap.Logger
+	agents            database.AgentRepository
+	tokens            database.EnrollmentTokenRepository
+	tasks             database.TaskRepository
+	templates         database.TemplateRepository
+	logs              database.ExecutionLogRepository
+	rules             []redact.Rule         // masked in task logs before they are stored
+	ca                *CA                   // nil if enrollment is disabled
+	approvals         signing.KeySet        // template approvers' keys; nil if only agents check approvals
+	keyring           *secrets.Keyring      // opens sealed secret params; nil if none is configured
+	recorders         map[uint]*LogRecorder // of tasks whose final response hasn't arrived, by task ID
+	recordersMutex    sync.Mutex
+	queryStreams      map[uint]*queryStream // by agent ID
+	queryStreamsMutex sync.Mutex
+	queryIDs          atomic.Uint64
+	optionsCache      map[string]cachedOptions // parameter choices by agent, kind, namespace and selector
+	optionsCacheMutex sync.Mutex
+	taskStreams       map[uint]*taskStream // by agent ID
+	taskStreamsMutex  sync.Mutex
+	guard             *calendar.Guard // holds dispatch of tasks a calendar blocks
+	grpc              *grpc.Server
+	stopCh            chan struct{}
+	wg                sync.WaitGroup
+}
+
+// NewServer creates a new agent server. Agents must present a client certificate signed by the
+// built-in or configured CA whose identity matches the agent they act as; agents without one can
+// enroll with a token if the built-in CA is configured. Serving without TLS has to be asked for
+// explicitly with GRPCConfig.Insecure.
+func NewServer(cfg config.GRPCConfig, logger *zap.Logger, db *gorm.DB, keyring *secrets.Keyring) (*Server, error) {
+	s := &Server{
+		config:       cfg,
+		logger:       logger,
+		keyring:      keyring,
+		agents:       database.NewAgentRepository(db),
+		tokens:       database.NewEnrollmentTokenRepository(db),
+		tasks:        database.NewTaskRepository(db),
+		templates:    database.NewTemplateRepository(db),
+		logs:         database.NewExecutionLogRepository(db),
+		recorders:    make(map[uint]*LogRecorder),
+		queryStreams: make(map[uint]*queryStream),
+		optionsCache: make(map[string]cachedOptions),
+		taskStreams:  make(map[uint]*taskStream),
+		stopCh:       make(chan struct{}),
+	}
+	s.guard = calendar.NewGuard(database.NewCalendarRepository(db), s.agents, s.templates)
+
+	rules, err := redact.LoadRules(cfg.RedactRulesFile)
+	if err != nil {
+		return nil, err
+	}
+	s.rules = rules
+
+	if cfg.ApprovalKeysFile != "" {
+		keys, err := signing.LoadKeySet(cfg.ApprovalKeysFile)
+		if err != nil {
+			return nil, err
+		}
+		s.approvals = keys
+	} else {
+		logger.Warn("No template approval keys configured (GRPC_TASK_APPROVAL_KEYS_FILE); tasks of unapproved templates are dispatched for agents to refuse")
+	}
+
+	var opts []grpc.ServerOption
+	if cfg.TLSEnabled {
+		if cfg.CACertFile != "" && cfg.CAKeyFile != "" {
+			ca, err := loadOrCreateCA(cfg.CACertFile, cfg.CAKeyFile, cfg.TrustDomain)
+			if err != nil {
+				return nil, fmt.Errorf("failed to load agent CA: %w", err)
+			}
+			s.ca = ca
+		}
+
+		creds, err := serverCredentials(cfg, s.ca)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts,
+			grpc.Creds(creds),
+			grpc.UnaryInterceptor(s.unaryInterceptor),
+			grpc.StreamInterceptor(s.streamInterceptor))
+	} else if cfg.Insecure {
+		logger.Warn("Agent gRPC server runs without TLS (GRPC_INSECURE); any caller can act as any agent and receive its tasks")
+	} else {
+		return nil, fmt.Errorf("agents must connect over mutual TLS: set GRPC_TLS_ENABLED, or GRPC_INSECURE=true for local development")
+	}
+
+	s.grpc = grpc.NewServer(opts...)
+	pb.RegisterAgentServiceServer(s.grpc, s)
+
+	return s, nil
+}
+
+// Start starts serving agents and dispatching ready tasks to them in the background
+func (s *Server) Start() error {
+	lis, err := net.Listen("tcp", s.config.Address())
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", s.config.Address(), err)
+	}
+
+	go func() {
+		s.logger.Info("Starting agent gRPC server", zap.String("address", s.config.Address()))
+		if err := s.grpc.Serve(lis); err != nil {
+			s.logger.Error("Agent gRPC server stopped", zap.Error(err))
+		}
+	}()
+
+	s.wg.Add(1)
+	go s.dispatchLoop()
+
+	return nil
+}
+
+// Stop stops dispatching and stops the server after in-flight calls have finished. Open task and
+// query streams are closed, as agents hold them open indefinitely.
+func (s *Server) Stop() {
+	close(s.stopCh)
+	s.wg.Wait()
+	s.grpc.Stop()
+}
+
+// NewTaskRequest builds the request that runs a task on an agent, carrying the template's approval
+// for the agent to verify. Sealed secret params are opened only here, right before they leave for
+// the agent; references are passed on for the agent to resolve.
+func (s *Server) NewTaskRequest(task *models.TaskInstance, template *models.Template, timeout int32) (*pb.ExecuteTaskRequest, error) {
+	req := &pb.ExecuteTaskRequest{
+		TaskId:         strconv.FormatUint(uint64(task.ID), 10),
+		Script:         template.Script,
+		Params:         make(map[string]string, len(task.Params)),
+		TimeoutSeconds: timeout,
+		TemplateName:   template.Name,
+		SecretParams:   params.SecretNames(template.ParamsSchema),
+		Priority:       priority(task),
+		KeyId:          template.ScriptKeyID,
+		ExpiresAt:      template.ScriptExpiresAt,
+		Signature:      template.ScriptSignature,
+	}
+
+	for name, value := range task.Params {
+		if secrets.IsSealed(value) {
+			opened, err := s.keyring.Open(value.(string))
+			if err != nil {
+				return nil, fmt.Errorf("failed to open secret param %s: %w", name, err)
+			}
+			req.Params[name] = opened
+			continue
+		}
+
+		switch v := value.(type) {
+		case string:
+			req.Params[name] = v
+		case map[string]interface{}, []interface{}:
+			data, err := json.Marshal(v)
+			if err != nil {
+				return nil, err
+			}
+			req.Params[name] = string(data)
+		default:
+			req.Params[name] = fmt.Sprint(v)
+		}
+	}
+
+	return req, nil
+}
+
+// CheckApproval verifies a template's stored approval against its current script. Without
+// approval keys configured every template passes, and agents are left to check.
+func (s *Server) CheckApproval(template *models.Template, now time.Time) error {
+	if s.approvals == nil {
+		return nil
+	}
+	approval := signing.Approval{
+		KeyID:     template.ScriptKeyID,
+		ExpiresAt: template.ScriptExpiresAt,
+		Signature: template.ScriptSignature,
+	}
+	return s.approvals.Verify(template.Name, "", template.Script, approval, now, s.config.ApprovalMaxTTL)
+}
+
+// priority returns the queue priority of a task on its agent: break-glass runs first, then tasks
+// someone is waiting on, then scheduled and automated ones
+func priority(task *models.TaskInstance) int32 {
+	switch {
+	case task.BreakGlassBy != nil:
+		return 2
+	case task.Origin == models.TaskOriginWeb || task.Origin == models.TaskOriginSlack || task.Origin == models.TaskOriginGoogleChat:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// Register creates or updates the agent record and binds it to the caller's certificate, unless
+// another certificate is bound to it already. Agents without a certificate enroll instead.
+func (s *Server) Register(ctx context.Context, req *pb.RegisterRequest) (*pb.RegisterResponse, error) {
+	if req.Name == "" {
+		return nil, status.Error(codes.InvalidArgument, "name is required")
+	}
+	identity := IdentityFromContext(ctx)
+	if identity == nil && req.EnrollmentToken != "" {
+		return s.enroll(ctx, req)
+	}
+
+	agent, err := s.agents.GetByName(ctx, req.Name)
+	if err != nil && !errors.Is(err, database.ErrNotFound) {
+		return nil, status.Error(codes.Internal, "failed to get agent")
+	}
+	if agent == nil {
+		agent = &models.ClusterAgent{Name: req.Name}
+	}
+	if err := checkLabels(agent.AllowedLabels, req.Labels); err != nil {
+		return nil, err
+	}
+
+	agent.Labels = labels(req.Labels)
+	agent.Version = req.Version
+	agent.Status = "online"
+	if req.Capabilities != nil {
+		agent.Capabilities = capabilitiesOf(req.Capabilities)
+	}
+	agent.LastHeartbeat = time.Now()
+	if identity != nil {
+		// Only renewal, enrollment and revocation replace a bound certificate; otherwise any
+		// other certificate issued for the name could take the agent over
+		if agent.CertFingerprint != "" && agent.CertFingerprint != identity.Fingerprint {
+			s.logger.Warn("Rejected registration with another certificate",
+				zap.String("agent", agent.Name),
+				zap.String("serial", identity.Serial))
+			return nil, status.Errorf(codes.PermissionDenied, "agent %q is bound to another certificate; revoke it to register with a new one", agent.Name)
+		}
+		expiresAt := identity.ExpiresAt
+		agent.CertFingerprint = identity.Fingerprint
+		agent.CertSerial = identity.Serial
+		agent.CertExpiresAt = &expiresAt
+	}
+
+	if agent.ID == 0 {
+		err = s.agents.Create(ctx, agent)
+	} else {
+		err = s.agents.Update(ctx, agent)
+	}
+	if err != nil {
+		s.logger.Error("Failed to save agent", zap.String("agent", req.Name), zap.Error(err))
+		return nil, status.Error(codes.Internal, "failed to save agent")
+	}
+
+	s.logger.Info("Agent registered", zap.String("agent", agent.Name), zap.Uint("agent_id", agent.ID))
+	return &pb.RegisterResponse{AgentId: strconv.FormatUint(uint64(agent.ID), 10), Success: true}, nil
+}
+
+// Heartbeat records that an agent is alive, along with its current labels, status, load and
+// capabilities
+func (s *Server) Heartbeat(ctx context.Context, req *pb.HeartbeatRequest) (*pb.HeartbeatResponse, error) {
+	id, err := strconv.ParseUint(req.AgentId, 10, 64)
+	if err != nil || id == 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid agent_id %q", req.AgentId)
+	}
+
+	agent, err := s.agents.GetByID(ctx, uint(id))
+	if err != nil {
+		if errors.Is(err, database.ErrNotFound) {
+			return nil, status.Errorf(codes.NotFound, "agent %d is not registered", id)
+		}
+		return nil, status.Error(codes.Internal, "failed to get agent")
+	}
+
+	agent.LastHeartbeat = time.Now()
+	agent.Status = req.Status
+	agent.Capacity = int(req.Capacity)
+	agent.RunningTasks = int(req.RunningTasks)
+	agent.QueuedTasks = int(req.QueuedTasks)
+	if req.Capabilities != nil {
+		agent.Capabilities = capabilitiesOf(req.Capabilities)
+	}
+	if req.Labels != nil {
+		if err := checkLabels(agent.AllowedLabels, req.Labels); err != nil {
+			return nil, err
+		}
+		agent.Labels = labels(req.Labels)
+	}
+	if err := s.agents.Update(ctx, agent); err != nil {
+		return nil, status.Error(codes.Internal, "failed to update agent")
+	}
+
+	return &pb.HeartbeatResponse{Success: true}, nil
+}
+
+// labels converts agent labels to the stored form
+func labels(l map[string]string) models.JSONSchema {
+	result := make(models.JSONSchema, len(l))
+	for k, v := range l {
+		result[k] = v
+	}
+	return result
+}
+
+// capabilitiesOf converts reported capabilities to the stored form
+func capabilitiesOf(c *pb.Capabilities) models.JSONSchema {
+	return capabilities.Capabilities{
+		Binaries:          c.Binaries,
+		Backends:          c.Backends,
+		KubernetesVersion: c.KubernetesVersion,
+		NodeCount:         int(c.NodeCount),
+		Namespace:         c.Namespace,
+	}.Map()
+}
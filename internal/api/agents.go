@@ -0,0 +1,63 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/BogdanDolia/ops-butler/internal/database"
+	"github.com/BogdanDolia/ops-butler/internal/models"
+)
+
+// revokeCertificateRequest is the body of a certificate revocation request
+type revokeCertificateRequest struct {
+	Reason string `json:"reason"`
+}
+
+// handleRevokeAgentCertificate revokes the client certificate an agent is bound to. The agent's
+// calls are rejected from then on until it registers with a new certificate.
+func (s *Server) handleRevokeAgentCertificate(c *gin.Context) {
+	id, ok := parseID(c)
+	if !ok {
+		return
+	}
+
+	user := currentUser(c)
+	if user == nil || user.Role != models.RoleAdmin {
+		s.respondError(c, fmt.Errorf("%w: only admins may revoke agent certificates", errNotPermitted))
+		return
+	}
+
+	var req revokeCertificateRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	agent, err := s.agents.GetByID(c.Request.Context(), id)
+	if err != nil {
+		s.respondError(c, err)
+		return
+	}
+	if agent.CertFingerprint == "" {
+		s.respondError(c, fmt.Errorf("%w: agent %d has no certificate bound", database.ErrValidation, agent.ID))
+		return
+	}
+
+	revocation := &models.RevokedCertificate{
+		AgentID:     agent.ID,
+		Fingerprint: agent.CertFingerprint,
+		Serial:      agent.CertSerial,
+		Reason:      req.Reason,
+		RevokedByID: &user.ID,
+	}
+	if err := s.agents.RevokeCertificate(c.Request.Context(), revocation); err != nil {
+		s.respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, revocation)
+}
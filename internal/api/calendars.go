@@ -0,0 +1,144 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/BogdanDolia/ops-butler/internal/calendar"
+	"github.com/BogdanDolia/ops-butler/internal/models"
+)
+
+// calendarRequest is the body of calendar create and update requests
+type calendarRequest struct {
+	Name          string                  `json:"name"`
+	Description   string                  `json:"description"`
+	TimeZone      string                  `json:"time_zone"`
+	AgentSelector models.JSONSchema       `json:"agent_selector"`
+	Enabled       *bool                   `json:"enabled"`
+	TemplateIDs   []uint                  `json:"template_ids"`
+	Windows       []models.CalendarWindow `json:"windows"`
+}
+
+// apply copies the request onto a calendar
+func (r *calendarRequest) apply(cal *models.Calendar, templates []models.Template) {
+	cal.Name = r.Name
+	cal.Description = r.Description
+	cal.TimeZone = r.TimeZone
+	if cal.TimeZone == "" {
+		cal.TimeZone = "UTC"
+	}
+	cal.AgentSelector = r.AgentSelector
+	cal.Enabled = r.Enabled == nil || *r.Enabled
+	cal.Windows = r.Windows
+	cal.Templates = templates
+}
+
+func (s *Server) handleListCalendars(c *gin.Context) {
+	offset, _ := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
+
+	calendars, err := s.calendars.List(c.Request.Context(), offset, limit)
+	if err != nil {
+		s.respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, calendars)
+}
+
+func (s *Server) handleGetCalendar(c *gin.Context) {
+	id, ok := parseID(c)
+	if !ok {
+		return
+	}
+
+	cal, err := s.calendars.GetByID(c.Request.Context(), id)
+	if err != nil {
+		s.respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, cal)
+}
+
+func (s *Server) handleCreateCalendar(c *gin.Context) {
+	var req calendarRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	templates, err := s.loadTemplates(c.Request.Context(), req.TemplateIDs)
+	if err != nil {
+		s.respondError(c, err)
+		return
+	}
+
+	cal := &models.Calendar{}
+	req.apply(cal, templates)
+	if err := calendar.Validate(cal); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := s.calendars.Create(c.Request.Context(), cal); err != nil {
+		s.respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, cal)
+}
+
+func (s *Server) handleUpdateCalendar(c *gin.Context) {
+	id, ok := parseID(c)
+	if !ok {
+		return
+	}
+
+	var req calendarRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	cal, err := s.calendars.GetByID(c.Request.Context(), id)
+	if err != nil {
+		s.respondError(c, err)
+		return
+	}
+
+	templates, err := s.loadTemplates(c.Request.Context(), req.TemplateIDs)
+	if err != nil {
+		s.respondError(c, err)
+		return
+	}
+
+	req.apply(cal, templates)
+	if err := calendar.Validate(cal); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := s.calendars.Update(c.Request.Context(), cal); err != nil {
+		s.respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, cal)
+}
+
+func (s *Server) handleDeleteCalendar(c *gin.Context) {
+	id, ok := parseID(c)
+	if !ok {
+		return
+	}
+
+	if err := s.calendars.Delete(c.Request.Context(), id); err != nil {
+		s.respondError(c, err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,147 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/BogdanDolia/ops-butler/internal/models"
+)
+
+// chatIdentityRequest is the body of requests that link a chat account to a user
+type chatIdentityRequest struct {
+	Provider   string `json:"provider" binding:"required"`
+	Workspace  string `json:"workspace"`
+	ChatUserID string `json:"chat_user_id" binding:"required"`
+	UserID     uint   `json:"user_id" binding:"required"`
+}
+
+// chatLinkRequest is the body of a request that confirms a link sent by "/ops link"
+type chatLinkRequest struct {
+	Token string `json:"token" binding:"required"`
+}
+
+// handleListChatIdentities lists the chat accounts linked to the current user; admins may list
+// another user's with ?user_id=
+func (s *Server) handleListChatIdentities(c *gin.Context) {
+	user := currentUser(c)
+	if user == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "authentication required"})
+		return
+	}
+
+	userID := user.ID
+	if value := c.Query("user_id"); value != "" {
+		id, err := strconv.ParseUint(value, 10, 64)
+		if err != nil || id == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user_id"})
+			return
+		}
+		if uint(id) != user.ID && user.Role != models.RoleAdmin {
+			s.respondError(c, fmt.Errorf("%w: only admins may list other users' chat accounts", errNotPermitted))
+			return
+		}
+		userID = uint(id)
+	}
+
+	identities, err := s.identities.ListByUserID(c.Request.Context(), userID)
+	if err != nil {
+		s.respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, identities)
+}
+
+// handleCreateChatIdentity links a chat account to a user on behalf of an admin, for platforms
+// that don't report verified email addresses
+func (s *Server) handleCreateChatIdentity(c *gin.Context) {
+	user := currentUser(c)
+	if user == nil || user.Role != models.RoleAdmin {
+		s.respondError(c, fmt.Errorf("%w: only admins may link chat accounts of other users", errNotPermitted))
+		return
+	}
+
+	var req chatIdentityRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if _, err := s.users.GetByID(c.Request.Context(), req.UserID); err != nil {
+		s.respondError(c, err)
+		return
+	}
+
+	now := time.Now()
+	identity := &models.ChatIdentity{
+		Provider:   req.Provider,
+		Workspace:  req.Workspace,
+		ChatUserID: req.ChatUserID,
+		UserID:     req.UserID,
+		Method:     models.ChatLinkMethodConfirmed,
+		VerifiedAt: &now,
+	}
+	if err := s.identities.Link(c.Request.Context(), identity); err != nil {
+		s.respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, identity)
+}
+
+// handleDeleteChatIdentity unlinks a chat account; users may unlink their own accounts
+func (s *Server) handleDeleteChatIdentity(c *gin.Context) {
+	id, ok := parseID(c)
+	if !ok {
+		return
+	}
+
+	user := currentUser(c)
+	if user == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "authentication required"})
+		return
+	}
+
+	identity, err := s.identities.GetByID(c.Request.Context(), id)
+	if err != nil {
+		s.respondError(c, err)
+		return
+	}
+	if identity.UserID != user.ID && user.Role != models.RoleAdmin {
+		s.respondError(c, fmt.Errorf("%w: only admins may unlink other users' chat accounts", errNotPermitted))
+		return
+	}
+
+	if err := s.identities.Delete(c.Request.Context(), id); err != nil {
+		s.respondError(c, err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// handleConfirmChatLink links the chat account a "/ops link" token was sent to, to the current user
+func (s *Server) handleConfirmChatLink(c *gin.Context) {
+	user := currentUser(c)
+	if user == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "authentication required"})
+		return
+	}
+
+	var req chatLinkRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	identity, err := s.linker.ConfirmLink(c.Request.Context(), req.Token, user)
+	if err != nil {
+		s.respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, identity)
+}
@@ -0,0 +1,195 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/BogdanDolia/ops-butler/internal/database"
+	"github.com/BogdanDolia/ops-butler/internal/models"
+	"github.com/BogdanDolia/ops-butler/internal/routing"
+)
+
+// chatRouteRequest is the body of chat route create and update requests
+type chatRouteRequest struct {
+	Name                string            `json:"name"`
+	Description         string            `json:"description"`
+	Priority            int               `json:"priority"`
+	Enabled             *bool             `json:"enabled"`
+	TemplateIDs         []uint            `json:"template_ids"`
+	Tags                []string          `json:"tags"`
+	AgentSelector       models.JSONSchema `json:"agent_selector"`
+	Origins             []string          `json:"origins"`
+	ChatType            string            `json:"chat_type"`
+	ChatID              string            `json:"chat_id"`
+	Mention             string            `json:"mention"`
+	ReminderMessageID   *uint             `json:"reminder_message_id"`
+	CompletionMessageID *uint             `json:"completion_message_id"`
+	FailureMessageID    *uint             `json:"failure_message_id"`
+}
+
+// apply copies the request onto a chat route
+func (r *chatRouteRequest) apply(route *models.ChatRoute, templates []models.Template) {
+	route.Name = r.Name
+	route.Description = r.Description
+	route.Priority = r.Priority
+	route.Enabled = r.Enabled == nil || *r.Enabled
+	route.Templates = templates
+	route.Tags = r.Tags
+	route.AgentSelector = r.AgentSelector
+	route.Origins = r.Origins
+	route.ChatType = r.ChatType
+	route.ChatID = r.ChatID
+	route.Mention = r.Mention
+	route.ReminderMessageID = r.ReminderMessageID
+	route.CompletionMessageID = r.CompletionMessageID
+	route.FailureMessageID = r.FailureMessageID
+}
+
+// validateChatRoute checks a route and that its platform and message templates exist
+func (s *Server) validateChatRoute(ctx context.Context, route *models.ChatRoute) error {
+	if err := routing.Validate(route); err != nil {
+		return fmt.Errorf("%w: %s", database.ErrValidation, err)
+	}
+	if route.ChatType != "" {
+		if _, err := s.chat.Provider(route.ChatType); err != nil {
+			return fmt.Errorf("%w: %s", database.ErrValidation, err)
+		}
+	}
+
+	messages := map[models.MessageKind]*uint{
+		models.MessageKindReminder:   route.ReminderMessageID,
+		models.MessageKindCompletion: route.CompletionMessageID,
+		models.MessageKindFailure:    route.FailureMessageID,
+	}
+	for kind, id := range messages {
+		if id == nil {
+			continue
+		}
+		message, err := s.messages.GetByID(ctx, *id)
+		if err != nil {
+			if errors.Is(err, database.ErrNotFound) || errors.Is(err, database.ErrInvalidID) {
+				return fmt.Errorf("%w: message template %d does not exist", database.ErrValidation, *id)
+			}
+			return err
+		}
+		if message.Kind != kind {
+			return fmt.Errorf("%w: message template %d is a %s message, not a %s message",
+				database.ErrValidation, *id, message.Kind, kind)
+		}
+	}
+
+	return nil
+}
+
+func (s *Server) handleListChatRoutes(c *gin.Context) {
+	offset, _ := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
+
+	routes, err := s.routes.List(c.Request.Context(), offset, limit)
+	if err != nil {
+		s.respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, routes)
+}
+
+func (s *Server) handleGetChatRoute(c *gin.Context) {
+	id, ok := parseID(c)
+	if !ok {
+		return
+	}
+
+	route, err := s.routes.GetByID(c.Request.Context(), id)
+	if err != nil {
+		s.respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, route)
+}
+
+func (s *Server) handleCreateChatRoute(c *gin.Context) {
+	var req chatRouteRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	templates, err := s.loadTemplates(c.Request.Context(), req.TemplateIDs)
+	if err != nil {
+		s.respondError(c, err)
+		return
+	}
+
+	route := &models.ChatRoute{}
+	req.apply(route, templates)
+	if err := s.validateChatRoute(c.Request.Context(), route); err != nil {
+		s.respondError(c, err)
+		return
+	}
+
+	if err := s.routes.Create(c.Request.Context(), route); err != nil {
+		s.respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, route)
+}
+
+func (s *Server) handleUpdateChatRoute(c *gin.Context) {
+	id, ok := parseID(c)
+	if !ok {
+		return
+	}
+
+	var req chatRouteRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	route, err := s.routes.GetByID(c.Request.Context(), id)
+	if err != nil {
+		s.respondError(c, err)
+		return
+	}
+
+	templates, err := s.loadTemplates(c.Request.Context(), req.TemplateIDs)
+	if err != nil {
+		s.respondError(c, err)
+		return
+	}
+
+	req.apply(route, templates)
+	if err := s.validateChatRoute(c.Request.Context(), route); err != nil {
+		s.respondError(c, err)
+		return
+	}
+
+	if err := s.routes.Update(c.Request.Context(), route); err != nil {
+		s.respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, route)
+}
+
+func (s *Server) handleDeleteChatRoute(c *gin.Context) {
+	id, ok := parseID(c)
+	if !ok {
+		return
+	}
+
+	if err := s.routes.Delete(c.Request.Context(), id); err != nil {
+		s.respondError(c, err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
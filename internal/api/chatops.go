@@ -0,0 +1,175 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+
+	"github.com/BogdanDolia/ops-butler/internal/chatops"
+	"github.com/BogdanDolia/ops-butler/internal/models"
+	"github.com/BogdanDolia/ops-butler/internal/scheduler"
+)
+
+// errNotPermitted is returned when a user's role doesn't allow an operation
+var errNotPermitted = errors.New("not permitted")
+
+// handleSlackInteraction acknowledges a Slack interactivity request right away; button presses
+// are carried out by a background worker that reports back through the response_url
+func (s *Server) handleSlackInteraction(c *gin.Context) {
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "failed to read body"})
+		return
+	}
+
+	response, err := s.chat.HandleSlackInteraction(c.Request.Context(), c.Request, body)
+	s.respondChat(c, response, err)
+}
+
+// handleSlackCommand runs an /ops slash command
+func (s *Server) handleSlackCommand(c *gin.Context) {
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "failed to read body"})
+		return
+	}
+
+	response, err := s.commands.HandleSlackCommand(c.Request.Context(), c.Request, body)
+	s.respondChat(c, response, err)
+}
+
+// handleChatEvent returns a handler that acknowledges a platform's webhook requests; reminder
+// actions are carried out by a background worker that updates the message afterwards
+func (s *Server) handleChatEvent(platform string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "failed to read body"})
+			return
+		}
+
+		response, err := s.chat.HandleInteraction(platform, c.Request, body)
+		s.respondChat(c, response, err)
+	}
+}
+
+// respondChat writes the response to a chat platform's webhook request
+func (s *Server) respondChat(c *gin.Context, response map[string]any, err error) {
+	switch {
+	case err == nil && response != nil:
+		c.JSON(http.StatusOK, response)
+	case err == nil, errors.Is(err, chatops.ErrSlackDuplicate):
+		c.Status(http.StatusOK)
+	case errors.Is(err, chatops.ErrSlackSignature), errors.Is(err, chatops.ErrSlackStaleRequest):
+		s.logger.Warn("Rejected Slack request", zap.String("ip", c.ClientIP()), zap.Error(err))
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid request signature"})
+	case errors.Is(err, chatops.ErrGoogleChatToken), errors.Is(err, chatops.ErrTeamsToken),
+		errors.Is(err, chatops.ErrMattermostSecret):
+		s.logger.Warn("Rejected chat request", zap.String("ip", c.ClientIP()), zap.Error(err))
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid request credentials"})
+	case errors.Is(err, chatops.ErrBusy):
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	}
+}
+
+// chatActions carries out reminder actions pressed in chat
+type chatActions struct {
+	server *Server
+}
+
+// HandleInteraction implements chatops.InteractionHandler
+func (a *chatActions) HandleInteraction(ctx context.Context, interaction *chatops.Interaction) (string, error) {
+	s := a.server
+
+	user := interaction.User
+	if !user.CanOperate() {
+		return "", fmt.Errorf("%w: role %q may not %s tasks", errNotPermitted, user.Role, interaction.Action)
+	}
+
+	task, err := s.tasks.GetByID(ctx, interaction.TaskID)
+	if err != nil {
+		return "", fmt.Errorf("failed to get task: %w", err)
+	}
+
+	switch interaction.Action {
+	case chatops.ActionRunNow:
+		// Report the outcome in the thread of the reminder that was acted on
+		if task.ChatThread == "" {
+			task.ChatThread = chatops.ThreadRef(interaction.Platform, interaction.Channel, interaction.MessageID)
+		}
+		// There is no way to give a break-glass reason from a button
+		if err := s.runTaskNow(ctx, task, user, ""); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("Task #%d is being run by %s.", task.ID, user.Name), nil
+
+	case chatops.ActionSnooze:
+		reminder, err := s.activeReminder(ctx, task.ID)
+		if err != nil {
+			return "", err
+		}
+		followUp, err := s.scheduler.SnoozeReminder(ctx, reminder.ID, scheduler.SnoozeRequest{
+			Duration: interaction.Value,
+			UserID:   &user.ID,
+		})
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("Task #%d was snoozed by %s until %s.",
+			task.ID, user.Name, inUserZone(followUp.ChatAt, user).Format("Mon Jan 2 15:04 MST")), nil
+
+	case chatops.ActionCancel:
+		if task.State != models.TaskStatePending && task.State != models.TaskStateScheduled {
+			return "", fmt.Errorf("%w: task is %s", errTaskNotRunnable, task.State)
+		}
+		if err := s.scheduler.CancelTask(ctx, task.ID); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("Task #%d was cancelled by %s.", task.ID, user.Name), nil
+	}
+
+	return "", fmt.Errorf("unsupported action %q", interaction.Action)
+}
+
+// activeReminder returns the latest reminder of a task that can still be snoozed
+func (s *Server) activeReminder(ctx context.Context, taskID uint) (*models.Reminder, error) {
+	reminders, err := s.reminders.ListByTaskID(ctx, taskID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list reminders: %w", err)
+	}
+
+	var active *models.Reminder
+	for _, reminder := range reminders {
+		switch reminder.State {
+		case models.ReminderStatePending, models.ReminderStateQueued, models.ReminderStateDelivered:
+		default:
+			continue
+		}
+		if active == nil || reminder.ID > active.ID {
+			active = reminder
+		}
+	}
+	if active == nil {
+		return nil, fmt.Errorf("%w: task %d has no active reminder", scheduler.ErrReminderNotActive, taskID)
+	}
+
+	return active, nil
+}
+
+// inUserZone converts a time to the user's time zone, if it has a valid one
+func inUserZone(t time.Time, user *models.User) time.Time {
+	if user.TimeZone != "" {
+		if loc, err := time.LoadLocation(user.TimeZone); err == nil {
+			return t.In(loc)
+		}
+	}
+	return t.UTC()
+}
@@ -0,0 +1,154 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/BogdanDolia/ops-butler/internal/agentserver"
+	"github.com/BogdanDolia/ops-butler/internal/database"
+	"github.com/BogdanDolia/ops-butler/internal/models"
+)
+
+// Enrollment token lifetimes
+const (
+	defaultEnrollmentTTL = time.Hour
+	maxEnrollmentTTL     = 24 * time.Hour
+)
+
+// enrollmentTokenRequest is the body of an enrollment token create request
+type enrollmentTokenRequest struct {
+	Name   string            `json:"name" binding:"required"`
+	Labels map[string]string `json:"labels"` // labels the agent may claim; "*" allows any value
+	TTL    string            `json:"ttl"`    // Go duration, 1h by default
+}
+
+// enrollmentTokenResponse returns a new token's secret, which is not stored and can't be
+// retrieved later, with a command that onboards the cluster
+type enrollmentTokenResponse struct {
+	*models.EnrollmentToken
+	Token   string `json:"token"`
+	Command string `json:"command"`
+}
+
+// requireAdmin responds with 403 and returns false unless the current user is an admin
+func (s *Server) requireAdmin(c *gin.Context, action string) bool {
+	user := currentUser(c)
+	if user == nil || user.Role != models.RoleAdmin {
+		s.respondError(c, fmt.Errorf("%w: only admins may %s", errNotPermitted, action))
+		return false
+	}
+	return true
+}
+
+func (s *Server) handleListEnrollmentTokens(c *gin.Context) {
+	if !s.requireAdmin(c, "list enrollment tokens") {
+		return
+	}
+
+	offset, _ := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
+
+	tokens, err := s.enrollments.List(c.Request.Context(), offset, limit)
+	if err != nil {
+		s.respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, tokens)
+}
+
+// handleCreateEnrollmentToken creates a single-use token that lets a new agent obtain its client
+// certificate
+func (s *Server) handleCreateEnrollmentToken(c *gin.Context) {
+	if !s.requireAdmin(c, "create enrollment tokens") {
+		return
+	}
+
+	var req enrollmentTokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ttl := defaultEnrollmentTTL
+	if req.TTL != "" {
+		d, err := time.ParseDuration(req.TTL)
+		if err != nil || d <= 0 || d > maxEnrollmentTTL {
+			s.respondError(c, fmt.Errorf("%w: ttl must be a duration of at most %s", database.ErrValidation, maxEnrollmentTTL))
+			return
+		}
+		ttl = d
+	}
+
+	secret, hash, err := agentserver.NewEnrollmentToken()
+	if err != nil {
+		s.respondError(c, err)
+		return
+	}
+
+	allowed := models.JSONSchema{}
+	for key, value := range req.Labels {
+		allowed[key] = value
+	}
+	token := &models.EnrollmentToken{
+		TokenHash:     hash,
+		Name:          req.Name,
+		AllowedLabels: allowed,
+		ExpiresAt:     time.Now().Add(ttl),
+		CreatedByID:   &currentUser(c).ID,
+	}
+	if err := s.enrollments.Create(c.Request.Context(), token); err != nil {
+		s.respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, enrollmentTokenResponse{
+		EnrollmentToken: token,
+		Token:           secret,
+		Command:         enrollmentCommand(req.Name, secret, req.Labels),
+	})
+}
+
+// handleDeleteEnrollmentToken deletes an enrollment token so it can't be used any more
+func (s *Server) handleDeleteEnrollmentToken(c *gin.Context) {
+	id, ok := parseID(c)
+	if !ok {
+		return
+	}
+	if !s.requireAdmin(c, "delete enrollment tokens") {
+		return
+	}
+
+	if err := s.enrollments.Delete(c.Request.Context(), id); err != nil {
+		s.respondError(c, err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// enrollmentCommand returns the command that stores the enrollment secret the agent deployment in
+// deploy/local/agent.yaml reads, and deploys the agent
+func enrollmentCommand(name, token string, allowed map[string]string) string {
+	var labels []string
+	for key, value := range allowed {
+		if value != agentserver.AnyLabelValue {
+			labels = append(labels, key+"="+value)
+		}
+	}
+	sort.Strings(labels)
+
+	cmd := "kubectl -n ops-portal create secret generic ops-portal-agent-enrollment" +
+		" --from-literal=AGENT_NAME=" + name +
+		" --from-literal=ENROLLMENT_TOKEN=" + token
+	if len(labels) > 0 {
+		cmd += " --from-literal=AGENT_LABELS=" + strings.Join(labels, ",")
+	}
+	return cmd + " && kubectl apply -f deploy/local/agent.yaml"
+}
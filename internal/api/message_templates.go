@@ -0,0 +1,157 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/BogdanDolia/ops-butler/internal/models"
+	"github.com/BogdanDolia/ops-butler/internal/routing"
+)
+
+// messageTemplateRequest is the body of message template create and update requests
+type messageTemplateRequest struct {
+	Name        string             `json:"name"`
+	Description string             `json:"description"`
+	Kind        models.MessageKind `json:"kind"`
+	Body        string             `json:"body"`
+}
+
+// apply copies the request onto a message template
+func (r *messageTemplateRequest) apply(message *models.MessageTemplate) {
+	message.Name = r.Name
+	message.Description = r.Description
+	message.Kind = r.Kind
+	message.Body = r.Body
+}
+
+// messagePreviewRequest is the body of a message preview request. Without a body the built-in
+// message of the kind is rendered.
+type messagePreviewRequest struct {
+	Kind models.MessageKind `json:"kind" binding:"required"`
+	Body string             `json:"body"`
+}
+
+func (s *Server) handleListMessageTemplates(c *gin.Context) {
+	offset, _ := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
+
+	messages, err := s.messages.List(c.Request.Context(), offset, limit)
+	if err != nil {
+		s.respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, messages)
+}
+
+func (s *Server) handleGetMessageTemplate(c *gin.Context) {
+	id, ok := parseID(c)
+	if !ok {
+		return
+	}
+
+	message, err := s.messages.GetByID(c.Request.Context(), id)
+	if err != nil {
+		s.respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, message)
+}
+
+func (s *Server) handleCreateMessageTemplate(c *gin.Context) {
+	var req messageTemplateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	message := &models.MessageTemplate{}
+	req.apply(message)
+	if err := routing.ValidateMessage(message); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := s.messages.Create(c.Request.Context(), message); err != nil {
+		s.respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, message)
+}
+
+func (s *Server) handleUpdateMessageTemplate(c *gin.Context) {
+	id, ok := parseID(c)
+	if !ok {
+		return
+	}
+
+	var req messageTemplateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	message, err := s.messages.GetByID(c.Request.Context(), id)
+	if err != nil {
+		s.respondError(c, err)
+		return
+	}
+
+	req.apply(message)
+	if err := routing.ValidateMessage(message); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := s.messages.Update(c.Request.Context(), message); err != nil {
+		s.respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, message)
+}
+
+func (s *Server) handleDeleteMessageTemplate(c *gin.Context) {
+	id, ok := parseID(c)
+	if !ok {
+		return
+	}
+
+	if err := s.messages.Delete(c.Request.Context(), id); err != nil {
+		s.respondError(c, err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// handlePreviewMessageTemplate renders a message template body with sample data
+func (s *Server) handlePreviewMessageTemplate(c *gin.Context) {
+	var req messagePreviewRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	message := &models.MessageTemplate{Name: "preview", Kind: req.Kind, Body: req.Body}
+	if message.Body == "" {
+		message.Body = routing.DefaultBody(req.Kind)
+	}
+	if err := routing.ValidateMessage(message); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	data := routing.SampleData(req.Kind)
+	text, err := routing.Render(message.Body, data)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"text": text, "data": data})
+}
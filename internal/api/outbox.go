@@ -0,0 +1,64 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/BogdanDolia/ops-butler/internal/models"
+)
+
+// handleListOutbox lists outbox messages in a state, dead-lettered ones by default
+func (s *Server) handleListOutbox(c *gin.Context) {
+	offset, _ := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	state := models.OutboxState(c.DefaultQuery("state", string(models.OutboxStateDead)))
+
+	switch state {
+	case models.OutboxStatePending, models.OutboxStateSending, models.OutboxStateSent, models.OutboxStateSkipped, models.OutboxStateDead:
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid state"})
+		return
+	}
+
+	messages, err := s.outbox.ListByState(c.Request.Context(), state, offset, limit)
+	if err != nil {
+		s.respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, messages)
+}
+
+func (s *Server) handleGetOutboxMessage(c *gin.Context) {
+	id, ok := parseID(c)
+	if !ok {
+		return
+	}
+
+	message, err := s.outbox.GetByID(c.Request.Context(), id)
+	if err != nil {
+		s.respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, message)
+}
+
+// handleRetryOutboxMessage puts a dead-lettered message back in the outbox for delivery
+func (s *Server) handleRetryOutboxMessage(c *gin.Context) {
+	id, ok := parseID(c)
+	if !ok {
+		return
+	}
+
+	message, err := s.outbox.Retry(c.Request.Context(), id, time.Now())
+	if err != nil {
+		s.respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, message)
+}
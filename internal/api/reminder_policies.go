@@ -0,0 +1,173 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/BogdanDolia/ops-butler/internal/models"
+)
+
+// reminderPolicyRequest is the body of reminder policy create and update requests
+type reminderPolicyRequest struct {
+	Name                 string                     `json:"name"`
+	Description          string                     `json:"description"`
+	RepeatEveryMinutes   int                        `json:"repeat_every_minutes"`
+	EscalateAfterMinutes int                        `json:"escalate_after_minutes"`
+	EscalationChatType   string                     `json:"escalation_chat_type"`
+	EscalationChatID     string                     `json:"escalation_chat_id"`
+	EscalationMention    string                     `json:"escalation_mention"`
+	FinalDeadlineMinutes int                        `json:"final_deadline_minutes"`
+	FinalAction          models.ReminderFinalAction `json:"final_action"`
+	TemplateIDs          []uint                     `json:"template_ids"`
+}
+
+// validate checks the request for consistency
+func (r *reminderPolicyRequest) validate() error {
+	if r.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	if r.RepeatEveryMinutes < 0 || r.EscalateAfterMinutes < 0 || r.FinalDeadlineMinutes < 0 {
+		return fmt.Errorf("durations must not be negative")
+	}
+
+	switch r.FinalAction {
+	case "", models.ReminderFinalActionNone:
+	case models.ReminderFinalActionCancel, models.ReminderFinalActionRun:
+		if r.FinalDeadlineMinutes == 0 {
+			return fmt.Errorf("final_deadline_minutes is required for final action %q", r.FinalAction)
+		}
+		if r.EscalateAfterMinutes > 0 && r.EscalateAfterMinutes >= r.FinalDeadlineMinutes {
+			return fmt.Errorf("escalate_after_minutes must be before final_deadline_minutes")
+		}
+	default:
+		return fmt.Errorf("unknown final action %q", r.FinalAction)
+	}
+
+	return nil
+}
+
+// apply copies the request onto a reminder policy
+func (r *reminderPolicyRequest) apply(policy *models.ReminderPolicy, templates []models.Template) {
+	policy.Name = r.Name
+	policy.Description = r.Description
+	policy.RepeatEveryMinutes = r.RepeatEveryMinutes
+	policy.EscalateAfterMinutes = r.EscalateAfterMinutes
+	policy.EscalationChatType = r.EscalationChatType
+	policy.EscalationChatID = r.EscalationChatID
+	policy.EscalationMention = r.EscalationMention
+	policy.FinalDeadlineMinutes = r.FinalDeadlineMinutes
+	policy.FinalAction = r.FinalAction
+	if policy.FinalAction == "" {
+		policy.FinalAction = models.ReminderFinalActionNone
+	}
+	policy.Templates = templates
+}
+
+func (s *Server) handleListReminderPolicies(c *gin.Context) {
+	offset, _ := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
+
+	policies, err := s.policies.List(c.Request.Context(), offset, limit)
+	if err != nil {
+		s.respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, policies)
+}
+
+func (s *Server) handleGetReminderPolicy(c *gin.Context) {
+	id, ok := parseID(c)
+	if !ok {
+		return
+	}
+
+	policy, err := s.policies.GetByID(c.Request.Context(), id)
+	if err != nil {
+		s.respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, policy)
+}
+
+func (s *Server) handleCreateReminderPolicy(c *gin.Context) {
+	var req reminderPolicyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := req.validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	templates, err := s.loadTemplates(c.Request.Context(), req.TemplateIDs)
+	if err != nil {
+		s.respondError(c, err)
+		return
+	}
+
+	policy := &models.ReminderPolicy{}
+	req.apply(policy, templates)
+	if err := s.policies.Create(c.Request.Context(), policy); err != nil {
+		s.respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, policy)
+}
+
+func (s *Server) handleUpdateReminderPolicy(c *gin.Context) {
+	id, ok := parseID(c)
+	if !ok {
+		return
+	}
+
+	var req reminderPolicyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := req.validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	policy, err := s.policies.GetByID(c.Request.Context(), id)
+	if err != nil {
+		s.respondError(c, err)
+		return
+	}
+
+	templates, err := s.loadTemplates(c.Request.Context(), req.TemplateIDs)
+	if err != nil {
+		s.respondError(c, err)
+		return
+	}
+
+	req.apply(policy, templates)
+	if err := s.policies.Update(c.Request.Context(), policy); err != nil {
+		s.respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, policy)
+}
+
+func (s *Server) handleDeleteReminderPolicy(c *gin.Context) {
+	id, ok := parseID(c)
+	if !ok {
+		return
+	}
+
+	if err := s.policies.Delete(c.Request.Context(), id); err != nil {
+		s.respondError(c, err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
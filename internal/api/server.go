@@ -2,10 +2,13 @@ package api
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
+	"sync"
 	"syscall"
 	"time"
 
@@ -13,23 +16,58 @@ import (
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"go.uber.org/zap"
 
+	"github.com/BogdanDolia/ops-butler/internal/agentserver"
+	"github.com/BogdanDolia/ops-butler/internal/calendar"
+	"github.com/BogdanDolia/ops-butler/internal/capabilities"
+	"github.com/BogdanDolia/ops-butler/internal/chatops"
 	"github.com/BogdanDolia/ops-butler/internal/config"
 	"github.com/BogdanDolia/ops-butler/internal/database"
+	"github.com/BogdanDolia/ops-butler/internal/models"
+	"github.com/BogdanDolia/ops-butler/internal/params"
+	"github.com/BogdanDolia/ops-butler/internal/scheduler"
+	"github.com/BogdanDolia/ops-butler/internal/secrets"
+	"github.com/BogdanDolia/ops-butler/internal/workflow"
 )
 
 // Server represents the API server
 type Server struct {
-	router     *gin.Engine
-	httpServer *http.Server
-	config     *config.Config
-	logger     *zap.Logger
-	db         *database.GormRepository
-	templates  database.TemplateRepository
+	router      *gin.Engine
+	httpServer  *http.Server
+	config      *config.Config
+	logger      *zap.Logger
+	db          *database.GormRepository
+	templates   database.TemplateRepository
+	tasks       database.TaskRepository
+	agents      database.AgentRepository
+	calendars   database.CalendarRepository
+	guard       *calendar.Guard
+	matcher     *capabilities.Matcher
+	logs        database.ExecutionLogRepository
+	workflows   database.WorkflowRepository
+	runs        database.WorkflowRunRepository
+	engine      *workflow.Engine
+	policies    database.ReminderPolicyRepository
+	reminders   database.ReminderRepository
+	users       database.UserRepository
+	identities  database.ChatIdentityRepository
+	routes      database.ChatRouteRepository
+	messages    database.MessageTemplateRepository
+	outbox      database.OutboxRepository
+	enrollments database.EnrollmentTokenRepository
+	keyring     *secrets.Keyring       // seals secret params; nil if none is configured
+	options     params.OptionsProvider // lists parameter choices from agents' clusters
+	scheduler   *scheduler.Scheduler
+	chat        *chatops.Service
+	commands    *chatops.Commands
+	linker      *chatops.Identities
+	stopChat    context.CancelFunc
+	chatWG      *sync.WaitGroup
 	// Add other repositories as needed
 }
 
 // NewServer creates a new API server
-func NewServer(cfg *config.Config, log *zap.Logger, db *database.GormRepository) *Server {
+func NewServer(cfg *config.Config, log *zap.Logger, db *database.GormRepository, sched *scheduler.Scheduler,
+	chat *chatops.Service, keyring *secrets.Keyring, options params.OptionsProvider) *Server {
 	// Set Gin mode based on environment
 	if cfg.Logging.Level == "debug" {
 		gin.SetMode(gin.DebugMode)
@@ -48,9 +86,13 @@ func NewServer(cfg *config.Config, log *zap.Logger, db *database.GormRepository)
 			ReadTimeout:  cfg.Server.ReadTimeout,
 			WriteTimeout: cfg.Server.WriteTimeout,
 		},
-		config: cfg,
-		logger: log,
-		db:     db,
+		config:    cfg,
+		logger:    log,
+		db:        db,
+		scheduler: sched,
+		chat:      chat,
+		keyring:   keyring,
+		options:   options,
 	}
 
 	// Initialize repositories
@@ -69,6 +111,26 @@ func NewServer(cfg *config.Config, log *zap.Logger, db *database.GormRepository)
 func (s *Server) initRepositories(db *database.GormRepository) {
 	// Initialize repositories
 	s.templates = database.NewTemplateRepository(db.DB())
+	s.tasks = database.NewTaskRepository(db.DB())
+	s.agents = database.NewAgentRepository(db.DB())
+	s.calendars = database.NewCalendarRepository(db.DB())
+	s.guard = calendar.NewGuard(s.calendars, s.agents, s.templates)
+	s.matcher = capabilities.NewMatcher(s.agents, s.config.GRPC.AgentTimeout)
+	s.logs = database.NewExecutionLogRepository(db.DB())
+	s.workflows = database.NewWorkflowRepository(db.DB())
+	s.runs = database.NewWorkflowRunRepository(db.DB())
+	s.engine = workflow.NewEngine(s.logger, s.workflows, s.runs, s.tasks, s.logs, s.templates, s.matcher, s.keyring)
+	s.policies = database.NewReminderPolicyRepository(db.DB())
+	s.reminders = database.NewReminderRepository(db.DB())
+	s.users = database.NewUserRepository(db.DB())
+	s.routes = database.NewChatRouteRepository(db.DB())
+	s.messages = database.NewMessageTemplateRepository(db.DB())
+	s.identities = database.NewChatIdentityRepository(db.DB())
+	s.outbox = database.NewOutboxRepository(db.DB())
+	s.enrollments = database.NewEnrollmentTokenRepository(db.DB())
+	s.linker = chatops.NewIdentities(s.chat, s.logger, s.identities, s.users,
+		s.config.ChatOps.PortalURL, s.config.ChatOps.LinkTTL)
+	s.commands = chatops.NewCommands(s.chat, s.logger, s.templates, s.tasks, s.guard, s.matcher, s.options, s.keyring)
 	// Initialize other repositories as needed
 }
 
@@ -96,6 +158,16 @@ func (s *Server) setupRoutes() {
 		s.router.GET("/metrics", gin.WrapH(promhttp.Handler()))
 	}
 
+	// ChatOps webhooks, authenticated by the platforms' request signatures
+	chat := s.router.Group("/chatops")
+	{
+		chat.POST("/slack/interactions", s.handleSlackInteraction)
+		chat.POST("/slack/commands", s.handleSlackCommand)
+		chat.POST("/google-chat/events", s.handleChatEvent("google_chat"))
+		chat.POST("/teams/messages", s.handleChatEvent("teams"))
+		chat.POST("/mattermost/actions", s.handleChatEvent("mattermost"))
+	}
+
 	// API v1 routes
 	v1 := s.router.Group("/api/v1")
 	{
@@ -107,6 +179,8 @@ func (s *Server) setupRoutes() {
 			templates.POST("", s.handleCreateTemplate)
 			templates.PUT("/:id", s.handleUpdateTemplate)
 			templates.DELETE("/:id", s.handleDeleteTemplate)
+			templates.PUT("/:id/approval", s.handleApproveTemplate)
+			templates.GET("/:id/params/:name/options", s.handleListParamOptions)
 		}
 
 		// Tasks
@@ -126,6 +200,99 @@ func (s *Server) setupRoutes() {
 		{
 			agents.GET("", s.handleListAgents)
 			agents.GET("/:id", s.handleGetAgent)
+			agents.POST("/:id/certificate/revoke", s.handleRevokeAgentCertificate)
+		}
+
+		// Agent enrollment tokens
+		enrollments := v1.Group("/enrollment-tokens")
+		{
+			enrollments.GET("", s.handleListEnrollmentTokens)
+			enrollments.POST("", s.handleCreateEnrollmentToken)
+			enrollments.DELETE("/:id", s.handleDeleteEnrollmentToken)
+		}
+
+		// Maintenance calendars
+		calendars := v1.Group("/calendars")
+		{
+			calendars.GET("", s.handleListCalendars)
+			calendars.GET("/:id", s.handleGetCalendar)
+			calendars.POST("", s.handleCreateCalendar)
+			calendars.PUT("/:id", s.handleUpdateCalendar)
+			calendars.DELETE("/:id", s.handleDeleteCalendar)
+		}
+
+		// Reminders
+		reminders := v1.Group("/reminders")
+		{
+			reminders.POST("/:id/snooze", s.handleSnoozeReminder)
+		}
+
+		// Reminder policies
+		policies := v1.Group("/reminder-policies")
+		{
+			policies.GET("", s.handleListReminderPolicies)
+			policies.GET("/:id", s.handleGetReminderPolicy)
+			policies.POST("", s.handleCreateReminderPolicy)
+			policies.PUT("/:id", s.handleUpdateReminderPolicy)
+			policies.DELETE("/:id", s.handleDeleteReminderPolicy)
+		}
+
+		// Chat routes
+		routes := v1.Group("/chat-routes")
+		{
+			routes.GET("", s.handleListChatRoutes)
+			routes.GET("/:id", s.handleGetChatRoute)
+			routes.POST("", s.handleCreateChatRoute)
+			routes.PUT("/:id", s.handleUpdateChatRoute)
+			routes.DELETE("/:id", s.handleDeleteChatRoute)
+		}
+
+		// Message templates
+		messages := v1.Group("/message-templates")
+		{
+			messages.GET("", s.handleListMessageTemplates)
+			messages.GET("/:id", s.handleGetMessageTemplate)
+			messages.POST("", s.handleCreateMessageTemplate)
+			messages.POST("/preview", s.handlePreviewMessageTemplate)
+			messages.PUT("/:id", s.handleUpdateMessageTemplate)
+			messages.DELETE("/:id", s.handleDeleteMessageTemplate)
+		}
+
+		// Chat identities
+		identities := v1.Group("/chat-identities")
+		{
+			identities.GET("", s.handleListChatIdentities)
+			identities.POST("", s.handleCreateChatIdentity)
+			identities.DELETE("/:id", s.handleDeleteChatIdentity)
+		}
+		v1.POST("/chat-links/confirm", s.handleConfirmChatLink)
+
+		// Chat outbox
+		outbox := v1.Group("/outbox")
+		{
+			outbox.GET("", s.handleListOutbox)
+			outbox.GET("/:id", s.handleGetOutboxMessage)
+			outbox.POST("/:id/retry", s.handleRetryOutboxMessage)
+		}
+
+		// Workflows
+		workflows := v1.Group("/workflows")
+		{
+			workflows.GET("", s.handleListWorkflows)
+			workflows.GET("/:id", s.handleGetWorkflow)
+			workflows.POST("", s.handleCreateWorkflow)
+			workflows.PUT("/:id", s.handleUpdateWorkflow)
+			workflows.DELETE("/:id", s.handleDeleteWorkflow)
+			workflows.GET("/:id/runs", s.handleListWorkflowRuns)
+			workflows.POST("/:id/runs", s.handleStartWorkflowRun)
+		}
+
+		// Workflow runs
+		runs := v1.Group("/workflow-runs")
+		{
+			runs.GET("/:id", s.handleGetWorkflowRun)
+			runs.POST("/:id/cancel", s.handleCancelWorkflowRun)
+			runs.GET("/:id/steps/:step/logs", s.handleGetWorkflowStepLogs)
 		}
 
 		// WebSocket for real-time logs
@@ -137,6 +304,11 @@ func (s *Server) setupRoutes() {
 
 // Start starts the server
 func (s *Server) Start() error {
+	// Start the workers that carry out chat interactions
+	ctx, cancel := context.WithCancel(context.Background())
+	s.stopChat = cancel
+	s.chatWG = s.chat.StartWorkers(ctx, &chatActions{server: s}, s.config.ChatOps.InteractionWorkers)
+
 	// Start the server in a goroutine
 	go func() {
 		s.logger.Info("Starting server", zap.String("address", s.config.Server.Address()))
@@ -169,6 +341,12 @@ func (s *Server) Stop() error {
 		return fmt.Errorf("server shutdown failed: %w", err)
 	}
 
+	// Stop the chat interaction workers
+	if s.stopChat != nil {
+		s.stopChat()
+		s.chatWG.Wait()
+	}
+
 	s.logger.Info("Server stopped")
 	return nil
 }
@@ -250,6 +428,66 @@ func contains(slice []string, item string) bool {
 	return false
 }
 
+// currentUser returns the authenticated user set by the auth middleware, if any
+func currentUser(c *gin.Context) *models.User {
+	value, ok := c.Get("user")
+	if !ok {
+		return nil
+	}
+	user, _ := value.(*models.User)
+	return user
+}
+
+// parseID parses the :id path parameter, responding with 400 if it is invalid
+func parseID(c *gin.Context) (uint, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil || id == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid ID"})
+		return 0, false
+	}
+	return uint(id), true
+}
+
+// loadTemplates loads templates by ID, failing with ErrValidation if any does not exist
+func (s *Server) loadTemplates(ctx context.Context, ids []uint) ([]models.Template, error) {
+	templates := make([]models.Template, 0, len(ids))
+	for _, id := range ids {
+		template, err := s.templates.GetByID(ctx, id)
+		if err != nil {
+			if errors.Is(err, database.ErrNotFound) || errors.Is(err, database.ErrInvalidID) {
+				return nil, fmt.Errorf("%w: template %d does not exist", database.ErrValidation, id)
+			}
+			return nil, err
+		}
+		templates = append(templates, *template)
+	}
+	return templates, nil
+}
+
+// respondError maps repository and domain errors to HTTP responses
+func (s *Server) respondError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, database.ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, database.ErrInvalidID), errors.Is(err, database.ErrValidation),
+		errors.Is(err, calendar.ErrBreakGlassReasonRequired), errors.Is(err, scheduler.ErrInvalidSnooze),
+		errors.Is(err, chatops.ErrInvalidLinkToken), errors.Is(err, params.ErrInvalidParams):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, calendar.ErrFrozen), errors.Is(err, calendar.ErrOutsideWindow), errors.Is(err, errNotPermitted):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	case errors.Is(err, scheduler.ErrReminderNotActive), errors.Is(err, scheduler.ErrSnoozeLimitReached),
+		errors.Is(err, errTaskNotRunnable), errors.Is(err, capabilities.ErrUnsatisfied):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, agentserver.ErrQueryFailed):
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+	case errors.Is(err, agentserver.ErrNoAgent), errors.Is(err, agentserver.ErrAgentUnavailable):
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+	default:
+		s.logger.Error("Request failed", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+	}
+}
+
 // handleHealth handles the health check endpoint
 func (s *Server) handleHealth(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{
@@ -309,9 +547,79 @@ func (s *Server) handleDeleteTask(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "Delete task"})
 }
 
+// errTaskNotRunnable is returned when running or cancelling a task that already ran or was cancelled
+var errTaskNotRunnable = errors.New("task cannot be changed in its current state")
+
+// executeTaskRequest is the optional body of a "Run now" request
+type executeTaskRequest struct {
+	BreakGlassReason string `json:"break_glass_reason"`
+}
+
 func (s *Server) handleExecuteTask(c *gin.Context) {
-	// TODO: Implement
-	c.JSON(http.StatusOK, gin.H{"message": "Execute task"})
+	id, ok := parseID(c)
+	if !ok {
+		return
+	}
+
+	var req executeTaskRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	task, err := s.tasks.GetByID(c.Request.Context(), id)
+	if err != nil {
+		s.respondError(c, err)
+		return
+	}
+
+	if err := s.runTaskNow(c.Request.Context(), task, currentUser(c), req.BreakGlassReason); err != nil {
+		s.respondError(c, err)
+		return
+	}
+
+	task.Params = models.JSONSchema(secrets.Redact(task.Params))
+	c.JSON(http.StatusOK, task)
+}
+
+// runTaskNow marks a pending or scheduled task ready for dispatch. A change freeze blocks it
+// unless the user breaks glass with a reason, which is recorded on the task.
+func (s *Server) runTaskNow(ctx context.Context, task *models.TaskInstance, user *models.User, reason string) error {
+	if task.State != models.TaskStatePending && task.State != models.TaskStateScheduled {
+		return fmt.Errorf("%w: task is %s", errTaskNotRunnable, task.State)
+	}
+
+	// Reject runs during a change freeze unless the user breaks glass
+	if err := s.guard.AuthorizeRunNow(ctx, task, user, reason); err != nil {
+		return err
+	}
+
+	// Reject runs no online agent can take instead of letting them time out
+	template, err := s.templates.GetByID(ctx, task.TemplateID)
+	if err != nil {
+		return fmt.Errorf("failed to get template: %w", err)
+	}
+	if err := s.matcher.Check(ctx, template); err != nil {
+		return err
+	}
+	if task.BreakGlassBy != nil {
+		s.logger.Warn("Change freeze overridden",
+			zap.Uint("task_id", task.ID),
+			zap.Uint("user_id", *task.BreakGlassBy),
+			zap.String("reason", task.BreakGlassReason))
+	}
+
+	// Pending without a due time means ready for dispatch; the reminder is marked actioned by
+	// the scheduler when it sees the task has moved on
+	task.State = models.TaskStatePending
+	task.DueAt = nil
+	if err := s.tasks.Update(ctx, task); err != nil {
+		return fmt.Errorf("failed to update task: %w", err)
+	}
+
+	return nil
 }
 
 func (s *Server) handleGetTaskLogs(c *gin.Context) {
@@ -319,6 +627,38 @@ func (s *Server) handleGetTaskLogs(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "Get task logs"})
 }
 
+// snoozeReminderRequest is the body of a snooze request; until takes precedence over duration
+type snoozeReminderRequest struct {
+	Duration string     `json:"duration"`
+	Until    *time.Time `json:"until"`
+}
+
+func (s *Server) handleSnoozeReminder(c *gin.Context) {
+	id, ok := parseID(c)
+	if !ok {
+		return
+	}
+
+	var req snoozeReminderRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	snooze := scheduler.SnoozeRequest{Duration: req.Duration, Until: req.Until}
+	if user := currentUser(c); user != nil {
+		snooze.UserID = &user.ID
+	}
+
+	followUp, err := s.scheduler.SnoozeReminder(c.Request.Context(), id, snooze)
+	if err != nil {
+		s.respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, followUp)
+}
+
 func (s *Server) handleListAgents(c *gin.Context) {
 	// TODO: Implement
 	c.JSON(http.StatusOK, gin.H{"message": "List agents"})
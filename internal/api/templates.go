@@ -0,0 +1,131 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/BogdanDolia/ops-butler/internal/database"
+	"github.com/BogdanDolia/ops-butler/internal/params"
+	"github.com/BogdanDolia/ops-butler/internal/signing"
+)
+
+// handleApproveTemplate stores an approval of a template's script, made outside the portal with an
+// approver's private key (see cmd/approve). It must match the script as stored now; tasks of the
+// template are only dispatched while it does.
+func (s *Server) handleApproveTemplate(c *gin.Context) {
+	id, ok := parseID(c)
+	if !ok {
+		return
+	}
+	if !s.requireAdmin(c, "approve templates") {
+		return
+	}
+
+	var approval signing.Approval
+	if err := c.ShouldBindJSON(&approval); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	template, err := s.templates.GetByID(c.Request.Context(), id)
+	if err != nil {
+		s.respondError(c, err)
+		return
+	}
+
+	// Reloaded on every approval so keys can be rotated without a restart
+	if s.config.GRPC.ApprovalKeysFile == "" {
+		s.respondError(c, fmt.Errorf("%w: no approval keys are configured (GRPC_TASK_APPROVAL_KEYS_FILE)", database.ErrValidation))
+		return
+	}
+	keys, err := signing.LoadKeySet(s.config.GRPC.ApprovalKeysFile)
+	if err != nil {
+		s.respondError(c, err)
+		return
+	}
+	if err := keys.Verify(template.Name, "", template.Script, approval, time.Now(), s.config.GRPC.ApprovalMaxTTL); err != nil {
+		s.respondError(c, fmt.Errorf("%w: %v", database.ErrValidation, err))
+		return
+	}
+
+	template.ScriptKeyID = approval.KeyID
+	template.ScriptExpiresAt = approval.ExpiresAt
+	template.ScriptSignature = approval.Signature
+	if err := s.templates.Update(c.Request.Context(), template); err != nil {
+		s.respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, template)
+}
+
+// handleListParamOptions lists the choices of a template parameter with a source, as the agent's
+// cluster has them now, for run forms. ?agent_id= asks a particular agent; every other query
+// parameter is the value chosen for another field, e.g. ?namespace=payments for the deployments
+// of a namespace.
+func (s *Server) handleListParamOptions(c *gin.Context) {
+	id, ok := parseID(c)
+	if !ok {
+		return
+	}
+
+	user := currentUser(c)
+	if user == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "authentication required"})
+		return
+	}
+	if !user.CanOperate() {
+		s.respondError(c, fmt.Errorf("%w: role %q may not run tasks", errNotPermitted, user.Role))
+		return
+	}
+
+	var agentID uint
+	values := make(map[string]string)
+	for name, list := range c.Request.URL.Query() {
+		if name == "agent_id" {
+			id, err := strconv.ParseUint(list[0], 10, 64)
+			if err != nil || id == 0 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid agent_id"})
+				return
+			}
+			agentID = uint(id)
+			continue
+		}
+		values[name] = list[0]
+	}
+
+	template, err := s.templates.GetByID(c.Request.Context(), id)
+	if err != nil {
+		s.respondError(c, err)
+		return
+	}
+
+	name := c.Param("name")
+	var field *params.Field
+	for _, f := range params.Fields(template.ParamsSchema) {
+		if f.Name == name {
+			field = &f
+			break
+		}
+	}
+	if field == nil {
+		s.respondError(c, fmt.Errorf("%w: template %s has no parameter %s", database.ErrNotFound, template.Name, name))
+		return
+	}
+	if field.Source == nil {
+		s.respondError(c, fmt.Errorf("%w: parameter %s has no source", database.ErrValidation, name))
+		return
+	}
+
+	options, err := s.options.ParamOptions(c.Request.Context(), template, *field, values, agentID)
+	if err != nil {
+		s.respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"options": options})
+}
@@ -0,0 +1,288 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+
+	"github.com/BogdanDolia/ops-butler/internal/models"
+	"github.com/BogdanDolia/ops-butler/internal/params"
+	"github.com/BogdanDolia/ops-butler/internal/secrets"
+	"github.com/BogdanDolia/ops-butler/internal/workflow"
+)
+
+// workflowRequest is the body of workflow create and update requests
+type workflowRequest struct {
+	Name        string                `json:"name"`
+	Description string                `json:"description"`
+	Steps       []models.WorkflowStep `json:"steps"`
+}
+
+// startWorkflowRunRequest is the body of a request to start a workflow run
+type startWorkflowRunRequest struct {
+	Params models.JSONSchema `json:"params"`
+}
+
+func (s *Server) handleListWorkflows(c *gin.Context) {
+	offset, _ := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
+
+	workflows, err := s.workflows.List(c.Request.Context(), offset, limit)
+	if err != nil {
+		s.respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, workflows)
+}
+
+func (s *Server) handleGetWorkflow(c *gin.Context) {
+	id, ok := parseID(c)
+	if !ok {
+		return
+	}
+
+	wf, err := s.workflows.GetByID(c.Request.Context(), id)
+	if err != nil {
+		s.respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, wf)
+}
+
+func (s *Server) handleCreateWorkflow(c *gin.Context) {
+	var req workflowRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	wf := &models.Workflow{
+		Name:        req.Name,
+		Description: req.Description,
+		Steps:       req.Steps,
+	}
+	if user := currentUser(c); user != nil {
+		wf.CreatedBy = user.ID
+	}
+	if err := workflow.Validate(wf); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := s.workflows.Create(c.Request.Context(), wf); err != nil {
+		s.respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, wf)
+}
+
+func (s *Server) handleUpdateWorkflow(c *gin.Context) {
+	id, ok := parseID(c)
+	if !ok {
+		return
+	}
+
+	var req workflowRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	wf, err := s.workflows.GetByID(c.Request.Context(), id)
+	if err != nil {
+		s.respondError(c, err)
+		return
+	}
+
+	wf.Name = req.Name
+	wf.Description = req.Description
+	wf.Steps = req.Steps
+	if err := workflow.Validate(wf); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := s.workflows.Update(c.Request.Context(), wf); err != nil {
+		s.respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, wf)
+}
+
+func (s *Server) handleDeleteWorkflow(c *gin.Context) {
+	id, ok := parseID(c)
+	if !ok {
+		return
+	}
+
+	if err := s.workflows.Delete(c.Request.Context(), id); err != nil {
+		s.respondError(c, err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+func (s *Server) handleListWorkflowRuns(c *gin.Context) {
+	id, ok := parseID(c)
+	if !ok {
+		return
+	}
+	offset, _ := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
+
+	runs, err := s.runs.ListByWorkflowID(c.Request.Context(), id, offset, limit)
+	if err != nil {
+		s.respondError(c, err)
+		return
+	}
+
+	for _, run := range runs {
+		redactRun(run)
+	}
+	c.JSON(http.StatusOK, runs)
+}
+
+func (s *Server) handleStartWorkflowRun(c *gin.Context) {
+	id, ok := parseID(c)
+	if !ok {
+		return
+	}
+
+	var req startWorkflowRunRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	wf, err := s.workflows.GetByID(c.Request.Context(), id)
+	if err != nil {
+		s.respondError(c, err)
+		return
+	}
+
+	// Run params named like a secret field of a step's template are sealed like task params, and
+	// stay sealed when rendered into that field
+	for _, step := range wf.Steps {
+		template, err := s.templates.GetByID(c.Request.Context(), step.TemplateID)
+		if err != nil {
+			s.respondError(c, err)
+			return
+		}
+		if err := params.Seal(template.ParamsSchema, req.Params, s.keyring); err != nil {
+			s.respondError(c, err)
+			return
+		}
+	}
+
+	var createdBy uint
+	if user := currentUser(c); user != nil {
+		createdBy = user.ID
+	}
+	run := workflow.NewRun(wf, req.Params, models.TaskOriginAPI, createdBy)
+	if err := s.runs.Create(c.Request.Context(), run); err != nil {
+		s.respondError(c, err)
+		return
+	}
+
+	// Start the root steps right away instead of waiting for the scheduler
+	if err := s.engine.Advance(c.Request.Context(), run); err != nil {
+		s.logger.Error("Failed to advance workflow run", zap.Uint("run_id", run.ID), zap.Error(err))
+	}
+
+	redactRun(run)
+	c.JSON(http.StatusCreated, run)
+}
+
+func (s *Server) handleGetWorkflowRun(c *gin.Context) {
+	id, ok := parseID(c)
+	if !ok {
+		return
+	}
+
+	run, err := s.runs.GetByID(c.Request.Context(), id)
+	if err != nil {
+		s.respondError(c, err)
+		return
+	}
+
+	redactRun(run)
+	c.JSON(http.StatusOK, run)
+}
+
+func (s *Server) handleCancelWorkflowRun(c *gin.Context) {
+	id, ok := parseID(c)
+	if !ok {
+		return
+	}
+
+	run, err := s.runs.GetByID(c.Request.Context(), id)
+	if err != nil {
+		s.respondError(c, err)
+		return
+	}
+
+	if run.State != models.WorkflowRunStateRunning {
+		c.JSON(http.StatusConflict, gin.H{"error": "workflow run is not running"})
+		return
+	}
+	if err := s.engine.Cancel(c.Request.Context(), run); err != nil {
+		s.respondError(c, err)
+		return
+	}
+
+	redactRun(run)
+	c.JSON(http.StatusOK, run)
+}
+
+func (s *Server) handleGetWorkflowStepLogs(c *gin.Context) {
+	id, ok := parseID(c)
+	if !ok {
+		return
+	}
+	offset, _ := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "500"))
+
+	run, err := s.runs.GetByID(c.Request.Context(), id)
+	if err != nil {
+		s.respondError(c, err)
+		return
+	}
+
+	var stepRun *models.WorkflowStepRun
+	for i := range run.Steps {
+		if run.Steps[i].StepName == c.Param("step") {
+			stepRun = &run.Steps[i]
+			break
+		}
+	}
+	if stepRun == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "step not found"})
+		return
+	}
+	if stepRun.TaskID == nil {
+		c.JSON(http.StatusOK, []*models.ExecutionLog{})
+		return
+	}
+
+	logs, err := s.logs.ListByTaskID(c.Request.Context(), *stepRun.TaskID, offset, limit)
+	if err != nil {
+		s.respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, logs)
+}
+
+// redactRun masks sealed run params before a run is returned
+func redactRun(run *models.WorkflowRun) {
+	run.Params = models.JSONSchema(secrets.Redact(run.Params))
+}
@@ -0,0 +1,303 @@
+package calendar
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/BogdanDolia/ops-butler/internal/models"
+)
+
+// Horizon is how far ahead NextAllowed is searched for
+const Horizon = 31 * 24 * time.Hour
+
+// Decision describes whether a task may be dispatched at a given time
+type Decision struct {
+	Allowed     bool
+	Frozen      bool       // true when a change freeze blocks dispatch, false when outside a maintenance window
+	Calendar    string     // name of the calendar that blocked dispatch
+	Reason      string     // human readable explanation
+	NextAllowed *time.Time // earliest time within Horizon at which dispatch is allowed, if any
+}
+
+// interval is a concrete occurrence of a window
+type interval struct {
+	start time.Time
+	end   time.Time
+	kind  models.WindowKind
+}
+
+// contains reports whether t falls inside [start, end)
+func (i interval) contains(t time.Time) bool {
+	return !t.Before(i.start) && t.Before(i.end)
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// Validate checks that a calendar and its windows are well formed
+func Validate(cal *models.Calendar) error {
+	if cal.Name == "" {
+		return fmt.Errorf("calendar name is required")
+	}
+	if _, err := loadLocation(cal.TimeZone); err != nil {
+		return err
+	}
+
+	for i, w := range cal.Windows {
+		if w.Kind != models.WindowKindAllow && w.Kind != models.WindowKindFreeze {
+			return fmt.Errorf("window %d: unknown kind %q", i, w.Kind)
+		}
+
+		oneOff := w.StartAt != nil || w.EndAt != nil
+		recurring := w.StartTime != "" || w.DurationMinutes != 0 || w.Weekdays != ""
+		switch {
+		case oneOff && recurring:
+			return fmt.Errorf("window %d: either start_at/end_at or start_time/duration_minutes must be set, not both", i)
+		case oneOff:
+			if w.StartAt == nil || w.EndAt == nil || !w.EndAt.After(*w.StartAt) {
+				return fmt.Errorf("window %d: end_at must be after start_at", i)
+			}
+		case recurring:
+			if _, _, err := parseClock(w.StartTime); err != nil {
+				return fmt.Errorf("window %d: %w", i, err)
+			}
+			if w.DurationMinutes <= 0 {
+				return fmt.Errorf("window %d: duration_minutes must be positive", i)
+			}
+			if _, err := parseWeekdays(w.Weekdays); err != nil {
+				return fmt.Errorf("window %d: %w", i, err)
+			}
+		default:
+			return fmt.Errorf("window %d: no schedule set", i)
+		}
+	}
+
+	return nil
+}
+
+// Applies reports whether a calendar restricts tasks of the given template running on an agent with the given labels.
+// Agent selectors only match when the target agent is known; see AppliesToAny otherwise.
+func Applies(cal *models.Calendar, templateID uint, agentLabels map[string]interface{}) bool {
+	if !cal.Enabled {
+		return false
+	}
+
+	if len(cal.Templates) > 0 {
+		attached := false
+		for _, t := range cal.Templates {
+			if t.ID == templateID {
+				attached = true
+				break
+			}
+		}
+		if !attached {
+			return false
+		}
+	}
+
+	return Matches(cal.AgentSelector, agentLabels)
+}
+
+// AppliesToAny reports whether a calendar restricts tasks of the given template that may run on
+// any of the candidate agents, given by their labels. It fails closed: a calendar with an agent
+// selector applies when no candidate is known, since the task might run anywhere.
+func AppliesToAny(cal *models.Calendar, templateID uint, candidates []map[string]interface{}) bool {
+	if len(candidates) == 0 {
+		// The selector can't be evaluated, so it is taken to match
+		return Applies(cal, templateID, cal.AgentSelector)
+	}
+	for _, labels := range candidates {
+		if Applies(cal, templateID, labels) {
+			return true
+		}
+	}
+	return false
+}
+
+// Matches reports whether labels carry all the labels of a selector
+func Matches(selector map[string]interface{}, labels map[string]interface{}) bool {
+	for key, want := range selector {
+		got, ok := labels[key]
+		if !ok || fmt.Sprint(got) != fmt.Sprint(want) {
+			return false
+		}
+	}
+	return true
+}
+
+// Check evaluates the given calendars at time t and, if dispatch is blocked, finds the next allowed time
+func Check(cals []*models.Calendar, t time.Time) Decision {
+	decision := evaluate(cals, t)
+	if decision.Allowed {
+		return decision
+	}
+
+	// Every change in the outcome happens at a window boundary, so only those need testing
+	var candidates []time.Time
+	for _, cal := range cals {
+		loc, err := loadLocation(cal.TimeZone)
+		if err != nil {
+			continue
+		}
+		for _, w := range cal.Windows {
+			for _, occ := range occurrences(w, loc, t, t.Add(Horizon)) {
+				for _, b := range []time.Time{occ.start, occ.end} {
+					if b.After(t) {
+						candidates = append(candidates, b)
+					}
+				}
+			}
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].Before(candidates[j]) })
+
+	for _, c := range candidates {
+		if evaluate(cals, c).Allowed {
+			next := c
+			decision.NextAllowed = &next
+			break
+		}
+	}
+
+	return decision
+}
+
+// evaluate checks every calendar at time t without looking ahead
+func evaluate(cals []*models.Calendar, t time.Time) Decision {
+	for _, cal := range cals {
+		loc, err := loadLocation(cal.TimeZone)
+		if err != nil {
+			loc = time.UTC
+		}
+
+		hasAllow := false
+		inAllow := false
+		for _, w := range cal.Windows {
+			for _, occ := range occurrences(w, loc, t, t.Add(time.Nanosecond)) {
+				if !occ.contains(t) {
+					continue
+				}
+				if occ.kind == models.WindowKindFreeze {
+					return Decision{
+						Frozen:   true,
+						Calendar: cal.Name,
+						Reason:   freezeReason(cal, w),
+					}
+				}
+				inAllow = true
+			}
+			if w.Kind == models.WindowKindAllow {
+				hasAllow = true
+			}
+		}
+
+		if hasAllow && !inAllow {
+			return Decision{
+				Calendar: cal.Name,
+				Reason:   fmt.Sprintf("outside maintenance windows of calendar %q", cal.Name),
+			}
+		}
+	}
+
+	return Decision{Allowed: true}
+}
+
+// freezeReason describes a freeze window
+func freezeReason(cal *models.Calendar, w models.CalendarWindow) string {
+	if w.Description != "" {
+		return fmt.Sprintf("change freeze %q in calendar %q", w.Description, cal.Name)
+	}
+	return fmt.Sprintf("change freeze in calendar %q", cal.Name)
+}
+
+// occurrences returns the occurrences of a window that overlap [from, to)
+func occurrences(w models.CalendarWindow, loc *time.Location, from, to time.Time) []interval {
+	if w.StartAt != nil && w.EndAt != nil {
+		occ := interval{start: *w.StartAt, end: *w.EndAt, kind: w.Kind}
+		if occ.end.After(from) && occ.start.Before(to) {
+			return []interval{occ}
+		}
+		return nil
+	}
+
+	hour, minute, err := parseClock(w.StartTime)
+	if err != nil || w.DurationMinutes <= 0 {
+		return nil
+	}
+	days, err := parseWeekdays(w.Weekdays)
+	if err != nil {
+		return nil
+	}
+	duration := time.Duration(w.DurationMinutes) * time.Minute
+
+	// Start early enough to catch occurrences that began before from and are still open
+	local := from.In(loc)
+	day := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, loc)
+	day = day.AddDate(0, 0, -int(duration/(24*time.Hour))-1)
+
+	var result []interval
+	for ; day.Before(to); day = day.AddDate(0, 0, 1) {
+		if len(days) > 0 && !days[day.Weekday()] {
+			continue
+		}
+		start := time.Date(day.Year(), day.Month(), day.Day(), hour, minute, 0, 0, loc)
+		occ := interval{start: start, end: start.Add(duration), kind: w.Kind}
+		if occ.end.After(from) && occ.start.Before(to) {
+			result = append(result, occ)
+		}
+	}
+
+	return result
+}
+
+// loadLocation loads an IANA time zone, defaulting to UTC
+func loadLocation(name string) (*time.Location, error) {
+	if name == "" {
+		return time.UTC, nil
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, fmt.Errorf("invalid time zone %q: %w", name, err)
+	}
+	return loc, nil
+}
+
+// parseClock parses an HH:MM time of day
+func parseClock(value string) (int, int, error) {
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid start_time %q, expected HH:MM", value)
+	}
+	return t.Hour(), t.Minute(), nil
+}
+
+// parseWeekdays parses a comma-separated list of weekday abbreviations
+func parseWeekdays(value string) (map[time.Weekday]bool, error) {
+	result := make(map[time.Weekday]bool)
+	if strings.TrimSpace(value) == "" {
+		return result, nil
+	}
+
+	for _, part := range strings.Split(value, ",") {
+		name := strings.ToLower(strings.TrimSpace(part))
+		if len(name) > 3 {
+			name = name[:3]
+		}
+		day, ok := weekdays[name]
+		if !ok {
+			return nil, fmt.Errorf("invalid weekday %q", part)
+		}
+		result[day] = true
+	}
+
+	return result, nil
+}
@@ -0,0 +1,157 @@
+package calendar
+
+import (
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/BogdanDolia/ops-butler/internal/models"
+)
+
+func TestApplies(t *testing.T) {
+	template := func(id uint) models.Template { return models.Template{Model: gorm.Model{ID: id}} }
+	prod := map[string]interface{}{"env": "prod", "region": "eu"}
+
+	tests := []struct {
+		name       string
+		cal        models.Calendar
+		templateID uint
+		labels     map[string]interface{}
+		want       bool
+	}{
+		{name: "applies to everything", cal: models.Calendar{Enabled: true}, templateID: 1, labels: prod, want: true},
+		{name: "disabled", cal: models.Calendar{}, templateID: 1, labels: prod},
+		{name: "attached template", cal: models.Calendar{Enabled: true, Templates: []models.Template{template(1), template(2)}},
+			templateID: 2, labels: prod, want: true},
+		{name: "other template", cal: models.Calendar{Enabled: true, Templates: []models.Template{template(1)}},
+			templateID: 2, labels: prod},
+		{name: "matching selector", cal: models.Calendar{Enabled: true, AgentSelector: models.JSONSchema{"env": "prod"}},
+			templateID: 1, labels: prod, want: true},
+		{name: "selector with another value", cal: models.Calendar{Enabled: true, AgentSelector: models.JSONSchema{"env": "staging"}},
+			templateID: 1, labels: prod},
+		{name: "selector label missing", cal: models.Calendar{Enabled: true, AgentSelector: models.JSONSchema{"team": "db"}},
+			templateID: 1, labels: prod},
+		{name: "selector without agent labels", cal: models.Calendar{Enabled: true, AgentSelector: models.JSONSchema{"env": "prod"}},
+			templateID: 1},
+		{name: "template and selector must both match",
+			cal:        models.Calendar{Enabled: true, Templates: []models.Template{template(1)}, AgentSelector: models.JSONSchema{"env": "staging"}},
+			templateID: 1, labels: prod},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Applies(&tt.cal, tt.templateID, tt.labels); got != tt.want {
+				t.Errorf("Applies() = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAppliesToAny(t *testing.T) {
+	prodOnly := &models.Calendar{Enabled: true, AgentSelector: models.JSONSchema{"env": "prod"}}
+
+	tests := []struct {
+		name       string
+		cal        *models.Calendar
+		candidates []map[string]interface{}
+		want       bool
+	}{
+		{name: "one candidate matches", cal: prodOnly,
+			candidates: []map[string]interface{}{{"env": "staging"}, {"env": "prod"}}, want: true},
+		{name: "no candidate matches", cal: prodOnly,
+			candidates: []map[string]interface{}{{"env": "staging"}, {"env": "dev"}}},
+		{name: "unknown agent fails closed", cal: prodOnly, want: true},
+		{name: "disabled with unknown agent", cal: &models.Calendar{AgentSelector: models.JSONSchema{"env": "prod"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := AppliesToAny(tt.cal, 1, tt.candidates); got != tt.want {
+				t.Errorf("AppliesToAny() = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMatches(t *testing.T) {
+	tests := []struct {
+		name     string
+		selector map[string]interface{}
+		labels   map[string]interface{}
+		want     bool
+	}{
+		{name: "empty selector", labels: map[string]interface{}{"env": "prod"}, want: true},
+		{name: "subset", selector: map[string]interface{}{"env": "prod"}, labels: map[string]interface{}{"env": "prod", "zone": "a"}, want: true},
+		{name: "different value", selector: map[string]interface{}{"env": "prod"}, labels: map[string]interface{}{"env": "dev"}},
+		{name: "missing label", selector: map[string]interface{}{"env": "prod"}, labels: map[string]interface{}{}},
+		{name: "values compared as text", selector: map[string]interface{}{"gpu": true, "cores": float64(8)},
+			labels: map[string]interface{}{"gpu": "true", "cores": "8"}, want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Matches(tt.selector, tt.labels); got != tt.want {
+				t.Errorf("Matches() = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheck(t *testing.T) {
+	at := func(day, hour, minute int) time.Time {
+		// March 2024: the 11th is a Monday
+		return time.Date(2024, time.March, day, hour, minute, 0, 0, time.UTC)
+	}
+	ptr := func(t time.Time) *time.Time { return &t }
+
+	nightly := &models.Calendar{Name: "nightly", Enabled: true, Windows: []models.CalendarWindow{
+		{Kind: models.WindowKindAllow, Weekdays: "mon,tue,wed,thu,fri", StartTime: "22:00", DurationMinutes: 240},
+	}}
+	freeze := &models.Calendar{Name: "release", Enabled: true, Windows: []models.CalendarWindow{
+		{Kind: models.WindowKindFreeze, Description: "v2 launch", StartAt: ptr(at(13, 0, 0)), EndAt: ptr(at(14, 0, 0))},
+	}}
+	kyiv := &models.Calendar{Name: "kyiv", Enabled: true, TimeZone: "Europe/Kyiv", Windows: []models.CalendarWindow{
+		{Kind: models.WindowKindAllow, StartTime: "09:00", DurationMinutes: 60},
+	}}
+
+	tests := []struct {
+		name        string
+		cals        []*models.Calendar
+		t           time.Time
+		wantAllowed bool
+		wantFrozen  bool
+		wantNext    *time.Time
+	}{
+		{name: "no calendars", t: at(11, 12, 0), wantAllowed: true},
+		{name: "inside a window", cals: []*models.Calendar{nightly}, t: at(11, 23, 0), wantAllowed: true},
+		{name: "window runs past midnight", cals: []*models.Calendar{nightly}, t: at(12, 1, 30), wantAllowed: true},
+		{name: "window end is exclusive", cals: []*models.Calendar{nightly}, t: at(12, 2, 0), wantNext: ptr(at(12, 22, 0))},
+		{name: "outside windows", cals: []*models.Calendar{nightly}, t: at(11, 12, 0), wantNext: ptr(at(11, 22, 0))},
+		{name: "weekend waits for monday", cals: []*models.Calendar{nightly}, t: at(16, 12, 0), wantNext: ptr(at(18, 22, 0))},
+		{name: "freeze", cals: []*models.Calendar{freeze}, t: at(13, 12, 0), wantFrozen: true, wantNext: ptr(at(14, 0, 0))},
+		{name: "freeze wins over a window", cals: []*models.Calendar{nightly, freeze}, t: at(13, 23, 0), wantFrozen: true,
+			wantNext: ptr(at(14, 0, 0))},
+		{name: "window partly frozen opens when the freeze ends", cals: []*models.Calendar{nightly, freeze}, t: at(13, 12, 0),
+			wantNext: ptr(at(14, 0, 0))},
+		// 09:00 in Kyiv is 07:00 UTC in March
+		{name: "window in the calendar time zone", cals: []*models.Calendar{kyiv}, t: at(11, 7, 30), wantAllowed: true},
+		{name: "next window in the calendar time zone", cals: []*models.Calendar{kyiv}, t: at(11, 9, 0), wantNext: ptr(at(12, 7, 0))},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Check(tt.cals, tt.t)
+			if got.Allowed != tt.wantAllowed || got.Frozen != tt.wantFrozen {
+				t.Fatalf("Check() = allowed %t, frozen %t (%s), want allowed %t, frozen %t",
+					got.Allowed, got.Frozen, got.Reason, tt.wantAllowed, tt.wantFrozen)
+			}
+			switch {
+			case tt.wantNext == nil && got.NextAllowed != nil:
+				t.Errorf("NextAllowed = %s, want none", got.NextAllowed)
+			case tt.wantNext != nil && (got.NextAllowed == nil || !got.NextAllowed.Equal(*tt.wantNext)):
+				t.Errorf("NextAllowed = %v, want %s", got.NextAllowed, tt.wantNext)
+			}
+		})
+	}
+}
@@ -0,0 +1,134 @@
+package calendar
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/BogdanDolia/ops-butler/internal/database"
+	"github.com/BogdanDolia/ops-butler/internal/models"
+)
+
+var (
+	// ErrFrozen is returned when a change freeze blocks a task
+	ErrFrozen = errors.New("change freeze in effect")
+	// ErrOutsideWindow is returned when a task is outside its maintenance windows
+	ErrOutsideWindow = errors.New("outside maintenance window")
+	// ErrBreakGlassReasonRequired is returned when a break-glass override is attempted without a reason
+	ErrBreakGlassReasonRequired = errors.New("break-glass reason is required")
+)
+
+// Guard checks tasks against the calendars that apply to them
+type Guard struct {
+	calendars database.CalendarRepository
+	agents    database.AgentRepository
+	templates database.TemplateRepository
+}
+
+// NewGuard creates a new Guard
+func NewGuard(calendars database.CalendarRepository, agents database.AgentRepository,
+	templates database.TemplateRepository) *Guard {
+	return &Guard{
+		calendars: calendars,
+		agents:    agents,
+		templates: templates,
+	}
+}
+
+// Check decides whether a task may be dispatched at time t. Calendars with an agent selector are
+// matched against the task's agent once it has one, and before that against every agent its
+// template may run on.
+func (g *Guard) Check(ctx context.Context, task *models.TaskInstance, t time.Time) (Decision, error) {
+	cals, err := g.calendars.ListEnabled(ctx)
+	if err != nil {
+		return Decision{}, fmt.Errorf("failed to list calendars: %w", err)
+	}
+	if len(cals) == 0 {
+		return Decision{Allowed: true}, nil
+	}
+
+	candidates, err := g.candidates(ctx, task)
+	if err != nil {
+		return Decision{}, err
+	}
+
+	var applicable []*models.Calendar
+	for _, cal := range cals {
+		if AppliesToAny(cal, task.TemplateID, candidates) {
+			applicable = append(applicable, cal)
+		}
+	}
+
+	return Check(applicable, t), nil
+}
+
+// candidates returns the labels of the agents a task may run on: its agent once it has one,
+// otherwise the agents carrying its template's agent selector. None are returned if the agent is
+// gone, which makes every calendar with an agent selector apply.
+func (g *Guard) candidates(ctx context.Context, task *models.TaskInstance) ([]map[string]interface{}, error) {
+	if task.AgentID != nil {
+		agent, err := g.agents.GetByID(ctx, *task.AgentID)
+		if err != nil {
+			if errors.Is(err, database.ErrNotFound) {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("failed to get agent: %w", err)
+		}
+		return []map[string]interface{}{agent.Labels}, nil
+	}
+
+	template, err := g.templates.GetByID(ctx, task.TemplateID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get template: %w", err)
+	}
+
+	const pageSize = 100
+	var candidates []map[string]interface{}
+	for offset := 0; ; offset += pageSize {
+		agents, err := g.agents.List(ctx, offset, pageSize)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list agents: %w", err)
+		}
+		for _, agent := range agents {
+			if Matches(template.AgentSelector, agent.Labels) {
+				candidates = append(candidates, agent.Labels)
+			}
+		}
+		if len(agents) < pageSize {
+			break
+		}
+	}
+	return candidates, nil
+}
+
+// AuthorizeRunNow checks whether user may run a task immediately. During a freeze or outside a
+// maintenance window this requires the user's break-glass permission and a reason, which are
+// recorded on the task; the caller is responsible for persisting it.
+func (g *Guard) AuthorizeRunNow(ctx context.Context, task *models.TaskInstance, user *models.User, reason string) error {
+	decision, err := g.Check(ctx, task, time.Now())
+	if err != nil {
+		return err
+	}
+	if decision.Allowed {
+		return nil
+	}
+
+	blocked := ErrOutsideWindow
+	if decision.Frozen {
+		blocked = ErrFrozen
+	}
+	if user == nil || !user.BreakGlass {
+		return fmt.Errorf("%w: %s", blocked, decision.Reason)
+	}
+
+	reason = strings.TrimSpace(reason)
+	if reason == "" {
+		return ErrBreakGlassReasonRequired
+	}
+
+	task.BreakGlassBy = &user.ID
+	task.BreakGlassReason = reason
+	return nil
+}
@@ -0,0 +1,265 @@
+package capabilities
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BogdanDolia/ops-butler/internal/database"
+	"github.com/BogdanDolia/ops-butler/internal/models"
+)
+
+// Requirement names that don't refer to a binary
+const (
+	NameKubernetes = "kubernetes" // the cluster's server version, e.g. kubernetes>=1.27
+	NameNodes      = "nodes"      // the cluster's node count, e.g. nodes>=3
+	NameNamespace  = "namespace"  // the agent's namespace, e.g. namespace=payments
+	NameBackend    = "backend"    // an interpreter scripts can run with, e.g. backend=python3
+)
+
+var (
+	// ErrInvalidRequirement is returned for template requirements that can't be parsed
+	ErrInvalidRequirement = errors.New("invalid requirement")
+	// ErrUnsatisfied is returned when no online agent satisfies a template's requirements
+	ErrUnsatisfied = errors.New("no online agent satisfies the template's requirements")
+)
+
+// Capabilities is what an agent reports it can do. It is stored on models.ClusterAgent.
+type Capabilities struct {
+	Binaries          map[string]string `json:"binaries,omitempty"` // tool name to version, e.g. helm: 3.12.3
+	Backends          []string          `json:"backends,omitempty"` // interpreters scripts can run with
+	KubernetesVersion string            `json:"kubernetes_version,omitempty"`
+	NodeCount         int               `json:"node_count,omitempty"`
+	Namespace         string            `json:"namespace,omitempty"`
+}
+
+// FromMap decodes capabilities stored on an agent
+func FromMap(m map[string]interface{}) Capabilities {
+	var c Capabilities
+	if data, err := json.Marshal(m); err == nil {
+		_ = json.Unmarshal(data, &c)
+	}
+	return c
+}
+
+// Map encodes capabilities for storing on an agent
+func (c Capabilities) Map() map[string]interface{} {
+	m := make(map[string]interface{})
+	if data, err := json.Marshal(c); err == nil {
+		_ = json.Unmarshal(data, &m)
+	}
+	return m
+}
+
+// Requirement is something a template needs from the agent it runs on: a binary, optionally in a
+// range of versions (helm>=3.12), or one of the special names above
+type Requirement struct {
+	Name    string
+	Op      string // "" if any version will do
+	Version string
+}
+
+// requirementPattern matches name, name<op>version and name=value
+var requirementPattern = regexp.MustCompile(`^([A-Za-z0-9_.-]+)\s*(?:(>=|<=|==|=|>|<)\s*(\S+))?$`)
+
+// Parse parses a requirement such as "kubectl", "helm>=3.12" or "backend=python3"
+func Parse(s string) (Requirement, error) {
+	m := requirementPattern.FindStringSubmatch(strings.TrimSpace(s))
+	if m == nil {
+		return Requirement{}, fmt.Errorf("%w %q, expected <name> or <name><op><version>", ErrInvalidRequirement, s)
+	}
+	r := Requirement{Name: m[1], Op: m[2], Version: m[3]}
+	if r.Op == "==" {
+		r.Op = "="
+	}
+
+	switch r.Name {
+	case NameNamespace, NameBackend:
+		if r.Op != "=" {
+			return Requirement{}, fmt.Errorf("%w %q, expected %s=<value>", ErrInvalidRequirement, s, r.Name)
+		}
+	default:
+		if r.Op != "" && version(r.Version) == nil {
+			return Requirement{}, fmt.Errorf("%w %q: %q is not a version", ErrInvalidRequirement, s, r.Version)
+		}
+	}
+	return r, nil
+}
+
+// String returns the requirement in its parsed form
+func (r Requirement) String() string {
+	return r.Name + r.Op + r.Version
+}
+
+// SatisfiedBy reports whether an agent with the given capabilities meets the requirement
+func (r Requirement) SatisfiedBy(c Capabilities) bool {
+	switch r.Name {
+	case NameNamespace:
+		return c.Namespace == r.Version
+	case NameBackend:
+		for _, backend := range c.Backends {
+			if backend == r.Version {
+				return true
+			}
+		}
+		return false
+	case NameKubernetes:
+		return c.KubernetesVersion != "" && r.matches(c.KubernetesVersion)
+	case NameNodes:
+		return c.NodeCount > 0 && r.matches(strconv.Itoa(c.NodeCount))
+	}
+
+	have, ok := c.Binaries[r.Name]
+	return ok && r.matches(have)
+}
+
+// matches compares a reported version with the required one
+func (r Requirement) matches(have string) bool {
+	if r.Op == "" {
+		return true
+	}
+	got := version(have)
+	if got == nil {
+		return false
+	}
+	cmp := compare(got, version(r.Version))
+	switch r.Op {
+	case ">=":
+		return cmp >= 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	case "<":
+		return cmp < 0
+	default:
+		return cmp == 0
+	}
+}
+
+// versionPattern finds the numeric part of versions such as v1.28.3+k3s1 or jq-1.6
+var versionPattern = regexp.MustCompile(`\d+(?:\.\d+)*`)
+
+// version returns the numeric components of a version, or nil if it has none
+func version(s string) []int {
+	match := versionPattern.FindString(s)
+	if match == "" {
+		return nil
+	}
+	parts := strings.Split(match, ".")
+	result := make([]int, len(parts))
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil {
+			return nil
+		}
+		result[i] = n
+	}
+	return result
+}
+
+// compare compares two versions component by component, missing components counting as 0, so
+// that 3.12 and 3.12.0 are equal
+func compare(a, b []int) int {
+	for i := 0; i < len(a) || i < len(b); i++ {
+		var x, y int
+		if i < len(a) {
+			x = a[i]
+		}
+		if i < len(b) {
+			y = b[i]
+		}
+		if x != y {
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+// Validate checks that all requirements can be parsed
+func Validate(requirements []string) error {
+	for _, s := range requirements {
+		if _, err := Parse(s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Unmet returns the requirements an agent with the given capabilities doesn't meet
+func Unmet(requirements []string, c Capabilities) ([]string, error) {
+	var unmet []string
+	for _, s := range requirements {
+		r, err := Parse(s)
+		if err != nil {
+			return nil, err
+		}
+		if !r.SatisfiedBy(c) {
+			unmet = append(unmet, r.String())
+		}
+	}
+	return unmet, nil
+}
+
+// Matcher checks templates' requirements against the capabilities of the online agents, so a
+// task no agent can run is refused when it is created rather than timing out later
+type Matcher struct {
+	agents  database.AgentRepository
+	timeout time.Duration
+}
+
+// NewMatcher creates a matcher. Agents without a heartbeat for longer than timeout are offline.
+func NewMatcher(agents database.AgentRepository, timeout time.Duration) *Matcher {
+	return &Matcher{agents: agents, timeout: timeout}
+}
+
+// Check returns an ErrUnsatisfied error, naming what the closest agent lacks, if no online agent
+// meets all requirements of a template
+func (m *Matcher) Check(ctx context.Context, template *models.Template) error {
+	if len(template.Requirements) == 0 {
+		return nil
+	}
+	if err := Validate(template.Requirements); err != nil {
+		return fmt.Errorf("%w: template %s: %v", database.ErrValidation, template.Name, err)
+	}
+
+	const pageSize = 100
+	cutoff := time.Now().Add(-m.timeout)
+	var closest *models.ClusterAgent
+	var closestUnmet []string
+	for offset := 0; ; offset += pageSize {
+		agents, err := m.agents.List(ctx, offset, pageSize)
+		if err != nil {
+			return fmt.Errorf("failed to list agents: %w", err)
+		}
+		for _, agent := range agents {
+			if agent.LastHeartbeat.Before(cutoff) {
+				continue
+			}
+			unmet, _ := Unmet(template.Requirements, FromMap(agent.Capabilities))
+			if len(unmet) == 0 {
+				return nil
+			}
+			if closest == nil || len(unmet) < len(closestUnmet) {
+				closest, closestUnmet = agent, unmet
+			}
+		}
+		if len(agents) < pageSize {
+			break
+		}
+	}
+
+	if closest == nil {
+		return fmt.Errorf("%w (%s): no agent is online", ErrUnsatisfied, strings.Join(template.Requirements, ", "))
+	}
+	return fmt.Errorf("%w (%s): closest agent %s lacks %s", ErrUnsatisfied,
+		strings.Join(template.Requirements, ", "), closest.Name, strings.Join(closestUnmet, ", "))
+}
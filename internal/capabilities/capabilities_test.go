@@ -0,0 +1,91 @@
+package capabilities
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Requirement
+		wantErr bool
+	}{
+		{in: "kubectl", want: Requirement{Name: "kubectl"}},
+		{in: "helm>=3.12", want: Requirement{Name: "helm", Op: ">=", Version: "3.12"}},
+		{in: " helm >= 3.12 ", want: Requirement{Name: "helm", Op: ">=", Version: "3.12"}},
+		{in: "jq==1.6", want: Requirement{Name: "jq", Op: "=", Version: "1.6"}},
+		{in: "backend=python3", want: Requirement{Name: NameBackend, Op: "=", Version: "python3"}},
+		{in: "namespace=payments", want: Requirement{Name: NameNamespace, Op: "=", Version: "payments"}},
+		{in: "namespace>=payments", wantErr: true},
+		{in: "backend", wantErr: true},
+		{in: "helm>=latest", wantErr: true},
+		{in: "helm 3", wantErr: true},
+		{in: "", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := Parse(tt.in)
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidRequirement) {
+					t.Errorf("Parse(%q) = %+v, %v, want ErrInvalidRequirement", tt.in, got, err)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("Parse(%q) = %+v, %v, want %+v", tt.in, got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestUnmet(t *testing.T) {
+	agent := Capabilities{
+		Binaries:          map[string]string{"kubectl": "v1.28.3", "helm": "3.12.0", "jq": "jq-1.6"},
+		Backends:          []string{"bash", "python3"},
+		KubernetesVersion: "v1.28.3+k3s1",
+		NodeCount:         3,
+		Namespace:         "payments",
+	}
+
+	tests := []struct {
+		name         string
+		requirements []string
+		want         []string
+	}{
+		{"none", nil, nil},
+		{"binaries", []string{"kubectl", "helm>=3.12", "helm<=3.12.0", "jq=1.6"}, nil},
+		{"missing binary", []string{"kubectl", "velero"}, []string{"velero"}},
+		{"old binary", []string{"helm>3.12", "helm<3"}, []string{"helm>3.12", "helm<3"}},
+		{"versions compared numerically", []string{"kubernetes>=1.9", "kubernetes<1.100"}, nil},
+		{"cluster", []string{"kubernetes>=1.29", "nodes>=3", "nodes>3"}, []string{"kubernetes>=1.29", "nodes>3"}},
+		{"namespace and backend", []string{"namespace=payments", "backend=python3", "backend=node"}, []string{"backend=node"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Unmet(tt.requirements, agent)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("Unmet(%q) = %q, want %q", tt.requirements, got, tt.want)
+			}
+		})
+	}
+
+	// Nothing about a cluster is known from an agent that didn't report it
+	if got, _ := Unmet([]string{"kubernetes", "nodes>=1"}, Capabilities{}); len(got) != 2 {
+		t.Errorf("Unmet() without cluster information = %q, want both unmet", got)
+	}
+}
+
+func TestCapabilitiesMap(t *testing.T) {
+	c := Capabilities{Binaries: map[string]string{"helm": "3.12.0"}, Backends: []string{"bash"}, NodeCount: 3}
+	got := FromMap(c.Map())
+	if got.Binaries["helm"] != "3.12.0" || !slices.Equal(got.Backends, c.Backends) || got.NodeCount != 3 {
+		t.Errorf("FromMap(Map()) = %+v, want %+v", got, c)
+	}
+}
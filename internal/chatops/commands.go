@@ -0,0 +1,712 @@
+package chatops
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/BogdanDolia/ops-butler/internal/calendar"
+	"github.com/BogdanDolia/ops-butler/internal/capabilities"
+	"github.com/BogdanDolia/ops-butler/internal/database"
+	"github.com/BogdanDolia/ops-butler/internal/models"
+	"github.com/BogdanDolia/ops-butler/internal/params"
+	"github.com/BogdanDolia/ops-butler/internal/secrets"
+)
+
+// runModalCallbackID identifies the modal opened by "/ops run <template>"
+const runModalCallbackID = "ops_run"
+
+// commandListLimit is the number of templates shown by "/ops list"
+const commandListLimit = 50
+
+// maxSelectOptions is the most options Slack accepts in a select
+const maxSelectOptions = 100
+
+// modalChoicesTimeout bounds listing the choices of a form's fields, which must fit within the
+// three seconds a slash command's trigger_id stays valid
+const modalChoicesTimeout = 2 * time.Second
+
+// commandHelp describes the /ops subcommands
+const commandHelp = "Usage:\n" +
+	"• `/ops list` lists templates\n" +
+	"• `/ops run <template> key=value ...` runs a template now; without parameters a form is opened\n" +
+	"• `/ops schedule <template> at <time> key=value ...` schedules a run, e.g. `at 14:30` or `at 2024-06-01 09:00`\n" +
+	"• `/ops status <task>` shows the state of a task\n" +
+	"• `/ops link` links your Slack account to your portal user"
+
+// errCommand is returned for mistakes in a command; its message is shown to the user
+var errCommand = errors.New("invalid command")
+
+// Commands handles /ops slash commands and the forms they open
+type Commands struct {
+	service   *Service
+	logger    *zap.Logger
+	templates database.TemplateRepository
+	tasks     database.TaskRepository
+	guard     *calendar.Guard
+	matcher   *capabilities.Matcher
+	options   params.OptionsProvider // lists choices of fields with a source; nil if unavailable
+	keyring   *secrets.Keyring       // seals secret params; nil if none is configured
+}
+
+// NewCommands creates the slash command handler and registers it with the service so that form
+// submissions arriving as interactions reach it
+func NewCommands(service *Service, logger *zap.Logger, templates database.TemplateRepository,
+	tasks database.TaskRepository, guard *calendar.Guard, matcher *capabilities.Matcher, options params.OptionsProvider,
+	keyring *secrets.Keyring) *Commands {
+	commands := &Commands{
+		service:   service,
+		logger:    logger,
+		templates: templates,
+		tasks:     tasks,
+		guard:     guard,
+		matcher:   matcher,
+		options:   options,
+		keyring:   keyring,
+	}
+	service.commands = commands
+	return commands
+}
+
+// slashCommand is a slash command invocation
+type slashCommand struct {
+	Text      string
+	TeamID    string
+	UserID    string
+	ChannelID string
+	TriggerID string
+}
+
+// HandleSlackCommand verifies and runs an /ops slash command. The returned message is the
+// immediate response; nil means an empty acknowledgement.
+func (c *Commands) HandleSlackCommand(ctx context.Context, r *http.Request, body []byte) (map[string]any, error) {
+	slack := c.service.slackClient
+	if slack == nil {
+		return nil, fmt.Errorf("slack is not enabled")
+	}
+
+	if err := slack.VerifyRequest(r, body); err != nil {
+		return nil, fmt.Errorf("failed to verify request: %w", err)
+	}
+
+	values, err := url.ParseQuery(string(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse body: %w", err)
+	}
+	cmd := slashCommand{
+		Text:      values.Get("text"),
+		TeamID:    values.Get("team_id"),
+		UserID:    values.Get("user_id"),
+		ChannelID: values.Get("channel_id"),
+		TriggerID: values.Get("trigger_id"),
+	}
+
+	text, err := c.run(ctx, cmd)
+	if err != nil {
+		c.logger.Info("Slash command failed",
+			zap.String("text", cmd.Text),
+			zap.String("user", cmd.UserID),
+			zap.Error(err))
+		return ephemeral(err.Error()), nil
+	}
+	if text == "" {
+		return nil, nil
+	}
+	return ephemeral(text), nil
+}
+
+// run carries out a slash command and returns the text to show to the user
+func (c *Commands) run(ctx context.Context, cmd slashCommand) (string, error) {
+	args := splitArgs(cmd.Text)
+	if len(args) == 0 || args[0] == "help" {
+		return commandHelp, nil
+	}
+	account := Account{Platform: "slack", Workspace: cmd.TeamID, UserID: cmd.UserID}
+	if args[0] == "link" {
+		return c.link(ctx, account)
+	}
+
+	user, err := c.slackUser(ctx, account)
+	if err != nil {
+		return "", err
+	}
+
+	switch args[0] {
+	case "list":
+		return c.list(ctx)
+	case "status":
+		if len(args) != 2 {
+			return "", fmt.Errorf("%w: usage: /ops status <task>", errCommand)
+		}
+		return c.status(ctx, args[1])
+	case "run":
+		if len(args) < 2 {
+			return "", fmt.Errorf("%w: usage: /ops run <template> key=value ...", errCommand)
+		}
+		return c.runTemplate(ctx, cmd, user, args[1], args[2:])
+	case "schedule":
+		if len(args) < 4 || args[2] != "at" {
+			return "", fmt.Errorf("%w: usage: /ops schedule <template> at <time> key=value ...", errCommand)
+		}
+		return c.schedule(ctx, cmd, user, args[1], args[3:])
+	}
+
+	return "", fmt.Errorf("%w: unknown subcommand %q\n%s", errCommand, args[0], commandHelp)
+}
+
+// list describes the available templates
+func (c *Commands) list(ctx context.Context) (string, error) {
+	templates, err := c.templates.List(ctx, 0, commandListLimit)
+	if err != nil {
+		return "", fmt.Errorf("failed to list templates: %w", err)
+	}
+	if len(templates) == 0 {
+		return "There are no templates yet.", nil
+	}
+
+	var b strings.Builder
+	b.WriteString("Templates:")
+	for _, t := range templates {
+		fmt.Fprintf(&b, "\n• *%s*", t.Name)
+		if t.Description != "" {
+			fmt.Fprintf(&b, " — %s", t.Description)
+		}
+	}
+	return b.String(), nil
+}
+
+// status describes a task
+func (c *Commands) status(ctx context.Context, arg string) (string, error) {
+	id, err := strconv.ParseUint(strings.TrimPrefix(arg, "#"), 10, 64)
+	if err != nil || id == 0 {
+		return "", fmt.Errorf("%w: invalid task ID %q", errCommand, arg)
+	}
+
+	task, err := c.tasks.GetByID(ctx, uint(id))
+	if err != nil {
+		if errors.Is(err, database.ErrNotFound) {
+			return "", fmt.Errorf("%w: task #%d not found", errCommand, id)
+		}
+		return "", fmt.Errorf("failed to get task: %w", err)
+	}
+
+	name := fmt.Sprintf("template %d", task.TemplateID)
+	if template, err := c.templates.GetByID(ctx, task.TemplateID); err == nil {
+		name = template.Name
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "Task #%d (%s) is *%s*", task.ID, name, task.State)
+	if task.DueAt != nil && (task.State == models.TaskStatePending || task.State == models.TaskStateScheduled) {
+		fmt.Fprintf(&b, ", due %s", task.DueAt.UTC().Format(time.RFC3339))
+	}
+	if task.CompletedAt != nil {
+		fmt.Fprintf(&b, ", finished %s", task.CompletedAt.UTC().Format(time.RFC3339))
+	}
+	if task.ExitCode != nil {
+		fmt.Fprintf(&b, " with exit code %d", *task.ExitCode)
+	}
+	b.WriteString(".")
+	return b.String(), nil
+}
+
+// runTemplate starts a task now, or opens a form when a template with parameters is run without any
+func (c *Commands) runTemplate(ctx context.Context, cmd slashCommand, user *models.User, name string, args []string) (string, error) {
+	template, err := c.template(ctx, name)
+	if err != nil {
+		return "", err
+	}
+
+	if len(args) == 0 && params.HasFields(template.ParamsSchema) {
+		modal := runModal(template, cmd.ChannelID, c.choices(ctx, template))
+		if err := c.service.slackClient.OpenView(ctx, cmd.TriggerID, modal); err != nil {
+			return "", fmt.Errorf("failed to open form: %w", err)
+		}
+		return "", nil
+	}
+
+	raw, err := parseAssignments(args)
+	if err != nil {
+		return "", err
+	}
+	values, err := params.Coerce(template.ParamsSchema, raw)
+	if err != nil {
+		return "", err
+	}
+
+	task, err := c.startTask(ctx, template, values, user, cmd.ChannelID, nil)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("Started task #%d (%s).", task.ID, template.Name), nil
+}
+
+// schedule creates a task that is due at the given time
+func (c *Commands) schedule(ctx context.Context, cmd slashCommand, user *models.User, name string, args []string) (string, error) {
+	template, err := c.template(ctx, name)
+	if err != nil {
+		return "", err
+	}
+
+	loc := time.UTC
+	if user.TimeZone != "" {
+		if l, err := time.LoadLocation(user.TimeZone); err == nil {
+			loc = l
+		}
+	}
+
+	// The time is one token, or two for "YYYY-MM-DD HH:MM"
+	when := args[0]
+	args = args[1:]
+	if len(args) > 0 && !strings.Contains(args[0], "=") {
+		when += " " + args[0]
+		args = args[1:]
+	}
+	dueAt, err := parseWhen(when, time.Now().In(loc))
+	if err != nil {
+		return "", err
+	}
+
+	raw, err := parseAssignments(args)
+	if err != nil {
+		return "", err
+	}
+	values, err := params.Coerce(template.ParamsSchema, raw)
+	if err != nil {
+		return "", err
+	}
+
+	task, err := c.startTask(ctx, template, values, user, cmd.ChannelID, &dueAt)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("Scheduled task #%d (%s) for %s.", task.ID, template.Name, dueAt.Format("Mon Jan 2 15:04 MST")), nil
+}
+
+// template looks up a template by name
+func (c *Commands) template(ctx context.Context, name string) (*models.Template, error) {
+	template, err := c.templates.GetByName(ctx, name)
+	if err != nil {
+		if errors.Is(err, database.ErrNotFound) {
+			return nil, fmt.Errorf("%w: unknown template %q, see /ops list", errCommand, name)
+		}
+		return nil, fmt.Errorf("failed to get template: %w", err)
+	}
+	return template, nil
+}
+
+// startTask creates a task from chat, announces it in the channel and records the announcement
+// as the task's thread. Without a due time the task is ready for dispatch immediately.
+func (c *Commands) startTask(ctx context.Context, template *models.Template, values models.JSONSchema,
+	user *models.User, channel string, dueAt *time.Time) (*models.TaskInstance, error) {
+	if err := params.Seal(template.ParamsSchema, values, c.keyring); err != nil {
+		return nil, err
+	}
+	if err := c.matcher.Check(ctx, template); err != nil {
+		return nil, err
+	}
+
+	task := &models.TaskInstance{
+		TemplateID: template.ID,
+		Params:     values,
+		State:      models.TaskStatePending,
+		DueAt:      dueAt,
+		Origin:     models.TaskOriginSlack,
+		ChatThread: ThreadRef("slack", channel, ""),
+		CreatedBy:  user.ID,
+	}
+
+	// Runs started from chat can't give a break-glass reason, so freezes always apply
+	if dueAt == nil {
+		if err := c.guard.AuthorizeRunNow(ctx, task, user, ""); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := c.tasks.Create(ctx, task); err != nil {
+		return nil, fmt.Errorf("failed to create task: %w", err)
+	}
+
+	c.logger.Info("Task created from Slack",
+		zap.Uint("task_id", task.ID),
+		zap.String("template", template.Name),
+		zap.Uint("user_id", user.ID))
+
+	verb := "started"
+	if dueAt != nil {
+		verb = "scheduled for " + dueAt.Format("Mon Jan 2 15:04 MST")
+	}
+	text := fmt.Sprintf("Task #%d (%s) %s by %s.", task.ID, template.Name, verb, user.Name)
+	ts, err := c.service.slackClient.SendMessage(ctx, channel, text)
+	if err != nil {
+		// The bot may not be a member of the channel; replies then go to the channel itself
+		c.logger.Warn("Failed to announce task in channel",
+			zap.Uint("task_id", task.ID),
+			zap.String("channel", channel),
+			zap.Error(err))
+		return task, nil
+	}
+
+	task.ChatThread = ThreadRef("slack", channel, ts)
+	if err := c.tasks.Update(ctx, task); err != nil {
+		c.logger.Error("Failed to record chat thread",
+			zap.Uint("task_id", task.ID),
+			zap.Error(err))
+	}
+	return task, nil
+}
+
+// link sends the user a one-time link that links their Slack account to the portal user who opens it
+func (c *Commands) link(ctx context.Context, account Account) (string, error) {
+	identities := c.service.identities
+	if identities == nil {
+		return "", fmt.Errorf("%w: account linking is not enabled", errCommand)
+	}
+
+	link, err := identities.StartLink(ctx, account)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("<%s|Open this link> and sign in to the portal within %s to link your Slack account. "+
+		"The link works once; don't share it.", link, identities.LinkTTL()), nil
+}
+
+// slackUser maps a Slack user to a portal user who may operate tasks
+func (c *Commands) slackUser(ctx context.Context, account Account) (*models.User, error) {
+	identities := c.service.identities
+	if identities == nil {
+		return nil, fmt.Errorf("%w: Slack accounts can't be mapped to portal users", errCommand)
+	}
+
+	user, err := identities.Resolve(ctx, account, func() (string, error) {
+		return c.service.slackClient.UserEmail(ctx, account.UserID)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !user.CanOperate() {
+		return nil, fmt.Errorf("%w: role %q may not run tasks", errCommand, user.Role)
+	}
+
+	return user, nil
+}
+
+// runModalMetadata is carried through the run form as its private metadata
+type runModalMetadata struct {
+	TemplateID uint   `json:"template_id"`
+	Channel    string `json:"channel"`
+}
+
+// choices lists the choices of a template's fields with a source, so the form can offer them as
+// selects. Fields whose choices can't be listed in time are left out and get text inputs; the
+// choices of a field depending on another one are listed for that field's default.
+func (c *Commands) choices(ctx context.Context, template *models.Template) map[string][]string {
+	if c.options == nil {
+		return nil
+	}
+	// Slack only accepts a form within three seconds of the command
+	ctx, cancel := context.WithTimeout(ctx, modalChoicesTimeout)
+	defer cancel()
+
+	fields := params.Fields(template.ParamsSchema)
+	defaults := make(map[string]string)
+	for _, field := range fields {
+		if field.Default != nil {
+			defaults[field.Name] = fmt.Sprint(field.Default)
+		}
+	}
+
+	choices := make(map[string][]string)
+	for _, field := range fields {
+		if field.Source == nil || len(field.Enum) > 0 {
+			continue
+		}
+		options, err := c.options.ParamOptions(ctx, template, field, defaults, 0)
+		if err != nil {
+			c.logger.Debug("Offering a text input instead of choices",
+				zap.String("template", template.Name),
+				zap.String("param", field.Name),
+				zap.Error(err))
+			continue
+		}
+		if len(options) > 0 {
+			choices[field.Name] = options
+		}
+	}
+	return choices
+}
+
+// runModal builds a form for a template's parameters: text inputs, number inputs, selects for
+// enums and listed choices, and checkboxes for booleans
+func runModal(template *models.Template, channel string, choices map[string][]string) map[string]any {
+	metadata, _ := json.Marshal(runModalMetadata{TemplateID: template.ID, Channel: channel})
+
+	blocks := make([]map[string]any, 0)
+	for _, field := range params.Fields(template.ParamsSchema) {
+		element := map[string]any{"action_id": "value"}
+		initial := ""
+		if field.Default != nil {
+			initial = fmt.Sprint(field.Default)
+		}
+
+		switch {
+		case field.Type == "boolean":
+			option := map[string]any{"text": plainText(field.Label()), "value": "true"}
+			element["type"] = "checkboxes"
+			element["options"] = []map[string]any{option}
+			if initial == "true" {
+				element["initial_options"] = []map[string]any{option}
+			}
+		case len(field.Enum) > 0 || len(choices[field.Name]) > 0:
+			values := field.Enum
+			if len(values) == 0 {
+				values = choices[field.Name]
+			}
+			if len(values) > maxSelectOptions {
+				values = values[:maxSelectOptions]
+			}
+			options := make([]map[string]any, 0, len(values))
+			for _, v := range values {
+				// Option texts are limited to 75 characters
+				option := map[string]any{"text": plainText(truncate(v, 75)), "value": v}
+				options = append(options, option)
+				if v == initial {
+					element["initial_option"] = option
+				}
+			}
+			element["type"] = "static_select"
+			element["options"] = options
+		case field.Type == "integer" || field.Type == "number":
+			element["type"] = "number_input"
+			element["is_decimal_allowed"] = field.Type == "number"
+			if initial != "" {
+				element["initial_value"] = initial
+			}
+		default:
+			element["type"] = "plain_text_input"
+			element["multiline"] = field.Type == "array" || field.Type == "object"
+			if initial != "" {
+				element["initial_value"] = initial
+			}
+		}
+
+		block := map[string]any{
+			"type":     "input",
+			"block_id": "param_" + field.Name,
+			"label":    plainText(field.Label()),
+			"element":  element,
+			// An unchecked checkbox is a valid "false"
+			"optional": !field.Required || field.Type == "boolean",
+		}
+		if field.Description != "" {
+			block["hint"] = plainText(field.Description)
+		}
+		blocks = append(blocks, block)
+	}
+
+	return map[string]any{
+		"type":             "modal",
+		"callback_id":      runModalCallbackID,
+		"private_metadata": string(metadata),
+		"title":            plainText(truncate("Run "+template.Name, 24)),
+		"submit":           plainText("Run"),
+		"close":            plainText("Cancel"),
+		"blocks":           blocks,
+	}
+}
+
+// slackViewSubmission is the part of a view_submission payload the run form uses
+type slackViewSubmission struct {
+	Team struct {
+		ID string `json:"id"`
+	} `json:"team"`
+	User struct {
+		ID string `json:"id"`
+	} `json:"user"`
+	View struct {
+		CallbackID      string `json:"callback_id"`
+		PrivateMetadata string `json:"private_metadata"`
+		State           struct {
+			Values map[string]map[string]struct {
+				Type           string  `json:"type"`
+				Value          *string `json:"value"`
+				SelectedOption *struct {
+					Value string `json:"value"`
+				} `json:"selected_option"`
+				SelectedOptions []struct {
+					Value string `json:"value"`
+				} `json:"selected_options"`
+			} `json:"values"`
+		} `json:"state"`
+	} `json:"view"`
+}
+
+// handleViewSubmission validates a submitted run form and starts the task. Validation errors are
+// returned to Slack so they are shown next to the inputs; nil closes the form.
+func (c *Commands) handleViewSubmission(ctx context.Context, payload []byte) (map[string]any, error) {
+	var submission slackViewSubmission
+	if err := json.Unmarshal(payload, &submission); err != nil {
+		return nil, fmt.Errorf("failed to parse payload: %w", err)
+	}
+	if submission.View.CallbackID != runModalCallbackID {
+		return nil, nil
+	}
+
+	var metadata runModalMetadata
+	if err := json.Unmarshal([]byte(submission.View.PrivateMetadata), &metadata); err != nil {
+		return nil, fmt.Errorf("failed to parse form metadata: %w", err)
+	}
+
+	raw := make(map[string]string)
+	for blockID, actions := range submission.View.State.Values {
+		name := strings.TrimPrefix(blockID, "param_")
+		input, ok := actions["value"]
+		if !ok {
+			continue
+		}
+		switch input.Type {
+		case "checkboxes":
+			raw[name] = strconv.FormatBool(len(input.SelectedOptions) > 0)
+		case "static_select":
+			if input.SelectedOption != nil {
+				raw[name] = input.SelectedOption.Value
+			}
+		default:
+			if input.Value != nil {
+				raw[name] = *input.Value
+			}
+		}
+	}
+
+	fail := func(err error) (map[string]any, error) {
+		// The form has no field for general errors; tell the user in the channel instead
+		if err := c.service.slackClient.PostEphemeral(ctx, metadata.Channel, submission.User.ID, err.Error()); err != nil {
+			c.logger.Error("Failed to report form error", zap.Error(err))
+		}
+		return nil, nil
+	}
+
+	user, err := c.slackUser(ctx, Account{Platform: "slack", Workspace: submission.Team.ID, UserID: submission.User.ID})
+	if err != nil {
+		return fail(err)
+	}
+	template, err := c.templates.GetByID(ctx, metadata.TemplateID)
+	if err != nil {
+		return fail(fmt.Errorf("failed to get template: %w", err))
+	}
+
+	values, err := params.Coerce(template.ParamsSchema, raw)
+	if err != nil {
+		var invalid *params.ValidationError
+		if errors.As(err, &invalid) {
+			fieldErrors := make(map[string]string, len(invalid.Fields))
+			for name, problem := range invalid.Fields {
+				fieldErrors["param_"+name] = problem
+			}
+			return map[string]any{"response_action": "errors", "errors": fieldErrors}, nil
+		}
+		return fail(err)
+	}
+
+	if _, err := c.startTask(ctx, template, values, user, metadata.Channel, nil); err != nil {
+		return fail(err)
+	}
+	return nil, nil
+}
+
+// parseAssignments parses key=value arguments
+func parseAssignments(args []string) (map[string]string, error) {
+	raw := make(map[string]string, len(args))
+	for _, arg := range args {
+		key, value, ok := strings.Cut(arg, "=")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("%w: expected key=value, got %q", errCommand, arg)
+		}
+		raw[key] = value
+	}
+	return raw, nil
+}
+
+// parseWhen parses the time of "/ops schedule": RFC 3339, "YYYY-MM-DD HH:MM" or "HH:MM" (the next
+// occurrence), interpreted in now's location
+func parseWhen(when string, now time.Time) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, when); err == nil {
+		return checkFuture(t, now)
+	}
+	if t, err := time.ParseInLocation("2006-01-02 15:04", when, now.Location()); err == nil {
+		return checkFuture(t, now)
+	}
+	if t, err := time.ParseInLocation("15:04", when, now.Location()); err == nil {
+		next := time.Date(now.Year(), now.Month(), now.Day(), t.Hour(), t.Minute(), 0, 0, now.Location())
+		if !next.After(now) {
+			next = next.AddDate(0, 0, 1)
+		}
+		return next, nil
+	}
+	return time.Time{}, fmt.Errorf("%w: can't parse time %q, use HH:MM, YYYY-MM-DD HH:MM or RFC 3339", errCommand, when)
+}
+
+// checkFuture rejects times that have passed
+func checkFuture(t, now time.Time) (time.Time, error) {
+	if !t.After(now) {
+		return time.Time{}, fmt.Errorf("%w: %s is in the past", errCommand, t.Format("Mon Jan 2 15:04 MST"))
+	}
+	return t, nil
+}
+
+// splitArgs splits command text on whitespace, keeping quoted strings together. Slack may turn
+// straight quotes into curly ones, so both are accepted.
+func splitArgs(text string) []string {
+	text = strings.NewReplacer("“", `"`, "”", `"`).Replace(text)
+
+	var args []string
+	var current strings.Builder
+	inQuotes, inArg := false, false
+	for _, r := range text {
+		switch {
+		case r == '"':
+			inQuotes = !inQuotes
+			inArg = true
+		case (r == ' ' || r == '\t' || r == '\n') && !inQuotes:
+			if inArg {
+				args = append(args, current.String())
+				current.Reset()
+				inArg = false
+			}
+		default:
+			current.WriteRune(r)
+			inArg = true
+		}
+	}
+	if inArg {
+		args = append(args, current.String())
+	}
+	return args
+}
+
+// ephemeral returns a slash command response only the invoking user sees
+func ephemeral(text string) map[string]any {
+	return map[string]any{"response_type": "ephemeral", "text": text}
+}
+
+// plainText returns a Block Kit plain text object
+func plainText(text string) map[string]any {
+	return map[string]any{"type": "plain_text", "text": text}
+}
+
+// truncate shortens text to at most n runes
+func truncate(text string, n int) string {
+	runes := []rune(text)
+	if len(runes) <= n {
+		return text
+	}
+	return string(runes[:n-1]) + "…"
+}
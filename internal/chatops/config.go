@@ -10,44 +10,106 @@ import (
 type Config struct {
 	Slack      SlackConfig
 	GoogleChat GoogleChatConfig
+	Teams      TeamsConfig
+	Mattermost MattermostConfig
 }
 
 // SlackConfig holds the Slack configuration
 type SlackConfig struct {
-	Enabled        bool
-	Token          string
-	SigningSecret  string
-	AppID          string
-	VerifyToken    string
-	BotUserID      string
-	DefaultChannel string
+	Enabled               bool
+	Token                 string
+	SigningSecret         string
+	PreviousSigningSecret string // still accepted while a new signing secret is rolled out
+	AppID                 string
+	VerifyToken           string
+	BotUserID             string
+	DefaultChannel        string
+	APIURL                string // base URL of the Web API, overridable for tests
+	MaxRetries            int    // retries of rate-limited calls
 }
 
 // GoogleChatConfig holds the Google Chat configuration
 type GoogleChatConfig struct {
 	Enabled        bool
-	ServiceAccount string
+	ServiceAccount string // path to a service account key file, or the key JSON itself
 	ProjectID      string
+	Audience       string // expected audience of incoming bearer tokens, i.e. the project number
 	DefaultSpace   string
+	APIURL         string // base URL of the Chat API, overridable for tests
+	TokenURL       string // OAuth token endpoint; defaults to the one in the key file
+	JWKSURL        string // public keys of the tokens Google Chat sends to the app
+	MaxRetries     int    // retries of rate-limited calls
+}
+
+// TeamsConfig holds the Microsoft Teams (Bot Framework) configuration
+type TeamsConfig struct {
+	Enabled             bool
+	AppID               string // Microsoft App ID of the bot; the audience of incoming tokens
+	AppPassword         string
+	TenantID            string // tenant of single-tenant bots, or botframework.com
+	ServiceURL          string // Bot Connector endpoint of the tenant's region
+	DefaultConversation string
+	TokenURL            string // overrides the token endpoint derived from TenantID
+	JWKSURL             string // public keys of the tokens the Bot Connector sends to the bot
+	MaxRetries          int    // retries of rate-limited calls
+}
+
+// MattermostConfig holds the Mattermost configuration
+type MattermostConfig struct {
+	Enabled        bool
+	URL            string // server URL
+	Token          string // bot access token
+	DefaultChannel string // channel ID
+	ActionURL      string // public URL of the portal's action endpoint called by buttons
+	ActionSecret   string // shared secret carried by buttons to authenticate action requests
+	MaxRetries     int    // retries of rate-limited calls
 }
 
 // NewConfig creates a new ChatOps configuration from environment variables
 func NewConfig() *Config {
 	return &Config{
 		Slack: SlackConfig{
-			Enabled:        getEnvAsBool("SLACK_ENABLED", false),
-			Token:          getEnv("SLACK_TOKEN", ""),
-			SigningSecret:  getEnv("SLACK_SIGNING_SECRET", ""),
-			AppID:          getEnv("SLACK_APP_ID", ""),
-			VerifyToken:    getEnv("SLACK_VERIFY_TOKEN", ""),
-			BotUserID:      getEnv("SLACK_BOT_USER_ID", ""),
-			DefaultChannel: getEnv("SLACK_DEFAULT_CHANNEL", "general"),
+			Enabled:               getEnvAsBool("SLACK_ENABLED", false),
+			Token:                 getEnv("SLACK_TOKEN", ""),
+			SigningSecret:         getEnv("SLACK_SIGNING_SECRET", ""),
+			PreviousSigningSecret: getEnv("SLACK_SIGNING_SECRET_PREVIOUS", ""),
+			AppID:                 getEnv("SLACK_APP_ID", ""),
+			VerifyToken:           getEnv("SLACK_VERIFY_TOKEN", ""),
+			BotUserID:             getEnv("SLACK_BOT_USER_ID", ""),
+			DefaultChannel:        getEnv("SLACK_DEFAULT_CHANNEL", "general"),
+			APIURL:                getEnv("SLACK_API_URL", "https://slack.com/api/"),
+			MaxRetries:            getEnvAsInt("SLACK_MAX_RETRIES", 3),
 		},
 		GoogleChat: GoogleChatConfig{
 			Enabled:        getEnvAsBool("GOOGLE_CHAT_ENABLED", false),
 			ServiceAccount: getEnv("GOOGLE_CHAT_SERVICE_ACCOUNT", ""),
 			ProjectID:      getEnv("GOOGLE_CHAT_PROJECT_ID", ""),
+			Audience:       getEnv("GOOGLE_CHAT_AUDIENCE", ""),
 			DefaultSpace:   getEnv("GOOGLE_CHAT_DEFAULT_SPACE", ""),
+			APIURL:         getEnv("GOOGLE_CHAT_API_URL", "https://chat.googleapis.com/"),
+			TokenURL:       getEnv("GOOGLE_CHAT_TOKEN_URL", ""),
+			JWKSURL:        getEnv("GOOGLE_CHAT_JWKS_URL", "https://www.googleapis.com/service_accounts/v1/jwk/"+googleChatIssuer),
+			MaxRetries:     getEnvAsInt("GOOGLE_CHAT_MAX_RETRIES", 3),
+		},
+		Teams: TeamsConfig{
+			Enabled:             getEnvAsBool("TEAMS_ENABLED", false),
+			AppID:               getEnv("TEAMS_APP_ID", ""),
+			AppPassword:         getEnv("TEAMS_APP_PASSWORD", ""),
+			TenantID:            getEnv("TEAMS_TENANT_ID", "botframework.com"),
+			ServiceURL:          getEnv("TEAMS_SERVICE_URL", "https://smba.trafficmanager.net/teams/"),
+			DefaultConversation: getEnv("TEAMS_DEFAULT_CONVERSATION", ""),
+			TokenURL:            getEnv("TEAMS_TOKEN_URL", ""),
+			JWKSURL:             getEnv("TEAMS_JWKS_URL", "https://login.botframework.com/v1/.well-known/keys"),
+			MaxRetries:          getEnvAsInt("TEAMS_MAX_RETRIES", 3),
+		},
+		Mattermost: MattermostConfig{
+			Enabled:        getEnvAsBool("MATTERMOST_ENABLED", false),
+			URL:            getEnv("MATTERMOST_URL", ""),
+			Token:          getEnv("MATTERMOST_TOKEN", ""),
+			DefaultChannel: getEnv("MATTERMOST_DEFAULT_CHANNEL", ""),
+			ActionURL:      getEnv("MATTERMOST_ACTION_URL", ""),
+			ActionSecret:   getEnv("MATTERMOST_ACTION_SECRET", ""),
+			MaxRetries:     getEnvAsInt("MATTERMOST_MAX_RETRIES", 3),
 		},
 	}
 }
@@ -61,6 +123,19 @@ func getEnv(key, defaultValue string) string {
 	return value
 }
 
+// getEnvAsInt gets an environment variable as an integer or returns a default value
+func getEnvAsInt(key string, defaultValue int) int {
+	valueStr := getEnv(key, "")
+	if valueStr == "" {
+		return defaultValue
+	}
+	value, err := strconv.Atoi(valueStr)
+	if err != nil {
+		return defaultValue
+	}
+	return value
+}
+
 // getEnvAsBool gets an environment variable as a boolean or returns a default value
 func getEnvAsBool(key string, defaultValue bool) bool {
 	valueStr := getEnv(key, "")
@@ -76,6 +151,6 @@ func getEnvAsBool(key string, defaultValue bool) bool {
 
 // String returns a string representation of the config
 func (c *Config) String() string {
-	return fmt.Sprintf("ChatOps Config: Slack Enabled=%v, Google Chat Enabled=%v",
-		c.Slack.Enabled, c.GoogleChat.Enabled)
+	return fmt.Sprintf("ChatOps Config: Slack Enabled=%v, Google Chat Enabled=%v, Teams Enabled=%v, Mattermost Enabled=%v",
+		c.Slack.Enabled, c.GoogleChat.Enabled, c.Teams.Enabled, c.Mattermost.Enabled)
 }
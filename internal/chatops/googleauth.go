@@ -0,0 +1,134 @@
+package chatops
+
+import (
+	"crypto/rsa"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	// googleChatIssuer is the account that signs the bearer tokens Google Chat sends to apps
+	googleChatIssuer = "chat@system.gserviceaccount.com"
+	// googleChatScope is the OAuth scope of calls made with app authentication
+	googleChatScope = "https://www.googleapis.com/auth/chat.bot"
+)
+
+// serviceAccountKey is the part of a service account key file used to get access tokens
+type serviceAccountKey struct {
+	ClientEmail  string `json:"client_email"`
+	PrivateKeyID string `json:"private_key_id"`
+	PrivateKey   string `json:"private_key"`
+	TokenURI     string `json:"token_uri"`
+}
+
+// loadServiceAccountKey reads a service account key from a file, or from the value itself if it
+// is JSON
+func loadServiceAccountKey(value string) (*serviceAccountKey, *rsa.PrivateKey, error) {
+	data := []byte(value)
+	if !strings.HasPrefix(strings.TrimSpace(value), "{") {
+		var err error
+		if data, err = os.ReadFile(value); err != nil {
+			return nil, nil, fmt.Errorf("failed to read service account key: %w", err)
+		}
+	}
+
+	var key serviceAccountKey
+	if err := json.Unmarshal(data, &key); err != nil {
+		return nil, nil, fmt.Errorf("failed to parse service account key: %w", err)
+	}
+	if key.ClientEmail == "" || key.PrivateKey == "" {
+		return nil, nil, fmt.Errorf("service account key has no client_email or private_key")
+	}
+
+	signer, err := jwt.ParseRSAPrivateKeyFromPEM([]byte(key.PrivateKey))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse service account private key: %w", err)
+	}
+
+	return &key, signer, nil
+}
+
+// serviceAccountTokens gets OAuth access tokens for a service account with the JWT bearer grant
+// and caches them until shortly before they expire
+type serviceAccountTokens struct {
+	key      *serviceAccountKey
+	signer   *rsa.PrivateKey
+	tokenURL string
+	scope    string
+	http     *http.Client
+
+	mu     sync.Mutex
+	token  string
+	expiry time.Time
+}
+
+// Token returns a valid access token, requesting a new one if needed
+func (t *serviceAccountTokens) Token() (string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	if t.token != "" && now.Add(tokenLeeway).Before(t.expiry) {
+		return t.token, nil
+	}
+
+	assertion, err := signJWT(t.signer, t.key.PrivateKeyID, jwt.MapClaims{
+		"iss":   t.key.ClientEmail,
+		"scope": t.scope,
+		"aud":   t.tokenURL,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := t.http.PostForm(t.tokenURL, url.Values{
+		"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+		"assertion":  {assertion},
+	})
+	if err != nil {
+		return "", fmt.Errorf("token request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		AccessToken      string `json:"access_token"`
+		ExpiresIn        int64  `json:"expires_in"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("failed to decode token response (HTTP %d): %w", resp.StatusCode, err)
+	}
+	if resp.StatusCode != http.StatusOK || result.AccessToken == "" {
+		return "", fmt.Errorf("%w: token request returned HTTP %d: %s %s",
+			ErrGoogleChatAuth, resp.StatusCode, result.Error, result.ErrorDescription)
+	}
+
+	t.token = result.AccessToken
+	t.expiry = now.Add(time.Duration(result.ExpiresIn) * time.Second)
+	return t.token, nil
+}
+
+// signJWT returns an RS256-signed JWT with the given claims
+func signJWT(signer *rsa.PrivateKey, keyID string, claims jwt.MapClaims) (string, error) {
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	if keyID != "" {
+		token.Header["kid"] = keyID
+	}
+
+	signed, err := token.SignedString(signer)
+	if err != nil {
+		return "", fmt.Errorf("failed to sign JWT: %w", err)
+	}
+	return signed, nil
+}
@@ -1,18 +1,67 @@
 package chatops
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
 	"time"
 
 	"go.uber.org/zap"
 )
 
+// Google Chat errors, matched with errors.Is against a *GoogleChatError
+var (
+	// ErrGoogleChatAuth is returned when the service account can't get a token or isn't authorized
+	ErrGoogleChatAuth = errors.New("google chat authentication failed")
+	// ErrGoogleChatSpace is returned when the space or message doesn't exist or the app isn't a member
+	ErrGoogleChatSpace = errors.New("google chat space unavailable")
+	// ErrGoogleChatRateLimited is returned when a call is still rate limited after all retries
+	ErrGoogleChatRateLimited = errors.New("google chat rate limit exceeded")
+	// ErrGoogleChatToken is returned when a request from Google Chat has no valid bearer token
+	ErrGoogleChatToken = errors.New("invalid google chat bearer token")
+)
+
+// GoogleChatError is an error returned by the Google Chat API
+type GoogleChatError struct {
+	Method     string
+	StatusCode int
+	Status     string // canonical error code, e.g. PERMISSION_DENIED
+	Message    string
+}
+
+// Error implements the error interface
+func (e *GoogleChatError) Error() string {
+	return fmt.Sprintf("google chat %s failed: HTTP %d %s: %s", e.Method, e.StatusCode, e.Status, e.Message)
+}
+
+// Unwrap maps Google API error codes to the package's error values
+func (e *GoogleChatError) Unwrap() error {
+	switch e.Status {
+	case "UNAUTHENTICATED":
+		return ErrGoogleChatAuth
+	case "PERMISSION_DENIED", "NOT_FOUND":
+		return ErrGoogleChatSpace
+	case "RESOURCE_EXHAUSTED":
+		return ErrGoogleChatRateLimited
+	}
+	return nil
+}
+
 // GoogleChatClient represents a Google Chat client
 type GoogleChatClient struct {
-	config GoogleChatConfig
-	logger *zap.Logger
+	config   GoogleChatConfig
+	logger   *zap.Logger
+	http     *http.Client
+	baseURL  string
+	tokens   *serviceAccountTokens
+	verifier *jwksVerifier
 }
 
 // NewGoogleChatClient creates a new Google Chat client
@@ -25,76 +74,501 @@ func NewGoogleChatClient(config GoogleChatConfig, logger *zap.Logger) (*GoogleCh
 		return nil, fmt.Errorf("google chat service account is required")
 	}
 
+	key, signer, err := loadServiceAccountKey(config.ServiceAccount)
+	if err != nil {
+		return nil, err
+	}
+
+	tokenURL := config.TokenURL
+	if tokenURL == "" {
+		tokenURL = key.TokenURI
+	}
+	if tokenURL == "" {
+		tokenURL = "https://oauth2.googleapis.com/token"
+	}
+
+	baseURL := config.APIURL
+	if baseURL == "" {
+		baseURL = "https://chat.googleapis.com/"
+	}
+	if !strings.HasSuffix(baseURL, "/") {
+		baseURL += "/"
+	}
+
+	jwksURL := config.JWKSURL
+	if jwksURL == "" {
+		jwksURL = "https://www.googleapis.com/service_accounts/v1/jwk/" + googleChatIssuer
+	}
+
+	httpClient := &http.Client{Timeout: 30 * time.Second}
 	return &GoogleChatClient{
-		config: config,
-		logger: logger,
+		config:  config,
+		logger:  logger,
+		http:    httpClient,
+		baseURL: baseURL,
+		tokens: &serviceAccountTokens{
+			key:      key,
+			signer:   signer,
+			tokenURL: tokenURL,
+			scope:    googleChatScope,
+			http:     httpClient,
+		},
+		verifier: newJWKSVerifier(jwksURL, httpClient, ErrGoogleChatToken),
 	}, nil
 }
 
-// SendMessage sends a message to a Google Chat space
-func (g *GoogleChatClient) SendMessage(space, text string) (string, error) {
-	g.logger.Debug("Sending message to Google Chat", zap.String("space", space), zap.String("text", text))
+// googleChatMessage is the part of a Chat API message resource the portal uses
+type googleChatMessage struct {
+	Name                 string           `json:"name,omitempty"`
+	Text                 string           `json:"text,omitempty"`
+	CardsV2              []googleChatCard `json:"cardsV2,omitempty"`
+	Thread               *googleThread    `json:"thread,omitempty"`
+	PrivateMessageViewer *googleUser      `json:"privateMessageViewer,omitempty"`
+}
 
-	if space == "" {
-		space = g.config.DefaultSpace
-	}
+// googleThread identifies a thread by its resource name
+type googleThread struct {
+	Name string `json:"name,omitempty"`
+}
+
+// googleUser identifies a user by its resource name
+type googleUser struct {
+	Name        string `json:"name"`
+	DisplayName string `json:"displayName,omitempty"`
+	Email       string `json:"email,omitempty"`
+}
 
-	// In a real implementation, this would use the Google Chat API to send a message
-	// For now, we'll just log the message and return a fake message ID
-	messageID := fmt.Sprintf("message_%d", time.Now().Unix())
-	return messageID, nil
+// Name implements ChatProvider
+func (g *GoogleChatClient) Name() string {
+	return "google_chat"
 }
 
-// SendReminderMessage sends a reminder message with interactive buttons
-func (g *GoogleChatClient) SendReminderMessage(space, text string, taskID uint) (string, error) {
+// DeduplicatesDeliveries implements DeduplicatingProvider: messages are created with the delivery
+// key as request ID
+func (g *GoogleChatClient) DeduplicatesDeliveries() bool {
+	return true
+}
+
+// SendMessage sends a message to a Google Chat space and returns its resource name
+func (g *GoogleChatClient) SendMessage(ctx context.Context, space, text string) (string, error) {
+	g.logger.Debug("Sending message to Google Chat", zap.String("space", space), zap.String("text", text))
+
+	return g.createMessage(ctx, space, &googleChatMessage{Text: text})
+}
+
+// SendReminderMessage sends a reminder message with a card carrying the task's actions
+func (g *GoogleChatClient) SendReminderMessage(ctx context.Context, space, text string, taskID uint) (string, error) {
 	g.logger.Debug("Sending reminder message to Google Chat",
 		zap.String("space", space),
 		zap.String("text", text),
 		zap.Uint("task_id", taskID))
 
-	if space == "" {
-		space = g.config.DefaultSpace
+	return g.createMessage(ctx, space, &googleChatMessage{
+		Text:    text, // notification fallback
+		CardsV2: []googleChatCard{reminderCard(text, taskID)},
+	})
+}
+
+// SendThreadReply sends a message to the thread of a message or thread resource name, or to the
+// space if thread is empty
+func (g *GoogleChatClient) SendThreadReply(ctx context.Context, space, thread, text string) (string, error) {
+	if thread == "" {
+		return g.SendMessage(ctx, space, text)
+	}
+
+	g.logger.Debug("Sending thread reply to Google Chat",
+		zap.String("space", space),
+		zap.String("thread", thread))
+
+	threadName, err := g.threadName(ctx, thread)
+	if err != nil {
+		return "", err
 	}
+	return g.createMessage(ctx, space, &googleChatMessage{Text: text, Thread: &googleThread{Name: threadName}})
+}
+
+// PostPrivate sends a message in a thread that only the given user can see
+func (g *GoogleChatClient) PostPrivate(ctx context.Context, space, thread, userName, text string) error {
+	g.logger.Debug("Sending private message to Google Chat",
+		zap.String("space", space),
+		zap.String("user", userName))
 
-	// In a real implementation, this would create a message with interactive buttons
-	// For now, we'll just call SendMessage
-	return g.SendMessage(space, fmt.Sprintf("%s (Task ID: %d)", text, taskID))
+	message := &googleChatMessage{Text: text, PrivateMessageViewer: &googleUser{Name: userName}}
+	if thread != "" {
+		threadName, err := g.threadName(ctx, thread)
+		if err != nil {
+			return err
+		}
+		message.Thread = &googleThread{Name: threadName}
+	}
+	_, err := g.createMessage(ctx, space, message)
+	return err
 }
 
-// UploadFile uploads a file to a Google Chat space
-func (g *GoogleChatClient) UploadFile(space, filename, content string) (string, error) {
+// UpdateMessage replaces the text of a message identified by its resource name; any cards are
+// removed. The space is part of the name.
+func (g *GoogleChatClient) UpdateMessage(ctx context.Context, space, messageName, text string) error {
+	g.logger.Debug("Updating message in Google Chat",
+		zap.String("message", messageName),
+		zap.String("text", text))
+
+	query := url.Values{"updateMask": {"text,cardsV2"}}
+	return g.call(ctx, "messages.update", http.MethodPatch, "v1/"+messageName, query, map[string]any{
+		"text":    text,
+		"cardsV2": []googleChatCard{},
+	}, nil)
+}
+
+// UploadFile posts a file to a Google Chat space, in a thread if thread is set. Chat apps can't
+// upload attachments with app authentication, so the content is posted as code blocks.
+func (g *GoogleChatClient) UploadFile(ctx context.Context, space, thread, filename, content string) (string, error) {
 	g.logger.Debug("Uploading file to Google Chat",
 		zap.String("space", space),
+		zap.String("thread", thread),
 		zap.String("filename", filename))
 
+	// Resolve the thread once rather than for every message
+	if thread != "" {
+		var err error
+		if thread, err = g.threadName(ctx, thread); err != nil {
+			return "", err
+		}
+	}
+
+	return postFileAsMessages(ctx, g, space, thread, filename, content)
+}
+
+// googleChatEvent is the part of an interaction event the portal uses
+type googleChatEvent struct {
+	Type  string `json:"type"`
+	Space struct {
+		Name string `json:"name"`
+	} `json:"space"`
+	Message struct {
+		Name   string       `json:"name"`
+		Thread googleThread `json:"thread"`
+	} `json:"message"`
+	User   googleUser `json:"user"`
+	Common struct {
+		InvokedFunction string            `json:"invokedFunction"`
+		Parameters      map[string]string `json:"parameters"`
+		FormInputs      map[string]struct {
+			StringInputs struct {
+				Value []string `json:"value"`
+			} `json:"stringInputs"`
+		} `json:"formInputs"`
+	} `json:"common"`
+}
+
+// ParseInteraction parses an interaction event into an Interaction. It returns nil without an
+// error for events that aren't reminder actions.
+func (g *GoogleChatClient) ParseInteraction(body []byte) (*Interaction, error) {
+	var event googleChatEvent
+	if err := json.Unmarshal(body, &event); err != nil {
+		return nil, fmt.Errorf("failed to parse event: %w", err)
+	}
+	if event.Type != "CARD_CLICKED" {
+		g.logger.Debug("Ignoring Google Chat event", zap.String("type", event.Type))
+		return nil, nil
+	}
+
+	interaction := &Interaction{
+		Platform:  "google_chat",
+		Action:    event.Common.InvokedFunction,
+		UserID:    event.User.Name,
+		UserName:  event.User.DisplayName,
+		UserEmail: event.User.Email,
+		Channel:   event.Space.Name,
+		MessageID: event.Message.Name,
+	}
+
+	switch interaction.Action {
+	case ActionRunNow, ActionCancel:
+	case ActionSnooze:
+		// The snooze menu carries the duration as its selected value
+		values := event.Common.FormInputs[ActionSnooze].StringInputs.Value
+		if len(values) == 0 || values[0] == "" {
+			return nil, nil
+		}
+		interaction.Value = values[0]
+	default:
+		g.logger.Debug("Ignoring unknown Google Chat action", zap.String("function", interaction.Action))
+		return nil, nil
+	}
+
+	taskID := event.Common.Parameters["task_id"]
+	id, err := strconv.ParseUint(taskID, 10, 64)
+	if err != nil || id == 0 {
+		return nil, fmt.Errorf("invalid task ID %q", taskID)
+	}
+	interaction.TaskID = uint(id)
+
+	return interaction, nil
+}
+
+// VerifyRequest verifies the bearer token Google Chat sends with every request: it must be
+// signed by Google Chat and issued for the configured audience
+func (g *GoogleChatClient) VerifyRequest(r *http.Request, body []byte) error {
+	if g.config.Audience == "" {
+		return fmt.Errorf("%w: no audience configured", ErrGoogleChatToken)
+	}
+
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		return fmt.Errorf("%w: missing bearer token", ErrGoogleChatToken)
+	}
+
+	return g.verifier.Verify(token, googleChatIssuer, g.config.Audience, time.Now())
+}
+
+// ResolveEmail returns the email address of the user who acted, which Google Chat includes in
+// the event
+func (g *GoogleChatClient) ResolveEmail(ctx context.Context, interaction *Interaction) (string, error) {
+	if interaction.UserEmail == "" {
+		return "", fmt.Errorf("google chat user %s has no email address", interaction.UserID)
+	}
+	return interaction.UserEmail, nil
+}
+
+// RespondInteraction replaces the reminder card on success, or shows the text only to the user
+// who clicked
+func (g *GoogleChatClient) RespondInteraction(ctx context.Context, interaction *Interaction, text string, success bool) error {
+	if success {
+		return g.UpdateMessage(ctx, interaction.Channel, interaction.MessageID, text)
+	}
+	return g.PostPrivate(ctx, interaction.Channel, interaction.MessageID, interaction.UserID, text)
+}
+
+// threadName returns the thread resource name for a thread or message resource name
+func (g *GoogleChatClient) threadName(ctx context.Context, name string) (string, error) {
+	if strings.Contains(name, "/threads/") {
+		return name, nil
+	}
+
+	var message googleChatMessage
+	if err := g.call(ctx, "messages.get", http.MethodGet, "v1/"+name, nil, nil, &message); err != nil {
+		return "", err
+	}
+	if message.Thread == nil || message.Thread.Name == "" {
+		return "", fmt.Errorf("google chat message %s has no thread", name)
+	}
+	return message.Thread.Name, nil
+}
+
+// createMessage posts a message to a space, replying in its thread if one is set. Messages of an
+// outbox delivery are sent with its key as request ID.
+func (g *GoogleChatClient) createMessage(ctx context.Context, space string, message *googleChatMessage) (string, error) {
 	if space == "" {
 		space = g.config.DefaultSpace
 	}
 
-	// In a real implementation, this would use the Google Chat API to upload a file
-	// For now, we'll just log the file and return a fake file ID
-	fileID := fmt.Sprintf("file_%d", time.Now().Unix())
-	return fileID, nil
+	query := url.Values{}
+	if message.Thread != nil {
+		query.Set("messageReplyOption", "REPLY_MESSAGE_FALLBACK_TO_NEW_THREAD")
+	}
+	// The Chat API returns the message created earlier with the same request ID rather than
+	// posting it again
+	if delivery := deliveryFrom(ctx); delivery != nil && delivery.Key != "" {
+		query.Set("requestId", delivery.Key)
+	}
+
+	var created googleChatMessage
+	if err := g.call(ctx, "messages.create", http.MethodPost, "v1/"+space+"/messages", query, message, &created); err != nil {
+		return "", err
+	}
+
+	return created.Name, nil
 }
 
-// HandleInteractiveComponent handles an interactive component from Google Chat
-func (g *GoogleChatClient) HandleInteractiveComponent(payload []byte) error {
-	g.logger.Debug("Handling interactive component from Google Chat")
+// call performs a Chat API call, waiting and retrying while the API reports rate limiting for at
+// most maxRateLimitWait at a time
+func (g *GoogleChatClient) call(ctx context.Context, method, httpMethod, path string, query url.Values, payload any, result any) error {
+	var body []byte
+	if payload != nil {
+		var err error
+		if body, err = json.Marshal(payload); err != nil {
+			return fmt.Errorf("failed to encode %s request: %w", method, err)
+		}
+	}
 
-	// In a real implementation, this would parse the payload and handle the interaction
-	// For now, we'll just log the payload
-	var data map[string]interface{}
-	if err := json.Unmarshal(payload, &data); err != nil {
-		return fmt.Errorf("failed to parse payload: %w", err)
+	target := g.baseURL + path
+	if len(query) > 0 {
+		target += "?" + query.Encode()
 	}
 
-	g.logger.Debug("Received interactive component", zap.Any("payload", data))
-	return nil
+	for attempt := 0; ; attempt++ {
+		token, err := g.tokens.Token()
+		if err != nil {
+			return fmt.Errorf("failed to get google chat access token: %w", err)
+		}
+
+		req, err := http.NewRequestWithContext(ctx, httpMethod, target, bytes.NewReader(body))
+		if err != nil {
+			return fmt.Errorf("failed to create %s request: %w", method, err)
+		}
+		if payload != nil {
+			req.Header.Set("Content-Type", "application/json; charset=utf-8")
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+
+		resp, err := g.http.Do(req)
+		if err != nil {
+			return fmt.Errorf("google chat %s request failed: %w", method, err)
+		}
+		data, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return fmt.Errorf("failed to read %s response: %w", method, err)
+		}
+
+		if resp.StatusCode == http.StatusTooManyRequests {
+			limited := &RateLimitError{
+				Method:     "google chat " + method,
+				RetryAfter: retryAfter(resp.Header.Get("Retry-After")),
+				Err:        &GoogleChatError{Method: method, StatusCode: resp.StatusCode, Status: "RESOURCE_EXHAUSTED"},
+			}
+			if err := waitRateLimit(ctx, g.logger, limited, attempt, g.config.MaxRetries); err != nil {
+				return err
+			}
+			continue
+		}
+		if resp.StatusCode != http.StatusOK {
+			var envelope struct {
+				Error struct {
+					Status  string `json:"status"`
+					Message string `json:"message"`
+				} `json:"error"`
+			}
+			_ = json.Unmarshal(data, &envelope)
+			if envelope.Error.Status == "" {
+				switch resp.StatusCode {
+				case http.StatusUnauthorized:
+					envelope.Error.Status = "UNAUTHENTICATED"
+				case http.StatusTooManyRequests:
+					envelope.Error.Status = "RESOURCE_EXHAUSTED"
+				}
+			}
+			return &GoogleChatError{
+				Method:     method,
+				StatusCode: resp.StatusCode,
+				Status:     envelope.Error.Status,
+				Message:    envelope.Error.Message,
+			}
+		}
+
+		if result != nil {
+			if err := json.Unmarshal(data, result); err != nil {
+				return fmt.Errorf("failed to decode %s response: %w", method, err)
+			}
+		}
+		return nil
+	}
 }
 
-// VerifyRequest verifies a request from Google Chat
-func (g *GoogleChatClient) VerifyRequest(r *http.Request) error {
-	// In a real implementation, this would verify the request
-	// For now, we'll just return nil
-	return nil
+// googleChatCard is a Cards v2 card with its ID
+type googleChatCard struct {
+	CardID string         `json:"cardId"`
+	Card   googleCardBody `json:"card"`
+}
+
+// googleCardBody is the content of a Cards v2 card
+type googleCardBody struct {
+	Sections []googleCardSection `json:"sections"`
+}
+
+// googleCardSection is a section of a card
+type googleCardSection struct {
+	Widgets []googleCardWidget `json:"widgets"`
+}
+
+// googleCardWidget is a card widget; exactly one field is set
+type googleCardWidget struct {
+	TextParagraph  *googleTextParagraph  `json:"textParagraph,omitempty"`
+	ButtonList     *googleButtonList     `json:"buttonList,omitempty"`
+	SelectionInput *googleSelectionInput `json:"selectionInput,omitempty"`
+}
+
+// googleTextParagraph is a widget showing formatted text
+type googleTextParagraph struct {
+	Text string `json:"text"`
+}
+
+// googleButtonList is a row of buttons
+type googleButtonList struct {
+	Buttons []googleButton `json:"buttons"`
+}
+
+// googleButton is a button that runs an action when clicked
+type googleButton struct {
+	Text    string        `json:"text"`
+	OnClick googleOnClick `json:"onClick"`
+}
+
+// googleOnClick is what happens when a button is clicked
+type googleOnClick struct {
+	Action googleAction `json:"action"`
+}
+
+// googleAction invokes a function of the app with parameters; it arrives as a CARD_CLICKED event
+type googleAction struct {
+	Function   string                  `json:"function"`
+	Parameters []googleActionParameter `json:"parameters,omitempty"`
+}
+
+// googleActionParameter is a key/value parameter of an action
+type googleActionParameter struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// googleSelectionInput is a menu whose selection runs an action
+type googleSelectionInput struct {
+	Name           string                `json:"name"`
+	Label          string                `json:"label"`
+	Type           string                `json:"type"`
+	Items          []googleSelectionItem `json:"items"`
+	OnChangeAction *googleAction         `json:"onChangeAction,omitempty"`
+}
+
+// googleSelectionItem is an item of a selection menu
+type googleSelectionItem struct {
+	Text     string `json:"text"`
+	Value    string `json:"value"`
+	Selected bool   `json:"selected,omitempty"`
+}
+
+// reminderCard builds a reminder card with "Run now" and "Cancel" buttons and a "Snooze" menu,
+// each invoking the function of its action with the task ID as a parameter
+func reminderCard(text string, taskID uint) googleChatCard {
+	parameters := []googleActionParameter{{Key: "task_id", Value: strconv.FormatUint(uint64(taskID), 10)}}
+	button := func(function, label string) googleButton {
+		return googleButton{
+			Text:    label,
+			OnClick: googleOnClick{Action: googleAction{Function: function, Parameters: parameters}},
+		}
+	}
+
+	// The first item stays selected so that picking any duration is a change
+	items := []googleSelectionItem{{Text: "Snooze…", Value: "", Selected: true}}
+	for _, option := range snoozeOptions {
+		items = append(items, googleSelectionItem{Text: option.Text.Text, Value: option.Value})
+	}
+
+	return googleChatCard{
+		CardID: fmt.Sprintf("task-%d", taskID),
+		Card: googleCardBody{Sections: []googleCardSection{{Widgets: []googleCardWidget{
+			{TextParagraph: &googleTextParagraph{Text: text}},
+			{ButtonList: &googleButtonList{Buttons: []googleButton{
+				button(ActionRunNow, "Run now"),
+				button(ActionCancel, "Cancel"),
+			}}},
+			{SelectionInput: &googleSelectionInput{
+				Name:           ActionSnooze,
+				Label:          "Snooze",
+				Type:           "DROPDOWN",
+				Items:          items,
+				OnChangeAction: &googleAction{Function: ActionSnooze, Parameters: parameters},
+			}},
+		}}}},
+	}
 }
@@ -0,0 +1,56 @@
+package chatops
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// newTestGoogleChat returns a client of a fake Chat API that creates messages named after the
+// request count, and records the query of every request
+func newTestGoogleChat(t *testing.T) (*GoogleChatClient, *[]string) {
+	t.Helper()
+	var queries []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer test-token" {
+			t.Errorf("Authorization = %q", r.Header.Get("Authorization"))
+		}
+		queries = append(queries, r.URL.RawQuery)
+		_, _ = w.Write([]byte(`{"name": "spaces/A/messages/1", "thread": {"name": "spaces/A/threads/1"}}`))
+	}))
+	t.Cleanup(server.Close)
+
+	return &GoogleChatClient{
+		config:  GoogleChatConfig{DefaultSpace: "spaces/A"},
+		logger:  zap.NewNop(),
+		http:    server.Client(),
+		baseURL: server.URL + "/",
+		tokens:  &serviceAccountTokens{token: "test-token", expiry: time.Now().Add(time.Hour)},
+	}, &queries
+}
+
+func TestGoogleChatRequestID(t *testing.T) {
+	client, queries := newTestGoogleChat(t)
+
+	if _, err := client.SendMessage(context.Background(), "", "hello"); err != nil {
+		t.Fatal(err)
+	}
+	ctx := WithDelivery(context.Background(), &Delivery{Key: "reminder-7"})
+	if _, err := client.SendMessage(ctx, "", "hello"); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"", "requestId=reminder-7"}
+	if len(*queries) != len(want) {
+		t.Fatalf("made %d requests, want %d", len(*queries), len(want))
+	}
+	for i, query := range *queries {
+		if query != want[i] {
+			t.Errorf("request %d query = %q, want %q", i, query, want[i])
+		}
+	}
+}
@@ -0,0 +1,203 @@
+package chatops
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/BogdanDolia/ops-butler/internal/database"
+	"github.com/BogdanDolia/ops-butler/internal/models"
+)
+
+var (
+	// ErrNotLinked is returned when a chat account isn't linked to a portal user; its message
+	// tells the user how to link it
+	ErrNotLinked = errors.New("your chat account is not linked to a portal user")
+	// ErrInvalidLinkToken is returned when a link token is unknown, already used or expired
+	ErrInvalidLinkToken = errors.New("the link is invalid, already used or expired")
+)
+
+// defaultLinkTTL is how long a link sent by "/ops link" can be used if no TTL is configured
+const defaultLinkTTL = 15 * time.Minute
+
+// Account identifies a user on a chat platform
+type Account struct {
+	Platform  string
+	Workspace string // Slack team or Teams tenant; empty on platforms with global user IDs
+	UserID    string
+}
+
+// Identities maps chat accounts to portal users. Accounts are linked automatically when the
+// platform reports the verified email address of a portal user, or explicitly by opening a
+// one-time link in the portal.
+type Identities struct {
+	service   *Service
+	logger    *zap.Logger
+	repo      database.ChatIdentityRepository
+	users     database.UserRepository
+	portalURL string
+	linkTTL   time.Duration
+}
+
+// NewIdentities creates the identity mapper and registers it with the service so that chat
+// interactions are attributed to portal users. portalURL is the base URL of the web portal that
+// link tokens are sent to.
+func NewIdentities(service *Service, logger *zap.Logger, repo database.ChatIdentityRepository,
+	users database.UserRepository, portalURL string, linkTTL time.Duration) *Identities {
+	if linkTTL <= 0 {
+		linkTTL = defaultLinkTTL
+	}
+	identities := &Identities{
+		service:   service,
+		logger:    logger,
+		repo:      repo,
+		users:     users,
+		portalURL: strings.TrimSuffix(portalURL, "/"),
+		linkTTL:   linkTTL,
+	}
+	service.identities = identities
+	return identities
+}
+
+// Resolve returns the portal user linked to a chat account. An account that isn't linked yet
+// is linked to the portal user with the email address returned by email, if there is one.
+func (i *Identities) Resolve(ctx context.Context, account Account, email func() (string, error)) (*models.User, error) {
+	identity, err := i.repo.GetByChatUser(ctx, account.Platform, account.Workspace, account.UserID)
+	if err == nil {
+		user, err := i.users.GetByID(ctx, identity.UserID)
+		if err != nil {
+			if errors.Is(err, database.ErrNotFound) {
+				return nil, i.notLinked(account)
+			}
+			return nil, fmt.Errorf("failed to get user: %w", err)
+		}
+		return user, nil
+	}
+	if !errors.Is(err, database.ErrNotFound) {
+		return nil, fmt.Errorf("failed to get chat identity: %w", err)
+	}
+
+	// Not linked yet: link by email if the platform reports one that belongs to a portal user
+	address, err := email()
+	if err != nil {
+		i.logger.Debug("No email address for chat account",
+			zap.String("platform", account.Platform),
+			zap.String("user", account.UserID),
+			zap.Error(err))
+		return nil, i.notLinked(account)
+	}
+	user, err := i.users.GetByEmail(ctx, address)
+	if err != nil {
+		if errors.Is(err, database.ErrNotFound) {
+			return nil, i.notLinked(account)
+		}
+		return nil, fmt.Errorf("failed to get user: %w", err)
+	}
+
+	now := time.Now()
+	identity = &models.ChatIdentity{
+		Provider:   account.Platform,
+		Workspace:  account.Workspace,
+		ChatUserID: account.UserID,
+		UserID:     user.ID,
+		Method:     models.ChatLinkMethodEmail,
+		VerifiedAt: &now,
+	}
+	if err := i.repo.Link(ctx, identity); err != nil {
+		return nil, fmt.Errorf("failed to link chat identity: %w", err)
+	}
+	i.logger.Info("Linked chat account by email",
+		zap.String("platform", account.Platform),
+		zap.String("user", account.UserID),
+		zap.Uint("user_id", user.ID))
+
+	return user, nil
+}
+
+// StartLink creates a one-time link to the portal that links a chat account to the portal user
+// who opens it
+func (i *Identities) StartLink(ctx context.Context, account Account) (string, error) {
+	if i.portalURL == "" {
+		return "", fmt.Errorf("account linking is not configured; ask an administrator to link your account")
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", fmt.Errorf("failed to generate link token: %w", err)
+	}
+	token := base64.RawURLEncoding.EncodeToString(secret)
+
+	if err := i.repo.CreateLinkToken(ctx, &models.ChatLinkToken{
+		TokenHash:  hashLinkToken(token),
+		Provider:   account.Platform,
+		Workspace:  account.Workspace,
+		ChatUserID: account.UserID,
+		ExpiresAt:  time.Now().Add(i.linkTTL),
+	}); err != nil {
+		return "", fmt.Errorf("failed to store link token: %w", err)
+	}
+
+	return i.portalURL + "/chat/link?token=" + url.QueryEscape(token), nil
+}
+
+// ConfirmLink links the chat account a link token was created for to a portal user
+func (i *Identities) ConfirmLink(ctx context.Context, token string, user *models.User) (*models.ChatIdentity, error) {
+	if token == "" {
+		return nil, ErrInvalidLinkToken
+	}
+
+	now := time.Now()
+	link, err := i.repo.ConsumeLinkToken(ctx, hashLinkToken(token), now)
+	if err != nil {
+		if errors.Is(err, database.ErrNotFound) {
+			return nil, ErrInvalidLinkToken
+		}
+		return nil, fmt.Errorf("failed to consume link token: %w", err)
+	}
+
+	identity := &models.ChatIdentity{
+		Provider:   link.Provider,
+		Workspace:  link.Workspace,
+		ChatUserID: link.ChatUserID,
+		UserID:     user.ID,
+		Method:     models.ChatLinkMethodConfirmed,
+		VerifiedAt: &now,
+	}
+	if err := i.repo.Link(ctx, identity); err != nil {
+		return nil, fmt.Errorf("failed to link chat identity: %w", err)
+	}
+	i.logger.Info("Linked chat account",
+		zap.String("platform", link.Provider),
+		zap.String("user", link.ChatUserID),
+		zap.Uint("user_id", user.ID))
+
+	return identity, nil
+}
+
+// LinkTTL returns how long a link created by StartLink can be used
+func (i *Identities) LinkTTL() time.Duration {
+	return i.linkTTL
+}
+
+// notLinked returns ErrNotLinked with a hint on how to link an account on its platform
+func (i *Identities) notLinked(account Account) error {
+	if account.Platform == "slack" && i.portalURL != "" {
+		return fmt.Errorf("%w. Run `/ops link` to link it.", ErrNotLinked)
+	}
+	return fmt.Errorf("%w. Use the same email address in chat and in the portal, or ask an administrator to link your account.", ErrNotLinked)
+}
+
+// hashLinkToken returns the hash a link token is stored by
+func hashLinkToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,177 @@
+package chatops
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/BogdanDolia/ops-butler/internal/database"
+	"github.com/BogdanDolia/ops-butler/internal/models"
+)
+
+// fakeIdentities keeps chat identities and link tokens in memory
+type fakeIdentities struct {
+	database.ChatIdentityRepository
+	identities []*models.ChatIdentity
+	tokens     []*models.ChatLinkToken
+}
+
+func (r *fakeIdentities) GetByChatUser(ctx context.Context, provider, workspace, chatUserID string) (*models.ChatIdentity, error) {
+	for _, identity := range r.identities {
+		if identity.Provider == provider && identity.Workspace == workspace && identity.ChatUserID == chatUserID {
+			return identity, nil
+		}
+	}
+	return nil, database.ErrNotFound
+}
+
+func (r *fakeIdentities) Link(ctx context.Context, identity *models.ChatIdentity) error {
+	r.identities = append(r.identities, identity)
+	return nil
+}
+
+func (r *fakeIdentities) CreateLinkToken(ctx context.Context, token *models.ChatLinkToken) error {
+	r.tokens = append(r.tokens, token)
+	return nil
+}
+
+func (r *fakeIdentities) ConsumeLinkToken(ctx context.Context, tokenHash string, now time.Time) (*models.ChatLinkToken, error) {
+	for _, token := range r.tokens {
+		if token.TokenHash == tokenHash && token.UsedAt == nil && now.Before(token.ExpiresAt) {
+			token.UsedAt = &now
+			return token, nil
+		}
+	}
+	return nil, database.ErrNotFound
+}
+
+// fakeUsers serves portal users
+type fakeUsers struct {
+	database.UserRepository
+	users []*models.User
+}
+
+func (r *fakeUsers) GetByID(ctx context.Context, id uint) (*models.User, error) {
+	for _, user := range r.users {
+		if user.ID == id {
+			return user, nil
+		}
+	}
+	return nil, database.ErrNotFound
+}
+
+func (r *fakeUsers) GetByEmail(ctx context.Context, email string) (*models.User, error) {
+	for _, user := range r.users {
+		if user.Email == email {
+			return user, nil
+		}
+	}
+	return nil, database.ErrNotFound
+}
+
+// newTestIdentities creates an identity mapper over the portal user ada@example.com with ID 1
+func newTestIdentities(t *testing.T) (*Identities, *fakeIdentities) {
+	t.Helper()
+	service, err := NewService(&Config{}, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ada := &models.User{Email: "ada@example.com"}
+	ada.ID = 1
+	repo := &fakeIdentities{}
+	return NewIdentities(service, zap.NewNop(), repo, &fakeUsers{users: []*models.User{ada}}, "https://portal.example.com/", time.Minute), repo
+}
+
+func TestIdentitiesResolve(t *testing.T) {
+	email := func(address string, err error) func() (string, error) {
+		return func() (string, error) { return address, err }
+	}
+
+	tests := []struct {
+		name     string
+		account  Account
+		email    func() (string, error)
+		wantUser uint
+	}{
+		{"linked", Account{Platform: "slack", Workspace: "T1", UserID: "U1"}, email("", errors.New("not asked")), 1},
+		{"by verified email", Account{Platform: "slack", Workspace: "T1", UserID: "U2"}, email("ada@example.com", nil), 1},
+		{"unknown email", Account{Platform: "slack", Workspace: "T1", UserID: "U3"}, email("eve@example.com", nil), 0},
+		{"no verified email", Account{Platform: "slack", Workspace: "T1", UserID: "U4"}, email("", errors.New("unverified")), 0},
+		{"same user ID in another workspace", Account{Platform: "slack", Workspace: "T2", UserID: "U1"}, email("", errors.New("unverified")), 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			identities, repo := newTestIdentities(t)
+			repo.identities = []*models.ChatIdentity{{Provider: "slack", Workspace: "T1", ChatUserID: "U1", UserID: 1}}
+
+			user, err := identities.Resolve(context.Background(), tt.account, tt.email)
+			if tt.wantUser == 0 {
+				if !errors.Is(err, ErrNotLinked) {
+					t.Errorf("Resolve() = %v, %v, want ErrNotLinked", user, err)
+				}
+				return
+			}
+			if err != nil || user.ID != tt.wantUser {
+				t.Fatalf("Resolve() = %v, %v, want user %d", user, err, tt.wantUser)
+			}
+			if _, err := repo.GetByChatUser(context.Background(), tt.account.Platform, tt.account.Workspace, tt.account.UserID); err != nil {
+				t.Error("account was not linked")
+			}
+		})
+	}
+}
+
+func TestIdentitiesConfirmLink(t *testing.T) {
+	identities, repo := newTestIdentities(t)
+	ctx := context.Background()
+	account := Account{Platform: "slack", Workspace: "T1", UserID: "U9"}
+	user := &models.User{Email: "ada@example.com"}
+	user.ID = 1
+
+	link, err := identities.StartLink(ctx, account)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(link, "https://portal.example.com/chat/link?token=") {
+		t.Fatalf("StartLink() = %q, want a link to the portal", link)
+	}
+	parsed, err := url.Parse(link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := parsed.Query().Get("token")
+	if repo.tokens[0].TokenHash == token {
+		t.Error("link token is stored in the clear")
+	}
+
+	if _, err := identities.ConfirmLink(ctx, "guess", user); !errors.Is(err, ErrInvalidLinkToken) {
+		t.Errorf("ConfirmLink() with an unknown token error = %v, want ErrInvalidLinkToken", err)
+	}
+	identity, err := identities.ConfirmLink(ctx, token, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if identity.ChatUserID != "U9" || identity.Workspace != "T1" || identity.UserID != 1 || identity.Method != models.ChatLinkMethodConfirmed {
+		t.Errorf("ConfirmLink() = %+v, want U9 in T1 linked to user 1", identity)
+	}
+	if _, err := identities.ConfirmLink(ctx, token, user); !errors.Is(err, ErrInvalidLinkToken) {
+		t.Errorf("ConfirmLink() with a used token error = %v, want ErrInvalidLinkToken", err)
+	}
+
+	// Links expire
+	link, err = identities.StartLink(ctx, account)
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo.tokens[1].ExpiresAt = time.Now().Add(-time.Second)
+	parsed, _ = url.Parse(link)
+	if _, err := identities.ConfirmLink(ctx, parsed.Query().Get("token"), user); !errors.Is(err, ErrInvalidLinkToken) {
+		t.Errorf("ConfirmLink() with an expired token error = %v, want ErrInvalidLinkToken", err)
+	}
+}
@@ -0,0 +1,145 @@
+package chatops
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"go.uber.org/zap"
+
+	"github.com/BogdanDolia/ops-butler/internal/models"
+)
+
+// ErrBusy is returned when an interaction can't be queued because the workers are saturated
+var ErrBusy = errors.New("chatops interaction queue is full")
+
+// interactionQueueSize is the number of interactions that may wait for a worker
+const interactionQueueSize = 100
+
+// Interaction is a button press or menu selection on a reminder message
+type Interaction struct {
+	Platform    string
+	Action      string // ActionRunNow, ActionSnooze or ActionCancel
+	TaskID      uint
+	Value       string // action argument, e.g. the snooze duration
+	Workspace   string // Slack team or Teams tenant of the user
+	UserID      string // platform user ID
+	UserName    string
+	UserEmail   string       // set by platforms that include it in the request
+	User        *models.User // portal user linked to the chat user, resolved by the worker
+	Channel     string
+	MessageID   string
+	ResponseURL string // Slack only
+}
+
+// InteractionHandler carries out an interaction and returns the text that replaces the original
+// message. Errors are shown only to the user who acted; the message is left unchanged.
+type InteractionHandler interface {
+	HandleInteraction(ctx context.Context, interaction *Interaction) (string, error)
+}
+
+// StartWorkers handles queued interactions in the background with the given number of workers
+// until ctx is done, so webhook requests can be acknowledged within the platform's deadline
+func (s *Service) StartWorkers(ctx context.Context, handler InteractionHandler, workers int) *sync.WaitGroup {
+	if workers <= 0 {
+		workers = 1
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case interaction := <-s.interactions:
+					s.processInteraction(ctx, handler, interaction)
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+
+	return &wg
+}
+
+// enqueue queues an interaction for the workers without blocking
+func (s *Service) enqueue(interaction *Interaction) error {
+	select {
+	case s.interactions <- interaction:
+		return nil
+	default:
+		return ErrBusy
+	}
+}
+
+// processInteraction resolves the acting user, runs the handler and reports the outcome
+func (s *Service) processInteraction(ctx context.Context, handler InteractionHandler, interaction *Interaction) {
+	s.logger.Info("Handling chat interaction",
+		zap.String("platform", interaction.Platform),
+		zap.String("action", interaction.Action),
+		zap.Uint("task_id", interaction.TaskID),
+		zap.String("user", interaction.UserID))
+
+	outcome, failed := s.runInteraction(ctx, handler, interaction)
+	if failed != nil {
+		s.logger.Warn("Chat interaction failed",
+			zap.String("action", interaction.Action),
+			zap.Uint("task_id", interaction.TaskID),
+			zap.Error(failed))
+		outcome = fmt.Sprintf("Could not %s task #%d: %s", actionVerb(interaction.Action), interaction.TaskID, failed)
+	}
+
+	provider, err := s.Provider(interaction.Platform)
+	if err == nil {
+		err = provider.RespondInteraction(ctx, interaction, outcome, failed == nil)
+	}
+	if err != nil {
+		s.logger.Error("Failed to respond to chat interaction",
+			zap.String("platform", interaction.Platform),
+			zap.Uint("task_id", interaction.TaskID),
+			zap.Error(err))
+	}
+}
+
+// runInteraction resolves the portal user who acted and calls the handler
+func (s *Service) runInteraction(ctx context.Context, handler InteractionHandler, interaction *Interaction) (string, error) {
+	if s.identities == nil {
+		return "", fmt.Errorf("chat accounts can't be mapped to portal users")
+	}
+	provider, err := s.Provider(interaction.Platform)
+	if err != nil {
+		return "", err
+	}
+
+	user, err := s.identities.Resolve(ctx, interaction.Account(), func() (string, error) {
+		return provider.ResolveEmail(ctx, interaction)
+	})
+	if err != nil {
+		return "", err
+	}
+	interaction.User = user
+
+	return handler.HandleInteraction(ctx, interaction)
+}
+
+// Account returns the chat account of the user who acted
+func (i *Interaction) Account() Account {
+	return Account{Platform: i.Platform, Workspace: i.Workspace, UserID: i.UserID}
+}
+
+// actionVerb returns the verb used in messages about an action
+func actionVerb(action string) string {
+	switch action {
+	case ActionRunNow:
+		return "run"
+	case ActionSnooze:
+		return "snooze"
+	case ActionCancel:
+		return "cancel"
+	default:
+		return "handle"
+	}
+}
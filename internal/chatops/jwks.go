@@ -0,0 +1,152 @@
+package chatops
+
+import (
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	// tokenLeeway is the clock skew tolerated when checking token times
+	tokenLeeway = time.Minute
+	// jwksRefreshInterval is how long fetched public keys are trusted before they are fetched again
+	jwksRefreshInterval = time.Hour
+	// jwksMinRefreshInterval limits refetches caused by tokens with an unknown key ID
+	jwksMinRefreshInterval = time.Minute
+)
+
+// jwksVerifier verifies RS256 JWTs against public keys fetched from a JWKS endpoint. Keys are
+// cached and fetched again periodically or when a token names a key that isn't known yet.
+type jwksVerifier struct {
+	url     string
+	http    *http.Client
+	invalid error // wrapped by the errors of rejected tokens
+
+	mu        sync.Mutex
+	keys      map[string]*rsa.PublicKey
+	fetchedAt time.Time
+}
+
+// newJWKSVerifier creates a verifier for the keys published at url; errors about rejected tokens
+// wrap invalid
+func newJWKSVerifier(url string, client *http.Client, invalid error) *jwksVerifier {
+	return &jwksVerifier{
+		url:     url,
+		http:    client,
+		invalid: invalid,
+		keys:    make(map[string]*rsa.PublicKey),
+	}
+}
+
+// Verify checks a token's signature, issuer, audience and validity period
+func (v *jwksVerifier) Verify(token, issuer, audience string, now time.Time) error {
+	parser := jwt.NewParser(
+		jwt.WithValidMethods([]string{jwt.SigningMethodRS256.Alg()}),
+		jwt.WithIssuer(issuer),
+		jwt.WithAudience(audience),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithLeeway(tokenLeeway),
+		jwt.WithTimeFunc(func() time.Time { return now }),
+	)
+
+	// Failing to fetch the keys isn't the token's fault, so that error is returned as it is
+	var keyErr error
+	_, err := parser.Parse(token, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		key, err := v.key(kid, now)
+		keyErr = err
+		return key, err
+	})
+	if keyErr != nil {
+		return keyErr
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %v", v.invalid, err)
+	}
+
+	return nil
+}
+
+// key returns the public key with the given ID, fetching the key set if it is stale or the ID is new
+func (v *jwksVerifier) key(kid string, now time.Time) (*rsa.PublicKey, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	key, ok := v.keys[kid]
+	stale := now.Sub(v.fetchedAt) > jwksRefreshInterval
+	if ok && !stale {
+		return key, nil
+	}
+
+	if stale || now.Sub(v.fetchedAt) > jwksMinRefreshInterval {
+		if err := v.refresh(now); err != nil {
+			// Keep using known keys if the endpoint is briefly unavailable
+			if ok {
+				return key, nil
+			}
+			return nil, err
+		}
+		if key, ok = v.keys[kid]; ok {
+			return key, nil
+		}
+	}
+
+	return nil, fmt.Errorf("%w: unknown key ID %q", v.invalid, kid)
+}
+
+// refresh fetches the key set; the caller holds mu
+func (v *jwksVerifier) refresh(now time.Time) error {
+	resp, err := v.http.Get(v.url)
+	if err != nil {
+		return fmt.Errorf("failed to fetch signing keys: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("signing keys endpoint returned HTTP %d", resp.StatusCode)
+	}
+
+	var set struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&set); err != nil {
+		return fmt.Errorf("failed to decode signing keys: %w", err)
+	}
+
+	keys := make(map[string]*rsa.PublicKey, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Kty != "RSA" {
+			continue
+		}
+		n, errN := base64.RawURLEncoding.DecodeString(k.N)
+		e, errE := base64.RawURLEncoding.DecodeString(k.E)
+		if errN != nil || errE != nil || len(e) == 0 {
+			continue
+		}
+		keys[k.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+	if len(keys) == 0 {
+		return errors.New("signing keys endpoint returned no RSA keys")
+	}
+
+	v.keys = keys
+	v.fetchedAt = now
+	return nil
+}
@@ -0,0 +1,104 @@
+package chatops
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestJWKSVerifier(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": "key-1",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	}))
+	defer server.Close()
+
+	const issuer, audience = "https://issuer.example", "app-id"
+	now := time.Now()
+	claims := func(change func(jwt.MapClaims)) jwt.MapClaims {
+		c := jwt.MapClaims{
+			"iss": issuer,
+			"aud": audience,
+			"iat": now.Unix(),
+			"exp": now.Add(time.Hour).Unix(),
+		}
+		if change != nil {
+			change(c)
+		}
+		return c
+	}
+	sign := func(signer *rsa.PrivateKey, kid string, c jwt.MapClaims) string {
+		token, err := signJWT(signer, kid, c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+	hs256, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims(nil)).SignedString([]byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	valid := sign(key, "key-1", claims(nil))
+	parts := strings.Split(valid, ".")
+	tampered := parts[0] + "." + base64.RawURLEncoding.EncodeToString([]byte(`{"iss":"`+issuer+`","aud":"other"}`)) + "." + parts[2]
+
+	tests := []struct {
+		name    string
+		token   string
+		wantErr bool
+	}{
+		{name: "valid", token: valid},
+		{name: "audience in a list", token: sign(key, "key-1", claims(func(c jwt.MapClaims) { c["aud"] = []string{"x", audience} }))},
+		{name: "expired within leeway", token: sign(key, "key-1", claims(func(c jwt.MapClaims) { c["exp"] = now.Add(-30 * time.Second).Unix() }))},
+		{name: "expired", token: sign(key, "key-1", claims(func(c jwt.MapClaims) { c["exp"] = now.Add(-time.Hour).Unix() })), wantErr: true},
+		{name: "no expiry", token: sign(key, "key-1", claims(func(c jwt.MapClaims) { delete(c, "exp") })), wantErr: true},
+		{name: "issued in the future", token: sign(key, "key-1", claims(func(c jwt.MapClaims) { c["iat"] = now.Add(time.Hour).Unix() })), wantErr: true},
+		{name: "wrong issuer", token: sign(key, "key-1", claims(func(c jwt.MapClaims) { c["iss"] = "https://evil.example" })), wantErr: true},
+		{name: "wrong audience", token: sign(key, "key-1", claims(func(c jwt.MapClaims) { c["aud"] = "other-app" })), wantErr: true},
+		{name: "signed by another key", token: sign(other, "key-1", claims(nil)), wantErr: true},
+		{name: "unknown key ID", token: sign(key, "key-2", claims(nil)), wantErr: true},
+		{name: "HMAC algorithm", token: hs256, wantErr: true},
+		{name: "tampered claims", token: tampered, wantErr: true},
+		{name: "malformed", token: "not-a-token", wantErr: true},
+	}
+
+	errInvalid := errors.New("invalid token")
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := newJWKSVerifier(server.URL, server.Client(), errInvalid)
+			err := v.Verify(tt.token, issuer, audience, now)
+			if !tt.wantErr {
+				if err != nil {
+					t.Fatalf("Verify() error = %v", err)
+				}
+				return
+			}
+			if !errors.Is(err, errInvalid) {
+				t.Fatalf("Verify() error = %v, want one wrapping %v", err, errInvalid)
+			}
+		})
+	}
+}
@@ -0,0 +1,441 @@
+package chatops
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Mattermost errors, matched with errors.Is against a *MattermostError
+var (
+	// ErrMattermostAuth is returned when the bot token is missing, invalid or revoked
+	ErrMattermostAuth = errors.New("mattermost authentication failed")
+	// ErrMattermostChannel is returned when the channel or post doesn't exist or the bot can't post to it
+	ErrMattermostChannel = errors.New("mattermost channel unavailable")
+	// ErrMattermostRateLimited is returned when a call is still rate limited after all retries
+	ErrMattermostRateLimited = errors.New("mattermost rate limit exceeded")
+	// ErrMattermostSecret is returned when an action request doesn't carry the action secret
+	ErrMattermostSecret = errors.New("invalid mattermost action secret")
+)
+
+// MattermostError is an error returned by the Mattermost REST API
+type MattermostError struct {
+	Method     string
+	StatusCode int
+	ID         string // error ID, e.g. api.context.permissions.app_error
+	Message    string
+}
+
+// Error implements the error interface
+func (e *MattermostError) Error() string {
+	return fmt.Sprintf("mattermost %s failed: HTTP %d %s: %s", e.Method, e.StatusCode, e.ID, e.Message)
+}
+
+// Unwrap maps HTTP status codes to the package's error values
+func (e *MattermostError) Unwrap() error {
+	switch e.StatusCode {
+	case http.StatusUnauthorized:
+		return ErrMattermostAuth
+	case http.StatusForbidden, http.StatusNotFound:
+		return ErrMattermostChannel
+	case http.StatusTooManyRequests:
+		return ErrMattermostRateLimited
+	}
+	return nil
+}
+
+// MattermostClient posts to Mattermost with a bot account and interactive message attachments
+type MattermostClient struct {
+	config  MattermostConfig
+	logger  *zap.Logger
+	http    *http.Client
+	baseURL string
+}
+
+// NewMattermostClient creates a new Mattermost client
+func NewMattermostClient(config MattermostConfig, logger *zap.Logger) (*MattermostClient, error) {
+	if !config.Enabled {
+		return nil, fmt.Errorf("mattermost is not enabled")
+	}
+
+	if config.URL == "" || config.Token == "" {
+		return nil, fmt.Errorf("mattermost URL and token are required")
+	}
+
+	if config.ActionURL == "" || config.ActionSecret == "" {
+		return nil, fmt.Errorf("mattermost action URL and secret are required")
+	}
+
+	return &MattermostClient{
+		config:  config,
+		logger:  logger,
+		http:    &http.Client{Timeout: 30 * time.Second},
+		baseURL: strings.TrimSuffix(config.URL, "/") + "/api/v4/",
+	}, nil
+}
+
+// Name implements ChatProvider
+func (m *MattermostClient) Name() string {
+	return "mattermost"
+}
+
+// mattermostPost is the part of a post the portal sends
+type mattermostPost struct {
+	ChannelID string         `json:"channel_id"`
+	Message   string         `json:"message"`
+	RootID    string         `json:"root_id,omitempty"`
+	FileIDs   []string       `json:"file_ids,omitempty"`
+	Props     map[string]any `json:"props,omitempty"`
+}
+
+// SendMessage sends a message to a Mattermost channel and returns the post ID
+func (m *MattermostClient) SendMessage(ctx context.Context, channel, text string) (string, error) {
+	m.logger.Debug("Sending message to Mattermost", zap.String("channel", channel), zap.String("text", text))
+
+	return m.createPost(ctx, &mattermostPost{ChannelID: channel, Message: text})
+}
+
+// SendReminderMessage sends a reminder message with an attachment carrying the task's actions
+func (m *MattermostClient) SendReminderMessage(ctx context.Context, channel, text string, taskID uint) (string, error) {
+	m.logger.Debug("Sending reminder message to Mattermost",
+		zap.String("channel", channel),
+		zap.String("text", text),
+		zap.Uint("task_id", taskID))
+
+	return m.createPost(ctx, &mattermostPost{
+		ChannelID: channel,
+		Props:     map[string]any{"attachments": []mattermostAttachment{m.reminderAttachment(text, taskID)}},
+	})
+}
+
+// SendThreadReply sends a message to the thread of a root post, or to the channel if thread is empty
+func (m *MattermostClient) SendThreadReply(ctx context.Context, channel, thread, text string) (string, error) {
+	m.logger.Debug("Sending thread reply to Mattermost",
+		zap.String("channel", channel),
+		zap.String("thread", thread))
+
+	return m.createPost(ctx, &mattermostPost{ChannelID: channel, Message: text, RootID: thread})
+}
+
+// UpdateMessage replaces the text of a post and removes its attachments
+func (m *MattermostClient) UpdateMessage(ctx context.Context, channel, postID, text string) error {
+	m.logger.Debug("Updating message in Mattermost",
+		zap.String("post", postID),
+		zap.String("text", text))
+
+	return m.postJSON(ctx, "posts.patch", http.MethodPut, "posts/"+url.PathEscape(postID)+"/patch", map[string]any{
+		"message": text,
+		"props":   map[string]any{},
+	}, nil)
+}
+
+// UploadFile uploads a file and shares it in a channel, in a thread if thread is set
+func (m *MattermostClient) UploadFile(ctx context.Context, channel, thread, filename, content string) (string, error) {
+	m.logger.Debug("Uploading file to Mattermost",
+		zap.String("channel", channel),
+		zap.String("thread", thread),
+		zap.String("filename", filename))
+
+	if channel == "" {
+		channel = m.config.DefaultChannel
+	}
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	if err := form.WriteField("channel_id", channel); err != nil {
+		return "", fmt.Errorf("failed to encode upload: %w", err)
+	}
+	part, err := form.CreateFormFile("files", filename)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode upload: %w", err)
+	}
+	if _, err := io.WriteString(part, content); err != nil {
+		return "", fmt.Errorf("failed to encode upload: %w", err)
+	}
+	if err := form.Close(); err != nil {
+		return "", fmt.Errorf("failed to encode upload: %w", err)
+	}
+
+	var upload struct {
+		FileInfos []struct {
+			ID string `json:"id"`
+		} `json:"file_infos"`
+	}
+	if err := m.call(ctx, "files.upload", http.MethodPost, "files", form.FormDataContentType(), body.Bytes(), &upload); err != nil {
+		return "", err
+	}
+	if len(upload.FileInfos) == 0 {
+		return "", fmt.Errorf("mattermost files.upload returned no file")
+	}
+
+	// Share the file in the channel
+	return m.createPost(ctx, &mattermostPost{
+		ChannelID: channel,
+		Message:   filename,
+		RootID:    thread,
+		FileIDs:   []string{upload.FileInfos[0].ID},
+	})
+}
+
+// mattermostActionRequest is the request Mattermost sends to an action's integration URL
+type mattermostActionRequest struct {
+	UserID    string `json:"user_id"`
+	UserName  string `json:"user_name"`
+	ChannelID string `json:"channel_id"`
+	PostID    string `json:"post_id"`
+	Context   struct {
+		Action         string `json:"action"`
+		TaskID         string `json:"task_id"`
+		Secret         string `json:"secret"`
+		SelectedOption string `json:"selected_option"`
+	} `json:"context"`
+}
+
+// VerifyRequest checks that an action request carries the action secret. Mattermost doesn't sign
+// its requests; the secret is part of each button's context, which the server never sends to clients.
+func (m *MattermostClient) VerifyRequest(r *http.Request, body []byte) error {
+	var request mattermostActionRequest
+	if err := json.Unmarshal(body, &request); err != nil {
+		return fmt.Errorf("%w: invalid body", ErrMattermostSecret)
+	}
+	if !hmac.Equal([]byte(request.Context.Secret), []byte(m.config.ActionSecret)) {
+		return ErrMattermostSecret
+	}
+	return nil
+}
+
+// ParseInteraction parses an action request into an Interaction. It returns nil without an error
+// for actions that aren't reminder actions.
+func (m *MattermostClient) ParseInteraction(body []byte) (*Interaction, error) {
+	var request mattermostActionRequest
+	if err := json.Unmarshal(body, &request); err != nil {
+		return nil, fmt.Errorf("failed to parse action: %w", err)
+	}
+
+	switch request.Context.Action {
+	case ActionRunNow, ActionSnooze, ActionCancel:
+	default:
+		m.logger.Debug("Ignoring unknown Mattermost action", zap.String("action", request.Context.Action))
+		return nil, nil
+	}
+
+	interaction := &Interaction{
+		Platform:  "mattermost",
+		Action:    request.Context.Action,
+		UserID:    request.UserID,
+		UserName:  request.UserName,
+		Channel:   request.ChannelID,
+		MessageID: request.PostID,
+	}
+	if interaction.Action == ActionSnooze {
+		interaction.Value = request.Context.SelectedOption
+	}
+
+	id, err := strconv.ParseUint(request.Context.TaskID, 10, 64)
+	if err != nil || id == 0 {
+		return nil, fmt.Errorf("invalid task ID %q", request.Context.TaskID)
+	}
+	interaction.TaskID = uint(id)
+
+	return interaction, nil
+}
+
+// ResolveEmail returns the verified email address of the user who acted
+func (m *MattermostClient) ResolveEmail(ctx context.Context, interaction *Interaction) (string, error) {
+	var user struct {
+		Email         string `json:"email"`
+		EmailVerified bool   `json:"email_verified"`
+	}
+	if err := m.postJSON(ctx, "users.get", http.MethodGet, "users/"+url.PathEscape(interaction.UserID), nil, &user); err != nil {
+		return "", err
+	}
+	if user.Email == "" {
+		return "", fmt.Errorf("mattermost user %s has no visible email address", interaction.UserID)
+	}
+	if !user.EmailVerified {
+		return "", fmt.Errorf("mattermost user %s has not verified their email address", interaction.UserID)
+	}
+
+	return user.Email, nil
+}
+
+// RespondInteraction replaces the reminder on success, or shows the text only to the user who acted
+func (m *MattermostClient) RespondInteraction(ctx context.Context, interaction *Interaction, text string, success bool) error {
+	if success {
+		return m.UpdateMessage(ctx, interaction.Channel, interaction.MessageID, text)
+	}
+
+	return m.postJSON(ctx, "posts.createEphemeral", http.MethodPost, "posts/ephemeral", map[string]any{
+		"user_id": interaction.UserID,
+		"post": mattermostPost{
+			ChannelID: interaction.Channel,
+			Message:   text,
+			RootID:    interaction.MessageID,
+		},
+	}, nil)
+}
+
+// createPost creates a post and returns its ID
+func (m *MattermostClient) createPost(ctx context.Context, post *mattermostPost) (string, error) {
+	if post.ChannelID == "" {
+		post.ChannelID = m.config.DefaultChannel
+	}
+
+	var created struct {
+		ID string `json:"id"`
+	}
+	if err := m.postJSON(ctx, "posts.create", http.MethodPost, "posts", post, &created); err != nil {
+		return "", err
+	}
+
+	return created.ID, nil
+}
+
+// postJSON calls the REST API with a JSON body, if payload is set, and decodes the response into result
+func (m *MattermostClient) postJSON(ctx context.Context, method, httpMethod, path string, payload any, result any) error {
+	var body []byte
+	contentType := ""
+	if payload != nil {
+		var err error
+		if body, err = json.Marshal(payload); err != nil {
+			return fmt.Errorf("failed to encode %s request: %w", method, err)
+		}
+		contentType = "application/json"
+	}
+	return m.call(ctx, method, httpMethod, path, contentType, body, result)
+}
+
+// call performs a REST API call, waiting and retrying while the server reports rate limiting for
+// at most maxRateLimitWait at a time
+func (m *MattermostClient) call(ctx context.Context, method, httpMethod, path, contentType string, body []byte, result any) error {
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, httpMethod, m.baseURL+path, bytes.NewReader(body))
+		if err != nil {
+			return fmt.Errorf("failed to create %s request: %w", method, err)
+		}
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		req.Header.Set("Authorization", "Bearer "+m.config.Token)
+
+		resp, err := m.http.Do(req)
+		if err != nil {
+			return fmt.Errorf("mattermost %s request failed: %w", method, err)
+		}
+		data, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return fmt.Errorf("failed to read %s response: %w", method, err)
+		}
+
+		if resp.StatusCode == http.StatusTooManyRequests {
+			// Mattermost reports the seconds until the limit resets
+			header := resp.Header.Get("Retry-After")
+			if header == "" {
+				header = resp.Header.Get("X-Ratelimit-Reset")
+			}
+			limited := &RateLimitError{
+				Method:     "mattermost " + method,
+				RetryAfter: retryAfter(header),
+				Err:        &MattermostError{Method: method, StatusCode: resp.StatusCode},
+			}
+			if err := waitRateLimit(ctx, m.logger, limited, attempt, m.config.MaxRetries); err != nil {
+				return err
+			}
+			continue
+		}
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			var envelope struct {
+				ID      string `json:"id"`
+				Message string `json:"message"`
+			}
+			_ = json.Unmarshal(data, &envelope)
+			return &MattermostError{
+				Method:     method,
+				StatusCode: resp.StatusCode,
+				ID:         envelope.ID,
+				Message:    envelope.Message,
+			}
+		}
+
+		if result != nil {
+			if err := json.Unmarshal(data, result); err != nil {
+				return fmt.Errorf("failed to decode %s response: %w", method, err)
+			}
+		}
+		return nil
+	}
+}
+
+// mattermostAttachment is a message attachment with interactive actions
+type mattermostAttachment struct {
+	Fallback string             `json:"fallback"`
+	Text     string             `json:"text"`
+	Actions  []mattermostAction `json:"actions"`
+}
+
+// mattermostAction is a button or menu of an attachment; Mattermost calls the integration URL
+// with the context when it is used
+type mattermostAction struct {
+	ID          string                `json:"id"`
+	Name        string                `json:"name"`
+	Type        string                `json:"type"`
+	Style       string                `json:"style,omitempty"`
+	Options     []mattermostOption    `json:"options,omitempty"`
+	Integration mattermostIntegration `json:"integration"`
+}
+
+// mattermostOption is an option of a menu action
+type mattermostOption struct {
+	Text  string `json:"text"`
+	Value string `json:"value"`
+}
+
+// mattermostIntegration is where an action is sent and what it carries
+type mattermostIntegration struct {
+	URL     string            `json:"url"`
+	Context map[string]string `json:"context"`
+}
+
+// reminderAttachment builds a reminder attachment with "Run now" and "Cancel" buttons and a
+// "Snooze" menu, each carrying the action, the task ID and the action secret
+func (m *MattermostClient) reminderAttachment(text string, taskID uint) mattermostAttachment {
+	integration := func(action string) mattermostIntegration {
+		return mattermostIntegration{
+			URL: m.config.ActionURL,
+			Context: map[string]string{
+				"action":  action,
+				"task_id": strconv.FormatUint(uint64(taskID), 10),
+				"secret":  m.config.ActionSecret,
+			},
+		}
+	}
+
+	options := make([]mattermostOption, 0, len(snoozeOptions))
+	for _, option := range snoozeOptions {
+		options = append(options, mattermostOption{Text: option.Text.Text, Value: option.Value})
+	}
+
+	// Action IDs may only contain letters and digits
+	return mattermostAttachment{
+		Fallback: text,
+		Text:     text,
+		Actions: []mattermostAction{
+			{ID: "runnow", Name: "Run now", Type: "button", Style: "primary", Integration: integration(ActionRunNow)},
+			{ID: "snooze", Name: "Snooze", Type: "select", Options: options, Integration: integration(ActionSnooze)},
+			{ID: "cancel", Name: "Cancel", Type: "button", Style: "danger", Integration: integration(ActionCancel)},
+		},
+	}
+}
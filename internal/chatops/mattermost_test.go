@@ -0,0 +1,177 @@
+package chatops
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// newTestMattermost returns a client of a fake REST API whose calls are answered by handler
+func newTestMattermost(t *testing.T, handler http.HandlerFunc) *MattermostClient {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer mm-token" {
+			t.Errorf("Authorization = %q", r.Header.Get("Authorization"))
+		}
+		handler(w, r)
+	}))
+	t.Cleanup(server.Close)
+
+	client, err := NewMattermostClient(MattermostConfig{
+		Enabled:        true,
+		URL:            server.URL + "/",
+		Token:          "mm-token",
+		DefaultChannel: "channel-default",
+		ActionURL:      "https://portal.example.com/api/v1/chatops/mattermost/actions",
+		ActionSecret:   "action-secret",
+		MaxRetries:     2,
+	}, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+func TestMattermostSendThreadReply(t *testing.T) {
+	var body string
+	client := newTestMattermost(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/api/v4/posts" {
+			t.Errorf("call = %s %s", r.Method, r.URL.Path)
+		}
+		data, _ := io.ReadAll(r.Body)
+		body = string(data)
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write([]byte(`{"id": "post-2"}`))
+	})
+
+	id, err := client.SendThreadReply(context.Background(), "", "post-1", "hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != "post-2" {
+		t.Errorf("SendThreadReply() = %q, want post-2", id)
+	}
+	if want := `{"channel_id":"channel-default","message":"hello","root_id":"post-1"}`; body != want {
+		t.Errorf("request body = %s, want %s", body, want)
+	}
+}
+
+func TestMattermostResolveEmail(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		want    string
+		wantErr bool
+	}{
+		{"verified", `{"email": "ada@example.com", "email_verified": true}`, "ada@example.com", false},
+		{"unverified", `{"email": "ada@example.com", "email_verified": false}`, "", true},
+		{"hidden", `{"email": "", "email_verified": true}`, "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := newTestMattermost(t, func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/api/v4/users/user-1" {
+					t.Errorf("path = %q", r.URL.Path)
+				}
+				_, _ = io.WriteString(w, tt.body)
+			})
+
+			got, err := client.ResolveEmail(context.Background(), &Interaction{UserID: "user-1"})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ResolveEmail() = %q, %v, wantErr %t", got, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ResolveEmail() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMattermostRateLimited(t *testing.T) {
+	calls := 0
+	client := newTestMattermost(t, func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		// Mattermost sends the seconds until the limit resets rather than Retry-After
+		w.Header().Set("X-Ratelimit-Reset", "60")
+		w.WriteHeader(http.StatusTooManyRequests)
+	})
+
+	_, err := client.SendMessage(context.Background(), "channel", "hello")
+	var limited *RateLimitError
+	if !errors.As(err, &limited) {
+		t.Fatalf("SendMessage() error = %v, want a RateLimitError", err)
+	}
+	if limited.RetryAfter != time.Minute {
+		t.Errorf("RetryAfter = %s, want 1m0s", limited.RetryAfter)
+	}
+	if !errors.Is(err, ErrMattermostRateLimited) {
+		t.Errorf("SendMessage() error = %v, want it to match ErrMattermostRateLimited", err)
+	}
+	if calls != 1 {
+		t.Errorf("made %d calls, want a wait above the cap returned without retrying", calls)
+	}
+}
+
+func TestMattermostActionRequest(t *testing.T) {
+	client := newTestMattermost(t, func(w http.ResponseWriter, r *http.Request) {})
+
+	tests := []struct {
+		name      string
+		body      string
+		verifyErr bool
+		want      *Interaction
+		parseErr  bool
+	}{
+		{
+			name: "snooze",
+			body: `{"user_id": "user-1", "user_name": "ada", "channel_id": "channel", "post_id": "post-1",
+				"context": {"action": "snooze", "task_id": "7", "secret": "action-secret", "selected_option": "1h"}}`,
+			want: &Interaction{Platform: "mattermost", Action: ActionSnooze, Value: "1h", TaskID: 7, UserID: "user-1", UserName: "ada",
+				Channel: "channel", MessageID: "post-1"},
+		},
+		{
+			name:      "wrong secret",
+			body:      `{"context": {"action": "run_now", "task_id": "7", "secret": "guess"}}`,
+			verifyErr: true,
+		},
+		{
+			name: "unknown action",
+			body: `{"context": {"action": "approve", "task_id": "7", "secret": "action-secret"}}`,
+		},
+		{
+			name:     "invalid task",
+			body:     `{"context": {"action": "cancel", "task_id": "0", "secret": "action-secret"}}`,
+			parseErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := client.VerifyRequest(httptest.NewRequest(http.MethodPost, "/", nil), []byte(tt.body))
+			if (err != nil) != tt.verifyErr {
+				t.Fatalf("VerifyRequest() error = %v, wantErr %t", err, tt.verifyErr)
+			}
+			if err != nil {
+				return
+			}
+
+			got, err := client.ParseInteraction([]byte(tt.body))
+			if (err != nil) != tt.parseErr {
+				t.Fatalf("ParseInteraction() error = %v, wantErr %t", err, tt.parseErr)
+			}
+			switch {
+			case tt.want == nil && got != nil:
+				t.Errorf("ParseInteraction() = %+v, want nil", got)
+			case tt.want != nil && (got == nil || *got != *tt.want):
+				t.Errorf("ParseInteraction() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,224 @@
+package chatops
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"go.uber.org/zap"
+)
+
+// ChatProvider is a chat platform the portal can post reminders and task outcomes to and receive
+// reminder actions from. Channels, threads and messages are identified by the platform's own IDs.
+type ChatProvider interface {
+	// Name returns the platform name used in reminders and thread references, e.g. "slack"
+	Name() string
+	// SendMessage posts a message to a channel, or to the default channel if it is empty, and
+	// returns the message ID
+	SendMessage(ctx context.Context, channel, text string) (string, error)
+	// SendReminderMessage posts a message with "Run now", "Snooze" and "Cancel" actions for a task
+	SendReminderMessage(ctx context.Context, channel, text string, taskID uint) (string, error)
+	// SendThreadReply posts a reply to the thread of a message, or to the channel if thread is empty
+	SendThreadReply(ctx context.Context, channel, thread, text string) (string, error)
+	// UpdateMessage replaces the text of a message and removes its actions
+	UpdateMessage(ctx context.Context, channel, messageID, text string) error
+	// UploadFile shares a file in a channel, in a thread if thread is set
+	UploadFile(ctx context.Context, channel, thread, filename, content string) (string, error)
+	// VerifyRequest checks that a webhook request was sent by the platform
+	VerifyRequest(r *http.Request, body []byte) error
+	// ParseInteraction parses a verified webhook request into an Interaction. It returns nil
+	// without an error for requests that aren't reminder actions.
+	ParseInteraction(body []byte) (*Interaction, error)
+	// ResolveEmail returns the verified email address of the user who acted in an interaction,
+	// used to link chat accounts to portal users automatically
+	ResolveEmail(ctx context.Context, interaction *Interaction) (string, error)
+	// RespondInteraction reports the outcome of an interaction: on success the text replaces the
+	// reminder, otherwise it is shown to the user who acted
+	RespondInteraction(ctx context.Context, interaction *Interaction, text string, success bool) error
+}
+
+// RegisterProvider adds a chat provider, replacing any provider with the same name. Providers
+// registered first are preferred as the default platform.
+func (s *Service) RegisterProvider(provider ChatProvider) {
+	name := provider.Name()
+	if _, ok := s.providers[name]; !ok {
+		s.order = append(s.order, name)
+	}
+	s.providers[name] = provider
+}
+
+// Provider returns the provider of a platform
+func (s *Service) Provider(platform string) (ChatProvider, error) {
+	provider, ok := s.providers[platform]
+	if !ok {
+		return nil, fmt.Errorf("chat platform %q is not enabled", platform)
+	}
+	return provider, nil
+}
+
+// maxRateLimitWait is the longest a call waits for a rate limit to pass before it gives up, so
+// that a platform asking for minutes doesn't hold up the caller; the outbox retries it later
+const maxRateLimitWait = 5 * time.Second
+
+// RateLimitError is returned when a platform still rate limits a call after all retries, or asks
+// to wait longer than maxRateLimitWait. The outbox retries the message after RetryAfter.
+type RateLimitError struct {
+	Method     string // e.g. "slack chat.postMessage"
+	RetryAfter time.Duration
+	Err        error // the platform's error
+}
+
+// Error implements the error interface
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("%s is rate limited, retry after %s", e.Method, e.RetryAfter)
+}
+
+// Unwrap returns the platform's error
+func (e *RateLimitError) Unwrap() error {
+	return e.Err
+}
+
+// waitRateLimit waits out a rate limit before a call's next attempt. It returns the limit rather
+// than waiting if the call has run out of retries or the wait is longer than maxRateLimitWait,
+// and the context's error if it is done first.
+func waitRateLimit(ctx context.Context, logger *zap.Logger, limited *RateLimitError, attempt, maxRetries int) error {
+	if attempt >= maxRetries || limited.RetryAfter > maxRateLimitWait {
+		return limited
+	}
+
+	logger.Warn("Rate limit hit, retrying",
+		zap.String("method", limited.Method),
+		zap.Duration("retry_after", limited.RetryAfter),
+		zap.Int("attempt", attempt+1))
+
+	timer := time.NewTimer(limited.RetryAfter)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Delivery carries an outbox message through a provider: the idempotency key that providers
+// able to deduplicate send along, and how far a file posted as several messages got, so that a
+// retry continues after the messages already posted
+type Delivery struct {
+	Key     string
+	FirstID string // ID of the first message of a file posted as several
+	Posted  int    // messages of such a file posted so far
+}
+
+// deliveryKey is the context key of the Delivery of a call
+type deliveryKey struct{}
+
+// WithDelivery returns a context carrying the delivery of an outbox message
+func WithDelivery(ctx context.Context, delivery *Delivery) context.Context {
+	return context.WithValue(ctx, deliveryKey{}, delivery)
+}
+
+// deliveryFrom returns the delivery a call is part of, or nil outside of the outbox
+func deliveryFrom(ctx context.Context) *Delivery {
+	delivery, _ := ctx.Value(deliveryKey{}).(*Delivery)
+	return delivery
+}
+
+// DeduplicatingProvider is implemented by providers whose platform recognizes a message sent again
+// with the same Delivery key and doesn't post it twice
+type DeduplicatingProvider interface {
+	ChatProvider
+	DeduplicatesDeliveries() bool
+}
+
+// Deduplicates reports whether a platform can be sent a message again safely when it isn't known
+// whether an earlier attempt posted it
+func (s *Service) Deduplicates(platform string) bool {
+	provider, ok := s.providers[platform].(DeduplicatingProvider)
+	return ok && provider.DeduplicatesDeliveries()
+}
+
+// maxFileMessages caps the number of messages a file is split into on platforms without uploads
+const maxFileMessages = 10
+
+// postFileAsMessages shares a file as code blocks split to fit a platform's message size, for
+// platforms where bots can't upload attachments. At most maxFileMessages messages are posted;
+// the rest is cut with a note. It returns the ID of the first message. Progress is kept in the
+// call's Delivery, if any: a retry skips the messages posted before, and each message has a key
+// of its own.
+func postFileAsMessages(ctx context.Context, provider ChatProvider, channel, thread, filename, content string) (string, error) {
+	delivery := deliveryFrom(ctx)
+	if delivery == nil {
+		delivery = &Delivery{}
+	}
+	header := filename + "\n"
+	chunks := splitMessage(strings.TrimRight(content, "\n"), MaxMessageLength(provider.Name())-len(header)-len("```\n\n```"))
+
+	// Keep the rest in the thread of the first message even if there was none before
+	if thread == "" {
+		thread = delivery.FirstID
+	}
+	for i, chunk := range chunks {
+		if i < delivery.Posted {
+			continue
+		}
+		partCtx := ctx
+		if delivery.Key != "" {
+			partCtx = WithDelivery(ctx, &Delivery{Key: fmt.Sprintf("%s/%d", delivery.Key, i)})
+		}
+
+		if i == maxFileMessages {
+			note := fmt.Sprintf("%s was cut after %d messages.", filename, maxFileMessages)
+			if _, err := provider.SendThreadReply(partCtx, channel, thread, note); err != nil {
+				return delivery.FirstID, err
+			}
+			delivery.Posted = i + 1
+			break
+		}
+
+		text := "```\n" + chunk + "\n```"
+		if i == 0 {
+			text = header + text
+		}
+		id, err := provider.SendThreadReply(partCtx, channel, thread, text)
+		if err != nil {
+			return delivery.FirstID, err
+		}
+		if i == 0 {
+			delivery.FirstID = id
+			if thread == "" {
+				thread = id
+			}
+		}
+		delivery.Posted = i + 1
+	}
+
+	return delivery.FirstID, nil
+}
+
+// splitMessage splits text into chunks of at most size bytes, preferring line breaks
+func splitMessage(text string, size int) []string {
+	var chunks []string
+	for len(text) > size {
+		cut := strings.LastIndex(text[:size], "\n")
+		if cut <= 0 {
+			// Don't cut a multibyte character in two
+			cut = size
+			for cut > 0 && !utf8.RuneStart(text[cut]) {
+				cut--
+			}
+			if cut == 0 {
+				_, cut = utf8.DecodeRuneInString(text)
+			}
+		}
+		chunks = append(chunks, text[:cut])
+		text = strings.TrimPrefix(text[cut:], "\n")
+	}
+	if text != "" || len(chunks) == 0 {
+		chunks = append(chunks, text)
+	}
+	return chunks
+}
@@ -0,0 +1,115 @@
+package chatops
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+// threadProvider is a chat platform that records the replies posted through it and fails the
+// post numbered failAt, counting from one
+type threadProvider struct {
+	ChatProvider
+	failAt int
+	calls  int
+	posted []string
+	keys   []string
+}
+
+func (p *threadProvider) Name() string {
+	return "fake"
+}
+
+func (p *threadProvider) SendThreadReply(ctx context.Context, channel, thread, text string) (string, error) {
+	p.calls++
+	if p.calls == p.failAt {
+		return "", errors.New("boom")
+	}
+	if delivery := deliveryFrom(ctx); delivery != nil {
+		p.keys = append(p.keys, delivery.Key)
+	}
+	p.posted = append(p.posted, text)
+	return fmt.Sprintf("m%d", len(p.posted)), nil
+}
+
+func TestPostFileAsMessagesResumes(t *testing.T) {
+	var lines []string
+	for i := 0; i < 2000; i++ {
+		lines = append(lines, fmt.Sprintf("line %d of the log", i))
+	}
+	content := strings.Join(lines, "\n")
+
+	provider := &threadProvider{failAt: 3}
+	delivery := &Delivery{Key: "file-1"}
+	ctx := WithDelivery(context.Background(), delivery)
+
+	if _, err := postFileAsMessages(ctx, provider, "C1", "", "job.log", content); err == nil {
+		t.Fatal("postFileAsMessages() succeeded, want the third post to fail")
+	}
+	if delivery.Posted != 2 || delivery.FirstID != "m1" {
+		t.Fatalf("delivery after failure = %+v, want 2 posted with the first m1", delivery)
+	}
+
+	first, err := postFileAsMessages(ctx, provider, "C1", "", "job.log", content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first != "m1" {
+		t.Errorf("first message = %q, want m1", first)
+	}
+
+	// Every part is posted once, and the note cutting the file follows the last one
+	if len(provider.posted) != maxFileMessages+1 {
+		t.Fatalf("posted %d messages, want %d", len(provider.posted), maxFileMessages+1)
+	}
+	if !strings.HasPrefix(provider.posted[0], "job.log\n") {
+		t.Errorf("first message %q doesn't start with the file name", provider.posted[0])
+	}
+	posted := map[string]bool{}
+	for i, text := range provider.posted {
+		if posted[text] {
+			t.Errorf("message %d was posted twice", i)
+		}
+		posted[text] = true
+	}
+	keys := map[string]bool{}
+	for _, key := range provider.keys {
+		if !strings.HasPrefix(key, "file-1/") || keys[key] {
+			t.Errorf("part key %q is not a unique key derived from the delivery", key)
+		}
+		keys[key] = true
+	}
+}
+
+func TestSplitMessage(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		size int
+		want []string
+	}{
+		{"fits", "hello", 10, []string{"hello"}},
+		{"empty", "", 10, []string{""}},
+		{"at a line break", "one\ntwo\nthree", 8, []string{"one\ntwo", "three"}},
+		{"long line", "abcdefgh", 3, []string{"abc", "def", "gh"}},
+		{"multibyte characters", "héllo wörld", 3, []string{"hé", "llo", " w", "ör", "ld"}},
+		{"character wider than size", "日本", 2, []string{"日", "本"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := splitMessage(tt.text, tt.size)
+			if strings.Join(got, "|") != strings.Join(tt.want, "|") {
+				t.Fatalf("splitMessage(%q, %d) = %q, want %q", tt.text, tt.size, got, tt.want)
+			}
+			for _, chunk := range got {
+				if !utf8.ValidString(chunk) {
+					t.Errorf("chunk %q is not valid UTF-8", chunk)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,50 @@
+package chatops
+
+import (
+	"sync"
+	"time"
+)
+
+// replayCache remembers delivery IDs for a while so retried or replayed requests are only
+// handled once. It is per process; instances behind a load balancer each keep their own.
+type replayCache struct {
+	mu        sync.Mutex
+	ttl       time.Duration
+	seen      map[string]time.Time
+	lastPrune time.Time
+}
+
+// newReplayCache creates a replay cache that forgets IDs after ttl
+func newReplayCache(ttl time.Duration) *replayCache {
+	return &replayCache{
+		ttl:  ttl,
+		seen: make(map[string]time.Time),
+	}
+}
+
+// seenBefore records the keys and reports whether any of them was recorded within the TTL
+func (c *replayCache) seenBefore(now time.Time, keys ...string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if now.Sub(c.lastPrune) > c.ttl {
+		for key, at := range c.seen {
+			if now.Sub(at) > c.ttl {
+				delete(c.seen, key)
+			}
+		}
+		c.lastPrune = now
+	}
+
+	duplicate := false
+	for _, key := range keys {
+		if key == "" {
+			continue
+		}
+		if at, ok := c.seen[key]; ok && now.Sub(at) <= c.ttl {
+			duplicate = true
+		}
+		c.seen[key] = now
+	}
+	return duplicate
+}
@@ -1,25 +1,75 @@
 package chatops
 
 import (
+	"context"
 	"fmt"
 	"net/http"
+	"strings"
 
 	"go.uber.org/zap"
 )
 
-// Service represents a ChatOps service
+// Reminder actions carried by interactive buttons; each button's value is the task ID
+const (
+	ActionRunNow = "run_now"
+	ActionSnooze = "snooze"
+	ActionCancel = "cancel"
+)
+
+// MaxMessageLength returns the number of characters a message to a platform should stay within
+func MaxMessageLength(platform string) int {
+	switch platform {
+	case "slack":
+		return 3900 // Slack truncates longer text and recommends staying under 4000
+	case "google_chat", "teams":
+		return 4000
+	case "mattermost":
+		return 16000 // the server's default limit is 16383
+	default:
+		return 2000
+	}
+}
+
+// ThreadRef identifies a chat thread, as stored in TaskInstance.ChatThread, by platform, channel
+// and the ID of its first message. Without a message ID it refers to the channel itself.
+func ThreadRef(platform, channel, messageID string) string {
+	ref := platform + ":" + channel
+	if messageID != "" {
+		ref += "#" + messageID
+	}
+	return ref
+}
+
+// ParseThreadRef splits a thread reference into platform, channel and message ID
+func ParseThreadRef(ref string) (string, string, string) {
+	platform, rest, ok := strings.Cut(ref, ":")
+	if !ok {
+		return "", "", ""
+	}
+	channel, messageID, _ := strings.Cut(rest, "#")
+	return platform, channel, messageID
+}
+
+// Service represents a ChatOps service. It posts through the registered chat providers and
+// keeps a typed Slack client for slash commands and forms, which only Slack supports.
 type Service struct {
-	config      *Config
-	logger      *zap.Logger
-	slackClient *SlackClient
-	chatClient  *GoogleChatClient
+	config       *Config
+	logger       *zap.Logger
+	providers    map[string]ChatProvider
+	order        []string
+	slackClient  *SlackClient
+	interactions chan *Interaction
+	commands     *Commands
+	identities   *Identities
 }
 
-// NewService creates a new ChatOps service
+// NewService creates a new ChatOps service with a provider for each enabled platform
 func NewService(config *Config, logger *zap.Logger) (*Service, error) {
 	service := &Service{
-		config: config,
-		logger: logger,
+		config:       config,
+		logger:       logger,
+		providers:    make(map[string]ChatProvider),
+		interactions: make(chan *Interaction, interactionQueueSize),
 	}
 
 	// Initialize Slack client if enabled
@@ -29,6 +79,7 @@ func NewService(config *Config, logger *zap.Logger) (*Service, error) {
 			return nil, fmt.Errorf("failed to create Slack client: %w", err)
 		}
 		service.slackClient = slackClient
+		service.RegisterProvider(slackClient)
 	}
 
 	// Initialize Google Chat client if enabled
@@ -37,108 +88,165 @@ func NewService(config *Config, logger *zap.Logger) (*Service, error) {
 		if err != nil {
 			return nil, fmt.Errorf("failed to create Google Chat client: %w", err)
 		}
-		service.chatClient = chatClient
+		service.RegisterProvider(chatClient)
+	}
+
+	// Initialize Microsoft Teams client if enabled
+	if config.Teams.Enabled {
+		teamsClient, err := NewTeamsClient(config.Teams, logger)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create Teams client: %w", err)
+		}
+		service.RegisterProvider(teamsClient)
+	}
+
+	// Initialize Mattermost client if enabled
+	if config.Mattermost.Enabled {
+		mattermostClient, err := NewMattermostClient(config.Mattermost, logger)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create Mattermost client: %w", err)
+		}
+		service.RegisterProvider(mattermostClient)
 	}
 
 	return service, nil
 }
 
+// DefaultPlatform returns the platform reminders go to when none is set, or "" if chat is disabled
+func (s *Service) DefaultPlatform() string {
+	if len(s.order) == 0 {
+		return ""
+	}
+	return s.order[0]
+}
+
 // SendMessage sends a message to a channel or space
-func (s *Service) SendMessage(platform, channel, text string) (string, error) {
+func (s *Service) SendMessage(ctx context.Context, platform, channel, text string) (string, error) {
 	s.logger.Debug("Sending message",
 		zap.String("platform", platform),
 		zap.String("channel", channel),
 		zap.String("text", text))
 
-	switch platform {
-	case "slack":
-		if s.slackClient == nil {
-			return "", fmt.Errorf("slack is not enabled")
-		}
-		return s.slackClient.SendMessage(channel, text)
-	case "google_chat":
-		if s.chatClient == nil {
-			return "", fmt.Errorf("google chat is not enabled")
-		}
-		return s.chatClient.SendMessage(channel, text)
-	default:
-		return "", fmt.Errorf("unsupported platform: %s", platform)
+	provider, err := s.Provider(platform)
+	if err != nil {
+		return "", err
 	}
+	return provider.SendMessage(ctx, channel, text)
 }
 
 // SendReminderMessage sends a reminder message with interactive buttons
-func (s *Service) SendReminderMessage(platform, channel, text string, taskID uint) (string, error) {
+func (s *Service) SendReminderMessage(ctx context.Context, platform, channel, text string, taskID uint) (string, error) {
 	s.logger.Debug("Sending reminder message",
 		zap.String("platform", platform),
 		zap.String("channel", channel),
 		zap.String("text", text),
 		zap.Uint("task_id", taskID))
 
-	switch platform {
-	case "slack":
-		if s.slackClient == nil {
-			return "", fmt.Errorf("slack is not enabled")
-		}
-		return s.slackClient.SendReminderMessage(channel, text, taskID)
-	case "google_chat":
-		if s.chatClient == nil {
-			return "", fmt.Errorf("google chat is not enabled")
-		}
-		return s.chatClient.SendReminderMessage(channel, text, taskID)
-	default:
-		return "", fmt.Errorf("unsupported platform: %s", platform)
+	provider, err := s.Provider(platform)
+	if err != nil {
+		return "", err
+	}
+	return provider.SendReminderMessage(ctx, channel, text, taskID)
+}
+
+// ReplyInThread posts a reply to a thread, or to the channel if threadID is empty
+func (s *Service) ReplyInThread(ctx context.Context, platform, channel, threadID, text string) (string, error) {
+	s.logger.Debug("Replying in thread",
+		zap.String("platform", platform),
+		zap.String("channel", channel),
+		zap.String("thread", threadID))
+
+	provider, err := s.Provider(platform)
+	if err != nil {
+		return "", err
+	}
+	return provider.SendThreadReply(ctx, channel, threadID, text)
+}
+
+// UpdateMessage replaces the text of a previously sent message
+func (s *Service) UpdateMessage(ctx context.Context, platform, channel, messageID, text string) error {
+	s.logger.Debug("Updating message",
+		zap.String("platform", platform),
+		zap.String("channel", channel),
+		zap.String("message_id", messageID))
+
+	provider, err := s.Provider(platform)
+	if err != nil {
+		return err
 	}
+	return provider.UpdateMessage(ctx, channel, messageID, text)
 }
 
-// UploadFile uploads a file to a channel or space
-func (s *Service) UploadFile(platform, channel, filename, content string) (string, error) {
+// UploadFile uploads a file to a channel or space, in a thread if threadID is set
+func (s *Service) UploadFile(ctx context.Context, platform, channel, threadID, filename, content string) (string, error) {
 	s.logger.Debug("Uploading file",
 		zap.String("platform", platform),
 		zap.String("channel", channel),
+		zap.String("thread", threadID),
 		zap.String("filename", filename))
 
-	switch platform {
-	case "slack":
-		if s.slackClient == nil {
-			return "", fmt.Errorf("slack is not enabled")
-		}
-		return s.slackClient.UploadFile(channel, filename, content)
-	case "google_chat":
-		if s.chatClient == nil {
-			return "", fmt.Errorf("google chat is not enabled")
-		}
-		return s.chatClient.UploadFile(channel, filename, content)
-	default:
-		return "", fmt.Errorf("unsupported platform: %s", platform)
+	provider, err := s.Provider(platform)
+	if err != nil {
+		return "", err
 	}
+	return provider.UploadFile(ctx, channel, threadID, filename, content)
 }
 
-// HandleSlackInteraction handles an interaction from Slack
-func (s *Service) HandleSlackInteraction(r *http.Request, body []byte) error {
+// HandleSlackInteraction verifies an interaction from Slack. Button presses are queued for the
+// workers; form submissions are handled right away and may return a response for Slack.
+func (s *Service) HandleSlackInteraction(ctx context.Context, r *http.Request, body []byte) (map[string]any, error) {
 	if s.slackClient == nil {
-		return fmt.Errorf("slack is not enabled")
+		return nil, fmt.Errorf("slack is not enabled")
 	}
 
 	// Verify the request
 	if err := s.slackClient.VerifyRequest(r, body); err != nil {
-		return fmt.Errorf("failed to verify request: %w", err)
+		return nil, fmt.Errorf("failed to verify request: %w", err)
+	}
+
+	// Form submissions need an answer in the response
+	if payload, ok := slackViewSubmissionPayload(body); ok {
+		if s.commands == nil {
+			return nil, fmt.Errorf("slash commands are not enabled")
+		}
+		return s.commands.handleViewSubmission(ctx, payload)
 	}
 
-	// Handle the interaction
-	return s.slackClient.HandleInteractiveComponent(body)
+	// Parse the interaction
+	interaction, err := s.slackClient.ParseInteraction(body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse interaction: %w", err)
+	}
+	if interaction == nil {
+		return nil, nil
+	}
+
+	return nil, s.enqueue(interaction)
 }
 
-// HandleGoogleChatInteraction handles an interaction from Google Chat
-func (s *Service) HandleGoogleChatInteraction(r *http.Request, body []byte) error {
-	if s.chatClient == nil {
-		return fmt.Errorf("google chat is not enabled")
+// HandleInteraction verifies a webhook request from a platform and queues reminder actions for
+// the workers. The returned response is empty; the outcome is reported asynchronously.
+func (s *Service) HandleInteraction(platform string, r *http.Request, body []byte) (map[string]any, error) {
+	provider, err := s.Provider(platform)
+	if err != nil {
+		return nil, err
 	}
 
 	// Verify the request
-	if err := s.chatClient.VerifyRequest(r); err != nil {
-		return fmt.Errorf("failed to verify request: %w", err)
+	if err := provider.VerifyRequest(r, body); err != nil {
+		return nil, fmt.Errorf("failed to verify request: %w", err)
+	}
+
+	// Parse the interaction
+	interaction, err := provider.ParseInteraction(body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse interaction: %w", err)
+	}
+	if interaction != nil {
+		if err := s.enqueue(interaction); err != nil {
+			return nil, err
+		}
 	}
 
-	// Handle the interaction
-	return s.chatClient.HandleInteractiveComponent(body)
+	return map[string]any{}, nil
 }
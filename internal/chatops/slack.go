@@ -1,18 +1,74 @@
 package chatops
 
 import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
 	"time"
 
 	"go.uber.org/zap"
 )
 
+// Slack errors, matched with errors.Is against a *SlackError
+var (
+	// ErrSlackAuth is returned when the token is missing, invalid or revoked
+	ErrSlackAuth = errors.New("slack authentication failed")
+	// ErrSlackChannel is returned when the channel doesn't exist or the bot can't post to it
+	ErrSlackChannel = errors.New("slack channel unavailable")
+	// ErrSlackRateLimited is returned when a call is still rate limited after all retries
+	ErrSlackRateLimited = errors.New("slack rate limit exceeded")
+	// ErrSlackSignature is returned when a request from Slack is unsigned or the signature doesn't match
+	ErrSlackSignature = errors.New("invalid slack request signature")
+	// ErrSlackStaleRequest is returned when a request's timestamp is too far from the current time
+	ErrSlackStaleRequest = errors.New("stale slack request")
+	// ErrSlackDuplicate is returned for a retry or replay of a request that was already handled
+	ErrSlackDuplicate = errors.New("duplicate slack request")
+)
+
+// slackMaxRequestAge is how far a request timestamp may be from the current time
+const slackMaxRequestAge = 5 * time.Minute
+
+// SlackError is an error returned by the Slack Web API
+type SlackError struct {
+	Method string
+	Code   string
+}
+
+// Error implements the error interface
+func (e *SlackError) Error() string {
+	return fmt.Sprintf("slack %s failed: %s", e.Method, e.Code)
+}
+
+// Unwrap maps Slack error codes to the package's error values
+func (e *SlackError) Unwrap() error {
+	switch e.Code {
+	case "not_authed", "invalid_auth", "account_inactive", "token_revoked", "token_expired", "missing_scope":
+		return ErrSlackAuth
+	case "channel_not_found", "not_in_channel", "is_archived", "restricted_action":
+		return ErrSlackChannel
+	case "ratelimited":
+		return ErrSlackRateLimited
+	}
+	return nil
+}
+
 // SlackClient represents a Slack client
 type SlackClient struct {
-	config SlackConfig
-	logger *zap.Logger
+	config  SlackConfig
+	logger  *zap.Logger
+	http    *http.Client
+	baseURL string
+	replays *replayCache
 }
 
 // NewSlackClient creates a new Slack client
@@ -25,28 +81,51 @@ func NewSlackClient(config SlackConfig, logger *zap.Logger) (*SlackClient, error
 		return nil, fmt.Errorf("slack token is required")
 	}
 
+	baseURL := config.APIURL
+	if baseURL == "" {
+		baseURL = "https://slack.com/api/"
+	}
+	if !strings.HasSuffix(baseURL, "/") {
+		baseURL += "/"
+	}
+
 	return &SlackClient{
-		config: config,
-		logger: logger,
+		config:  config,
+		logger:  logger,
+		http:    &http.Client{Timeout: 30 * time.Second},
+		baseURL: baseURL,
+		replays: newReplayCache(2 * slackMaxRequestAge),
 	}, nil
 }
 
+// Name implements ChatProvider
+func (s *SlackClient) Name() string {
+	return "slack"
+}
+
 // SendMessage sends a message to a Slack channel
-func (s *SlackClient) SendMessage(channel, text string) (string, error) {
+func (s *SlackClient) SendMessage(ctx context.Context, channel, text string) (string, error) {
 	s.logger.Debug("Sending message to Slack", zap.String("channel", channel), zap.String("text", text))
 
 	if channel == "" {
 		channel = s.config.DefaultChannel
 	}
 
-	// In a real implementation, this would use the Slack API to send a message
-	// For now, we'll just log the message and return a fake timestamp
-	timestamp := fmt.Sprintf("%d.%d", time.Now().Unix(), time.Now().Nanosecond()/1000000)
-	return timestamp, nil
+	var resp struct {
+		TS string `json:"ts"`
+	}
+	if err := s.postJSON(ctx, "chat.postMessage", map[string]any{
+		"channel": channel,
+		"text":    text,
+	}, &resp); err != nil {
+		return "", err
+	}
+
+	return resp.TS, nil
 }
 
 // SendReminderMessage sends a reminder message with interactive buttons
-func (s *SlackClient) SendReminderMessage(channel, text string, taskID uint) (string, error) {
+func (s *SlackClient) SendReminderMessage(ctx context.Context, channel, text string, taskID uint) (string, error) {
 	s.logger.Debug("Sending reminder message to Slack",
 		zap.String("channel", channel),
 		zap.String("text", text),
@@ -56,13 +135,86 @@ func (s *SlackClient) SendReminderMessage(channel, text string, taskID uint) (st
 		channel = s.config.DefaultChannel
 	}
 
-	// In a real implementation, this would create a message with interactive buttons
-	// For now, we'll just call SendMessage
-	return s.SendMessage(channel, fmt.Sprintf("%s (Task ID: %d)", text, taskID))
+	var resp struct {
+		TS string `json:"ts"`
+	}
+	if err := s.postJSON(ctx, "chat.postMessage", map[string]any{
+		"channel": channel,
+		"text":    text, // notification fallback
+		"blocks":  reminderBlocks(text, taskID),
+	}, &resp); err != nil {
+		return "", err
+	}
+
+	return resp.TS, nil
+}
+
+// SendThreadReply sends a message to a thread, or to the channel if threadTS is empty
+func (s *SlackClient) SendThreadReply(ctx context.Context, channel, threadTS, text string) (string, error) {
+	if threadTS == "" {
+		return s.SendMessage(ctx, channel, text)
+	}
+
+	s.logger.Debug("Sending thread reply to Slack",
+		zap.String("channel", channel),
+		zap.String("thread_ts", threadTS))
+
+	var resp struct {
+		TS string `json:"ts"`
+	}
+	if err := s.postJSON(ctx, "chat.postMessage", map[string]any{
+		"channel":   channel,
+		"thread_ts": threadTS,
+		"text":      text,
+	}, &resp); err != nil {
+		return "", err
+	}
+
+	return resp.TS, nil
+}
+
+// PostEphemeral sends a message to a channel that only the given user can see
+func (s *SlackClient) PostEphemeral(ctx context.Context, channel, userID, text string) error {
+	s.logger.Debug("Sending ephemeral message to Slack",
+		zap.String("channel", channel),
+		zap.String("user", userID))
+
+	return s.postJSON(ctx, "chat.postEphemeral", map[string]any{
+		"channel": channel,
+		"user":    userID,
+		"text":    text,
+	}, nil)
+}
+
+// OpenView opens a modal in response to an interaction identified by its trigger ID
+func (s *SlackClient) OpenView(ctx context.Context, triggerID string, view any) error {
+	return s.postJSON(ctx, "views.open", map[string]any{
+		"trigger_id": triggerID,
+		"view":       view,
+	}, nil)
+}
+
+// UpdateMessage replaces the text of a message identified by its timestamp; any buttons are removed
+func (s *SlackClient) UpdateMessage(ctx context.Context, channel, timestamp, text string) error {
+	s.logger.Debug("Updating message in Slack",
+		zap.String("channel", channel),
+		zap.String("ts", timestamp),
+		zap.String("text", text))
+
+	if channel == "" {
+		channel = s.config.DefaultChannel
+	}
+
+	return s.postJSON(ctx, "chat.update", map[string]any{
+		"channel": channel,
+		"ts":      timestamp,
+		"text":    text,
+		"blocks":  []slackBlock{sectionBlock(text)},
+	}, nil)
 }
 
 // ScheduleMessage schedules a message to be sent at a future time
-func (s *SlackClient) ScheduleMessage(channel, text string, postAt time.Time) (string, string, error) {
+func (s *SlackClient) ScheduleMessage(ctx context.Context, channel, text string, postAt time.Time) (string, string, error) {
 	s.logger.Debug("Scheduling message in Slack",
 		zap.String("channel", channel),
 		zap.String("text", text),
@@ -72,47 +224,519 @@ func (s *SlackClient) ScheduleMessage(channel, text string, postAt time.Time) (s
 		channel = s.config.DefaultChannel
 	}
 
-	// In a real implementation, this would use the Slack API to schedule a message
-	// For now, we'll just log the message and return fake IDs
-	scheduledMessageID := fmt.Sprintf("scheduled_%d", time.Now().Unix())
-	timestamp := fmt.Sprintf("%d.%d", postAt.Unix(), 0)
-	return scheduledMessageID, timestamp, nil
+	var resp struct {
+		ScheduledMessageID string `json:"scheduled_message_id"`
+		PostAt             int64  `json:"post_at"`
+	}
+	if err := s.postJSON(ctx, "chat.scheduleMessage", map[string]any{
+		"channel": channel,
+		"text":    text,
+		"post_at": postAt.Unix(),
+	}, &resp); err != nil {
+		return "", "", err
+	}
+
+	return resp.ScheduledMessageID, strconv.FormatInt(resp.PostAt, 10), nil
 }
 
-// UploadFile uploads a file to a Slack channel
-func (s *SlackClient) UploadFile(channel, filename, content string) (string, error) {
+// UploadFile uploads a file to a Slack channel using the external upload flow, in the thread of
+// threadTS if it is set
+func (s *SlackClient) UploadFile(ctx context.Context, channel, threadTS, filename, content string) (string, error) {
 	s.logger.Debug("Uploading file to Slack",
 		zap.String("channel", channel),
+		zap.String("thread_ts", threadTS),
 		zap.String("filename", filename))
 
 	if channel == "" {
 		channel = s.config.DefaultChannel
 	}
 
-	// In a real implementation, this would use the Slack API to upload a file
-	// For now, we'll just log the file and return a fake file ID
-	fileID := fmt.Sprintf("file_%d", time.Now().Unix())
-	return fileID, nil
+	// Reserve an upload URL
+	var upload struct {
+		UploadURL string `json:"upload_url"`
+		FileID    string `json:"file_id"`
+	}
+	if err := s.postForm(ctx, "files.getUploadURLExternal", url.Values{
+		"filename": {filename},
+		"length":   {strconv.Itoa(len(content))},
+	}, &upload); err != nil {
+		return "", err
+	}
+
+	// Send the content
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, upload.UploadURL, strings.NewReader(content))
+	if err != nil {
+		return "", fmt.Errorf("failed to create upload request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	resp, err := s.http.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to upload file content: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to upload file content: HTTP %d", resp.StatusCode)
+	}
+
+	// Share the file in the channel
+	files, err := json.Marshal([]map[string]string{{"id": upload.FileID, "title": filename}})
+	if err != nil {
+		return "", fmt.Errorf("failed to encode files: %w", err)
+	}
+	complete := url.Values{
+		"files":      {string(files)},
+		"channel_id": {channel},
+	}
+	if threadTS != "" {
+		complete.Set("thread_ts", threadTS)
+	}
+	if err := s.postForm(ctx, "files.completeUploadExternal", complete, nil); err != nil {
+		return "", err
+	}
+
+	return upload.FileID, nil
+}
+
+// slackInteractionPayload is the part of a block_actions payload the portal uses
+type slackInteractionPayload struct {
+	Type string `json:"type"`
+	User struct {
+		ID       string `json:"id"`
+		Username string `json:"username"`
+		Name     string `json:"name"`
+	} `json:"user"`
+	Team struct {
+		ID string `json:"id"`
+	} `json:"team"`
+	Channel struct {
+		ID string `json:"id"`
+	} `json:"channel"`
+	Container struct {
+		MessageTS string `json:"message_ts"`
+		ChannelID string `json:"channel_id"`
+	} `json:"container"`
+	ResponseURL string `json:"response_url"`
+	Actions     []struct {
+		ActionID       string `json:"action_id"`
+		BlockID        string `json:"block_id"`
+		Value          string `json:"value"`
+		SelectedOption *struct {
+			Value string `json:"value"`
+		} `json:"selected_option"`
+	} `json:"actions"`
+}
+
+// ParseInteraction parses the form-encoded body of an interactivity request into an Interaction.
+// It returns nil without an error for payloads that aren't reminder actions.
+func (s *SlackClient) ParseInteraction(body []byte) (*Interaction, error) {
+	values, err := url.ParseQuery(string(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse body: %w", err)
+	}
+
+	var payload slackInteractionPayload
+	if err := json.Unmarshal([]byte(values.Get("payload")), &payload); err != nil {
+		return nil, fmt.Errorf("failed to parse payload: %w", err)
+	}
+	if payload.Type != "block_actions" || len(payload.Actions) == 0 {
+		s.logger.Debug("Ignoring Slack interaction", zap.String("type", payload.Type))
+		return nil, nil
+	}
+
+	action := payload.Actions[0]
+	interaction := &Interaction{
+		Platform:    "slack",
+		Action:      action.ActionID,
+		Workspace:   payload.Team.ID,
+		UserID:      payload.User.ID,
+		UserName:    payload.User.Name,
+		Channel:     payload.Container.ChannelID,
+		MessageID:   payload.Container.MessageTS,
+		ResponseURL: payload.ResponseURL,
+	}
+	if interaction.UserName == "" {
+		interaction.UserName = payload.User.Username
+	}
+	if interaction.Channel == "" {
+		interaction.Channel = payload.Channel.ID
+	}
+
+	// Buttons carry the task ID as their value; the snooze menu carries the duration and the
+	// task ID is taken from the block ID
+	taskID := action.Value
+	if action.SelectedOption != nil {
+		interaction.Value = action.SelectedOption.Value
+		taskID = strings.TrimPrefix(action.BlockID, "task_")
+	}
+
+	switch interaction.Action {
+	case ActionRunNow, ActionSnooze, ActionCancel:
+	default:
+		s.logger.Debug("Ignoring unknown Slack action", zap.String("action_id", interaction.Action))
+		return nil, nil
+	}
+
+	id, err := strconv.ParseUint(taskID, 10, 64)
+	if err != nil || id == 0 {
+		return nil, fmt.Errorf("invalid task ID %q", taskID)
+	}
+	interaction.TaskID = uint(id)
+
+	return interaction, nil
+}
+
+// slackViewSubmissionPayload returns the payload of an interactivity request if it is a form submission
+func slackViewSubmissionPayload(body []byte) ([]byte, bool) {
+	values, err := url.ParseQuery(string(body))
+	if err != nil {
+		return nil, false
+	}
+	payload := []byte(values.Get("payload"))
+
+	var envelope struct {
+		Type string `json:"type"`
+	}
+	if err := json.Unmarshal(payload, &envelope); err != nil || envelope.Type != "view_submission" {
+		return nil, false
+	}
+	return payload, true
+}
+
+// UserEmail returns the email address of a Slack user
+func (s *SlackClient) UserEmail(ctx context.Context, userID string) (string, error) {
+	var resp struct {
+		User struct {
+			Profile struct {
+				Email string `json:"email"`
+			} `json:"profile"`
+		} `json:"user"`
+	}
+	if err := s.postForm(ctx, "users.info", url.Values{"user": {userID}}, &resp); err != nil {
+		return "", err
+	}
+	if resp.User.Profile.Email == "" {
+		return "", fmt.Errorf("slack user %s has no visible email address", userID)
+	}
+
+	return resp.User.Profile.Email, nil
+}
+
+// ResolveEmail returns the email address of the user who acted in an interaction. Slack only
+// shows confirmed addresses.
+func (s *SlackClient) ResolveEmail(ctx context.Context, interaction *Interaction) (string, error) {
+	return s.UserEmail(ctx, interaction.UserID)
+}
+
+// RespondInteraction replaces the reminder through the interaction's response_url on success,
+// or shows the text only to the user who acted
+func (s *SlackClient) RespondInteraction(ctx context.Context, interaction *Interaction, text string, success bool) error {
+	if interaction.ResponseURL == "" {
+		return nil
+	}
+	return s.Respond(ctx, interaction.ResponseURL, text, success)
 }
 
-// HandleInteractiveComponent handles an interactive component from Slack
-func (s *SlackClient) HandleInteractiveComponent(payload []byte) error {
-	s.logger.Debug("Handling interactive component from Slack")
+// Respond posts to an interaction's response_url, either replacing the original message or
+// showing the text only to the user who acted
+func (s *SlackClient) Respond(ctx context.Context, responseURL, text string, replaceOriginal bool) error {
+	message := map[string]any{
+		"text":             text,
+		"replace_original": replaceOriginal,
+	}
+	if replaceOriginal {
+		message["blocks"] = []slackBlock{sectionBlock(text)}
+	} else {
+		message["response_type"] = "ephemeral"
+	}
+
+	body, err := json.Marshal(message)
+	if err != nil {
+		return fmt.Errorf("failed to encode response: %w", err)
+	}
 
-	// In a real implementation, this would parse the payload and handle the interaction
-	// For now, we'll just log the payload
-	var data map[string]interface{}
-	if err := json.Unmarshal(payload, &data); err != nil {
-		return fmt.Errorf("failed to parse payload: %w", err)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, responseURL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create response_url request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := s.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to post to response_url: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("response_url returned HTTP %d", resp.StatusCode)
 	}
 
-	s.logger.Debug("Received interactive component", zap.Any("payload", data))
 	return nil
 }
 
-// VerifyRequest verifies a request from Slack
+// VerifyRequest verifies the v0 signature of a request from Slack against the current or previous
+// signing secret, rejects stale timestamps and reports retries and replays as ErrSlackDuplicate
 func (s *SlackClient) VerifyRequest(r *http.Request, body []byte) error {
-	// In a real implementation, this would verify the request signature
-	// For now, we'll just return nil
+	secrets := make([]string, 0, 2)
+	for _, secret := range []string{s.config.SigningSecret, s.config.PreviousSigningSecret} {
+		if secret != "" {
+			secrets = append(secrets, secret)
+		}
+	}
+	if len(secrets) == 0 {
+		return fmt.Errorf("%w: no signing secret configured", ErrSlackSignature)
+	}
+
+	timestamp := r.Header.Get("X-Slack-Request-Timestamp")
+	signature := r.Header.Get("X-Slack-Signature")
+	if timestamp == "" || signature == "" {
+		return fmt.Errorf("%w: missing signature headers", ErrSlackSignature)
+	}
+
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("%w: invalid timestamp %q", ErrSlackSignature, timestamp)
+	}
+	now := time.Now()
+	if age := now.Sub(time.Unix(seconds, 0)); age > slackMaxRequestAge || age < -slackMaxRequestAge {
+		return fmt.Errorf("%w: timestamp is %s off", ErrSlackStaleRequest, age.Round(time.Second))
+	}
+
+	valid := false
+	for _, secret := range secrets {
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write([]byte("v0:" + timestamp + ":"))
+		mac.Write(body)
+		expected := "v0=" + hex.EncodeToString(mac.Sum(nil))
+		if hmac.Equal([]byte(expected), []byte(signature)) {
+			valid = true
+			break
+		}
+	}
+	if !valid {
+		return ErrSlackSignature
+	}
+
+	// Slack retries deliveries it considers failed with a new timestamp and signature, so
+	// dedupe on the payload's own ID as well as on the signature
+	if s.replays.seenBefore(now, "sig:"+signature, slackDeliveryID(r, body)) {
+		s.logger.Info("Ignoring duplicate Slack request",
+			zap.String("retry_num", r.Header.Get("X-Slack-Retry-Num")),
+			zap.String("retry_reason", r.Header.Get("X-Slack-Retry-Reason")))
+		return ErrSlackDuplicate
+	}
+
 	return nil
 }
+
+// slackDeliveryID returns the ID that identifies a delivery across retries: the event ID of Events
+// API callbacks and the trigger ID of interactions and slash commands
+func slackDeliveryID(r *http.Request, body []byte) string {
+	var ids struct {
+		EventID   string `json:"event_id"`
+		TriggerID string `json:"trigger_id"`
+	}
+
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		if err := json.Unmarshal(body, &ids); err != nil {
+			return ""
+		}
+	} else {
+		values, err := url.ParseQuery(string(body))
+		if err != nil {
+			return ""
+		}
+		if payload := values.Get("payload"); payload != "" {
+			if err := json.Unmarshal([]byte(payload), &ids); err != nil {
+				return ""
+			}
+		} else {
+			ids.TriggerID = values.Get("trigger_id")
+		}
+	}
+
+	switch {
+	case ids.EventID != "":
+		return "event:" + ids.EventID
+	case ids.TriggerID != "":
+		return "trigger:" + ids.TriggerID
+	}
+	return ""
+}
+
+// slackResponse is the envelope of every Web API response
+type slackResponse struct {
+	OK      bool   `json:"ok"`
+	Error   string `json:"error"`
+	Warning string `json:"warning"`
+}
+
+// postJSON calls a Web API method with a JSON body and decodes the response into result
+func (s *SlackClient) postJSON(ctx context.Context, method string, payload any, result any) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to encode %s request: %w", method, err)
+	}
+	return s.call(ctx, method, "application/json; charset=utf-8", body, result)
+}
+
+// postForm calls a Web API method with a form-encoded body and decodes the response into result
+func (s *SlackClient) postForm(ctx context.Context, method string, values url.Values, result any) error {
+	return s.call(ctx, method, "application/x-www-form-urlencoded", []byte(values.Encode()), result)
+}
+
+// call performs a Web API call. Rate-limited calls are retried after the wait Slack asks for via
+// Retry-After, unless that is longer than maxRateLimitWait or the retries run out, which returns a
+// *RateLimitError.
+func (s *SlackClient) call(ctx context.Context, method, contentType string, body []byte, result any) error {
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.baseURL+method, bytes.NewReader(body))
+		if err != nil {
+			return fmt.Errorf("failed to create %s request: %w", method, err)
+		}
+		req.Header.Set("Content-Type", contentType)
+		req.Header.Set("Authorization", "Bearer "+s.config.Token)
+
+		resp, err := s.http.Do(req)
+		if err != nil {
+			return fmt.Errorf("slack %s request failed: %w", method, err)
+		}
+		data, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return fmt.Errorf("failed to read %s response: %w", method, err)
+		}
+
+		if resp.StatusCode == http.StatusTooManyRequests {
+			wait := retryAfter(resp.Header.Get("Retry-After"))
+			limited := &RateLimitError{Method: "slack " + method, RetryAfter: wait, Err: &SlackError{Method: method, Code: "ratelimited"}}
+			if err := waitRateLimit(ctx, s.logger, limited, attempt, s.config.MaxRetries); err != nil {
+				return err
+			}
+			continue
+		}
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("slack %s returned HTTP %d", method, resp.StatusCode)
+		}
+
+		var envelope slackResponse
+		if err := json.Unmarshal(data, &envelope); err != nil {
+			return fmt.Errorf("failed to decode %s response: %w", method, err)
+		}
+		if !envelope.OK {
+			return &SlackError{Method: method, Code: envelope.Error}
+		}
+		if envelope.Warning != "" {
+			s.logger.Debug("Slack API warning", zap.String("method", method), zap.String("warning", envelope.Warning))
+		}
+
+		if result != nil {
+			if err := json.Unmarshal(data, result); err != nil {
+				return fmt.Errorf("failed to decode %s response: %w", method, err)
+			}
+		}
+		return nil
+	}
+}
+
+// retryAfter parses a Retry-After header in seconds, defaulting to one second
+func retryAfter(header string) time.Duration {
+	seconds, err := strconv.Atoi(header)
+	if err != nil || seconds <= 0 {
+		return time.Second
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// slackText is a Block Kit text object
+type slackText struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+// slackConfirm is a Block Kit confirmation dialog
+type slackConfirm struct {
+	Title   slackText `json:"title"`
+	Text    slackText `json:"text"`
+	Confirm slackText `json:"confirm"`
+	Deny    slackText `json:"deny"`
+}
+
+// slackOption is an option of a Block Kit select menu
+type slackOption struct {
+	Text  slackText `json:"text"`
+	Value string    `json:"value"`
+}
+
+// slackElement is a Block Kit interactive element
+type slackElement struct {
+	Type        string        `json:"type"`
+	ActionID    string        `json:"action_id"`
+	Text        *slackText    `json:"text,omitempty"`
+	Placeholder *slackText    `json:"placeholder,omitempty"`
+	Value       string        `json:"value,omitempty"`
+	Style       string        `json:"style,omitempty"`
+	Options     []slackOption `json:"options,omitempty"`
+	Confirm     *slackConfirm `json:"confirm,omitempty"`
+}
+
+// snoozeOptions are offered in the snooze menu; values are parsed by the scheduler
+var snoozeOptions = []slackOption{
+	{Text: slackText{Type: "plain_text", Text: "30 minutes"}, Value: "30m"},
+	{Text: slackText{Type: "plain_text", Text: "1 hour"}, Value: "1h"},
+	{Text: slackText{Type: "plain_text", Text: "2 hours"}, Value: "2h"},
+	{Text: slackText{Type: "plain_text", Text: "4 hours"}, Value: "4h"},
+	{Text: slackText{Type: "plain_text", Text: "Tomorrow morning"}, Value: "tomorrow"},
+	{Text: slackText{Type: "plain_text", Text: "Next business morning"}, Value: "next_business_morning"},
+}
+
+// slackBlock is a Block Kit layout block
+type slackBlock struct {
+	Type     string         `json:"type"`
+	BlockID  string         `json:"block_id,omitempty"`
+	Text     *slackText     `json:"text,omitempty"`
+	Elements []slackElement `json:"elements,omitempty"`
+}
+
+// sectionBlock returns a section block with markdown text
+func sectionBlock(text string) slackBlock {
+	return slackBlock{Type: "section", Text: &slackText{Type: "mrkdwn", Text: text}}
+}
+
+// reminderBlocks builds a reminder message with "Run now" and "Cancel" buttons carrying the task ID
+// and a "Snooze" menu
+func reminderBlocks(text string, taskID uint) []slackBlock {
+	value := strconv.FormatUint(uint64(taskID), 10)
+	button := func(actionID, label, style string) slackElement {
+		return slackElement{
+			Type:     "button",
+			ActionID: actionID,
+			Text:     &slackText{Type: "plain_text", Text: label},
+			Value:    value,
+			Style:    style,
+		}
+	}
+
+	cancel := button(ActionCancel, "Cancel", "danger")
+	cancel.Confirm = &slackConfirm{
+		Title:   slackText{Type: "plain_text", Text: "Cancel task?"},
+		Text:    slackText{Type: "mrkdwn", Text: fmt.Sprintf("Task #%d will not run.", taskID)},
+		Confirm: slackText{Type: "plain_text", Text: "Cancel task"},
+		Deny:    slackText{Type: "plain_text", Text: "Keep it"},
+	}
+
+	return []slackBlock{
+		sectionBlock(text),
+		{
+			Type:    "actions",
+			BlockID: "task_" + value,
+			Elements: []slackElement{
+				button(ActionRunNow, "Run now", "primary"),
+				{
+					Type:        "static_select",
+					ActionID:    ActionSnooze,
+					Placeholder: &slackText{Type: "plain_text", Text: "Snooze"},
+					Options:     snoozeOptions,
+				},
+				cancel,
+			},
+		},
+	}
+}
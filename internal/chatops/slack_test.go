@@ -0,0 +1,228 @@
+package chatops
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// slackReply is a response of the fake Web API
+type slackReply struct {
+	status     int
+	retryAfter string
+	body       string
+}
+
+// newTestSlack returns a client of a fake Web API that answers calls with replies in order,
+// repeating the last one, and records the requests it received
+func newTestSlack(t *testing.T, replies ...slackReply) (*SlackClient, *[]map[string]any, *atomic.Int32) {
+	t.Helper()
+	var requests []map[string]any
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer xoxb-test" {
+			t.Errorf("Authorization = %q", r.Header.Get("Authorization"))
+		}
+		var payload map[string]any
+		_ = json.NewDecoder(r.Body).Decode(&payload)
+		if payload == nil {
+			payload = map[string]any{}
+		}
+		payload["method"] = r.URL.Path
+		requests = append(requests, payload)
+
+		reply := replies[min(int(calls.Add(1))-1, len(replies)-1)]
+		if reply.retryAfter != "" {
+			w.Header().Set("Retry-After", reply.retryAfter)
+		}
+		w.WriteHeader(reply.status)
+		_, _ = w.Write([]byte(reply.body))
+	}))
+	t.Cleanup(server.Close)
+
+	client, err := NewSlackClient(SlackConfig{
+		Enabled:        true,
+		Token:          "xoxb-test",
+		DefaultChannel: "C-default",
+		APIURL:         server.URL,
+		MaxRetries:     2,
+	}, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client, &requests, &calls
+}
+
+func TestSlackPostMessage(t *testing.T) {
+	client, requests, _ := newTestSlack(t, slackReply{status: http.StatusOK, body: `{"ok":true,"ts":"1700000000.000100"}`})
+
+	ts, err := client.SendThreadReply(context.Background(), "C1", "1690000000.000001", "done")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ts != "1700000000.000100" {
+		t.Errorf("ts = %q, want 1700000000.000100", ts)
+	}
+	got := (*requests)[0]
+	if got["method"] != "/chat.postMessage" || got["channel"] != "C1" || got["thread_ts"] != "1690000000.000001" || got["text"] != "done" {
+		t.Errorf("request = %v", got)
+	}
+}
+
+func TestSlackUpdateMessage(t *testing.T) {
+	client, requests, _ := newTestSlack(t, slackReply{status: http.StatusOK, body: `{"ok":true}`})
+
+	if err := client.UpdateMessage(context.Background(), "C1", "1690000000.000001", "Task #1 ran"); err != nil {
+		t.Fatal(err)
+	}
+	got := (*requests)[0]
+	if got["method"] != "/chat.update" || got["channel"] != "C1" || got["ts"] != "1690000000.000001" || got["text"] != "Task #1 ran" {
+		t.Errorf("request = %v", got)
+	}
+	if blocks, _ := got["blocks"].([]any); len(blocks) != 1 {
+		t.Errorf("blocks = %v, want the text only, without buttons", got["blocks"])
+	}
+}
+
+func TestSlackCall(t *testing.T) {
+	ok := slackReply{status: http.StatusOK, body: `{"ok":true,"ts":"1"}`}
+	limited := func(retryAfter string) slackReply {
+		return slackReply{status: http.StatusTooManyRequests, retryAfter: retryAfter}
+	}
+
+	tests := []struct {
+		name          string
+		replies       []slackReply
+		cancelled     bool
+		wantCalls     int32
+		wantErr       error
+		wantRetryWait time.Duration // of the *RateLimitError returned
+	}{
+		{name: "success", replies: []slackReply{ok}, wantCalls: 1},
+		{name: "short rate limit is waited out", replies: []slackReply{limited("1"), ok}, wantCalls: 2},
+		{name: "long rate limit is returned", replies: []slackReply{limited("120")}, wantCalls: 1, wantErr: ErrSlackRateLimited, wantRetryWait: 2 * time.Minute},
+		{name: "rate limited after all retries", replies: []slackReply{limited("1")}, wantCalls: 3, wantErr: ErrSlackRateLimited, wantRetryWait: time.Second},
+		{name: "cancelled while waiting", replies: []slackReply{limited("1")}, cancelled: true, wantCalls: 1, wantErr: context.Canceled},
+		{name: "api error", replies: []slackReply{{status: http.StatusOK, body: `{"ok":false,"error":"channel_not_found"}`}}, wantCalls: 1, wantErr: ErrSlackChannel},
+		{name: "revoked token", replies: []slackReply{{status: http.StatusOK, body: `{"ok":false,"error":"token_revoked"}`}}, wantCalls: 1, wantErr: ErrSlackAuth},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, _, calls := newTestSlack(t, tt.replies...)
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if tt.cancelled {
+				time.AfterFunc(100*time.Millisecond, cancel)
+			}
+
+			start := time.Now()
+			_, err := client.SendMessage(ctx, "C1", "hello")
+			if tt.wantErr == nil && err != nil || tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Fatalf("SendMessage() error = %v, want %v", err, tt.wantErr)
+			}
+			if got := calls.Load(); got != tt.wantCalls {
+				t.Errorf("calls = %d, want %d", got, tt.wantCalls)
+			}
+			if elapsed := time.Since(start); elapsed > maxRateLimitWait*time.Duration(tt.wantCalls) {
+				t.Errorf("SendMessage() took %s", elapsed)
+			}
+
+			var limit *RateLimitError
+			if errors.As(err, &limit) != (tt.wantRetryWait != 0) || limit != nil && limit.RetryAfter != tt.wantRetryWait {
+				t.Errorf("SendMessage() error = %v, want a *RateLimitError retrying after %s", err, tt.wantRetryWait)
+			}
+		})
+	}
+}
+
+func TestSlackHTTPError(t *testing.T) {
+	client, _, _ := newTestSlack(t, slackReply{status: http.StatusInternalServerError})
+
+	_, err := client.SendMessage(context.Background(), "C1", "hello")
+	var limit *RateLimitError
+	if err == nil || errors.As(err, &limit) {
+		t.Errorf("SendMessage() error = %v, want a plain error", err)
+	}
+}
+
+// signedSlackRequest returns an interaction request for trigger signed with secret at a time
+func signedSlackRequest(secret, trigger string, at time.Time) (*http.Request, []byte) {
+	body := []byte(url.Values{"payload": {`{"type": "block_actions", "trigger_id": "` + trigger + `"}`}}.Encode())
+	timestamp := strconv.FormatInt(at.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("v0:" + timestamp + ":"))
+	mac.Write(body)
+
+	r := httptest.NewRequest(http.MethodPost, "/api/v1/chatops/slack/interactions", strings.NewReader(string(body)))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.Header.Set("X-Slack-Request-Timestamp", timestamp)
+	r.Header.Set("X-Slack-Signature", "v0="+hex.EncodeToString(mac.Sum(nil)))
+	return r, body
+}
+
+func TestSlackVerifyRequest(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		name    string
+		secret  string
+		trigger string
+		at      time.Time
+		header  string // removed from the request if set
+		wantErr error
+	}{
+		{name: "signed", secret: "current", trigger: "t1", at: now},
+		{name: "signed with the previous secret", secret: "previous", trigger: "t2", at: now},
+		{name: "wrong secret", secret: "guess", trigger: "t3", at: now, wantErr: ErrSlackSignature},
+		{name: "missing signature", secret: "current", trigger: "t4", at: now, header: "X-Slack-Signature", wantErr: ErrSlackSignature},
+		{name: "stale", secret: "current", trigger: "t5", at: now.Add(-10 * time.Minute), wantErr: ErrSlackStaleRequest},
+		{name: "from the future", secret: "current", trigger: "t6", at: now.Add(10 * time.Minute), wantErr: ErrSlackStaleRequest},
+		// Slack's retry of the first request, signed again with a new timestamp
+		{name: "retried delivery", secret: "current", trigger: "t1", at: now.Add(time.Second), wantErr: ErrSlackDuplicate},
+	}
+
+	client, err := NewSlackClient(SlackConfig{
+		Enabled:               true,
+		Token:                 "xoxb-test",
+		SigningSecret:         "current",
+		PreviousSigningSecret: "previous",
+	}, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, body := signedSlackRequest(tt.secret, tt.trigger, tt.at)
+			if tt.header != "" {
+				r.Header.Del(tt.header)
+			}
+			if err := client.VerifyRequest(r, body); !errors.Is(err, tt.wantErr) {
+				t.Errorf("VerifyRequest() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	// The very same request replayed
+	r, body := signedSlackRequest("current", "t7", now)
+	if err := client.VerifyRequest(r, body); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.VerifyRequest(r, body); !errors.Is(err, ErrSlackDuplicate) {
+		t.Errorf("VerifyRequest() of a replay error = %v, want %v", err, ErrSlackDuplicate)
+	}
+}
@@ -0,0 +1,488 @@
+package chatops
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Microsoft Teams errors, matched with errors.Is against a *TeamsError
+var (
+	// ErrTeamsAuth is returned when the bot can't get a token or isn't authorized
+	ErrTeamsAuth = errors.New("teams authentication failed")
+	// ErrTeamsConversation is returned when the conversation or activity doesn't exist
+	ErrTeamsConversation = errors.New("teams conversation unavailable")
+	// ErrTeamsRateLimited is returned when a call is still rate limited after all retries
+	ErrTeamsRateLimited = errors.New("teams rate limit exceeded")
+	// ErrTeamsToken is returned when a request from the Bot Connector has no valid bearer token
+	ErrTeamsToken = errors.New("invalid teams bearer token")
+)
+
+const (
+	// teamsIssuer is the issuer of the tokens the Bot Connector sends to bots
+	teamsIssuer = "https://api.botframework.com"
+	// teamsScope is the OAuth scope of calls to the Bot Connector
+	teamsScope = "https://api.botframework.com/.default"
+)
+
+// TeamsError is an error returned by the Bot Connector API
+type TeamsError struct {
+	Method     string
+	StatusCode int
+	Code       string
+	Message    string
+}
+
+// Error implements the error interface
+func (e *TeamsError) Error() string {
+	return fmt.Sprintf("teams %s failed: HTTP %d %s: %s", e.Method, e.StatusCode, e.Code, e.Message)
+}
+
+// Unwrap maps HTTP status codes to the package's error values
+func (e *TeamsError) Unwrap() error {
+	switch e.StatusCode {
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return ErrTeamsAuth
+	case http.StatusNotFound:
+		return ErrTeamsConversation
+	case http.StatusTooManyRequests:
+		return ErrTeamsRateLimited
+	}
+	return nil
+}
+
+// TeamsClient posts to Microsoft Teams through the Bot Framework connector
+type TeamsClient struct {
+	config     TeamsConfig
+	logger     *zap.Logger
+	http       *http.Client
+	serviceURL string
+	tokenURL   string
+	verifier   *jwksVerifier
+
+	mu     sync.Mutex
+	token  string
+	expiry time.Time
+}
+
+// NewTeamsClient creates a new Teams client
+func NewTeamsClient(config TeamsConfig, logger *zap.Logger) (*TeamsClient, error) {
+	if !config.Enabled {
+		return nil, fmt.Errorf("teams is not enabled")
+	}
+
+	if config.AppID == "" || config.AppPassword == "" {
+		return nil, fmt.Errorf("teams app ID and password are required")
+	}
+
+	serviceURL := config.ServiceURL
+	if serviceURL == "" {
+		serviceURL = "https://smba.trafficmanager.net/teams/"
+	}
+	if !strings.HasSuffix(serviceURL, "/") {
+		serviceURL += "/"
+	}
+
+	tokenURL := config.TokenURL
+	if tokenURL == "" {
+		tenant := config.TenantID
+		if tenant == "" {
+			tenant = "botframework.com"
+		}
+		tokenURL = "https://login.microsoftonline.com/" + tenant + "/oauth2/v2.0/token"
+	}
+
+	jwksURL := config.JWKSURL
+	if jwksURL == "" {
+		jwksURL = "https://login.botframework.com/v1/.well-known/keys"
+	}
+
+	httpClient := &http.Client{Timeout: 30 * time.Second}
+	return &TeamsClient{
+		config:     config,
+		logger:     logger,
+		http:       httpClient,
+		serviceURL: serviceURL,
+		tokenURL:   tokenURL,
+		verifier:   newJWKSVerifier(jwksURL, httpClient, ErrTeamsToken),
+	}, nil
+}
+
+// Name implements ChatProvider
+func (t *TeamsClient) Name() string {
+	return "teams"
+}
+
+// teamsActivity is the part of a Bot Framework activity the portal uses
+type teamsActivity struct {
+	Type         string             `json:"type"`
+	ID           string             `json:"id,omitempty"`
+	Text         string             `json:"text,omitempty"`
+	TextFormat   string             `json:"textFormat,omitempty"`
+	ReplyToID    string             `json:"replyToId,omitempty"`
+	From         *teamsAccount      `json:"from,omitempty"`
+	Conversation *teamsConversation `json:"conversation,omitempty"`
+	Attachments  []teamsAttachment  `json:"attachments,omitempty"`
+	Value        json.RawMessage    `json:"value,omitempty"`
+}
+
+// teamsAccount is a user or bot in a conversation
+type teamsAccount struct {
+	ID   string `json:"id"`
+	Name string `json:"name,omitempty"`
+}
+
+// teamsConversation identifies a conversation: a channel, group chat or personal chat
+type teamsConversation struct {
+	ID       string `json:"id"`
+	TenantID string `json:"tenantId,omitempty"`
+}
+
+// teamsAttachment is a card attached to an activity
+type teamsAttachment struct {
+	ContentType string `json:"contentType"`
+	Content     any    `json:"content"`
+}
+
+// SendMessage sends a message to a Teams conversation and returns the activity ID
+func (t *TeamsClient) SendMessage(ctx context.Context, conversation, text string) (string, error) {
+	t.logger.Debug("Sending message to Teams", zap.String("conversation", conversation), zap.String("text", text))
+
+	return t.sendActivity(ctx, conversation, "", &teamsActivity{Type: "message", Text: text, TextFormat: "markdown"})
+}
+
+// SendReminderMessage sends a reminder message with an Adaptive Card carrying the task's actions
+func (t *TeamsClient) SendReminderMessage(ctx context.Context, conversation, text string, taskID uint) (string, error) {
+	t.logger.Debug("Sending reminder message to Teams",
+		zap.String("conversation", conversation),
+		zap.String("text", text),
+		zap.Uint("task_id", taskID))
+
+	return t.sendActivity(ctx, conversation, "", &teamsActivity{
+		Type:        "message",
+		Attachments: []teamsAttachment{reminderAdaptiveCard(text, taskID)},
+	})
+}
+
+// SendThreadReply replies to an activity, or sends to the conversation if thread is empty
+func (t *TeamsClient) SendThreadReply(ctx context.Context, conversation, thread, text string) (string, error) {
+	t.logger.Debug("Sending thread reply to Teams",
+		zap.String("conversation", conversation),
+		zap.String("thread", thread))
+
+	return t.sendActivity(ctx, conversation, thread, &teamsActivity{Type: "message", Text: text, TextFormat: "markdown"})
+}
+
+// UpdateMessage replaces an activity with a plain text message, removing its card
+func (t *TeamsClient) UpdateMessage(ctx context.Context, conversation, activityID, text string) error {
+	t.logger.Debug("Updating message in Teams",
+		zap.String("conversation", conversation),
+		zap.String("activity", activityID),
+		zap.String("text", text))
+
+	if conversation == "" {
+		conversation = t.config.DefaultConversation
+	}
+
+	path := "v3/conversations/" + url.PathEscape(conversation) + "/activities/" + url.PathEscape(activityID)
+	return t.call(ctx, "activities.update", http.MethodPut, path, &teamsActivity{
+		Type:       "message",
+		ID:         activityID,
+		Text:       text,
+		TextFormat: "markdown",
+	}, nil)
+}
+
+// UploadFile posts a file to a conversation. Bots can't attach files to channel messages without
+// Microsoft Graph, so the content is posted as code blocks.
+func (t *TeamsClient) UploadFile(ctx context.Context, conversation, thread, filename, content string) (string, error) {
+	t.logger.Debug("Uploading file to Teams",
+		zap.String("conversation", conversation),
+		zap.String("thread", thread),
+		zap.String("filename", filename))
+
+	return postFileAsMessages(ctx, t, conversation, thread, filename, content)
+}
+
+// VerifyRequest verifies the bearer token the Bot Connector sends with every activity: it must
+// be signed by the Bot Framework and issued for the bot's app ID
+func (t *TeamsClient) VerifyRequest(r *http.Request, body []byte) error {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		return fmt.Errorf("%w: missing bearer token", ErrTeamsToken)
+	}
+
+	return t.verifier.Verify(token, teamsIssuer, t.config.AppID, time.Now())
+}
+
+// teamsSubmitData is the data of a card's Action.Submit, merged with the card's inputs
+type teamsSubmitData struct {
+	Action string `json:"action"`
+	TaskID string `json:"task_id"`
+	Snooze string `json:"snooze"`
+}
+
+// ParseInteraction parses an activity into an Interaction. It returns nil without an error for
+// activities that aren't reminder card submissions.
+func (t *TeamsClient) ParseInteraction(body []byte) (*Interaction, error) {
+	var activity teamsActivity
+	if err := json.Unmarshal(body, &activity); err != nil {
+		return nil, fmt.Errorf("failed to parse activity: %w", err)
+	}
+	if activity.Type != "message" || len(activity.Value) == 0 {
+		t.logger.Debug("Ignoring Teams activity", zap.String("type", activity.Type))
+		return nil, nil
+	}
+
+	var data teamsSubmitData
+	if err := json.Unmarshal(activity.Value, &data); err != nil {
+		return nil, fmt.Errorf("failed to parse card data: %w", err)
+	}
+
+	switch data.Action {
+	case ActionRunNow, ActionSnooze, ActionCancel:
+	default:
+		t.logger.Debug("Ignoring unknown Teams action", zap.String("action", data.Action))
+		return nil, nil
+	}
+
+	interaction := &Interaction{
+		Platform:  "teams",
+		Action:    data.Action,
+		MessageID: activity.ReplyToID,
+	}
+	if data.Action == ActionSnooze {
+		interaction.Value = data.Snooze
+	}
+	if activity.From != nil {
+		interaction.UserID = activity.From.ID
+		interaction.UserName = activity.From.Name
+	}
+	if activity.Conversation != nil {
+		interaction.Channel = activity.Conversation.ID
+		interaction.Workspace = activity.Conversation.TenantID
+	}
+
+	id, err := strconv.ParseUint(data.TaskID, 10, 64)
+	if err != nil || id == 0 {
+		return nil, fmt.Errorf("invalid task ID %q", data.TaskID)
+	}
+	interaction.TaskID = uint(id)
+
+	return interaction, nil
+}
+
+// ResolveEmail looks up the email address of the user who acted among the conversation's members.
+// The user principal name isn't used in its place: it looks like an email address but needn't be
+// one the user receives mail at, or that belongs to the same person in the portal.
+func (t *TeamsClient) ResolveEmail(ctx context.Context, interaction *Interaction) (string, error) {
+	var member struct {
+		Email string `json:"email"`
+	}
+	path := "v3/conversations/" + url.PathEscape(interaction.Channel) + "/members/" + url.PathEscape(interaction.UserID)
+	if err := t.call(ctx, "conversations.getMember", http.MethodGet, path, nil, &member); err != nil {
+		return "", err
+	}
+
+	if member.Email == "" {
+		return "", fmt.Errorf("teams user %s has no email address", interaction.UserID)
+	}
+	return member.Email, nil
+}
+
+// RespondInteraction replaces the reminder card on success. Teams has no messages visible to a
+// single user, so errors are posted as a reply to the reminder.
+func (t *TeamsClient) RespondInteraction(ctx context.Context, interaction *Interaction, text string, success bool) error {
+	if success {
+		return t.UpdateMessage(ctx, interaction.Channel, interaction.MessageID, text)
+	}
+	_, err := t.SendThreadReply(ctx, interaction.Channel, interaction.MessageID, text)
+	return err
+}
+
+// sendActivity sends an activity to a conversation, as a reply if replyTo is set, and returns its ID
+func (t *TeamsClient) sendActivity(ctx context.Context, conversation, replyTo string, activity *teamsActivity) (string, error) {
+	if conversation == "" {
+		conversation = t.config.DefaultConversation
+	}
+
+	path := "v3/conversations/" + url.PathEscape(conversation) + "/activities"
+	method := "conversations.sendToConversation"
+	if replyTo != "" {
+		path += "/" + url.PathEscape(replyTo)
+		method = "conversations.replyToActivity"
+	}
+
+	var resp struct {
+		ID string `json:"id"`
+	}
+	if err := t.call(ctx, method, http.MethodPost, path, activity, &resp); err != nil {
+		return "", err
+	}
+
+	return resp.ID, nil
+}
+
+// accessToken returns a Bot Connector token from the client credentials grant, cached until
+// shortly before it expires
+func (t *TeamsClient) accessToken() (string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	if t.token != "" && now.Add(tokenLeeway).Before(t.expiry) {
+		return t.token, nil
+	}
+
+	resp, err := t.http.PostForm(t.tokenURL, url.Values{
+		"grant_type":    {"client_credentials"},
+		"client_id":     {t.config.AppID},
+		"client_secret": {t.config.AppPassword},
+		"scope":         {teamsScope},
+	})
+	if err != nil {
+		return "", fmt.Errorf("token request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		AccessToken      string `json:"access_token"`
+		ExpiresIn        int64  `json:"expires_in"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("failed to decode token response (HTTP %d): %w", resp.StatusCode, err)
+	}
+	if resp.StatusCode != http.StatusOK || result.AccessToken == "" {
+		return "", fmt.Errorf("%w: token request returned HTTP %d: %s %s",
+			ErrTeamsAuth, resp.StatusCode, result.Error, result.ErrorDescription)
+	}
+
+	t.token = result.AccessToken
+	t.expiry = now.Add(time.Duration(result.ExpiresIn) * time.Second)
+	return t.token, nil
+}
+
+// call performs a Bot Connector call, waiting and retrying while it reports rate limiting for at
+// most maxRateLimitWait at a time
+func (t *TeamsClient) call(ctx context.Context, method, httpMethod, path string, payload any, result any) error {
+	var body []byte
+	if payload != nil {
+		var err error
+		if body, err = json.Marshal(payload); err != nil {
+			return fmt.Errorf("failed to encode %s request: %w", method, err)
+		}
+	}
+
+	for attempt := 0; ; attempt++ {
+		token, err := t.accessToken()
+		if err != nil {
+			return fmt.Errorf("failed to get teams access token: %w", err)
+		}
+
+		req, err := http.NewRequestWithContext(ctx, httpMethod, t.serviceURL+path, bytes.NewReader(body))
+		if err != nil {
+			return fmt.Errorf("failed to create %s request: %w", method, err)
+		}
+		if payload != nil {
+			req.Header.Set("Content-Type", "application/json; charset=utf-8")
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+
+		resp, err := t.http.Do(req)
+		if err != nil {
+			return fmt.Errorf("teams %s request failed: %w", method, err)
+		}
+		data, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return fmt.Errorf("failed to read %s response: %w", method, err)
+		}
+
+		if resp.StatusCode == http.StatusTooManyRequests {
+			limited := &RateLimitError{
+				Method:     "teams " + method,
+				RetryAfter: retryAfter(resp.Header.Get("Retry-After")),
+				Err:        &TeamsError{Method: method, StatusCode: resp.StatusCode},
+			}
+			if err := waitRateLimit(ctx, t.logger, limited, attempt, t.config.MaxRetries); err != nil {
+				return err
+			}
+			continue
+		}
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			var envelope struct {
+				Error struct {
+					Code    string `json:"code"`
+					Message string `json:"message"`
+				} `json:"error"`
+			}
+			_ = json.Unmarshal(data, &envelope)
+			return &TeamsError{
+				Method:     method,
+				StatusCode: resp.StatusCode,
+				Code:       envelope.Error.Code,
+				Message:    envelope.Error.Message,
+			}
+		}
+
+		if result != nil && len(data) > 0 {
+			if err := json.Unmarshal(data, result); err != nil {
+				return fmt.Errorf("failed to decode %s response: %w", method, err)
+			}
+		}
+		return nil
+	}
+}
+
+// reminderAdaptiveCard builds a reminder card with "Run now", "Snooze" and "Cancel" submit
+// actions carrying the task ID; "Snooze" uses the duration picked in the card's menu
+func reminderAdaptiveCard(text string, taskID uint) teamsAttachment {
+	id := strconv.FormatUint(uint64(taskID), 10)
+	submit := func(action, title, style string) map[string]any {
+		submit := map[string]any{
+			"type":  "Action.Submit",
+			"title": title,
+			"data":  map[string]string{"action": action, "task_id": id},
+		}
+		if style != "" {
+			submit["style"] = style
+		}
+		return submit
+	}
+
+	choices := make([]map[string]string, 0, len(snoozeOptions))
+	for _, option := range snoozeOptions {
+		choices = append(choices, map[string]string{"title": option.Text.Text, "value": option.Value})
+	}
+
+	return teamsAttachment{
+		ContentType: "application/vnd.microsoft.card.adaptive",
+		Content: map[string]any{
+			"type":    "AdaptiveCard",
+			"$schema": "http://adaptivecards.io/schemas/adaptive-card.json",
+			"version": "1.4",
+			"body": []map[string]any{
+				{"type": "TextBlock", "text": text, "wrap": true},
+				{"type": "Input.ChoiceSet", "id": "snooze", "placeholder": "Snooze for…", "value": snoozeOptions[0].Value, "choices": choices},
+			},
+			"actions": []map[string]any{
+				submit(ActionRunNow, "Run now", "positive"),
+				submit(ActionSnooze, "Snooze", ""),
+				submit(ActionCancel, "Cancel", "destructive"),
+			},
+		},
+	}
+}
@@ -0,0 +1,162 @@
+package chatops
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+// newTestTeams returns a client of a fake Bot Connector and token endpoint. Connector calls are
+// answered by handler; the number of tokens issued is counted.
+func newTestTeams(t *testing.T, handler http.HandlerFunc) (*TeamsClient, *int) {
+	t.Helper()
+	var tokens int
+	mux := http.NewServeMux()
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil || r.PostForm.Get("client_secret") != "secret" {
+			t.Errorf("token request form = %v", r.PostForm)
+		}
+		tokens++
+		_, _ = w.Write([]byte(`{"access_token": "teams-token", "expires_in": 3600}`))
+	})
+	mux.HandleFunc("/v3/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer teams-token" {
+			t.Errorf("Authorization = %q", r.Header.Get("Authorization"))
+		}
+		handler(w, r)
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	client, err := NewTeamsClient(TeamsConfig{
+		Enabled:             true,
+		AppID:               "app",
+		AppPassword:         "secret",
+		ServiceURL:          server.URL,
+		TokenURL:            server.URL + "/token",
+		DefaultConversation: "conv-default",
+	}, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client, &tokens
+}
+
+func TestTeamsSendThreadReply(t *testing.T) {
+	var paths []string
+	client, tokens := newTestTeams(t, func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.Method+" "+r.URL.Path)
+		_, _ = w.Write([]byte(`{"id": "activity-2"}`))
+	})
+
+	ctx := context.Background()
+	id, err := client.SendThreadReply(ctx, "", "activity-1", "hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != "activity-2" {
+		t.Errorf("SendThreadReply() = %q, want activity-2", id)
+	}
+	if err := client.UpdateMessage(ctx, "conv", "activity-1", "done"); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		"POST /v3/conversations/conv-default/activities/activity-1",
+		"PUT /v3/conversations/conv/activities/activity-1",
+	}
+	if len(paths) != len(want) {
+		t.Fatalf("made calls %v, want %v", paths, want)
+	}
+	for i := range want {
+		if paths[i] != want[i] {
+			t.Errorf("call %d = %q, want %q", i, paths[i], want[i])
+		}
+	}
+	if *tokens != 1 {
+		t.Errorf("requested %d tokens, want the first one reused", *tokens)
+	}
+}
+
+func TestTeamsResolveEmail(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		body    string
+		want    string
+		wantErr bool
+		errIs   error
+	}{
+		{"email", http.StatusOK, `{"email": "ada@example.com", "userPrincipalName": "ada@corp.example.com"}`, "ada@example.com", false, nil},
+		{"only a user principal name", http.StatusOK, `{"userPrincipalName": "ada@corp.example.com"}`, "", true, nil},
+		{"not a member", http.StatusNotFound, `{"error": {"code": "ConversationNotFound"}}`, "", true, ErrTeamsConversation},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, _ := newTestTeams(t, func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/v3/conversations/conv/members/user-1" {
+					t.Errorf("path = %q", r.URL.Path)
+				}
+				w.WriteHeader(tt.status)
+				_, _ = io.WriteString(w, tt.body)
+			})
+
+			got, err := client.ResolveEmail(context.Background(), &Interaction{Channel: "conv", UserID: "user-1"})
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("ResolveEmail() = %q, want an error", got)
+				}
+				if tt.errIs != nil && !errors.Is(err, tt.errIs) {
+					t.Errorf("ResolveEmail() error = %v, want %v", err, tt.errIs)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("ResolveEmail() = %q, %v, want %q", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestTeamsParseInteraction(t *testing.T) {
+	client, _ := newTestTeams(t, func(w http.ResponseWriter, r *http.Request) {})
+
+	tests := []struct {
+		name    string
+		body    string
+		want    *Interaction
+		wantErr bool
+	}{
+		{
+			name: "snooze",
+			body: `{"type": "message", "replyToId": "activity-1", "value": {"action": "snooze", "task_id": "7", "snooze": "1h"},
+				"from": {"id": "user-1", "name": "Ada"}, "conversation": {"id": "conv", "tenantId": "tenant"}}`,
+			want: &Interaction{Platform: "teams", Action: ActionSnooze, Value: "1h", TaskID: 7, UserID: "user-1", UserName: "Ada",
+				Channel: "conv", Workspace: "tenant", MessageID: "activity-1"},
+		},
+		{name: "plain message", body: `{"type": "message", "text": "hi"}`},
+		{name: "unknown action", body: `{"type": "message", "value": {"action": "approve", "task_id": "7"}}`},
+		{name: "invalid task", body: `{"type": "message", "value": {"action": "run_now", "task_id": "x"}}`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := client.ParseInteraction([]byte(tt.body))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseInteraction() error = %v, wantErr %t", err, tt.wantErr)
+			}
+			switch {
+			case tt.want == nil && got != nil:
+				t.Errorf("ParseInteraction() = %+v, want nil", got)
+			case tt.want != nil && (got == nil || *got != *tt.want):
+				t.Errorf("ParseInteraction() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -16,6 +16,8 @@ type Config struct {
 	Logging   LoggingConfig
 	Telemetry TelemetryConfig
 	ChatOps   ChatOpsConfig
+	GRPC      GRPCConfig
+	Secrets   SecretsConfig
 }
 
 // ServerConfig holds the server configuration
@@ -31,6 +33,35 @@ type ServerConfig struct {
 	CORSAllowOrigins []string
 }
 
+// SecretsConfig holds the key encryption keys that secret parameter values are sealed with
+type SecretsConfig struct {
+	KEKFile          string   // base64 32-byte key; secret fields only accept references without one
+	PreviousKEKFiles []string // earlier KEKs, still used to open values sealed before a rotation
+}
+
+// GRPCConfig holds the configuration of the gRPC server agents connect to
+type GRPCConfig struct {
+	Host             string
+	Port             int
+	TLSEnabled       bool
+	Insecure         bool // serves agents without mutual TLS, so any caller can act as any agent; local development only
+	TLSCertFile      string
+	TLSKeyFile       string
+	TLSClientCAFile  string // additional CA whose agent certificates are accepted besides the built-in one
+	TrustDomain      string // agent certificates carry the URI SAN spiffe://<TrustDomain>/agent/<name>
+	CACertFile       string // built-in CA that issues agent certificates on enrollment; created if missing
+	CAKeyFile        string
+	AgentCertTTL     time.Duration // lifetime of issued agent certificates; agents renew them before expiry
+	ApprovalKeysFile string        // PEM public keys of the template approvers; their private keys never reach the portal
+	ApprovalMaxTTL   time.Duration // approvals that expire further ahead are refused
+	RedactRulesFile  string        // extra name/pattern rules masked in task logs besides the built-in ones
+	AgentTimeout     time.Duration // agents without a heartbeat for this long aren't given tasks
+	QueryTimeout     time.Duration // how long an agent may take to answer a query, e.g. for parameter choices
+	OptionsCacheTTL  time.Duration // how long parameter choices listed by an agent are reused
+	DispatchInterval time.Duration // how often ready tasks are sent to agents
+	TaskTimeout      time.Duration // how long a dispatched task may run on its agent
+}
+
 // DatabaseConfig holds the database configuration
 type DatabaseConfig struct {
 	Host     string
@@ -75,6 +106,9 @@ type ChatOpsConfig struct {
 	SlackSigningSecret string
 	GoogleChatEnabled  bool
 	GoogleChatToken    string
+	InteractionWorkers int
+	PortalURL          string        // base URL of the web portal, used in account links sent to chat users
+	LinkTTL            time.Duration // how long an account link can be used
 }
 
 // NewConfig creates a new configuration from environment variables
@@ -126,6 +160,34 @@ func NewConfig() *Config {
 			SlackSigningSecret: getEnv("CHATOPS_SLACK_SIGNING_SECRET", ""),
 			GoogleChatEnabled:  getEnvAsBool("CHATOPS_GOOGLE_CHAT_ENABLED", false),
 			GoogleChatToken:    getEnv("CHATOPS_GOOGLE_CHAT_TOKEN", ""),
+			InteractionWorkers: getEnvAsInt("CHATOPS_INTERACTION_WORKERS", 4),
+			PortalURL:          getEnv("CHATOPS_PORTAL_URL", ""),
+			LinkTTL:            getEnvAsDuration("CHATOPS_LINK_TTL", 15*time.Minute),
+		},
+		GRPC: GRPCConfig{
+			Host:             getEnv("GRPC_HOST", "0.0.0.0"),
+			Port:             getEnvAsInt("GRPC_PORT", 9090),
+			TLSEnabled:       getEnvAsBool("GRPC_TLS_ENABLED", false),
+			Insecure:         getEnvAsBool("GRPC_INSECURE", false),
+			TLSCertFile:      getEnv("GRPC_TLS_CERT_FILE", ""),
+			TLSKeyFile:       getEnv("GRPC_TLS_KEY_FILE", ""),
+			TLSClientCAFile:  getEnv("GRPC_TLS_CLIENT_CA_FILE", ""),
+			TrustDomain:      getEnv("GRPC_TRUST_DOMAIN", "ops-butler"),
+			CACertFile:       getEnv("GRPC_CA_CERT_FILE", ""),
+			CAKeyFile:        getEnv("GRPC_CA_KEY_FILE", ""),
+			AgentCertTTL:     getEnvAsDuration("GRPC_AGENT_CERT_TTL", 24*time.Hour),
+			ApprovalKeysFile: getEnv("GRPC_TASK_APPROVAL_KEYS_FILE", ""),
+			ApprovalMaxTTL:   getEnvAsDuration("GRPC_TASK_APPROVAL_MAX_TTL", 366*24*time.Hour),
+			RedactRulesFile:  getEnv("GRPC_REDACT_RULES_FILE", ""),
+			AgentTimeout:     getEnvAsDuration("GRPC_AGENT_TIMEOUT", 2*time.Minute),
+			QueryTimeout:     getEnvAsDuration("GRPC_QUERY_TIMEOUT", 10*time.Second),
+			OptionsCacheTTL:  getEnvAsDuration("GRPC_OPTIONS_CACHE_TTL", 30*time.Second),
+			DispatchInterval: getEnvAsDuration("GRPC_DISPATCH_INTERVAL", 2*time.Second),
+			TaskTimeout:      getEnvAsDuration("GRPC_TASK_TIMEOUT", time.Hour),
+		},
+		Secrets: SecretsConfig{
+			KEKFile:          getEnv("SECRETS_KEK_FILE", ""),
+			PreviousKEKFiles: getEnvAsSlice("SECRETS_PREVIOUS_KEK_FILES", nil),
 		},
 	}
 }
@@ -197,3 +259,8 @@ func (c *DatabaseConfig) DSN() string {
 func (c *ServerConfig) Address() string {
 	return fmt.Sprintf("%s:%d", c.Host, c.Port)
 }
+
+// Address returns the gRPC server address
+func (c *GRPCConfig) Address() string {
+	return fmt.Sprintf("%s:%d", c.Host, c.Port)
+}
@@ -0,0 +1,175 @@
+package database
+
+import (
+	"context"
+	"errors"
+
+	"github.com/BogdanDolia/ops-butler/internal/models"
+	"gorm.io/gorm"
+)
+
+// GormAgentRepository is a GORM implementation of AgentRepository
+type GormAgentRepository struct {
+	*GormRepository
+}
+
+// NewAgentRepository creates a new GormAgentRepository
+func NewAgentRepository(db *gorm.DB) AgentRepository {
+	return &GormAgentRepository{
+		GormRepository: NewGormRepository(db),
+	}
+}
+
+// Create creates a new agent
+func (r *GormAgentRepository) Create(ctx context.Context, agent *models.ClusterAgent) error {
+	if agent == nil {
+		return ErrValidation
+	}
+
+	result := r.db.WithContext(ctx).Create(agent)
+	if result.Error != nil {
+		return result.Error
+	}
+
+	return nil
+}
+
+// GetByID gets an agent by ID
+func (r *GormAgentRepository) GetByID(ctx context.Context, id uint) (*models.ClusterAgent, error) {
+	if id == 0 {
+		return nil, ErrInvalidID
+	}
+
+	var agent models.ClusterAgent
+	result := r.db.WithContext(ctx).First(&agent, id)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, ErrNotFound
+		}
+		return nil, result.Error
+	}
+
+	return &agent, nil
+}
+
+// GetByName gets an agent by name
+func (r *GormAgentRepository) GetByName(ctx context.Context, name string) (*models.ClusterAgent, error) {
+	if name == "" {
+		return nil, ErrValidation
+	}
+
+	var agent models.ClusterAgent
+	result := r.db.WithContext(ctx).Where("name = ?", name).First(&agent)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, ErrNotFound
+		}
+		return nil, result.Error
+	}
+
+	return &agent, nil
+}
+
+// List lists agents with pagination
+func (r *GormAgentRepository) List(ctx context.Context, offset, limit int) ([]*models.ClusterAgent, error) {
+	if limit <= 0 {
+		limit = 10 // Default limit
+	}
+	if offset < 0 {
+		offset = 0
+	}
+
+	var agents []*models.ClusterAgent
+	result := r.db.WithContext(ctx).Offset(offset).Limit(limit).Find(&agents)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	return agents, nil
+}
+
+// Update updates an agent
+func (r *GormAgentRepository) Update(ctx context.Context, agent *models.ClusterAgent) error {
+	if agent == nil || agent.ID == 0 {
+		return ErrInvalidID
+	}
+
+	result := r.db.WithContext(ctx).Save(agent)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+// AddQueuedTasks counts tasks sent to an agent until its next heartbeat reports its real load
+func (r *GormAgentRepository) AddQueuedTasks(ctx context.Context, id uint, n int) error {
+	if id == 0 {
+		return ErrInvalidID
+	}
+
+	result := r.db.WithContext(ctx).Model(&models.ClusterAgent{}).
+		Where("id = ?", id).
+		Update("queued_tasks", gorm.Expr("queued_tasks + ?", n))
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+// Delete deletes an agent by ID
+func (r *GormAgentRepository) Delete(ctx context.Context, id uint) error {
+	if id == 0 {
+		return ErrInvalidID
+	}
+
+	result := r.db.WithContext(ctx).Delete(&models.ClusterAgent{}, id)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+// RevokeCertificate records a revoked agent certificate and unbinds it from its agent, which has to
+// register again with a new certificate
+func (r *GormAgentRepository) RevokeCertificate(ctx context.Context, revocation *models.RevokedCertificate) error {
+	if revocation == nil || revocation.AgentID == 0 || revocation.Fingerprint == "" {
+		return ErrValidation
+	}
+
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(revocation).Error; err != nil {
+			return err
+		}
+
+		return tx.Model(&models.ClusterAgent{}).
+			Where("id = ? AND cert_fingerprint = ?", revocation.AgentID, revocation.Fingerprint).
+			Updates(map[string]interface{}{
+				"cert_fingerprint": "",
+				"cert_serial":      "",
+				"cert_expires_at":  nil,
+			}).Error
+	})
+}
+
+// IsCertificateRevoked reports whether the certificate with the given fingerprint was revoked
+func (r *GormAgentRepository) IsCertificateRevoked(ctx context.Context, fingerprint string) (bool, error) {
+	var count int64
+	result := r.db.WithContext(ctx).Model(&models.RevokedCertificate{}).Where("fingerprint = ?", fingerprint).Count(&count)
+	if result.Error != nil {
+		return false, result.Error
+	}
+
+	return count > 0, nil
+}
@@ -0,0 +1,143 @@
+package database
+
+import (
+	"context"
+	"errors"
+
+	"github.com/BogdanDolia/ops-butler/internal/models"
+	"gorm.io/gorm"
+)
+
+// GormCalendarRepository is a GORM implementation of CalendarRepository
+type GormCalendarRepository struct {
+	*GormRepository
+}
+
+// NewCalendarRepository creates a new GormCalendarRepository
+func NewCalendarRepository(db *gorm.DB) CalendarRepository {
+	return &GormCalendarRepository{
+		GormRepository: NewGormRepository(db),
+	}
+}
+
+// Create creates a new calendar together with its windows and template links
+func (r *GormCalendarRepository) Create(ctx context.Context, calendar *models.Calendar) error {
+	if calendar == nil || calendar.Name == "" {
+		return ErrValidation
+	}
+
+	result := r.db.WithContext(ctx).Create(calendar)
+	if result.Error != nil {
+		return result.Error
+	}
+
+	return nil
+}
+
+// GetByID gets a calendar by ID
+func (r *GormCalendarRepository) GetByID(ctx context.Context, id uint) (*models.Calendar, error) {
+	if id == 0 {
+		return nil, ErrInvalidID
+	}
+
+	var calendar models.Calendar
+	result := r.db.WithContext(ctx).
+		Preload("Windows").
+		Preload("Templates").
+		First(&calendar, id)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, ErrNotFound
+		}
+		return nil, result.Error
+	}
+
+	return &calendar, nil
+}
+
+// List lists calendars with pagination
+func (r *GormCalendarRepository) List(ctx context.Context, offset, limit int) ([]*models.Calendar, error) {
+	if limit <= 0 {
+		limit = 10 // Default limit
+	}
+	if offset < 0 {
+		offset = 0
+	}
+
+	var calendars []*models.Calendar
+	result := r.db.WithContext(ctx).
+		Preload("Windows").
+		Preload("Templates").
+		Offset(offset).
+		Limit(limit).
+		Find(&calendars)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	return calendars, nil
+}
+
+// ListEnabled lists all enabled calendars with their windows and templates
+func (r *GormCalendarRepository) ListEnabled(ctx context.Context) ([]*models.Calendar, error) {
+	var calendars []*models.Calendar
+	result := r.db.WithContext(ctx).
+		Preload("Windows").
+		Preload("Templates").
+		Where("enabled = ?", true).
+		Find(&calendars)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	return calendars, nil
+}
+
+// Update updates a calendar, replacing its windows and template links
+func (r *GormCalendarRepository) Update(ctx context.Context, calendar *models.Calendar) error {
+	if calendar == nil || calendar.ID == 0 {
+		return ErrInvalidID
+	}
+
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Omit("Windows", "Templates").Save(calendar)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrNotFound
+		}
+
+		if err := tx.Where("calendar_id = ?", calendar.ID).Delete(&models.CalendarWindow{}).Error; err != nil {
+			return err
+		}
+		for i := range calendar.Windows {
+			calendar.Windows[i].ID = 0
+			calendar.Windows[i].CalendarID = calendar.ID
+		}
+		if len(calendar.Windows) > 0 {
+			if err := tx.Create(&calendar.Windows).Error; err != nil {
+				return err
+			}
+		}
+
+		return tx.Model(calendar).Association("Templates").Replace(calendar.Templates)
+	})
+}
+
+// Delete deletes a calendar by ID
+func (r *GormCalendarRepository) Delete(ctx context.Context, id uint) error {
+	if id == 0 {
+		return ErrInvalidID
+	}
+
+	result := r.db.WithContext(ctx).Delete(&models.Calendar{}, id)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
@@ -0,0 +1,161 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/BogdanDolia/ops-butler/internal/models"
+	"gorm.io/gorm"
+)
+
+// GormChatIdentityRepository is a GORM implementation of ChatIdentityRepository
+type GormChatIdentityRepository struct {
+	*GormRepository
+}
+
+// NewChatIdentityRepository creates a new GormChatIdentityRepository
+func NewChatIdentityRepository(db *gorm.DB) ChatIdentityRepository {
+	return &GormChatIdentityRepository{
+		GormRepository: NewGormRepository(db),
+	}
+}
+
+// GetByID gets a chat identity by ID
+func (r *GormChatIdentityRepository) GetByID(ctx context.Context, id uint) (*models.ChatIdentity, error) {
+	if id == 0 {
+		return nil, ErrInvalidID
+	}
+
+	var identity models.ChatIdentity
+	result := r.db.WithContext(ctx).First(&identity, id)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, ErrNotFound
+		}
+		return nil, result.Error
+	}
+
+	return &identity, nil
+}
+
+// GetByChatUser gets the identity of a chat account
+func (r *GormChatIdentityRepository) GetByChatUser(ctx context.Context, provider, workspace, chatUserID string) (*models.ChatIdentity, error) {
+	if provider == "" || chatUserID == "" {
+		return nil, ErrValidation
+	}
+
+	var identity models.ChatIdentity
+	result := r.db.WithContext(ctx).
+		Where("provider = ? AND workspace = ? AND chat_user_id = ?", provider, workspace, chatUserID).
+		First(&identity)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, ErrNotFound
+		}
+		return nil, result.Error
+	}
+
+	return &identity, nil
+}
+
+// ListByUserID lists the chat identities linked to a user
+func (r *GormChatIdentityRepository) ListByUserID(ctx context.Context, userID uint) ([]*models.ChatIdentity, error) {
+	if userID == 0 {
+		return nil, ErrInvalidID
+	}
+
+	var identities []*models.ChatIdentity
+	result := r.db.WithContext(ctx).Where("user_id = ?", userID).Order("provider, workspace").Find(&identities)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	return identities, nil
+}
+
+// Link links a chat account to a user. An existing identity of the account is pointed at the new
+// user, so a chat account is linked to at most one user.
+func (r *GormChatIdentityRepository) Link(ctx context.Context, identity *models.ChatIdentity) error {
+	if identity == nil || identity.UserID == 0 || identity.Provider == "" || identity.ChatUserID == "" {
+		return ErrValidation
+	}
+
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var existing models.ChatIdentity
+		err := tx.Where("provider = ? AND workspace = ? AND chat_user_id = ?",
+			identity.Provider, identity.Workspace, identity.ChatUserID).First(&existing).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return tx.Create(identity).Error
+		}
+		if err != nil {
+			return err
+		}
+
+		existing.UserID = identity.UserID
+		existing.Method = identity.Method
+		existing.VerifiedAt = identity.VerifiedAt
+		if err := tx.Save(&existing).Error; err != nil {
+			return err
+		}
+		*identity = existing
+		return nil
+	})
+}
+
+// Delete unlinks a chat identity. The row is removed rather than soft deleted so the account can
+// be linked again.
+func (r *GormChatIdentityRepository) Delete(ctx context.Context, id uint) error {
+	if id == 0 {
+		return ErrInvalidID
+	}
+
+	result := r.db.WithContext(ctx).Unscoped().Delete(&models.ChatIdentity{}, id)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+// CreateLinkToken stores a link token
+func (r *GormChatIdentityRepository) CreateLinkToken(ctx context.Context, token *models.ChatLinkToken) error {
+	if token == nil || token.TokenHash == "" {
+		return ErrValidation
+	}
+
+	result := r.db.WithContext(ctx).Create(token)
+	if result.Error != nil {
+		return result.Error
+	}
+
+	return nil
+}
+
+// ConsumeLinkToken marks an unused, unexpired link token as used and returns it. A token can be
+// consumed only once; ErrNotFound is returned for unknown, used and expired tokens.
+func (r *GormChatIdentityRepository) ConsumeLinkToken(ctx context.Context, tokenHash string, now time.Time) (*models.ChatLinkToken, error) {
+	if tokenHash == "" {
+		return nil, ErrValidation
+	}
+
+	result := r.db.WithContext(ctx).Model(&models.ChatLinkToken{}).
+		Where("token_hash = ? AND used_at IS NULL AND expires_at > ?", tokenHash, now).
+		Update("used_at", now)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, ErrNotFound
+	}
+
+	var token models.ChatLinkToken
+	if err := r.db.WithContext(ctx).Where("token_hash = ?", tokenHash).First(&token).Error; err != nil {
+		return nil, err
+	}
+
+	return &token, nil
+}
@@ -0,0 +1,127 @@
+package database
+
+import (
+	"context"
+	"errors"
+
+	"github.com/BogdanDolia/ops-butler/internal/models"
+	"gorm.io/gorm"
+)
+
+// GormChatRouteRepository is a GORM implementation of ChatRouteRepository
+type GormChatRouteRepository struct {
+	*GormRepository
+}
+
+// NewChatRouteRepository creates a new GormChatRouteRepository
+func NewChatRouteRepository(db *gorm.DB) ChatRouteRepository {
+	return &GormChatRouteRepository{
+		GormRepository: NewGormRepository(db),
+	}
+}
+
+// Create creates a new chat route together with its template links
+func (r *GormChatRouteRepository) Create(ctx context.Context, route *models.ChatRoute) error {
+	if route == nil || route.Name == "" {
+		return ErrValidation
+	}
+
+	result := r.db.WithContext(ctx).Create(route)
+	if result.Error != nil {
+		return result.Error
+	}
+
+	return nil
+}
+
+// GetByID gets a chat route by ID
+func (r *GormChatRouteRepository) GetByID(ctx context.Context, id uint) (*models.ChatRoute, error) {
+	if id == 0 {
+		return nil, ErrInvalidID
+	}
+
+	var route models.ChatRoute
+	result := r.db.WithContext(ctx).Preload("Templates").First(&route, id)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, ErrNotFound
+		}
+		return nil, result.Error
+	}
+
+	return &route, nil
+}
+
+// List lists chat routes in the order they are tried, with pagination
+func (r *GormChatRouteRepository) List(ctx context.Context, offset, limit int) ([]*models.ChatRoute, error) {
+	if limit <= 0 {
+		limit = 10 // Default limit
+	}
+	if offset < 0 {
+		offset = 0
+	}
+
+	var routes []*models.ChatRoute
+	result := r.db.WithContext(ctx).
+		Preload("Templates").
+		Order("priority, id").
+		Offset(offset).
+		Limit(limit).
+		Find(&routes)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	return routes, nil
+}
+
+// ListEnabled lists all enabled chat routes with their templates in the order they are tried
+func (r *GormChatRouteRepository) ListEnabled(ctx context.Context) ([]*models.ChatRoute, error) {
+	var routes []*models.ChatRoute
+	result := r.db.WithContext(ctx).
+		Preload("Templates").
+		Where("enabled = ?", true).
+		Order("priority, id").
+		Find(&routes)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	return routes, nil
+}
+
+// Update updates a chat route, replacing its template links
+func (r *GormChatRouteRepository) Update(ctx context.Context, route *models.ChatRoute) error {
+	if route == nil || route.ID == 0 {
+		return ErrInvalidID
+	}
+
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Omit("Templates").Save(route)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrNotFound
+		}
+
+		return tx.Model(route).Association("Templates").Replace(route.Templates)
+	})
+}
+
+// Delete deletes a chat route by ID
+func (r *GormChatRouteRepository) Delete(ctx context.Context, id uint) error {
+	if id == 0 {
+		return ErrInvalidID
+	}
+
+	result := r.db.WithContext(ctx).Delete(&models.ChatRoute{}, id)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
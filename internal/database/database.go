@@ -64,14 +64,53 @@ func Connect(config *Config) (*gorm.DB, error) {
 
 // Migrate runs database migrations
 func Migrate(db *gorm.DB) error {
-	return db.AutoMigrate(
+	if err := dropDuplicateLogChunks(db); err != nil {
+		return err
+	}
+
+	err := db.AutoMigrate(
 		&models.Template{},
 		&models.TaskInstance{},
 		&models.Reminder{},
 		&models.ExecutionLog{},
 		&models.ClusterAgent{},
+		&models.RevokedCertificate{},
+		&models.EnrollmentToken{},
 		&models.User{},
+		&models.Calendar{},
+		&models.CalendarWindow{},
+		&models.Workflow{},
+		&models.WorkflowStep{},
+		&models.WorkflowRun{},
+		&models.WorkflowStepRun{},
+		&models.ReminderPolicy{},
+		&models.ChatIdentity{},
+		&models.ChatLinkToken{},
+		&models.ChatRoute{},
+		&models.MessageTemplate{},
+		&models.OutboxMessage{},
 	)
+	if err != nil {
+		return err
+	}
+
+	return installScheduleTriggers(db)
+}
+
+// dropDuplicateLogChunks deletes log chunks stored more than once for the same task, stream and
+// sequence, keeping the first, so the unique index on them can be created
+func dropDuplicateLogChunks(db *gorm.DB) error {
+	migrator := db.Migrator()
+	if !migrator.HasTable(&models.ExecutionLog{}) || migrator.HasIndex(&models.ExecutionLog{}, "idx_execution_logs_sequence") {
+		return nil
+	}
+
+	err := db.Exec(`DELETE FROM execution_logs a USING execution_logs b
+		WHERE a.task_id = b.task_id AND a.stream = b.stream AND a.sequence = b.sequence AND a.id > b.id`).Error
+	if err != nil {
+		return fmt.Errorf("failed to drop duplicate log chunks: %w", err)
+	}
+	return nil
 }
 
 // getEnv gets an environment variable or returns a default value
@@ -0,0 +1,109 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/BogdanDolia/ops-butler/internal/models"
+	"gorm.io/gorm"
+)
+
+// GormEnrollmentTokenRepository is a GORM implementation of EnrollmentTokenRepository
+type GormEnrollmentTokenRepository struct {
+	*GormRepository
+}
+
+// NewEnrollmentTokenRepository creates a new GormEnrollmentTokenRepository
+func NewEnrollmentTokenRepository(db *gorm.DB) EnrollmentTokenRepository {
+	return &GormEnrollmentTokenRepository{
+		GormRepository: NewGormRepository(db),
+	}
+}
+
+// Create stores an enrollment token
+func (r *GormEnrollmentTokenRepository) Create(ctx context.Context, token *models.EnrollmentToken) error {
+	if token == nil || token.TokenHash == "" || token.Name == "" {
+		return ErrValidation
+	}
+
+	result := r.db.WithContext(ctx).Create(token)
+	if result.Error != nil {
+		return result.Error
+	}
+
+	return nil
+}
+
+// GetByHash gets an enrollment token by the hash of its secret
+func (r *GormEnrollmentTokenRepository) GetByHash(ctx context.Context, tokenHash string) (*models.EnrollmentToken, error) {
+	if tokenHash == "" {
+		return nil, ErrValidation
+	}
+
+	var token models.EnrollmentToken
+	result := r.db.WithContext(ctx).Where("token_hash = ?", tokenHash).First(&token)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, ErrNotFound
+		}
+		return nil, result.Error
+	}
+
+	return &token, nil
+}
+
+// List lists enrollment tokens, newest first, with pagination
+func (r *GormEnrollmentTokenRepository) List(ctx context.Context, offset, limit int) ([]*models.EnrollmentToken, error) {
+	if limit <= 0 {
+		limit = 10 // Default limit
+	}
+	if offset < 0 {
+		offset = 0
+	}
+
+	var tokens []*models.EnrollmentToken
+	result := r.db.WithContext(ctx).Order("id DESC").Offset(offset).Limit(limit).Find(&tokens)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	return tokens, nil
+}
+
+// Consume marks an unused, unexpired enrollment token as used. A token can be consumed only once;
+// ErrNotFound is returned for unknown, used and expired tokens.
+func (r *GormEnrollmentTokenRepository) Consume(ctx context.Context, id uint, now time.Time) error {
+	if id == 0 {
+		return ErrInvalidID
+	}
+
+	result := r.db.WithContext(ctx).Model(&models.EnrollmentToken{}).
+		Where("id = ? AND used_at IS NULL AND expires_at > ?", id, now).
+		Update("used_at", now)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+// Delete deletes an enrollment token by ID, revoking it if it wasn't used yet
+func (r *GormEnrollmentTokenRepository) Delete(ctx context.Context, id uint) error {
+	if id == 0 {
+		return ErrInvalidID
+	}
+
+	result := r.db.WithContext(ctx).Delete(&models.EnrollmentToken{}, id)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
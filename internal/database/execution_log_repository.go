@@ -0,0 +1,129 @@
+package database
+
+import (
+	"context"
+	"errors"
+
+	"github.com/BogdanDolia/ops-butler/internal/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// GormExecutionLogRepository is a GORM implementation of ExecutionLogRepository
+type GormExecutionLogRepository struct {
+	*GormRepository
+}
+
+// NewExecutionLogRepository creates a new GormExecutionLogRepository
+func NewExecutionLogRepository(db *gorm.DB) ExecutionLogRepository {
+	return &GormExecutionLogRepository{
+		GormRepository: NewGormRepository(db),
+	}
+}
+
+// Create creates a new execution log chunk. A chunk already stored for the same task, stream and
+// sequence, e.g. by another server the agent was connected to, is left as it is.
+func (r *GormExecutionLogRepository) Create(ctx context.Context, log *models.ExecutionLog) error {
+	if log == nil || log.TaskID == 0 {
+		return ErrValidation
+	}
+
+	result := r.db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "task_id"}, {Name: "stream"}, {Name: "sequence"}},
+		DoNothing: true,
+	}).Create(log)
+	if result.Error != nil {
+		return result.Error
+	}
+
+	return nil
+}
+
+// GetByID gets an execution log chunk by ID
+func (r *GormExecutionLogRepository) GetByID(ctx context.Context, id uint) (*models.ExecutionLog, error) {
+	if id == 0 {
+		return nil, ErrInvalidID
+	}
+
+	var log models.ExecutionLog
+	result := r.db.WithContext(ctx).First(&log, id)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, ErrNotFound
+		}
+		return nil, result.Error
+	}
+
+	return &log, nil
+}
+
+// ListByTaskID lists the log chunks of a task in sequence order with pagination
+func (r *GormExecutionLogRepository) ListByTaskID(ctx context.Context, taskID uint, offset, limit int) ([]*models.ExecutionLog, error) {
+	if taskID == 0 {
+		return nil, ErrInvalidID
+	}
+	if limit <= 0 {
+		limit = 10 // Default limit
+	}
+	if offset < 0 {
+		offset = 0
+	}
+
+	var logs []*models.ExecutionLog
+	result := r.db.WithContext(ctx).
+		Where("task_id = ?", taskID).
+		Order("sequence").
+		Offset(offset).
+		Limit(limit).
+		Find(&logs)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	return logs, nil
+}
+
+// ListByAgentID lists the log chunks produced by an agent with pagination
+func (r *GormExecutionLogRepository) ListByAgentID(ctx context.Context, agentID uint, offset, limit int) ([]*models.ExecutionLog, error) {
+	if agentID == 0 {
+		return nil, ErrInvalidID
+	}
+	if limit <= 0 {
+		limit = 10 // Default limit
+	}
+	if offset < 0 {
+		offset = 0
+	}
+
+	var logs []*models.ExecutionLog
+	result := r.db.WithContext(ctx).
+		Where("agent_id = ?", agentID).
+		Order("timestamp").
+		Offset(offset).
+		Limit(limit).
+		Find(&logs)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	return logs, nil
+}
+
+// LastSequence returns the highest sequence number stored for a task, or 0 if it has no logs
+func (r *GormExecutionLogRepository) LastSequence(ctx context.Context, taskID uint) (int, error) {
+	if taskID == 0 {
+		return 0, ErrInvalidID
+	}
+
+	var last int
+	result := r.db.WithContext(ctx).
+		Model(&models.ExecutionLog{}).
+		Where("task_id = ?", taskID).
+		Select("COALESCE(MAX(sequence), 0)").
+		Scan(&last)
+	if result.Error != nil {
+		return 0, result.Error
+	}
+
+	return last, nil
+}
@@ -0,0 +1,114 @@
+package database
+
+import (
+	"context"
+	"errors"
+
+	"github.com/BogdanDolia/ops-butler/internal/models"
+	"gorm.io/gorm"
+)
+
+// GormMessageTemplateRepository is a GORM implementation of MessageTemplateRepository
+type GormMessageTemplateRepository struct {
+	*GormRepository
+}
+
+// NewMessageTemplateRepository creates a new GormMessageTemplateRepository
+func NewMessageTemplateRepository(db *gorm.DB) MessageTemplateRepository {
+	return &GormMessageTemplateRepository{
+		GormRepository: NewGormRepository(db),
+	}
+}
+
+// Create creates a new message template
+func (r *GormMessageTemplateRepository) Create(ctx context.Context, message *models.MessageTemplate) error {
+	if message == nil || message.Name == "" {
+		return ErrValidation
+	}
+
+	result := r.db.WithContext(ctx).Create(message)
+	if result.Error != nil {
+		return result.Error
+	}
+
+	return nil
+}
+
+// GetByID gets a message template by ID
+func (r *GormMessageTemplateRepository) GetByID(ctx context.Context, id uint) (*models.MessageTemplate, error) {
+	if id == 0 {
+		return nil, ErrInvalidID
+	}
+
+	var message models.MessageTemplate
+	result := r.db.WithContext(ctx).First(&message, id)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, ErrNotFound
+		}
+		return nil, result.Error
+	}
+
+	return &message, nil
+}
+
+// List lists message templates with pagination
+func (r *GormMessageTemplateRepository) List(ctx context.Context, offset, limit int) ([]*models.MessageTemplate, error) {
+	if limit <= 0 {
+		limit = 10 // Default limit
+	}
+	if offset < 0 {
+		offset = 0
+	}
+
+	var messages []*models.MessageTemplate
+	result := r.db.WithContext(ctx).Offset(offset).Limit(limit).Find(&messages)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	return messages, nil
+}
+
+// Update updates a message template
+func (r *GormMessageTemplateRepository) Update(ctx context.Context, message *models.MessageTemplate) error {
+	if message == nil || message.ID == 0 {
+		return ErrInvalidID
+	}
+
+	result := r.db.WithContext(ctx).Save(message)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+// Delete deletes a message template by ID; routes that used it fall back to the built-in message
+func (r *GormMessageTemplateRepository) Delete(ctx context.Context, id uint) error {
+	if id == 0 {
+		return ErrInvalidID
+	}
+
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		for _, column := range []string{"reminder_message_id", "completion_message_id", "failure_message_id"} {
+			if err := tx.Model(&models.ChatRoute{}).Where(column+" = ?", id).
+				Update(column, nil).Error; err != nil {
+				return err
+			}
+		}
+
+		result := tx.Delete(&models.MessageTemplate{}, id)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrNotFound
+		}
+
+		return nil
+	})
+}
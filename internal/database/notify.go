@@ -0,0 +1,143 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5/stdlib"
+	"gorm.io/gorm"
+)
+
+// ScheduleChannel is the Postgres notification channel for changes to task and reminder due times
+const ScheduleChannel = "schedule_changes"
+
+// Tables reported in schedule change notifications
+const (
+	ScheduleTableTasks     = "task_instances"
+	ScheduleTableReminders = "reminders"
+)
+
+// scheduleTriggerSQL creates a trigger that notifies ScheduleChannel whenever a task or reminder
+// changes. The payload carries the time the row next needs attention (null if never): the due
+// time of pending tasks, the chat time of pending reminders and the next policy action of
+// delivered ones.
+const scheduleTriggerSQL = `
+CREATE OR REPLACE FUNCTION notify_schedule_change() RETURNS trigger AS $$
+DECLARE
+	rec record;
+	due timestamptz;
+BEGIN
+	IF TG_OP = 'DELETE' THEN
+		rec := OLD;
+	ELSE
+		rec := NEW;
+	END IF;
+
+	IF TG_OP <> 'DELETE' AND rec.deleted_at IS NULL THEN
+		IF TG_TABLE_NAME = 'task_instances' THEN
+			IF rec.state = 'pending' THEN
+				due := rec.due_at;
+			END IF;
+		ELSIF rec.state = 'pending' THEN
+			due := rec.chat_at;
+		ELSIF rec.state = 'delivered' THEN
+			due := rec.next_action_at;
+		END IF;
+	END IF;
+
+	PERFORM pg_notify('schedule_changes', json_build_object(
+		'table', TG_TABLE_NAME,
+		'id', rec.id,
+		'at', extract(epoch FROM due))::text);
+	RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS schedule_change ON task_instances;
+CREATE TRIGGER schedule_change AFTER INSERT OR UPDATE OR DELETE ON task_instances
+	FOR EACH ROW EXECUTE FUNCTION notify_schedule_change();
+
+DROP TRIGGER IF EXISTS schedule_change ON reminders;
+CREATE TRIGGER schedule_change AFTER INSERT OR UPDATE OR DELETE ON reminders
+	FOR EACH ROW EXECUTE FUNCTION notify_schedule_change();
+`
+
+// ScheduleChange is a change to the due time of a task or reminder
+type ScheduleChange struct {
+	Table string
+	ID    uint
+	At    *time.Time // nil if the row no longer needs scheduling
+}
+
+// scheduleNotification is the JSON payload sent by the schedule trigger
+type scheduleNotification struct {
+	Table string   `json:"table"`
+	ID    uint     `json:"id"`
+	At    *float64 `json:"at"`
+}
+
+// installScheduleTriggers creates the triggers that publish schedule changes
+func installScheduleTriggers(db *gorm.DB) error {
+	if err := db.Exec(scheduleTriggerSQL).Error; err != nil {
+		return fmt.Errorf("failed to install schedule triggers: %w", err)
+	}
+	return nil
+}
+
+// ListenScheduleChanges listens on ScheduleChannel on a dedicated connection and calls handle for
+// every change. ready is called once the connection is listening. It blocks until ctx is done or
+// the connection fails; notifications sent while nobody listens are lost, so callers should
+// re-read due rows after ready.
+func ListenScheduleChanges(ctx context.Context, db *gorm.DB, ready func(), handle func(ScheduleChange)) error {
+	sqlDB, err := db.DB()
+	if err != nil {
+		return fmt.Errorf("failed to get database handle: %w", err)
+	}
+
+	conn, err := sqlDB.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get connection: %w", err)
+	}
+	defer conn.Close()
+
+	return conn.Raw(func(driverConn any) error {
+		stdConn, ok := driverConn.(*stdlib.Conn)
+		if !ok {
+			return fmt.Errorf("listening for notifications requires the pgx driver, got %T", driverConn)
+		}
+		pgConn := stdConn.Conn()
+
+		if _, err := pgConn.Exec(ctx, "LISTEN "+ScheduleChannel); err != nil {
+			return fmt.Errorf("failed to listen on %s: %w", ScheduleChannel, err)
+		}
+		defer func() {
+			// The connection goes back to the pool; stop delivering notifications to it
+			unlistenCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			_, _ = pgConn.Exec(unlistenCtx, "UNLISTEN "+ScheduleChannel)
+		}()
+
+		ready()
+
+		for {
+			n, err := pgConn.WaitForNotification(ctx)
+			if err != nil {
+				return fmt.Errorf("failed to wait for notification: %w", err)
+			}
+
+			var payload scheduleNotification
+			if err := json.Unmarshal([]byte(n.Payload), &payload); err != nil {
+				continue
+			}
+
+			change := ScheduleChange{Table: payload.Table, ID: payload.ID}
+			if payload.At != nil {
+				at := time.UnixMicro(int64(*payload.At * 1e6))
+				change.At = &at
+			}
+			handle(change)
+		}
+	})
+}
@@ -0,0 +1,221 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/BogdanDolia/ops-butler/internal/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// GormOutboxRepository is a GORM implementation of OutboxRepository
+type GormOutboxRepository struct {
+	*GormRepository
+}
+
+// NewOutboxRepository creates a new GormOutboxRepository
+func NewOutboxRepository(db *gorm.DB) OutboxRepository {
+	return &GormOutboxRepository{
+		GormRepository: NewGormRepository(db),
+	}
+}
+
+// Enqueue saves updates, the records whose state change the messages announce, and adds the
+// messages to the outbox in one transaction. Messages whose idempotency key was enqueued before
+// are skipped, so a state change that is retried doesn't post twice.
+func (r *GormOutboxRepository) Enqueue(ctx context.Context, messages []*models.OutboxMessage, updates ...interface{}) error {
+	for _, message := range messages {
+		if message == nil || message.IdempotencyKey == "" || message.Platform == "" {
+			return ErrValidation
+		}
+	}
+
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := saveAll(tx, updates); err != nil {
+			return err
+		}
+
+		for _, message := range messages {
+			message.State = models.OutboxStatePending
+			if message.NextAttemptAt.IsZero() {
+				message.NextAttemptAt = time.Now()
+			}
+			if err := tx.Clauses(clause.OnConflict{
+				Columns:   []clause.Column{{Name: "idempotency_key"}},
+				DoNothing: true,
+			}).Create(message).Error; err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// GetByID gets an outbox message by ID
+func (r *GormOutboxRepository) GetByID(ctx context.Context, id uint) (*models.OutboxMessage, error) {
+	if id == 0 {
+		return nil, ErrInvalidID
+	}
+
+	var message models.OutboxMessage
+	result := r.db.WithContext(ctx).First(&message, id)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, ErrNotFound
+		}
+		return nil, result.Error
+	}
+
+	return &message, nil
+}
+
+// ListByState lists outbox messages in a state, newest first, with pagination
+func (r *GormOutboxRepository) ListByState(ctx context.Context, state models.OutboxState, offset, limit int) ([]*models.OutboxMessage, error) {
+	if limit <= 0 {
+		limit = 10 // Default limit
+	}
+	if offset < 0 {
+		offset = 0
+	}
+
+	var messages []*models.OutboxMessage
+	result := r.db.WithContext(ctx).
+		Where("state = ?", state).
+		Order("id DESC").
+		Offset(offset).
+		Limit(limit).
+		Find(&messages)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	return messages, nil
+}
+
+// ClaimDue returns pending messages whose next attempt is due, oldest first, marks them sending and
+// counts the attempt. Claimed messages aren't due again until lease has passed, so concurrent
+// schedulers don't deliver the same message. Messages still sending once their lease has passed
+// are claimed again; their delivery was interrupted and may have posted. The messages returned
+// keep the State they were claimed in, so the caller can tell the two apart.
+func (r *GormOutboxRepository) ClaimDue(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]*models.OutboxMessage, error) {
+	if limit <= 0 {
+		limit = 10 // Default limit
+	}
+
+	var messages []*models.OutboxMessage
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("state IN ? AND next_attempt_at <= ?", []models.OutboxState{models.OutboxStatePending, models.OutboxStateSending}, now).
+			Order("next_attempt_at, id").
+			Limit(limit).
+			Find(&messages).Error; err != nil {
+			return err
+		}
+		if len(messages) == 0 {
+			return nil
+		}
+
+		ids := make([]uint, 0, len(messages))
+		for _, message := range messages {
+			message.Attempts++
+			message.NextAttemptAt = now.Add(lease)
+			ids = append(ids, message.ID)
+		}
+		return tx.Model(&models.OutboxMessage{}).Where("id IN ?", ids).Updates(map[string]interface{}{
+			"state":           models.OutboxStateSending,
+			"attempts":        gorm.Expr("attempts + 1"),
+			"next_attempt_at": now.Add(lease),
+		}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return messages, nil
+}
+
+// Complete saves a delivered or skipped message together with updates, the records that depend on
+// the delivery, in one transaction
+func (r *GormOutboxRepository) Complete(ctx context.Context, message *models.OutboxMessage, updates ...interface{}) error {
+	if message == nil || message.ID == 0 {
+		return ErrInvalidID
+	}
+
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := saveAll(tx, updates); err != nil {
+			return err
+		}
+
+		result := tx.Save(message)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrNotFound
+		}
+
+		return nil
+	})
+}
+
+// Update updates an outbox message
+func (r *GormOutboxRepository) Update(ctx context.Context, message *models.OutboxMessage) error {
+	if message == nil || message.ID == 0 {
+		return ErrInvalidID
+	}
+
+	result := r.db.WithContext(ctx).Save(message)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+// Retry puts a dead message back in the outbox for immediate delivery with a fresh attempt count
+func (r *GormOutboxRepository) Retry(ctx context.Context, id uint, now time.Time) (*models.OutboxMessage, error) {
+	message, err := r.GetByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if message.State != models.OutboxStateDead {
+		return nil, fmt.Errorf("%w: outbox message %d is %s, not dead", ErrValidation, id, message.State)
+	}
+
+	result := r.db.WithContext(ctx).Model(&models.OutboxMessage{}).
+		Where("id = ? AND state = ?", id, models.OutboxStateDead).
+		Updates(map[string]interface{}{
+			"state":           models.OutboxStatePending,
+			"attempts":        0,
+			"next_attempt_at": now,
+		})
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, ErrNotFound
+	}
+
+	return r.GetByID(ctx, id)
+}
+
+// saveAll saves each record in a transaction; records without an ID are created
+func saveAll(tx *gorm.DB, records []interface{}) error {
+	for _, record := range records {
+		result := tx.Save(record)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrNotFound
+		}
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+package database
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+
+	"github.com/BogdanDolia/ops-butler/internal/models"
+)
+
+// newTestOutbox returns an outbox repository over an empty outbox in the PostgreSQL database of
+// TEST_DATABASE_DSN, skipping the test if it isn't set
+func newTestOutbox(t *testing.T) OutboxRepository {
+	t.Helper()
+	dsn := os.Getenv("TEST_DATABASE_DSN")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_DSN is not set")
+	}
+
+	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Migrate(db); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Unscoped().Where("1 = 1").Delete(&models.OutboxMessage{}).Error; err != nil {
+		t.Fatal(err)
+	}
+	return NewOutboxRepository(db)
+}
+
+func TestOutboxEnqueueDeduplicates(t *testing.T) {
+	outbox := newTestOutbox(t)
+	ctx := context.Background()
+
+	for _, text := range []string{"first", "again"} {
+		message := &models.OutboxMessage{IdempotencyKey: "reminder:1:1", Kind: models.OutboxKindMessage, Platform: "slack", Text: text}
+		if err := outbox.Enqueue(ctx, []*models.OutboxMessage{message}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	messages, err := outbox.ListByState(ctx, models.OutboxStatePending, 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 1 || messages[0].Text != "first" {
+		t.Fatalf("outbox holds %d messages, want only the first enqueued", len(messages))
+	}
+}
+
+func TestOutboxClaimDue(t *testing.T) {
+	outbox := newTestOutbox(t)
+	ctx := context.Background()
+	now := time.Now()
+	const lease = time.Minute
+
+	message := &models.OutboxMessage{IdempotencyKey: "message:1", Kind: models.OutboxKindMessage, Platform: "slack", NextAttemptAt: now.Add(-time.Second)}
+	later := &models.OutboxMessage{IdempotencyKey: "message:2", Kind: models.OutboxKindMessage, Platform: "slack", NextAttemptAt: now.Add(time.Hour)}
+	if err := outbox.Enqueue(ctx, []*models.OutboxMessage{message, later}); err != nil {
+		t.Fatal(err)
+	}
+
+	claimed, err := outbox.ClaimDue(ctx, now, lease, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(claimed) != 1 || claimed[0].ID != message.ID {
+		t.Fatalf("claimed %d messages, want only the due one", len(claimed))
+	}
+	if claimed[0].State != models.OutboxStatePending || claimed[0].Attempts != 1 {
+		t.Errorf("claimed message is %s after %d attempts, want pending, the state it was claimed in, after 1", claimed[0].State, claimed[0].Attempts)
+	}
+
+	// Leased to the first claim, then claimed again as interrupted
+	if again, err := outbox.ClaimDue(ctx, now, lease, 10); err != nil || len(again) != 0 {
+		t.Fatalf("ClaimDue() within the lease = %d messages, %v, want none", len(again), err)
+	}
+	again, err := outbox.ClaimDue(ctx, now.Add(lease), lease, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(again) != 1 || again[0].State != models.OutboxStateSending || again[0].Attempts != 2 {
+		t.Fatalf("ClaimDue() after the lease = %+v, want the message still sending, claimed a second time", again)
+	}
+
+	stored, err := outbox.GetByID(ctx, message.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.State != models.OutboxStateSending || stored.Attempts != 2 {
+		t.Errorf("stored message is %s after %d attempts, want sending after 2", stored.State, stored.Attempts)
+	}
+
+	// A dead message isn't claimed until it is retried
+	stored.State = models.OutboxStateDead
+	if err := outbox.Update(ctx, stored); err != nil {
+		t.Fatal(err)
+	}
+	if dead, err := outbox.ClaimDue(ctx, now.Add(2*lease), lease, 10); err != nil || len(dead) != 0 {
+		t.Fatalf("ClaimDue() = %d messages, %v, want the dead message left alone", len(dead), err)
+	}
+	if _, err := outbox.Retry(ctx, message.ID, now.Add(2*lease)); err != nil {
+		t.Fatal(err)
+	}
+	retried, err := outbox.ClaimDue(ctx, now.Add(2*lease), lease, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(retried) != 1 || retried[0].State != models.OutboxStatePending || retried[0].Attempts != 1 {
+		t.Errorf("ClaimDue() after Retry = %+v, want the message pending with a fresh attempt count", retried)
+	}
+}
@@ -0,0 +1,132 @@
+package database
+
+import (
+	"context"
+	"errors"
+
+	"github.com/BogdanDolia/ops-butler/internal/models"
+	"gorm.io/gorm"
+)
+
+// GormReminderPolicyRepository is a GORM implementation of ReminderPolicyRepository
+type GormReminderPolicyRepository struct {
+	*GormRepository
+}
+
+// NewReminderPolicyRepository creates a new GormReminderPolicyRepository
+func NewReminderPolicyRepository(db *gorm.DB) ReminderPolicyRepository {
+	return &GormReminderPolicyRepository{
+		GormRepository: NewGormRepository(db),
+	}
+}
+
+// Create creates a new reminder policy and attaches its templates
+func (r *GormReminderPolicyRepository) Create(ctx context.Context, policy *models.ReminderPolicy) error {
+	if policy == nil || policy.Name == "" {
+		return ErrValidation
+	}
+
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Omit("Templates").Create(policy).Error; err != nil {
+			return err
+		}
+		return attachTemplates(tx, policy)
+	})
+}
+
+// GetByID gets a reminder policy by ID
+func (r *GormReminderPolicyRepository) GetByID(ctx context.Context, id uint) (*models.ReminderPolicy, error) {
+	if id == 0 {
+		return nil, ErrInvalidID
+	}
+
+	var policy models.ReminderPolicy
+	result := r.db.WithContext(ctx).Preload("Templates").First(&policy, id)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, ErrNotFound
+		}
+		return nil, result.Error
+	}
+
+	return &policy, nil
+}
+
+// List lists reminder policies with pagination
+func (r *GormReminderPolicyRepository) List(ctx context.Context, offset, limit int) ([]*models.ReminderPolicy, error) {
+	if limit <= 0 {
+		limit = 10 // Default limit
+	}
+	if offset < 0 {
+		offset = 0
+	}
+
+	var policies []*models.ReminderPolicy
+	result := r.db.WithContext(ctx).Preload("Templates").Offset(offset).Limit(limit).Find(&policies)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	return policies, nil
+}
+
+// Update updates a reminder policy, replacing the set of templates it is attached to
+func (r *GormReminderPolicyRepository) Update(ctx context.Context, policy *models.ReminderPolicy) error {
+	if policy == nil || policy.ID == 0 {
+		return ErrInvalidID
+	}
+
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Omit("Templates").Save(policy)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrNotFound
+		}
+
+		return attachTemplates(tx, policy)
+	})
+}
+
+// attachTemplates points exactly the policy's templates at the policy
+func attachTemplates(tx *gorm.DB, policy *models.ReminderPolicy) error {
+	if err := tx.Model(&models.Template{}).Where("reminder_policy_id = ?", policy.ID).
+		Update("reminder_policy_id", nil).Error; err != nil {
+		return err
+	}
+	if len(policy.Templates) == 0 {
+		return nil
+	}
+
+	ids := make([]uint, 0, len(policy.Templates))
+	for _, t := range policy.Templates {
+		ids = append(ids, t.ID)
+	}
+	return tx.Model(&models.Template{}).Where("id IN ?", ids).
+		Update("reminder_policy_id", policy.ID).Error
+}
+
+// Delete deletes a reminder policy by ID, detaching it from its templates
+func (r *GormReminderPolicyRepository) Delete(ctx context.Context, id uint) error {
+	if id == 0 {
+		return ErrInvalidID
+	}
+
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.Template{}).Where("reminder_policy_id = ?", id).
+			Update("reminder_policy_id", nil).Error; err != nil {
+			return err
+		}
+
+		result := tx.Delete(&models.ReminderPolicy{}, id)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrNotFound
+		}
+
+		return nil
+	})
+}
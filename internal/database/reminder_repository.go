@@ -3,6 +3,7 @@ package database
 import (
 	"context"
 	"errors"
+	"time"
 
 	"github.com/BogdanDolia/ops-butler/internal/models"
 	"gorm.io/gorm"
@@ -90,6 +91,31 @@ func (r *GormReminderRepository) ListPending(ctx context.Context, offset, limit
 	return reminders, nil
 }
 
+// ListDue lists pending reminders whose chat time has come and delivered reminders whose next
+// policy action (repeat, escalation or final action) is due, with pagination
+func (r *GormReminderRepository) ListDue(ctx context.Context, now time.Time, offset, limit int) ([]*models.Reminder, error) {
+	if limit <= 0 {
+		limit = 10 // Default limit
+	}
+	if offset < 0 {
+		offset = 0
+	}
+
+	var reminders []*models.Reminder
+	result := r.db.WithContext(ctx).
+		Where("(state = ? AND chat_at <= ?) OR (state = ? AND next_action_at <= ?)",
+			models.ReminderStatePending, now, models.ReminderStateDelivered, now).
+		Order("chat_at, id").
+		Offset(offset).
+		Limit(limit).
+		Find(&reminders)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	return reminders, nil
+}
+
 // Update updates a reminder
 func (r *GormReminderRepository) Update(ctx context.Context, reminder *models.Reminder) error {
 	if reminder == nil || reminder.ID == 0 {
@@ -3,6 +3,7 @@ package database
 import (
 	"context"
 	"errors"
+	"time"
 
 	"github.com/BogdanDolia/ops-butler/internal/models"
 	"gorm.io/gorm"
@@ -41,7 +42,12 @@ type TaskRepository interface {
 	List(ctx context.Context, offset, limit int) ([]*models.TaskInstance, error)
 	ListByTemplateID(ctx context.Context, templateID uint, offset, limit int) ([]*models.TaskInstance, error)
 	ListByState(ctx context.Context, state models.TaskState, offset, limit int) ([]*models.TaskInstance, error)
-	ListDue(ctx context.Context, offset, limit int) ([]*models.TaskInstance, error)
+	ListDue(ctx context.Context, now time.Time, offset, limit int) ([]*models.TaskInstance, error)
+	ListUnnotified(ctx context.Context, since time.Time, limit int) ([]*models.TaskInstance, error)
+	ListByAgentAndState(ctx context.Context, agentID uint, state models.TaskState) ([]*models.TaskInstance, error)
+	ListReady(ctx context.Context, offset, limit int) ([]*models.TaskInstance, error)
+	Claim(ctx context.Context, task *models.TaskInstance, agentID uint, now time.Time) error
+	Release(ctx context.Context, task *models.TaskInstance) error
 	Update(ctx context.Context, task *models.TaskInstance) error
 	Delete(ctx context.Context, id uint) error
 }
@@ -53,10 +59,21 @@ type ReminderRepository interface {
 	GetByID(ctx context.Context, id uint) (*models.Reminder, error)
 	ListByTaskID(ctx context.Context, taskID uint) ([]*models.Reminder, error)
 	ListPending(ctx context.Context, offset, limit int) ([]*models.Reminder, error)
+	ListDue(ctx context.Context, now time.Time, offset, limit int) ([]*models.Reminder, error)
 	Update(ctx context.Context, reminder *models.Reminder) error
 	Delete(ctx context.Context, id uint) error
 }
 
+// ReminderPolicyRepository is the interface for reminder policy operations
+type ReminderPolicyRepository interface {
+	Repository
+	Create(ctx context.Context, policy *models.ReminderPolicy) error
+	GetByID(ctx context.Context, id uint) (*models.ReminderPolicy, error)
+	List(ctx context.Context, offset, limit int) ([]*models.ReminderPolicy, error)
+	Update(ctx context.Context, policy *models.ReminderPolicy) error
+	Delete(ctx context.Context, id uint) error
+}
+
 // ExecutionLogRepository is the interface for execution log operations
 type ExecutionLogRepository interface {
 	Repository
@@ -64,6 +81,7 @@ type ExecutionLogRepository interface {
 	GetByID(ctx context.Context, id uint) (*models.ExecutionLog, error)
 	ListByTaskID(ctx context.Context, taskID uint, offset, limit int) ([]*models.ExecutionLog, error)
 	ListByAgentID(ctx context.Context, agentID uint, offset, limit int) ([]*models.ExecutionLog, error)
+	LastSequence(ctx context.Context, taskID uint) (int, error)
 }
 
 // AgentRepository is the interface for agent operations
@@ -74,7 +92,53 @@ type AgentRepository interface {
 	GetByName(ctx context.Context, name string) (*models.ClusterAgent, error)
 	List(ctx context.Context, offset, limit int) ([]*models.ClusterAgent, error)
 	Update(ctx context.Context, agent *models.ClusterAgent) error
+	AddQueuedTasks(ctx context.Context, id uint, n int) error
 	Delete(ctx context.Context, id uint) error
+	RevokeCertificate(ctx context.Context, revocation *models.RevokedCertificate) error
+	IsCertificateRevoked(ctx context.Context, fingerprint string) (bool, error)
+}
+
+// EnrollmentTokenRepository is the interface for agent enrollment token operations
+type EnrollmentTokenRepository interface {
+	Repository
+	Create(ctx context.Context, token *models.EnrollmentToken) error
+	GetByHash(ctx context.Context, tokenHash string) (*models.EnrollmentToken, error)
+	List(ctx context.Context, offset, limit int) ([]*models.EnrollmentToken, error)
+	Consume(ctx context.Context, id uint, now time.Time) error
+	Delete(ctx context.Context, id uint) error
+}
+
+// CalendarRepository is the interface for maintenance calendar operations
+type CalendarRepository interface {
+	Repository
+	Create(ctx context.Context, calendar *models.Calendar) error
+	GetByID(ctx context.Context, id uint) (*models.Calendar, error)
+	List(ctx context.Context, offset, limit int) ([]*models.Calendar, error)
+	ListEnabled(ctx context.Context) ([]*models.Calendar, error)
+	Update(ctx context.Context, calendar *models.Calendar) error
+	Delete(ctx context.Context, id uint) error
+}
+
+// WorkflowRepository is the interface for workflow definition operations
+type WorkflowRepository interface {
+	Repository
+	Create(ctx context.Context, workflow *models.Workflow) error
+	GetByID(ctx context.Context, id uint) (*models.Workflow, error)
+	GetByName(ctx context.Context, name string) (*models.Workflow, error)
+	List(ctx context.Context, offset, limit int) ([]*models.Workflow, error)
+	Update(ctx context.Context, workflow *models.Workflow) error
+	Delete(ctx context.Context, id uint) error
+}
+
+// WorkflowRunRepository is the interface for workflow run operations
+type WorkflowRunRepository interface {
+	Repository
+	Create(ctx context.Context, run *models.WorkflowRun) error
+	GetByID(ctx context.Context, id uint) (*models.WorkflowRun, error)
+	ListByWorkflowID(ctx context.Context, workflowID uint, offset, limit int) ([]*models.WorkflowRun, error)
+	ListByState(ctx context.Context, state models.WorkflowRunState, offset, limit int) ([]*models.WorkflowRun, error)
+	Update(ctx context.Context, run *models.WorkflowRun) error
+	StartStep(ctx context.Context, stepRun *models.WorkflowStepRun, task *models.TaskInstance, now time.Time) error
 }
 
 // UserRepository is the interface for user operations
@@ -89,6 +153,51 @@ type UserRepository interface {
 	Delete(ctx context.Context, id uint) error
 }
 
+// ChatIdentityRepository is the interface for chat identity and link token operations
+type ChatIdentityRepository interface {
+	Repository
+	GetByID(ctx context.Context, id uint) (*models.ChatIdentity, error)
+	GetByChatUser(ctx context.Context, provider, workspace, chatUserID string) (*models.ChatIdentity, error)
+	ListByUserID(ctx context.Context, userID uint) ([]*models.ChatIdentity, error)
+	Link(ctx context.Context, identity *models.ChatIdentity) error
+	Delete(ctx context.Context, id uint) error
+	CreateLinkToken(ctx context.Context, token *models.ChatLinkToken) error
+	ConsumeLinkToken(ctx context.Context, tokenHash string, now time.Time) (*models.ChatLinkToken, error)
+}
+
+// ChatRouteRepository is the interface for chat route operations
+type ChatRouteRepository interface {
+	Repository
+	Create(ctx context.Context, route *models.ChatRoute) error
+	GetByID(ctx context.Context, id uint) (*models.ChatRoute, error)
+	List(ctx context.Context, offset, limit int) ([]*models.ChatRoute, error)
+	ListEnabled(ctx context.Context) ([]*models.ChatRoute, error)
+	Update(ctx context.Context, route *models.ChatRoute) error
+	Delete(ctx context.Context, id uint) error
+}
+
+// MessageTemplateRepository is the interface for message template operations
+type MessageTemplateRepository interface {
+	Repository
+	Create(ctx context.Context, message *models.MessageTemplate) error
+	GetByID(ctx context.Context, id uint) (*models.MessageTemplate, error)
+	List(ctx context.Context, offset, limit int) ([]*models.MessageTemplate, error)
+	Update(ctx context.Context, message *models.MessageTemplate) error
+	Delete(ctx context.Context, id uint) error
+}
+
+// OutboxRepository is the interface for chat outbox operations
+type OutboxRepository interface {
+	Repository
+	Enqueue(ctx context.Context, messages []*models.OutboxMessage, updates ...interface{}) error
+	GetByID(ctx context.Context, id uint) (*models.OutboxMessage, error)
+	ListByState(ctx context.Context, state models.OutboxState, offset, limit int) ([]*models.OutboxMessage, error)
+	ClaimDue(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]*models.OutboxMessage, error)
+	Complete(ctx context.Context, message *models.OutboxMessage, updates ...interface{}) error
+	Update(ctx context.Context, message *models.OutboxMessage) error
+	Retry(ctx context.Context, id uint, now time.Time) (*models.OutboxMessage, error)
+}
+
 // GormRepository is a base repository implementation using GORM
 type GormRepository struct {
 	db *gorm.DB
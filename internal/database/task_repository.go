@@ -113,8 +113,8 @@ func (r *GormTaskRepository) ListByState(ctx context.Context, state models.TaskS
 	return tasks, nil
 }
 
-// ListDue lists tasks that are due with pagination
-func (r *GormTaskRepository) ListDue(ctx context.Context, offset, limit int) ([]*models.TaskInstance, error) {
+// ListDue lists pending tasks due at or before now with pagination
+func (r *GormTaskRepository) ListDue(ctx context.Context, now time.Time, offset, limit int) ([]*models.TaskInstance, error) {
 	if limit <= 0 {
 		limit = 10 // Default limit
 	}
@@ -124,7 +124,8 @@ func (r *GormTaskRepository) ListDue(ctx context.Context, offset, limit int) ([]
 
 	var tasks []*models.TaskInstance
 	result := r.db.WithContext(ctx).
-		Where("state = ? AND due_at <= ?", models.TaskStatePending, time.Now()).
+		Where("state = ? AND due_at <= ?", models.TaskStatePending, now).
+		Order("id").
 		Offset(offset).
 		Limit(limit).
 		Find(&tasks)
@@ -135,6 +136,113 @@ func (r *GormTaskRepository) ListDue(ctx context.Context, offset, limit int) ([]
 	return tasks, nil
 }
 
+// ListReady lists pending tasks without a due time, which are ready for dispatch, oldest first
+func (r *GormTaskRepository) ListReady(ctx context.Context, offset, limit int) ([]*models.TaskInstance, error) {
+	if limit <= 0 {
+		limit = 10 // Default limit
+	}
+	if offset < 0 {
+		offset = 0
+	}
+
+	var tasks []*models.TaskInstance
+	result := r.db.WithContext(ctx).
+		Where("state = ? AND due_at IS NULL", models.TaskStatePending).
+		Order("id").
+		Offset(offset).
+		Limit(limit).
+		Find(&tasks)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	return tasks, nil
+}
+
+// Claim marks a ready task running on an agent. It fails with ErrNotFound if the task is no longer
+// ready, e.g. because another server dispatched it or it was cancelled meanwhile.
+func (r *GormTaskRepository) Claim(ctx context.Context, task *models.TaskInstance, agentID uint, now time.Time) error {
+	if task == nil || task.ID == 0 || agentID == 0 {
+		return ErrInvalidID
+	}
+
+	result := r.db.WithContext(ctx).Model(&models.TaskInstance{}).
+		Where("id = ? AND state = ? AND due_at IS NULL", task.ID, models.TaskStatePending).
+		Updates(map[string]interface{}{"state": models.TaskStateRunning, "agent_id": agentID, "started_at": now})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+
+	task.State = models.TaskStateRunning
+	task.AgentID = &agentID
+	task.StartedAt = &now
+	return nil
+}
+
+// Release makes a claimed task ready for dispatch again, e.g. when it couldn't be sent to its agent
+func (r *GormTaskRepository) Release(ctx context.Context, task *models.TaskInstance) error {
+	if task == nil || task.ID == 0 {
+		return ErrInvalidID
+	}
+
+	result := r.db.WithContext(ctx).Model(&models.TaskInstance{}).
+		Where("id = ? AND state = ?", task.ID, models.TaskStateRunning).
+		Updates(map[string]interface{}{"state": models.TaskStatePending, "agent_id": nil, "started_at": nil})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+
+	task.State = models.TaskStatePending
+	task.AgentID = nil
+	task.StartedAt = nil
+	return nil
+}
+
+// ListUnnotified lists tasks with a chat thread that finished after since and whose outcome has not
+// been posted yet, oldest first
+func (r *GormTaskRepository) ListUnnotified(ctx context.Context, since time.Time, limit int) ([]*models.TaskInstance, error) {
+	if limit <= 0 {
+		limit = 10 // Default limit
+	}
+
+	var tasks []*models.TaskInstance
+	result := r.db.WithContext(ctx).
+		Where("state IN ? AND notified_at IS NULL AND chat_thread <> '' AND completed_at >= ?",
+			[]models.TaskState{models.TaskStateCompleted, models.TaskStateFailed}, since).
+		Order("completed_at").
+		Limit(limit).
+		Find(&tasks)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	return tasks, nil
+}
+
+// ListByAgentAndState lists all tasks of an agent in a state
+func (r *GormTaskRepository) ListByAgentAndState(ctx context.Context, agentID uint, state models.TaskState) ([]*models.TaskInstance, error) {
+	if agentID == 0 {
+		return nil, ErrInvalidID
+	}
+	if state == "" {
+		return nil, ErrValidation
+	}
+
+	var tasks []*models.TaskInstance
+	result := r.db.WithContext(ctx).Where("agent_id = ? AND state = ?", agentID, state).Order("id").Find(&tasks)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	return tasks, nil
+}
+
 // Update updates a task
 func (r *GormTaskRepository) Update(ctx context.Context, task *models.TaskInstance) error {
 	if task == nil || task.ID == 0 {
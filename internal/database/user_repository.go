@@ -0,0 +1,141 @@
+package database
+
+import (
+	"context"
+	"errors"
+
+	"github.com/BogdanDolia/ops-butler/internal/models"
+	"gorm.io/gorm"
+)
+
+// GormUserRepository is a GORM implementation of UserRepository
+type GormUserRepository struct {
+	*GormRepository
+}
+
+// NewUserRepository creates a new GormUserRepository
+func NewUserRepository(db *gorm.DB) UserRepository {
+	return &GormUserRepository{
+		GormRepository: NewGormRepository(db),
+	}
+}
+
+// Create creates a new user
+func (r *GormUserRepository) Create(ctx context.Context, user *models.User) error {
+	if user == nil {
+		return ErrValidation
+	}
+
+	result := r.db.WithContext(ctx).Create(user)
+	if result.Error != nil {
+		return result.Error
+	}
+
+	return nil
+}
+
+// GetByID gets a user by ID
+func (r *GormUserRepository) GetByID(ctx context.Context, id uint) (*models.User, error) {
+	if id == 0 {
+		return nil, ErrInvalidID
+	}
+
+	var user models.User
+	result := r.db.WithContext(ctx).First(&user, id)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, ErrNotFound
+		}
+		return nil, result.Error
+	}
+
+	return &user, nil
+}
+
+// GetByEmail gets a user by email
+func (r *GormUserRepository) GetByEmail(ctx context.Context, email string) (*models.User, error) {
+	if email == "" {
+		return nil, ErrValidation
+	}
+
+	var user models.User
+	result := r.db.WithContext(ctx).Where("email = ?", email).First(&user)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, ErrNotFound
+		}
+		return nil, result.Error
+	}
+
+	return &user, nil
+}
+
+// GetByExternalID gets a user by the ID assigned by its identity provider
+func (r *GormUserRepository) GetByExternalID(ctx context.Context, externalID string) (*models.User, error) {
+	if externalID == "" {
+		return nil, ErrValidation
+	}
+
+	var user models.User
+	result := r.db.WithContext(ctx).Where("external_id = ?", externalID).First(&user)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, ErrNotFound
+		}
+		return nil, result.Error
+	}
+
+	return &user, nil
+}
+
+// List lists users with pagination
+func (r *GormUserRepository) List(ctx context.Context, offset, limit int) ([]*models.User, error) {
+	if limit <= 0 {
+		limit = 10 // Default limit
+	}
+	if offset < 0 {
+		offset = 0
+	}
+
+	var users []*models.User
+	result := r.db.WithContext(ctx).Offset(offset).Limit(limit).Find(&users)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	return users, nil
+}
+
+// Update updates a user
+func (r *GormUserRepository) Update(ctx context.Context, user *models.User) error {
+	if user == nil || user.ID == 0 {
+		return ErrInvalidID
+	}
+
+	result := r.db.WithContext(ctx).Save(user)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+// Delete deletes a user by ID
+func (r *GormUserRepository) Delete(ctx context.Context, id uint) error {
+	if id == 0 {
+		return ErrInvalidID
+	}
+
+	result := r.db.WithContext(ctx).Delete(&models.User{}, id)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
@@ -0,0 +1,136 @@
+package database
+
+import (
+	"context"
+	"errors"
+
+	"github.com/BogdanDolia/ops-butler/internal/models"
+	"gorm.io/gorm"
+)
+
+// GormWorkflowRepository is a GORM implementation of WorkflowRepository
+type GormWorkflowRepository struct {
+	*GormRepository
+}
+
+// NewWorkflowRepository creates a new GormWorkflowRepository
+func NewWorkflowRepository(db *gorm.DB) WorkflowRepository {
+	return &GormWorkflowRepository{
+		GormRepository: NewGormRepository(db),
+	}
+}
+
+// Create creates a new workflow together with its steps
+func (r *GormWorkflowRepository) Create(ctx context.Context, workflow *models.Workflow) error {
+	if workflow == nil || workflow.Name == "" {
+		return ErrValidation
+	}
+
+	result := r.db.WithContext(ctx).Create(workflow)
+	if result.Error != nil {
+		return result.Error
+	}
+
+	return nil
+}
+
+// GetByID gets a workflow by ID
+func (r *GormWorkflowRepository) GetByID(ctx context.Context, id uint) (*models.Workflow, error) {
+	if id == 0 {
+		return nil, ErrInvalidID
+	}
+
+	var workflow models.Workflow
+	result := r.db.WithContext(ctx).Preload("Steps").First(&workflow, id)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, ErrNotFound
+		}
+		return nil, result.Error
+	}
+
+	return &workflow, nil
+}
+
+// GetByName gets a workflow by name
+func (r *GormWorkflowRepository) GetByName(ctx context.Context, name string) (*models.Workflow, error) {
+	if name == "" {
+		return nil, ErrValidation
+	}
+
+	var workflow models.Workflow
+	result := r.db.WithContext(ctx).Preload("Steps").Where("name = ?", name).First(&workflow)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, ErrNotFound
+		}
+		return nil, result.Error
+	}
+
+	return &workflow, nil
+}
+
+// List lists workflows with pagination
+func (r *GormWorkflowRepository) List(ctx context.Context, offset, limit int) ([]*models.Workflow, error) {
+	if limit <= 0 {
+		limit = 10 // Default limit
+	}
+	if offset < 0 {
+		offset = 0
+	}
+
+	var workflows []*models.Workflow
+	result := r.db.WithContext(ctx).Preload("Steps").Offset(offset).Limit(limit).Find(&workflows)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	return workflows, nil
+}
+
+// Update updates a workflow, replacing its steps
+func (r *GormWorkflowRepository) Update(ctx context.Context, workflow *models.Workflow) error {
+	if workflow == nil || workflow.ID == 0 {
+		return ErrInvalidID
+	}
+
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Omit("Steps").Save(workflow)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrNotFound
+		}
+
+		if err := tx.Where("workflow_id = ?", workflow.ID).Delete(&models.WorkflowStep{}).Error; err != nil {
+			return err
+		}
+		for i := range workflow.Steps {
+			workflow.Steps[i].ID = 0
+			workflow.Steps[i].WorkflowID = workflow.ID
+		}
+		if len(workflow.Steps) > 0 {
+			return tx.Create(&workflow.Steps).Error
+		}
+
+		return nil
+	})
+}
+
+// Delete deletes a workflow by ID
+func (r *GormWorkflowRepository) Delete(ctx context.Context, id uint) error {
+	if id == 0 {
+		return ErrInvalidID
+	}
+
+	result := r.db.WithContext(ctx).Delete(&models.Workflow{}, id)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
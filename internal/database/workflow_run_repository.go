@@ -0,0 +1,168 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/BogdanDolia/ops-butler/internal/models"
+	"gorm.io/gorm"
+)
+
+// GormWorkflowRunRepository is a GORM implementation of WorkflowRunRepository
+type GormWorkflowRunRepository struct {
+	*GormRepository
+}
+
+// NewWorkflowRunRepository creates a new GormWorkflowRunRepository
+func NewWorkflowRunRepository(db *gorm.DB) WorkflowRunRepository {
+	return &GormWorkflowRunRepository{
+		GormRepository: NewGormRepository(db),
+	}
+}
+
+// Create creates a new workflow run together with its step runs
+func (r *GormWorkflowRunRepository) Create(ctx context.Context, run *models.WorkflowRun) error {
+	if run == nil || run.WorkflowID == 0 {
+		return ErrValidation
+	}
+
+	result := r.db.WithContext(ctx).Create(run)
+	if result.Error != nil {
+		return result.Error
+	}
+
+	return nil
+}
+
+// GetByID gets a workflow run by ID
+func (r *GormWorkflowRunRepository) GetByID(ctx context.Context, id uint) (*models.WorkflowRun, error) {
+	if id == 0 {
+		return nil, ErrInvalidID
+	}
+
+	var run models.WorkflowRun
+	result := r.db.WithContext(ctx).Preload("Steps").First(&run, id)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, ErrNotFound
+		}
+		return nil, result.Error
+	}
+
+	return &run, nil
+}
+
+// ListByWorkflowID lists runs of a workflow with pagination, newest first
+func (r *GormWorkflowRunRepository) ListByWorkflowID(ctx context.Context, workflowID uint, offset, limit int) ([]*models.WorkflowRun, error) {
+	if workflowID == 0 {
+		return nil, ErrInvalidID
+	}
+	if limit <= 0 {
+		limit = 10 // Default limit
+	}
+	if offset < 0 {
+		offset = 0
+	}
+
+	var runs []*models.WorkflowRun
+	result := r.db.WithContext(ctx).
+		Preload("Steps").
+		Where("workflow_id = ?", workflowID).
+		Order("id DESC").
+		Offset(offset).
+		Limit(limit).
+		Find(&runs)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	return runs, nil
+}
+
+// ListByState lists workflow runs by state with pagination
+func (r *GormWorkflowRunRepository) ListByState(ctx context.Context, state models.WorkflowRunState, offset, limit int) ([]*models.WorkflowRun, error) {
+	if state == "" {
+		return nil, ErrValidation
+	}
+	if limit <= 0 {
+		limit = 10 // Default limit
+	}
+	if offset < 0 {
+		offset = 0
+	}
+
+	var runs []*models.WorkflowRun
+	result := r.db.WithContext(ctx).
+		Preload("Steps").
+		Where("state = ?", state).
+		Order("id").
+		Offset(offset).
+		Limit(limit).
+		Find(&runs)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	return runs, nil
+}
+
+// Update updates a workflow run and its step runs
+func (r *GormWorkflowRunRepository) Update(ctx context.Context, run *models.WorkflowRun) error {
+	if run == nil || run.ID == 0 {
+		return ErrInvalidID
+	}
+
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Omit("Steps").Save(run)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrNotFound
+		}
+
+		for i := range run.Steps {
+			if err := tx.Save(&run.Steps[i]).Error; err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// StartStep creates the task of a pending step run and marks the step run running with it, in one
+// transaction. It fails with ErrNotFound, creating nothing, if the step run is no longer pending,
+// e.g. because another scheduler started it first.
+func (r *GormWorkflowRunRepository) StartStep(ctx context.Context, stepRun *models.WorkflowStepRun, task *models.TaskInstance, now time.Time) error {
+	if stepRun == nil || stepRun.ID == 0 || task == nil {
+		return ErrInvalidID
+	}
+
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(task).Error; err != nil {
+			return err
+		}
+
+		result := tx.Model(&models.WorkflowStepRun{}).
+			Where("id = ? AND state = ?", stepRun.ID, models.StepRunStatePending).
+			Updates(map[string]interface{}{"state": models.StepRunStateRunning, "task_id": task.ID, "started_at": now})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrNotFound
+		}
+		return nil
+	})
+	if err != nil {
+		task.ID = 0
+		return err
+	}
+
+	stepRun.State = models.StepRunStateRunning
+	stepRun.TaskID = &task.ID
+	stepRun.StartedAt = &now
+	return nil
+}
@@ -12,13 +12,22 @@ import (
 // Template represents a task template that wraps a script with parameter schema
 type Template struct {
 	gorm.Model
-	Name            string         `json:"name" gorm:"uniqueIndex"`
-	Description     string         `json:"description"`
-	Script          string         `json:"script"`
-	ParamsSchema    JSONSchema     `json:"params_schema" gorm:"type:jsonb"`
-	RequireApproval bool           `json:"require_approval" gorm:"default:false"`
-	CreatedBy       uint           `json:"created_by"`
-	TaskInstances   []TaskInstance `json:"-" gorm:"foreignKey:TemplateID"`
+	Name             string         `json:"name" gorm:"uniqueIndex"`
+	Description      string         `json:"description"`
+	Script           string         `json:"script"`
+	ScriptKeyID      string         `json:"script_key_id"`     // approval of Script by a signer outside the portal, see internal/signing
+	ScriptExpiresAt  int64          `json:"script_expires_at"` // unix time the approval ends
+	ScriptSignature  []byte         `json:"script_signature"`
+	ParamsSchema     JSONSchema     `json:"params_schema" gorm:"type:jsonb"`
+	Tags             StringList     `json:"tags" gorm:"type:jsonb"` // used by chat routes, e.g. "team:payments"
+	RequireApproval  bool           `json:"require_approval" gorm:"default:false"`
+	ReminderPolicyID *uint          `json:"reminder_policy_id"`
+	MaxSnoozes       int            `json:"max_snoozes"`                      // 0 means unlimited
+	MaxSnoozeMinutes int            `json:"max_snooze_minutes"`               // total delay across all snoozes, 0 means unlimited
+	Requirements     StringList     `json:"requirements" gorm:"type:jsonb"`   // what the agent must have, e.g. "helm>=3.12"
+	AgentSelector    JSONSchema     `json:"agent_selector" gorm:"type:jsonb"` // labels the agent running its tasks must carry
+	CreatedBy        uint           `json:"created_by"`
+	TaskInstances    []TaskInstance `json:"-" gorm:"foreignKey:TemplateID"`
 }
 
 // JSONSchema represents a JSON schema for template parameters
@@ -45,6 +54,30 @@ func (j JSONSchema) Value() (driver.Value, error) {
 	return json.Marshal(j)
 }
 
+// StringList represents a list of strings stored as JSON
+type StringList []string
+
+// Scan implements the sql.Scanner interface for StringList
+func (l *StringList) Scan(value interface{}) error {
+	bytes, ok := value.([]byte)
+	if !ok {
+		return errors.New("failed to unmarshal StringList value")
+	}
+
+	var result []string
+	err := json.Unmarshal(bytes, &result)
+	*l = result
+	return err
+}
+
+// Value implements the driver.Valuer interface for StringList
+func (l StringList) Value() (driver.Value, error) {
+	if len(l) == 0 {
+		return nil, nil
+	}
+	return json.Marshal(l)
+}
+
 // TaskState represents the state of a task instance
 type TaskState string
 
@@ -67,28 +100,36 @@ const (
 	TaskOriginAPI        TaskOrigin = "api"
 	TaskOriginScheduler  TaskOrigin = "scheduler"
 	TaskOriginSheet      TaskOrigin = "sheet"
+	TaskOriginWorkflow   TaskOrigin = "workflow"
 )
 
 // TaskInstance represents an instance of a task to be executed
 type TaskInstance struct {
 	gorm.Model
-	TemplateID  uint           `json:"template_id" gorm:"index"`
-	Template    Template       `json:"-" gorm:"foreignKey:TemplateID"`
-	Params      JSONSchema     `json:"params" gorm:"type:jsonb"`
-	State       TaskState      `json:"state" gorm:"default:'pending'"`
-	DueAt       *time.Time     `json:"due_at"`
-	Origin      TaskOrigin     `json:"origin"`
-	ChatThread  string         `json:"chat_thread"`
-	CreatedBy   uint           `json:"created_by"`
-	ExecutedBy  *uint          `json:"executed_by"`
-	AgentID     *uint          `json:"agent_id"`
-	Agent       *ClusterAgent  `json:"-" gorm:"foreignKey:AgentID"`
-	Reminders   []Reminder     `json:"-" gorm:"foreignKey:TaskID"`
-	Logs        []ExecutionLog `json:"-" gorm:"foreignKey:TaskID"`
-	ApprovedBy  *uint          `json:"approved_by"`
-	ApprovedAt  *time.Time     `json:"approved_at"`
-	CompletedAt *time.Time     `json:"completed_at"`
-	ExitCode    *int           `json:"exit_code"`
+	TemplateID       uint           `json:"template_id" gorm:"index"`
+	Template         Template       `json:"-" gorm:"foreignKey:TemplateID"`
+	Params           JSONSchema     `json:"params" gorm:"type:jsonb"`
+	State            TaskState      `json:"state" gorm:"default:'pending'"`
+	DueAt            *time.Time     `json:"due_at"`
+	Origin           TaskOrigin     `json:"origin"`
+	ChatThread       string         `json:"chat_thread"`
+	CreatedBy        uint           `json:"created_by"`
+	ExecutedBy       *uint          `json:"executed_by"`
+	AgentID          *uint          `json:"agent_id"`
+	Agent            *ClusterAgent  `json:"-" gorm:"foreignKey:AgentID"`
+	Reminders        []Reminder     `json:"-" gorm:"foreignKey:TaskID"`
+	Logs             []ExecutionLog `json:"-" gorm:"foreignKey:TaskID"`
+	ApprovedBy       *uint          `json:"approved_by"`
+	ApprovedAt       *time.Time     `json:"approved_at"`
+	StartedAt        *time.Time     `json:"started_at"`
+	CompletedAt      *time.Time     `json:"completed_at"`
+	ExitCode         *int           `json:"exit_code"`
+	BreakGlassBy     *uint          `json:"break_glass_by"`
+	BreakGlassReason string         `json:"break_glass_reason"`           // why a change freeze was overridden
+	NotifiedAt       *time.Time     `json:"notified_at"`                  // when the outcome was posted to ChatThread
+	Redactions       JSONSchema     `json:"redactions" gorm:"type:jsonb"` // secrets masked in the logs, by rule
+	CPUUsageMs       *int64         `json:"cpu_usage_ms"`                 // reported by agents that run tasks in cgroups
+	PeakMemoryBytes  *int64         `json:"peak_memory_bytes"`
 }
 
 // ReminderState represents the state of a reminder
@@ -96,59 +137,379 @@ type ReminderState string
 
 const (
 	ReminderStatePending   ReminderState = "pending"
+	ReminderStateQueued    ReminderState = "queued" // waiting in the outbox to be posted
 	ReminderStateDelivered ReminderState = "delivered"
 	ReminderStateActioned  ReminderState = "actioned"
 	ReminderStateCancelled ReminderState = "cancelled"
+	ReminderStateSnoozed   ReminderState = "snoozed"
+)
+
+// Reminder escalation levels
+const (
+	ReminderLevelInitial   = 0 // posted to the reminder's own channel
+	ReminderLevelEscalated = 1 // escalated to the policy's escalation target
+	ReminderLevelFinal     = 2 // the policy's final action has been taken
 )
 
 // Reminder represents a scheduled reminder for a task
 type Reminder struct {
 	gorm.Model
-	TaskID     uint          `json:"task_id" gorm:"index"`
-	Task       TaskInstance  `json:"-" gorm:"foreignKey:TaskID"`
-	ChatAt     time.Time     `json:"chat_at" gorm:"index"`
-	State      ReminderState `json:"state" gorm:"default:'pending'"`
-	ChatType   string        `json:"chat_type"` // slack, google_chat
-	ChatID     string        `json:"chat_id"`   // channel ID, space name, etc.
-	MessageID  string        `json:"message_id"`
-	SnoozedAt  *time.Time    `json:"snoozed_at"`
-	SnoozedBy  *uint         `json:"snoozed_by"`
-	CancelledAt *time.Time   `json:"cancelled_at"`
-	CancelledBy *uint        `json:"cancelled_by"`
+	TaskID          uint          `json:"task_id" gorm:"index"`
+	Task            TaskInstance  `json:"-" gorm:"foreignKey:TaskID"`
+	ChatAt          time.Time     `json:"chat_at" gorm:"index"`
+	State           ReminderState `json:"state" gorm:"default:'pending'"`
+	ChatType        string        `json:"chat_type"` // slack, google_chat, teams, mattermost
+	ChatID          string        `json:"chat_id"`   // channel ID, space name, etc.
+	MessageID       string        `json:"message_id"`
+	SnoozedAt       *time.Time    `json:"snoozed_at"`
+	SnoozedBy       *uint         `json:"snoozed_by"`
+	CancelledAt     *time.Time    `json:"cancelled_at"`
+	CancelledBy     *uint         `json:"cancelled_by"`
+	PolicyID        *uint         `json:"policy_id"`
+	RouteID         *uint         `json:"route_id"` // chat route that chose ChatType and ChatID
+	EscalationLevel int           `json:"escalation_level" gorm:"default:0"`
+	SendCount       int           `json:"send_count" gorm:"default:0"`
+	FirstSentAt     *time.Time    `json:"first_sent_at"` // policy deadlines are measured from here
+	LastSentAt      *time.Time    `json:"last_sent_at"`
+	EscalatedAt     *time.Time    `json:"escalated_at"`
+	NextActionAt    *time.Time    `json:"next_action_at" gorm:"index"` // next repeat, escalation or final action of a delivered reminder
+	SnoozedUntil    *time.Time    `json:"snoozed_until"`
+	SnoozedFromID   *uint         `json:"snoozed_from_id"` // reminder this one is the follow-up of
+	SnoozeCount     int           `json:"snooze_count" gorm:"default:0"`
+	SnoozedMinutes  int           `json:"snoozed_minutes" gorm:"default:0"` // total delay of all snoozes so far
+}
+
+// ReminderFinalAction is what happens when nobody acts on a reminder before its final deadline
+type ReminderFinalAction string
+
+const (
+	ReminderFinalActionNone   ReminderFinalAction = "none"
+	ReminderFinalActionCancel ReminderFinalAction = "cancel"
+	ReminderFinalActionRun    ReminderFinalAction = "run"
+)
+
+// ReminderPolicy controls how reminders of a template are repeated and escalated until someone acts.
+// Zero durations disable the corresponding step.
+type ReminderPolicy struct {
+	gorm.Model
+	Name                 string              `json:"name" gorm:"uniqueIndex"`
+	Description          string              `json:"description"`
+	RepeatEveryMinutes   int                 `json:"repeat_every_minutes"`
+	EscalateAfterMinutes int                 `json:"escalate_after_minutes"`
+	EscalationChatType   string              `json:"escalation_chat_type"` // defaults to the reminder's chat type
+	EscalationChatID     string              `json:"escalation_chat_id"`   // channel, space or user ID
+	EscalationMention    string              `json:"escalation_mention"`   // e.g. <@U123> or <!subteam^S123>
+	FinalDeadlineMinutes int                 `json:"final_deadline_minutes"`
+	FinalAction          ReminderFinalAction `json:"final_action" gorm:"default:'none'"`
+	Templates            []Template          `json:"templates,omitempty" gorm:"foreignKey:ReminderPolicyID"`
 }
 
 // ExecutionLog represents a log chunk from task execution
 type ExecutionLog struct {
 	gorm.Model
-	TaskID    uint      `json:"task_id" gorm:"index"`
+	TaskID    uint         `json:"task_id" gorm:"index;uniqueIndex:idx_execution_logs_sequence"`
 	Task      TaskInstance `json:"-" gorm:"foreignKey:TaskID"`
-	AgentID   uint      `json:"agent_id" gorm:"index"`
+	AgentID   uint         `json:"agent_id" gorm:"index"`
 	Agent     ClusterAgent `json:"-" gorm:"foreignKey:AgentID"`
-	Chunk     string    `json:"chunk"`
-	Timestamp time.Time `json:"timestamp" gorm:"index"`
-	Stream    string    `json:"stream"` // stdout, stderr
-	Sequence  int       `json:"sequence" gorm:"index"`
+	Chunk     string       `json:"chunk"`
+	Timestamp time.Time    `json:"timestamp" gorm:"index"`
+	Stream    string       `json:"stream" gorm:"uniqueIndex:idx_execution_logs_sequence"`         // stdout, stderr
+	Sequence  int          `json:"sequence" gorm:"index;uniqueIndex:idx_execution_logs_sequence"` // one chunk per stream and sequence
 }
 
 // ClusterAgent represents a cluster agent
 type ClusterAgent struct {
 	gorm.Model
-	Name          string            `json:"name" gorm:"uniqueIndex"`
-	Labels        JSONSchema        `json:"labels" gorm:"type:jsonb"`
-	LastHeartbeat time.Time         `json:"last_heartbeat"`
-	Status        string            `json:"status" gorm:"default:'unknown'"`
-	Version       string            `json:"version"`
-	TaskInstances []TaskInstance    `json:"-" gorm:"foreignKey:AgentID"`
-	Logs          []ExecutionLog    `json:"-" gorm:"foreignKey:AgentID"`
+	Name          string     `json:"name" gorm:"uniqueIndex"`
+	Labels        JSONSchema `json:"labels" gorm:"type:jsonb"`
+	LastHeartbeat time.Time  `json:"last_heartbeat"`
+	Status        string     `json:"status" gorm:"default:'unknown'"`
+	Version       string     `json:"version"`
+	Capacity      int        `json:"capacity"` // tasks the agent runs at once, as of the last heartbeat
+	RunningTasks  int        `json:"running_tasks"`
+	QueuedTasks   int        `json:"queued_tasks"`
+	Capabilities  JSONSchema `json:"capabilities" gorm:"type:jsonb"` // binaries, backends and cluster facts, as of the last heartbeat
+	// Client certificate the agent last registered with; calls with any other certificate are rejected
+	CertFingerprint string         `json:"cert_fingerprint" gorm:"index"` // hex SHA-256 of the DER certificate
+	CertSerial      string         `json:"cert_serial"`
+	CertExpiresAt   *time.Time     `json:"cert_expires_at"`
+	AllowedLabels   JSONSchema     `json:"allowed_labels" gorm:"type:jsonb"` // labels the agent may claim, from its enrollment token; "*" allows any value
+	TaskInstances   []TaskInstance `json:"-" gorm:"foreignKey:AgentID"`
+	Logs            []ExecutionLog `json:"-" gorm:"foreignKey:AgentID"`
+}
+
+// EnrollmentToken lets a new agent obtain its first client certificate; it can be used once
+type EnrollmentToken struct {
+	gorm.Model
+	TokenHash     string     `json:"-" gorm:"uniqueIndex"`
+	Name          string     `json:"name"`                             // the agent name the token enrolls
+	AllowedLabels JSONSchema `json:"allowed_labels" gorm:"type:jsonb"` // labels the agent may claim; "*" allows any value
+	ExpiresAt     time.Time  `json:"expires_at"`
+	UsedAt        *time.Time `json:"used_at"`
+	CreatedByID   *uint      `json:"created_by_id"`
+}
+
+// RevokedCertificate is an agent client certificate that must not be accepted any more
+type RevokedCertificate struct {
+	gorm.Model
+	AgentID     uint   `json:"agent_id" gorm:"index"`
+	Fingerprint string `json:"fingerprint" gorm:"uniqueIndex"`
+	Serial      string `json:"serial"`
+	Reason      string `json:"reason"`
+	RevokedByID *uint  `json:"revoked_by_id"`
 }
 
 // User represents a user in the system
 type User struct {
 	gorm.Model
-	Email        string `json:"email" gorm:"uniqueIndex"`
-	Name         string `json:"name"`
-	Role         string `json:"role" gorm:"default:'viewer'"`
-	ExternalID   string `json:"external_id" gorm:"uniqueIndex"`
-	Provider     string `json:"provider"` // github, google, etc.
-	LastLoginAt  *time.Time `json:"last_login_at"`
-}
\ No newline at end of file
+	Email       string     `json:"email" gorm:"uniqueIndex"`
+	Name        string     `json:"name"`
+	Role        string     `json:"role" gorm:"default:'viewer'"`
+	ExternalID  string     `json:"external_id" gorm:"uniqueIndex"`
+	Provider    string     `json:"provider"` // github, google, etc.
+	LastLoginAt *time.Time `json:"last_login_at"`
+	BreakGlass  bool       `json:"break_glass" gorm:"default:false"` // may run tasks during a change freeze
+	TimeZone    string     `json:"time_zone"`                        // IANA name, used e.g. for "next business morning"
+}
+
+// User roles
+const (
+	RoleViewer   = "viewer"
+	RoleOperator = "operator" // may run, snooze and cancel tasks
+	RoleAdmin    = "admin"
+)
+
+// CanOperate reports whether the user may run, snooze and cancel tasks
+func (u *User) CanOperate() bool {
+	return u.Role == RoleOperator || u.Role == RoleAdmin
+}
+
+// ChatRoute decides where reminders of matching tasks are posted, who is mentioned and which
+// message templates are used. Routes are tried by ascending Priority and the first match wins;
+// empty match fields match every task.
+type ChatRoute struct {
+	gorm.Model
+	Name                string     `json:"name" gorm:"uniqueIndex"`
+	Description         string     `json:"description"`
+	Priority            int        `json:"priority" gorm:"index"`
+	Enabled             bool       `json:"enabled"`
+	Templates           []Template `json:"templates,omitempty" gorm:"many2many:chat_route_templates"`
+	Tags                StringList `json:"tags" gorm:"type:jsonb"`           // matches templates with any of these tags
+	AgentSelector       JSONSchema `json:"agent_selector" gorm:"type:jsonb"` // labels the task's agent must have
+	Origins             StringList `json:"origins" gorm:"type:jsonb"`        // task origins, e.g. "scheduler"
+	ChatType            string     `json:"chat_type"`                        // empty means the default platform
+	ChatID              string     `json:"chat_id"`                          // empty means the platform's default channel
+	Mention             string     `json:"mention"`                          // e.g. "<!subteam^S123>" or "@oncall"
+	ReminderMessageID   *uint      `json:"reminder_message_id"`
+	CompletionMessageID *uint      `json:"completion_message_id"`
+	FailureMessageID    *uint      `json:"failure_message_id"`
+}
+
+// MessageKind is the kind of chat message a message template renders
+type MessageKind string
+
+const (
+	MessageKindReminder   MessageKind = "reminder"
+	MessageKindCompletion MessageKind = "completion"
+	MessageKindFailure    MessageKind = "failure"
+)
+
+// MessageTemplate is a user-editable Go text/template for a kind of chat message
+type MessageTemplate struct {
+	gorm.Model
+	Name        string      `json:"name" gorm:"uniqueIndex"`
+	Description string      `json:"description"`
+	Kind        MessageKind `json:"kind"`
+	Body        string      `json:"body"`
+}
+
+// OutboxState represents the delivery state of an outbox message
+type OutboxState string
+
+const (
+	OutboxStatePending OutboxState = "pending"
+	OutboxStateSending OutboxState = "sending" // claimed for delivery; still sending after the lease means it was interrupted
+	OutboxStateSent    OutboxState = "sent"
+	OutboxStateSkipped OutboxState = "skipped" // no longer needed, e.g. the reminder was cancelled
+	OutboxStateDead    OutboxState = "dead"    // gave up after the maximum number of attempts
+)
+
+// Outbox message kinds
+const (
+	OutboxKindReminder = "reminder" // a message with reminder actions for TaskID
+	OutboxKindMessage  = "message"  // a plain message
+	OutboxKindReply    = "reply"    // a reply in Thread
+	OutboxKindUpdate   = "update"   // replaces the text of message Thread
+	OutboxKindFile     = "file"     // Text shared as Filename in Thread
+)
+
+// OutboxMessage is a chat message written in the same transaction as the state change it
+// announces and delivered by the scheduler with retries
+type OutboxMessage struct {
+	gorm.Model
+	IdempotencyKey string      `json:"idempotency_key" gorm:"uniqueIndex"` // e.g. "reminder:12:1"; a key is enqueued once
+	Kind           string      `json:"kind"`
+	Platform       string      `json:"platform"`
+	Channel        string      `json:"channel"`
+	Thread         string      `json:"thread"`
+	Text           string      `json:"text"`
+	Filename       string      `json:"filename"`
+	TaskID         *uint       `json:"task_id" gorm:"index"`
+	ReminderID     *uint       `json:"reminder_id" gorm:"index"`
+	State          OutboxState `json:"state" gorm:"index;default:'pending'"`
+	Attempts       int         `json:"attempts"`
+	NextAttemptAt  time.Time   `json:"next_attempt_at" gorm:"index"`
+	LastError      string      `json:"last_error"`
+	MessageID      string      `json:"message_id"` // of the first message of a file posted as several, once that is posted
+	Parts          int         `json:"parts"`      // messages of a file posted as several that were posted so far
+	SentAt         *time.Time  `json:"sent_at"`    // recorded as soon as the message is posted, before it is completed
+}
+
+// ChatIdentity links an account on a chat platform to a portal user
+type ChatIdentity struct {
+	gorm.Model
+	Provider   string     `json:"provider" gorm:"uniqueIndex:idx_chat_identity_account"`  // slack, google_chat, teams, mattermost
+	Workspace  string     `json:"workspace" gorm:"uniqueIndex:idx_chat_identity_account"` // Slack team or Teams tenant; empty where user IDs are global
+	ChatUserID string     `json:"chat_user_id" gorm:"uniqueIndex:idx_chat_identity_account"`
+	UserID     uint       `json:"user_id" gorm:"index"`
+	User       User       `json:"-" gorm:"foreignKey:UserID"`
+	Method     string     `json:"method"` // ChatLinkMethodEmail or ChatLinkMethodConfirmed
+	VerifiedAt *time.Time `json:"verified_at"`
+}
+
+// How a chat identity was linked
+const (
+	ChatLinkMethodEmail     = "email"     // the platform reported the same verified email as the portal user's
+	ChatLinkMethodConfirmed = "confirmed" // the user opened a one-time link in the portal
+)
+
+// ChatLinkToken is a one-time token that lets a chat user link their account in the portal
+type ChatLinkToken struct {
+	gorm.Model
+	TokenHash  string     `json:"-" gorm:"uniqueIndex"` // SHA-256 of the token sent to the user
+	Provider   string     `json:"provider"`
+	Workspace  string     `json:"workspace"`
+	ChatUserID string     `json:"chat_user_id"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	UsedAt     *time.Time `json:"used_at"`
+}
+
+// WindowKind represents the kind of a calendar window
+type WindowKind string
+
+const (
+	// WindowKindAllow marks a maintenance window in which tasks may run
+	WindowKindAllow WindowKind = "allow"
+	// WindowKindFreeze marks a change freeze in which tasks must not run
+	WindowKindFreeze WindowKind = "freeze"
+)
+
+// Calendar groups maintenance windows and change freezes that restrict when tasks may be dispatched.
+// A calendar applies to a task when its template is attached (or no templates are attached) and the
+// target agent matches AgentSelector (or the selector is empty).
+type Calendar struct {
+	gorm.Model
+	Name          string           `json:"name" gorm:"uniqueIndex"`
+	Description   string           `json:"description"`
+	TimeZone      string           `json:"time_zone" gorm:"default:'UTC'"` // IANA name, e.g. Europe/Kyiv
+	AgentSelector JSONSchema       `json:"agent_selector" gorm:"type:jsonb"`
+	Enabled       bool             `json:"enabled"`
+	Windows       []CalendarWindow `json:"windows" gorm:"foreignKey:CalendarID"`
+	Templates     []Template       `json:"templates,omitempty" gorm:"many2many:calendar_templates"`
+}
+
+// CalendarWindow is a one-off (StartAt/EndAt) or weekly recurring (Weekdays/StartTime/DurationMinutes) window
+type CalendarWindow struct {
+	gorm.Model
+	CalendarID      uint       `json:"calendar_id" gorm:"index"`
+	Kind            WindowKind `json:"kind"`
+	Description     string     `json:"description"`
+	StartAt         *time.Time `json:"start_at"`
+	EndAt           *time.Time `json:"end_at"`
+	Weekdays        string     `json:"weekdays"`   // comma-separated, e.g. "sat,sun"; empty means every day
+	StartTime       string     `json:"start_time"` // HH:MM in the calendar time zone
+	DurationMinutes int        `json:"duration_minutes"`
+}
+
+// StepCondition decides whether a workflow step runs based on its dependencies.
+// Besides the constants below, "exit_code==N" and "exit_code!=N" compare every dependency's exit code.
+type StepCondition string
+
+const (
+	StepConditionSuccess StepCondition = "success"
+	StepConditionFailure StepCondition = "failure"
+	StepConditionAlways  StepCondition = "always"
+)
+
+// Workflow represents a multi-step runbook composed of templates
+type Workflow struct {
+	gorm.Model
+	Name        string         `json:"name" gorm:"uniqueIndex"`
+	Description string         `json:"description"`
+	Steps       []WorkflowStep `json:"steps" gorm:"foreignKey:WorkflowID"`
+	CreatedBy   uint           `json:"created_by"`
+}
+
+// WorkflowStep is a node of a workflow DAG. Param values are Go templates that may reference
+// run params and earlier steps, e.g. {{ .params.node }} or {{ .steps.drain.outputs.pods }}.
+type WorkflowStep struct {
+	gorm.Model
+	WorkflowID   uint          `json:"workflow_id" gorm:"index"`
+	Name         string        `json:"name"`
+	TemplateID   uint          `json:"template_id"`
+	Template     Template      `json:"-" gorm:"foreignKey:TemplateID"`
+	DependsOn    StringList    `json:"depends_on" gorm:"type:jsonb"`
+	Condition    StepCondition `json:"condition"`
+	Params       JSONSchema    `json:"params" gorm:"type:jsonb"`
+	Compensation bool          `json:"compensation"` // runs only after the workflow has failed
+}
+
+// WorkflowRunState represents the state of a workflow run
+type WorkflowRunState string
+
+const (
+	WorkflowRunStateRunning   WorkflowRunState = "running"
+	WorkflowRunStateCompleted WorkflowRunState = "completed"
+	WorkflowRunStateFailed    WorkflowRunState = "failed"
+	WorkflowRunStateCancelled WorkflowRunState = "cancelled"
+)
+
+// WorkflowRun represents an execution of a workflow
+type WorkflowRun struct {
+	gorm.Model
+	WorkflowID  uint              `json:"workflow_id" gorm:"index"`
+	Workflow    Workflow          `json:"-" gorm:"foreignKey:WorkflowID"`
+	State       WorkflowRunState  `json:"state" gorm:"default:'running';index"`
+	Params      JSONSchema        `json:"params" gorm:"type:jsonb"`
+	Origin      TaskOrigin        `json:"origin"`
+	CreatedBy   uint              `json:"created_by"`
+	StartedAt   time.Time         `json:"started_at"`
+	CompletedAt *time.Time        `json:"completed_at"`
+	Steps       []WorkflowStepRun `json:"steps" gorm:"foreignKey:RunID"`
+}
+
+// StepRunState represents the state of a workflow step run
+type StepRunState string
+
+const (
+	StepRunStatePending   StepRunState = "pending"
+	StepRunStateRunning   StepRunState = "running"
+	StepRunStateCompleted StepRunState = "completed"
+	StepRunStateFailed    StepRunState = "failed"
+	StepRunStateSkipped   StepRunState = "skipped"
+)
+
+// WorkflowStepRun tracks a single step within a workflow run
+type WorkflowStepRun struct {
+	gorm.Model
+	RunID       uint         `json:"run_id" gorm:"index"`
+	StepID      uint         `json:"step_id"`
+	StepName    string       `json:"step_name"`
+	State       StepRunState `json:"state" gorm:"default:'pending'"`
+	TaskID      *uint        `json:"task_id" gorm:"index"`
+	ExitCode    *int         `json:"exit_code"`
+	Outputs     JSONSchema   `json:"outputs" gorm:"type:jsonb"`
+	Error       string       `json:"error"`
+	StartedAt   *time.Time   `json:"started_at"`
+	CompletedAt *time.Time   `json:"completed_at"`
+}
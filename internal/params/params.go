@@ -0,0 +1,258 @@
+package params
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/BogdanDolia/ops-butler/internal/models"
+	"github.com/BogdanDolia/ops-butler/internal/secrets"
+)
+
+// ErrInvalidParams is returned when parameters don't match a template's schema
+var ErrInvalidParams = errors.New("invalid parameters")
+
+// Field is a parameter described by a template's ParamsSchema, which uses a subset of JSON Schema:
+// an object with typed properties, enums, defaults and a list of required names
+type Field struct {
+	Name        string
+	Title       string
+	Description string
+	Type        string // string, integer, number, boolean, array or object
+	Enum        []string
+	Default     interface{}
+	Required    bool
+	Secret      bool    // "secret": true; stored sealed, or given as a reference the agent resolves
+	Source      *Source // choices listed from a cluster, nil if the field has none
+}
+
+// Label returns the title of the field, or its name if it has none
+func (f Field) Label() string {
+	if f.Title != "" {
+		return f.Title
+	}
+	return f.Name
+}
+
+// ValidationError lists the fields that failed validation with a message for each
+type ValidationError struct {
+	Fields map[string]string
+}
+
+// Error implements the error interface
+func (e *ValidationError) Error() string {
+	names := make([]string, 0, len(e.Fields))
+	for name := range e.Fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	parts := make([]string, 0, len(names))
+	for _, name := range names {
+		parts = append(parts, fmt.Sprintf("%s: %s", name, e.Fields[name]))
+	}
+	return fmt.Sprintf("%s: %s", ErrInvalidParams, strings.Join(parts, "; "))
+}
+
+// Unwrap makes errors.Is(err, ErrInvalidParams) true
+func (e *ValidationError) Unwrap() error {
+	return ErrInvalidParams
+}
+
+// Fields returns the fields of a schema, required ones first and then by name
+func Fields(schema models.JSONSchema) []Field {
+	properties, _ := schema["properties"].(map[string]interface{})
+	required := make(map[string]bool)
+	if list, ok := schema["required"].([]interface{}); ok {
+		for _, name := range list {
+			if s, ok := name.(string); ok {
+				required[s] = true
+			}
+		}
+	}
+
+	fields := make([]Field, 0, len(properties))
+	for name, raw := range properties {
+		prop, _ := raw.(map[string]interface{})
+		field := Field{
+			Name:     name,
+			Type:     "string",
+			Required: required[name],
+		}
+		if t, ok := prop["type"].(string); ok {
+			field.Type = t
+		}
+		field.Secret, _ = prop["secret"].(bool)
+		field.Title, _ = prop["title"].(string)
+		field.Description, _ = prop["description"].(string)
+		field.Default = prop["default"]
+		field.Source = parseSource(prop["source"])
+		if enum, ok := prop["enum"].([]interface{}); ok {
+			for _, v := range enum {
+				field.Enum = append(field.Enum, fmt.Sprint(v))
+			}
+		}
+		fields = append(fields, field)
+	}
+
+	sort.Slice(fields, func(i, j int) bool {
+		if fields[i].Required != fields[j].Required {
+			return fields[i].Required
+		}
+		return fields[i].Name < fields[j].Name
+	})
+	return fields
+}
+
+// HasFields reports whether a schema describes any parameters
+func HasFields(schema models.JSONSchema) bool {
+	properties, _ := schema["properties"].(map[string]interface{})
+	return len(properties) > 0
+}
+
+// Coerce converts raw string values (e.g. from key=value arguments or form inputs) to the types
+// declared by the schema, applies defaults and checks required fields and enums. Without a schema
+// the values are passed through as strings.
+func Coerce(schema models.JSONSchema, raw map[string]string) (models.JSONSchema, error) {
+	result := make(models.JSONSchema, len(raw))
+	if !HasFields(schema) {
+		for name, value := range raw {
+			result[name] = value
+		}
+		return result, nil
+	}
+
+	fields := Fields(schema)
+	known := make(map[string]bool, len(fields))
+	problems := make(map[string]string)
+
+	for _, field := range fields {
+		known[field.Name] = true
+
+		value, ok := raw[field.Name]
+		if !ok || value == "" {
+			switch {
+			case field.Default != nil:
+				result[field.Name] = field.Default
+			case field.Required:
+				problems[field.Name] = "is required"
+			}
+			continue
+		}
+
+		if len(field.Enum) > 0 && !contains(field.Enum, value) {
+			problems[field.Name] = fmt.Sprintf("must be one of %s", strings.Join(field.Enum, ", "))
+			continue
+		}
+
+		converted, err := convert(field.Type, value)
+		if err != nil {
+			problems[field.Name] = err.Error()
+			continue
+		}
+		result[field.Name] = converted
+	}
+
+	for name := range raw {
+		if !known[name] {
+			problems[name] = "is not a parameter of this template"
+		}
+	}
+
+	if len(problems) > 0 {
+		return nil, &ValidationError{Fields: problems}
+	}
+	return result, nil
+}
+
+// SecretNames returns the names of a schema's secret fields
+func SecretNames(schema models.JSONSchema) []string {
+	var names []string
+	for _, field := range Fields(schema) {
+		if field.Secret {
+			names = append(names, field.Name)
+		}
+	}
+	return names
+}
+
+// Seal seals the values of secret fields in place so they are encrypted at rest. References such as
+// k8s://payments/db/password or env://DB_PASSWORD are kept as they are for the agent to resolve;
+// without a keyring they are the only values secret fields accept.
+func Seal(schema models.JSONSchema, values models.JSONSchema, keyring *secrets.Keyring) error {
+	problems := make(map[string]string)
+	for _, name := range SecretNames(schema) {
+		value, ok := values[name]
+		if !ok || secrets.IsSealed(value) {
+			continue
+		}
+
+		s := fmt.Sprint(value)
+		if _, isRef, err := secrets.ParseReference(s); isRef {
+			if err != nil {
+				problems[name] = err.Error()
+			}
+			continue
+		}
+
+		sealed, err := keyring.Seal(s)
+		if errors.Is(err, secrets.ErrNoKeyring) {
+			problems[name] = "must be a k8s:// or env:// reference, secret values can't be stored"
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		values[name] = sealed
+	}
+
+	if len(problems) > 0 {
+		return &ValidationError{Fields: problems}
+	}
+	return nil
+}
+
+// convert parses a string as a value of a JSON Schema type
+func convert(typ, value string) (interface{}, error) {
+	switch typ {
+	case "integer":
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("must be an integer")
+		}
+		return n, nil
+	case "number":
+		n, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return nil, fmt.Errorf("must be a number")
+		}
+		return n, nil
+	case "boolean":
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("must be true or false")
+		}
+		return b, nil
+	case "array", "object":
+		var v interface{}
+		if err := json.Unmarshal([]byte(value), &v); err != nil {
+			return nil, fmt.Errorf("must be a JSON %s", typ)
+		}
+		return v, nil
+	default:
+		return value, nil
+	}
+}
+
+// contains reports whether a list contains a value
+func contains(list []string, value string) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,86 @@
+package params
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/BogdanDolia/ops-butler/internal/models"
+)
+
+// Kinds of objects a source lists
+const (
+	SourceNamespaces  = "namespaces"
+	SourceDeployments = "deployments"
+	SourceNodes       = "nodes"
+)
+
+// Source is a dynamic list of choices for a field, the names of objects in the cluster of an agent,
+// declared in ParamsSchema as e.g.
+//
+//	"deployment": {
+//	  "type": "string",
+//	  "source": {"kind": "deployments", "namespace_param": "namespace", "label_selector": "tier=web",
+//	             "agent_labels": {"env": "prod"}}
+//	}
+//
+// Deployments are listed in a fixed namespace or the one chosen for another field. agent_labels
+// picks the agent, and so the cluster, that is asked.
+type Source struct {
+	Kind           string
+	Namespace      string
+	NamespaceParam string
+	LabelSelector  string
+	AgentLabels    map[string]string
+}
+
+// parseSource reads the "source" of a schema property. It returns nil if there is none.
+func parseSource(raw interface{}) *Source {
+	prop, ok := raw.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	source := &Source{AgentLabels: make(map[string]string)}
+	source.Kind, _ = prop["kind"].(string)
+	source.Namespace, _ = prop["namespace"].(string)
+	source.NamespaceParam, _ = prop["namespace_param"].(string)
+	source.LabelSelector, _ = prop["label_selector"].(string)
+	if labels, ok := prop["agent_labels"].(map[string]interface{}); ok {
+		for k, v := range labels {
+			source.AgentLabels[k] = fmt.Sprint(v)
+		}
+	}
+	return source
+}
+
+// Query returns the namespace to list objects in given the values chosen so far, and checks that
+// the source can be queried
+func (s *Source) Query(values map[string]string) (namespace string, err error) {
+	switch s.Kind {
+	case SourceNamespaces, SourceNodes:
+		return "", nil
+	case SourceDeployments:
+	default:
+		return "", fmt.Errorf("%w: unknown source kind %q, expected %s", ErrInvalidParams, s.Kind,
+			strings.Join([]string{SourceNamespaces, SourceDeployments, SourceNodes}, ", "))
+	}
+
+	namespace = s.Namespace
+	if s.NamespaceParam != "" {
+		namespace = values[s.NamespaceParam]
+		if namespace == "" {
+			return "", fmt.Errorf("%w: choose %s first", ErrInvalidParams, s.NamespaceParam)
+		}
+	}
+	if namespace == "" {
+		return "", fmt.Errorf("%w: source of kind %s needs namespace or namespace_param", ErrInvalidParams, s.Kind)
+	}
+	return namespace, nil
+}
+
+// OptionsProvider lists the choices of fields with a source; values are the values chosen for the
+// template's other fields so far and agentID the agent to ask, 0 for any suitable one
+type OptionsProvider interface {
+	ParamOptions(ctx context.Context, template *models.Template, field Field, values map[string]string,
+		agentID uint) ([]string, error)
+}
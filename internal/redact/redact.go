@@ -0,0 +1,354 @@
+package redact
+
+import (
+	"encoding/base64"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Rule names of the built-in redactions
+const (
+	RuleSecretParam = "secret_param"
+	RulePEM         = "pem"
+	RuleSecretData  = "secret_data"
+)
+
+// minSecretLength is the length below which secret values aren't redacted, as masking every
+// occurrence of a one or two character value would mangle the whole log
+const minSecretLength = 4
+
+// maxPendingLine is the longest partial line held back waiting for its end
+const maxPendingLine = 64 * 1024
+
+// maxMatchLength is the longest pattern match kept whole when a very long line is redacted in parts
+const maxMatchLength = 1024
+
+// masked matches the text that replaces redacted values, which rules must not match again
+var masked = regexp.MustCompile(`\[REDACTED:[^\]]*\]`)
+
+// Rule redacts every match of a pattern. If the pattern has a group named "secret", only that part
+// of the match is masked.
+type Rule struct {
+	Name    string `yaml:"name"`
+	Pattern string `yaml:"pattern"`
+	regexp  *regexp.Regexp
+}
+
+// DefaultRules returns the built-in pattern rules
+func DefaultRules() []Rule {
+	rules := []Rule{
+		{Name: "aws_access_key", Pattern: `\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`},
+		{Name: "aws_secret_key", Pattern: `(?i)aws_secret_access_key["']?\s*[:=]\s*["']?(?P<secret>[A-Za-z0-9/+=]{40})`},
+		{Name: "jwt", Pattern: `\beyJ[A-Za-z0-9_-]{5,}\.eyJ[A-Za-z0-9_-]{5,}\.[A-Za-z0-9_-]+`},
+		{Name: "bearer_token", Pattern: `(?i)\bbearer\s+(?P<secret>[A-Za-z0-9._~+/-]{16,}=*)`},
+		{Name: "password_assignment", Pattern: `(?i)(?:password|passwd|secret|token|api[_-]?key)["']?\s*[:=]\s*["']?(?P<secret>[^\s"',]{8,})`},
+	}
+	for i := range rules {
+		rules[i].regexp = regexp.MustCompile(rules[i].Pattern)
+	}
+	return rules
+}
+
+// LoadRules returns the built-in rules followed by those in a YAML file of name/pattern pairs, if
+// one is given
+func LoadRules(path string) ([]Rule, error) {
+	rules := DefaultRules()
+	if path == "" {
+		return rules, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read redaction rules: %w", err)
+	}
+	var extra []Rule
+	if err := yaml.Unmarshal(data, &extra); err != nil {
+		return nil, fmt.Errorf("failed to parse redaction rules: %w", err)
+	}
+	for i := range extra {
+		if extra[i].Name == "" {
+			return nil, fmt.Errorf("redaction rule %d: name is required", i)
+		}
+		re, err := regexp.Compile(extra[i].Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("redaction rule %s: %w", extra[i].Name, err)
+		}
+		extra[i].regexp = re
+	}
+
+	return append(rules, extra...), nil
+}
+
+// Mask returns the text that replaces a redacted value
+func Mask(rule string) string {
+	return "[REDACTED:" + rule + "]"
+}
+
+// Redactor masks secret values and pattern matches in log lines and counts what it masked. It is
+// safe for concurrent use, so one redactor can serve all output streams of a task.
+type Redactor struct {
+	rules  []Rule
+	values []string // secret values and their base64 encodings, longest first
+	mu     sync.Mutex
+	counts map[string]int
+}
+
+// New creates a redactor for a task with the given rules and secret values
+func New(rules []Rule, secretValues []string) *Redactor {
+	r := &Redactor{rules: rules, counts: make(map[string]int)}
+	for _, value := range secretValues {
+		if len(value) < minSecretLength {
+			continue
+		}
+		// kubectl and helm print Secret data base64 encoded
+		r.values = append(r.values, value,
+			base64.StdEncoding.EncodeToString([]byte(value)),
+			base64.RawStdEncoding.EncodeToString([]byte(value)))
+	}
+	sort.Slice(r.values, func(i, j int) bool { return len(r.values[i]) > len(r.values[j]) })
+	return r
+}
+
+// Counts returns the number of redactions made so far by rule
+func (r *Redactor) Counts() map[string]int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	counts := make(map[string]int, len(r.counts))
+	for rule, n := range r.counts {
+		counts[rule] = n
+	}
+	return counts
+}
+
+// count records redactions
+func (r *Redactor) count(rule string, n int) {
+	if n == 0 {
+		return
+	}
+	r.mu.Lock()
+	r.counts[rule] += n
+	r.mu.Unlock()
+}
+
+// Line redacts secret values and pattern matches in a line. Rules only look at the text between
+// what is already masked, so a mask is neither masked nor counted again.
+func (r *Redactor) Line(line string) string {
+	for _, value := range r.values {
+		if n := strings.Count(line, value); n > 0 {
+			line = strings.ReplaceAll(line, value, Mask(RuleSecretParam))
+			r.count(RuleSecretParam, n)
+		}
+	}
+
+	for _, rule := range r.rules {
+		secret := rule.regexp.SubexpIndex("secret")
+		n := 0
+		line = unmasked(line, func(text string) string {
+			return rule.regexp.ReplaceAllStringFunc(text, func(match string) string {
+				n++
+				if secret < 0 {
+					return Mask(rule.Name)
+				}
+				sub := rule.regexp.FindStringSubmatchIndex(match)
+				if sub == nil || sub[2*secret] < 0 {
+					return Mask(rule.Name)
+				}
+				return match[:sub[2*secret]] + Mask(rule.Name) + match[sub[2*secret+1]:]
+			})
+		})
+		r.count(rule.Name, n)
+	}
+
+	return line
+}
+
+// unmasked applies redact to each part of a line between masks, leaving the masks as they are
+func unmasked(line string, redact func(string) string) string {
+	masks := masked.FindAllStringIndex(line, -1)
+	if masks == nil {
+		return redact(line)
+	}
+
+	var out strings.Builder
+	start := 0
+	for _, m := range masks {
+		out.WriteString(redact(line[start:m[0]]))
+		out.WriteString(line[m[0]:m[1]])
+		start = m[1]
+	}
+	out.WriteString(redact(line[start:]))
+	return out.String()
+}
+
+// overlap returns how much of a very long line is held back when the rest is redacted, enough for
+// the longest secret value and pattern match to arrive whole
+func (r *Redactor) overlap() int {
+	if len(r.values) > 0 && len(r.values[0]) > maxMatchLength {
+		return len(r.values[0])
+	}
+	return maxMatchLength
+}
+
+// matches returns the spans of secret values and pattern matches in text
+func (r *Redactor) matches(text string) [][]int {
+	var spans [][]int
+	for _, value := range r.values {
+		for start := 0; ; {
+			i := strings.Index(text[start:], value)
+			if i < 0 {
+				break
+			}
+			spans = append(spans, []int{start + i, start + i + len(value)})
+			start += i + 1
+		}
+	}
+	for _, rule := range r.rules {
+		spans = append(spans, rule.regexp.FindAllStringIndex(text, -1)...)
+	}
+	return spans
+}
+
+var (
+	pemBegin = regexp.MustCompile(`-----BEGIN [A-Z0-9 ]+-----`)
+	pemEnd   = regexp.MustCompile(`-----END [A-Z0-9 ]+-----`)
+
+	// dataBlock starts the data of a Secret in YAML (data:, stringData:) or JSON ("data": {)
+	dataBlock = regexp.MustCompile(`^(\s*)(?:(data|stringData):\s*$|"(data|stringData)":\s*\{\s*$)`)
+	// dataEntry is a key and value inside a data block
+	dataEntry = regexp.MustCompile(`^(\s*"?[\w.-]+"?:\s*"?)([^"\s]+)("?,?\s*)$`)
+	// base64Value is what Secret data values look like
+	base64Value = regexp.MustCompile(`^[A-Za-z0-9+/]{8,}={0,2}$`)
+)
+
+// Stream redacts a log stream that arrives in arbitrary chunks. It works on whole lines, holding a
+// partial line back until the rest arrives, so a secret split across chunks is still found. It also
+// masks multi-line PEM blocks and the values of Kubernetes Secret data blocks.
+type Stream struct {
+	redactor *Redactor
+	pending  string
+	inPEM    bool
+	data     struct {
+		active     bool
+		indent     int
+		json       bool
+		stringData bool
+	}
+}
+
+// NewStream creates a stream that redacts with the given redactor
+func NewStream(redactor *Redactor) *Stream {
+	return &Stream{redactor: redactor}
+}
+
+// Write adds a chunk and returns the redacted text of the lines it completed
+func (s *Stream) Write(chunk string) string {
+	s.pending += chunk
+
+	var out strings.Builder
+	for {
+		i := strings.IndexByte(s.pending, '\n')
+		if i < 0 {
+			break
+		}
+		if line, ok := s.line(s.pending[:i]); ok {
+			out.WriteString(line)
+			out.WriteByte('\n')
+		}
+		s.pending = s.pending[i+1:]
+	}
+
+	// A very long line is redacted in parts rather than held back indefinitely
+	if len(s.pending) > maxPendingLine {
+		cut := s.cut()
+		line, _ := s.line(s.pending[:cut])
+		out.WriteString(line)
+		s.pending = s.pending[cut:]
+	}
+
+	return out.String()
+}
+
+// cut returns where to split a very long pending line: before the part held back for the next
+// chunk, moved further back so that no secret value or pattern match spans the split
+func (s *Stream) cut() int {
+	cut := len(s.pending) - s.redactor.overlap()
+	spans := s.redactor.matches(s.pending)
+	for moved := true; moved && cut > 0; {
+		moved = false
+		for _, span := range spans {
+			if span[0] < cut && cut < span[1] {
+				cut, moved = span[0], true
+			}
+		}
+	}
+	if cut <= 0 {
+		return len(s.pending)
+	}
+	return cut
+}
+
+// Flush returns the redacted text of a final line without a newline
+func (s *Stream) Flush() string {
+	line, _ := s.line(s.pending)
+	s.pending = ""
+	return line
+}
+
+// line redacts a complete line, tracking PEM and Secret data blocks across lines. ok is false for
+// lines inside a PEM block, which are dropped.
+func (s *Stream) line(line string) (string, bool) {
+	if s.inPEM {
+		loc := pemEnd.FindStringIndex(line)
+		if loc == nil {
+			return "", false
+		}
+		s.inPEM = false
+		if rest := line[loc[1]:]; strings.TrimSpace(rest) != "" {
+			return s.line(rest)
+		}
+		return "", false
+	}
+	if loc := pemBegin.FindStringIndex(line); loc != nil {
+		s.redactor.count(RulePEM, 1)
+		prefix := s.redactor.Line(line[:loc[0]]) + Mask(RulePEM)
+		rest := line[loc[1]:]
+		if end := pemEnd.FindStringIndex(rest); end != nil {
+			suffix, _ := s.line(rest[end[1]:])
+			return prefix + suffix, true
+		}
+		s.inPEM = true
+		return prefix, true
+	}
+
+	if s.data.active {
+		indent := len(line) - len(strings.TrimLeft(line, " \t"))
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case trimmed == "":
+		case s.data.json && strings.HasPrefix(trimmed, "}"):
+			s.data.active = false
+		case !s.data.json && indent <= s.data.indent:
+			s.data.active = false
+		default:
+			if m := dataEntry.FindStringSubmatch(line); m != nil && (s.data.stringData || base64Value.MatchString(m[2])) {
+				s.redactor.count(RuleSecretData, 1)
+				return m[1] + Mask(RuleSecretData) + m[3], true
+			}
+		}
+	}
+	if m := dataBlock.FindStringSubmatch(line); m != nil {
+		s.data.active = true
+		s.data.indent = len(m[1])
+		s.data.json = m[3] != ""
+		s.data.stringData = m[2] == "stringData" || m[3] == "stringData"
+	}
+
+	return s.redactor.Line(line), true
+}
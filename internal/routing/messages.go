@@ -0,0 +1,124 @@
+package routing
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/BogdanDolia/ops-butler/internal/models"
+)
+
+// maxMessageBody is the longest message template body accepted
+const maxMessageBody = 4000
+
+// MessageData is the data message templates are rendered with
+type MessageData struct {
+	TaskID       uint
+	TemplateName string
+	Tags         []string
+	Params       map[string]interface{}
+	Origin       string
+	State        string
+	DueAt        *time.Time
+	Duration     time.Duration // how long a finished task ran, 0 if unknown
+	ExitCode     *int
+	Escalated    bool   // the reminder is an escalation nobody acted on
+	Mention      string // the route's or escalation policy's mention
+}
+
+// Built-in message bodies, used when a route has no message template of a kind
+const (
+	defaultReminderBody = `{{with .Mention}}{{.}} {{end}}` +
+		`{{if .Escalated}}Escalation: task #{{.TaskID}} ({{.TemplateName}}) is due and nobody has acted on it yet.` +
+		`{{else}}Task #{{.TaskID}} ({{.TemplateName}}) is due.{{end}}`
+	defaultCompletionBody = `{{with .Mention}}{{.}} {{end}}Task #{{.TaskID}} ({{.TemplateName}}) {{.State}}` +
+		`{{with .Duration}} in {{.}}{{end}}{{with .ExitCode}} with exit code {{.}}{{end}}.`
+)
+
+// DefaultBody returns the built-in message body of a kind
+func DefaultBody(kind models.MessageKind) string {
+	if kind == models.MessageKindReminder {
+		return defaultReminderBody
+	}
+	return defaultCompletionBody
+}
+
+// funcs are the functions available to message templates besides the text/template builtins
+var funcs = template.FuncMap{
+	"join":  strings.Join,
+	"lower": strings.ToLower,
+	"upper": strings.ToUpper,
+	"date": func(layout string, t *time.Time) string {
+		if t == nil {
+			return ""
+		}
+		return t.UTC().Format(layout)
+	},
+}
+
+// Render renders a message template body with the given data
+func Render(body string, data MessageData) (string, error) {
+	tmpl, err := template.New("message").Funcs(funcs).Parse(body)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse message template: %w", err)
+	}
+
+	var b strings.Builder
+	if err := tmpl.Execute(&b, data); err != nil {
+		return "", fmt.Errorf("failed to render message template: %w", err)
+	}
+
+	text := strings.TrimSpace(b.String())
+	if text == "" {
+		return "", fmt.Errorf("message template rendered an empty message")
+	}
+	return text, nil
+}
+
+// ValidateMessage checks a message template: its kind is known and its body renders with sample data
+func ValidateMessage(message *models.MessageTemplate) error {
+	if message.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	switch message.Kind {
+	case models.MessageKindReminder, models.MessageKindCompletion, models.MessageKindFailure:
+	default:
+		return fmt.Errorf("unknown message kind %q", message.Kind)
+	}
+	if len(message.Body) > maxMessageBody {
+		return fmt.Errorf("body must be at most %d characters", maxMessageBody)
+	}
+
+	_, err := Render(message.Body, SampleData(message.Kind))
+	return err
+}
+
+// SampleData returns made-up data for previewing a message of a kind
+func SampleData(kind models.MessageKind) MessageData {
+	due := time.Now().UTC().Truncate(time.Minute)
+	data := MessageData{
+		TaskID:       42,
+		TemplateName: "restart-deployment",
+		Tags:         []string{"team:payments"},
+		Params:       map[string]interface{}{"namespace": "payments", "deployment": "api"},
+		Origin:       string(models.TaskOriginScheduler),
+		State:        string(models.TaskStateScheduled),
+		DueAt:        &due,
+		Mention:      "@oncall",
+	}
+
+	switch kind {
+	case models.MessageKindCompletion, models.MessageKindFailure:
+		exitCode := 0
+		data.State = string(models.TaskStateCompleted)
+		if kind == models.MessageKindFailure {
+			exitCode = 1
+			data.State = string(models.TaskStateFailed)
+		}
+		data.Duration = 83 * time.Second
+		data.ExitCode = &exitCode
+	}
+
+	return data
+}
@@ -0,0 +1,195 @@
+package routing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"go.uber.org/zap"
+
+	"github.com/BogdanDolia/ops-butler/internal/database"
+	"github.com/BogdanDolia/ops-butler/internal/models"
+)
+
+// Platforms are the chat platforms a route can post to
+var Platforms = []string{"slack", "google_chat", "teams", "mattermost"}
+
+// Validate checks a chat route for consistency
+func Validate(route *models.ChatRoute) error {
+	if route.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	if route.ChatType != "" && !contains(Platforms, route.ChatType) {
+		return fmt.Errorf("unknown chat type %q", route.ChatType)
+	}
+	if route.ChatID != "" && route.ChatType == "" {
+		return fmt.Errorf("chat_type is required when chat_id is set")
+	}
+	return nil
+}
+
+// Matches reports whether a route applies to a task of the given template and origin running on an
+// agent with the given labels. Agent selectors only match when the target agent is known.
+func Matches(route *models.ChatRoute, template *models.Template, agentLabels map[string]interface{}, origin models.TaskOrigin) bool {
+	if !route.Enabled {
+		return false
+	}
+
+	if len(route.Templates) > 0 {
+		attached := false
+		for _, t := range route.Templates {
+			if t.ID == template.ID {
+				attached = true
+				break
+			}
+		}
+		if !attached {
+			return false
+		}
+	}
+
+	if len(route.Tags) > 0 {
+		tagged := false
+		for _, tag := range template.Tags {
+			if contains(route.Tags, tag) {
+				tagged = true
+				break
+			}
+		}
+		if !tagged {
+			return false
+		}
+	}
+
+	if len(route.Origins) > 0 && !contains(route.Origins, string(origin)) {
+		return false
+	}
+
+	for key, want := range route.AgentSelector {
+		got, ok := agentLabels[key]
+		if !ok || fmt.Sprint(got) != fmt.Sprint(want) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Router picks the chat route of a task and renders its messages
+type Router struct {
+	logger   *zap.Logger
+	routes   database.ChatRouteRepository
+	messages database.MessageTemplateRepository
+	agents   database.AgentRepository
+}
+
+// NewRouter creates a new Router
+func NewRouter(logger *zap.Logger, routes database.ChatRouteRepository, messages database.MessageTemplateRepository,
+	agents database.AgentRepository) *Router {
+	return &Router{
+		logger:   logger,
+		routes:   routes,
+		messages: messages,
+		agents:   agents,
+	}
+}
+
+// Route returns the first enabled route that matches a task, or nil if none does
+func (r *Router) Route(ctx context.Context, task *models.TaskInstance, template *models.Template) (*models.ChatRoute, error) {
+	routes, err := r.routes.ListEnabled(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list chat routes: %w", err)
+	}
+	if len(routes) == 0 {
+		return nil, nil
+	}
+
+	var labels map[string]interface{}
+	if task.AgentID != nil {
+		agent, err := r.agents.GetByID(ctx, *task.AgentID)
+		if err != nil && !errors.Is(err, database.ErrNotFound) {
+			return nil, fmt.Errorf("failed to get agent: %w", err)
+		}
+		if agent != nil {
+			labels = agent.Labels
+		}
+	}
+
+	for _, route := range routes {
+		if Matches(route, template, labels, task.Origin) {
+			return route, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// RouteByID returns a route, or nil if id is nil or the route was deleted
+func (r *Router) RouteByID(ctx context.Context, id *uint) (*models.ChatRoute, error) {
+	if id == nil {
+		return nil, nil
+	}
+
+	route, err := r.routes.GetByID(ctx, *id)
+	if err != nil {
+		if errors.Is(err, database.ErrNotFound) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get chat route: %w", err)
+	}
+
+	return route, nil
+}
+
+// Message renders a message of the given kind with the route's message template, or with the
+// built-in one if the route has none. A template that fails to render falls back to the built-in
+// one so a broken template never silences reminders.
+func (r *Router) Message(ctx context.Context, route *models.ChatRoute, kind models.MessageKind, data MessageData) (string, error) {
+	if route != nil {
+		if id := messageID(route, kind); id != nil {
+			text, err := r.render(ctx, *id, data)
+			if err == nil {
+				return text, nil
+			}
+			r.logger.Warn("Falling back to the built-in message",
+				zap.Uint("route_id", route.ID),
+				zap.String("kind", string(kind)),
+				zap.Uint("message_id", *id),
+				zap.Error(err))
+		}
+	}
+
+	return Render(DefaultBody(kind), data)
+}
+
+// render renders a stored message template
+func (r *Router) render(ctx context.Context, id uint, data MessageData) (string, error) {
+	message, err := r.messages.GetByID(ctx, id)
+	if err != nil {
+		return "", fmt.Errorf("failed to get message template: %w", err)
+	}
+	return Render(message.Body, data)
+}
+
+// messageID returns the ID of the route's message template of a kind
+func messageID(route *models.ChatRoute, kind models.MessageKind) *uint {
+	switch kind {
+	case models.MessageKindReminder:
+		return route.ReminderMessageID
+	case models.MessageKindCompletion:
+		return route.CompletionMessageID
+	case models.MessageKindFailure:
+		return route.FailureMessageID
+	}
+	return nil
+}
+
+// contains checks if a string is present in a slice
+func contains(slice []string, item string) bool {
+	for _, s := range slice {
+		if s == item {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,67 @@
+package routing
+
+import (
+	"testing"
+
+	"github.com/BogdanDolia/ops-butler/internal/models"
+)
+
+func TestMatches(t *testing.T) {
+	template := &models.Template{Tags: models.StringList{"team:payments", "db"}}
+	template.ID = 7
+	attached := models.Template{}
+	attached.ID = 7
+	other := models.Template{}
+	other.ID = 8
+	labels := map[string]interface{}{"env": "prod", "replicas": 3}
+
+	tests := []struct {
+		name   string
+		route  models.ChatRoute
+		labels map[string]interface{}
+		origin models.TaskOrigin
+		want   bool
+	}{
+		{"catch-all", models.ChatRoute{Enabled: true}, nil, models.TaskOriginWeb, true},
+		{"disabled", models.ChatRoute{}, nil, models.TaskOriginWeb, false},
+		{"attached template", models.ChatRoute{Enabled: true, Templates: []models.Template{other, attached}}, nil, models.TaskOriginWeb, true},
+		{"other template", models.ChatRoute{Enabled: true, Templates: []models.Template{other}}, nil, models.TaskOriginWeb, false},
+		{"any tag", models.ChatRoute{Enabled: true, Tags: models.StringList{"team:search", "db"}}, nil, models.TaskOriginWeb, true},
+		{"no tag", models.ChatRoute{Enabled: true, Tags: models.StringList{"team:search"}}, nil, models.TaskOriginWeb, false},
+		{"origin", models.ChatRoute{Enabled: true, Origins: models.StringList{"scheduler"}}, nil, models.TaskOriginScheduler, true},
+		{"other origin", models.ChatRoute{Enabled: true, Origins: models.StringList{"scheduler"}}, nil, models.TaskOriginSlack, false},
+		{"agent labels", models.ChatRoute{Enabled: true, AgentSelector: models.JSONSchema{"env": "prod", "replicas": "3"}}, labels, models.TaskOriginWeb, true},
+		{"other agent label", models.ChatRoute{Enabled: true, AgentSelector: models.JSONSchema{"env": "dev"}}, labels, models.TaskOriginWeb, false},
+		{"agent not known yet", models.ChatRoute{Enabled: true, AgentSelector: models.JSONSchema{"env": "prod"}}, nil, models.TaskOriginWeb, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Matches(&tt.route, template, tt.labels, tt.origin); got != tt.want {
+				t.Errorf("Matches() = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		route   models.ChatRoute
+		wantErr bool
+	}{
+		{"default platform", models.ChatRoute{Name: "all"}, false},
+		{"channel", models.ChatRoute{Name: "payments", ChatType: "slack", ChatID: "C1"}, false},
+		{"no name", models.ChatRoute{ChatType: "slack"}, true},
+		{"unknown platform", models.ChatRoute{Name: "irc", ChatType: "irc"}, true},
+		{"channel without platform", models.ChatRoute{Name: "payments", ChatID: "C1"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := Validate(&tt.route); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %t", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,200 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/BogdanDolia/ops-butler/internal/chatops"
+	"github.com/BogdanDolia/ops-butler/internal/models"
+)
+
+// logPageSize is the number of log chunks read per query when collecting a task's log
+const logPageSize = 500
+
+// processCompletions posts the outcome of finished tasks to their chat threads
+func (s *Scheduler) processCompletions() {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(s.config.PollingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := s.notifyCompletions(context.Background()); err != nil {
+				s.logger.Error("Failed to notify task completions", zap.Error(err))
+			}
+		case <-s.stopCh:
+			return
+		}
+	}
+}
+
+// notifyCompletions posts the outcome of tasks that finished recently and haven't been announced
+func (s *Scheduler) notifyCompletions(ctx context.Context) error {
+	since := time.Now().Add(-s.config.CompletionNoticeWindow)
+	tasks, err := s.tasks.ListUnnotified(ctx, since, s.config.MaxConcurrentTasks)
+	if err != nil {
+		return fmt.Errorf("failed to list finished tasks: %w", err)
+	}
+
+	for _, task := range tasks {
+		messages, err := s.completionMessages(ctx, task)
+		if err != nil {
+			s.logger.Error("Failed to prepare task outcome",
+				zap.Uint("task_id", task.ID),
+				zap.Error(err))
+		}
+
+		// Mark the task even if preparing the outcome failed so one broken task doesn't block the rest
+		task.NotifiedAt = timePtr(time.Now())
+		if err := s.outbox.Enqueue(ctx, messages, task); err != nil {
+			return fmt.Errorf("failed to mark task %d notified: %w", task.ID, err)
+		}
+	}
+
+	return nil
+}
+
+// completionMessages returns the reply with a task's outcome and a log excerpt for its thread, and
+// the full log as a file attachment
+func (s *Scheduler) completionMessages(ctx context.Context, task *models.TaskInstance) ([]*models.OutboxMessage, error) {
+	platform, channel, thread := chatops.ParseThreadRef(task.ChatThread)
+	if platform == "" {
+		return nil, nil
+	}
+
+	template, err := s.templates.GetByID(ctx, task.TemplateID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get template: %w", err)
+	}
+
+	logs, err := s.taskLogs(ctx, task.ID)
+	if err != nil {
+		return nil, err
+	}
+	var full strings.Builder
+	for _, chunk := range logs {
+		full.WriteString(chunk.Chunk)
+	}
+
+	route, err := s.router.Route(ctx, task, template)
+	if err != nil {
+		return nil, err
+	}
+	kind := models.MessageKindCompletion
+	if task.State == models.TaskStateFailed {
+		kind = models.MessageKindFailure
+	}
+	data := messageData(task, template)
+	data.Duration = runDuration(task, logs)
+	if route != nil {
+		data.Mention = route.Mention
+	}
+	summary, err := s.router.Message(ctx, route, kind, data)
+	if err != nil {
+		return nil, err
+	}
+
+	text := summary
+	if full.Len() > 0 {
+		budget := chatops.MaxMessageLength(platform) - len(summary) - len("\n```\n\n```")
+		text += "\n```\n" + logExcerpt(full.String(), s.config.LogExcerptHeadLines, s.config.LogExcerptTailLines, budget) + "\n```"
+	}
+
+	messages := []*models.OutboxMessage{{
+		IdempotencyKey: fmt.Sprintf("task:%d:outcome", task.ID),
+		Kind:           models.OutboxKindReply,
+		Platform:       platform,
+		Channel:        channel,
+		Thread:         thread,
+		Text:           text,
+		TaskID:         &task.ID,
+	}}
+	if full.Len() > 0 {
+		messages = append(messages, &models.OutboxMessage{
+			IdempotencyKey: fmt.Sprintf("task:%d:log", task.ID),
+			Kind:           models.OutboxKindFile,
+			Platform:       platform,
+			Channel:        channel,
+			Thread:         thread,
+			Filename:       fmt.Sprintf("task-%d.log", task.ID),
+			Text:           full.String(),
+			TaskID:         &task.ID,
+		})
+	}
+
+	return messages, nil
+}
+
+// taskLogs reads all log chunks of a task in order
+func (s *Scheduler) taskLogs(ctx context.Context, taskID uint) ([]*models.ExecutionLog, error) {
+	var all []*models.ExecutionLog
+	for offset := 0; ; offset += logPageSize {
+		page, err := s.logs.ListByTaskID(ctx, taskID, offset, logPageSize)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list task logs: %w", err)
+		}
+		all = append(all, page...)
+		if len(page) < logPageSize {
+			break
+		}
+	}
+	return all, nil
+}
+
+// runDuration returns how long a finished task ran, measured from its first log chunk if its
+// start wasn't recorded, or 0 if unknown
+func runDuration(task *models.TaskInstance, logs []*models.ExecutionLog) time.Duration {
+	start := task.StartedAt
+	if start == nil && len(logs) > 0 {
+		start = &logs[0].Timestamp
+	}
+	if start == nil || task.CompletedAt == nil {
+		return 0
+	}
+	return task.CompletedAt.Sub(*start).Round(time.Second)
+}
+
+// logExcerpt returns the first head and last tail lines of a log, dropping lines from the middle
+// until it fits in budget characters
+func logExcerpt(log string, head, tail, budget int) string {
+	lines := strings.Split(strings.TrimRight(log, "\n"), "\n")
+	if head < 0 {
+		head = 0
+	}
+	if tail < 0 {
+		tail = 0
+	}
+	if head+tail > len(lines) {
+		head, tail = len(lines), 0
+	}
+
+	for {
+		excerpt := strings.Join(lines[:head], "\n")
+		if omitted := len(lines) - head - tail; omitted > 0 {
+			excerpt += fmt.Sprintf("\n… %d lines omitted, see the attached log …", omitted)
+		}
+		if tail > 0 {
+			excerpt += "\n" + strings.Join(lines[len(lines)-tail:], "\n")
+		}
+
+		if len(excerpt) <= budget || head+tail == 0 {
+			if len(excerpt) > budget && budget > 0 {
+				excerpt = excerpt[:budget]
+			}
+			return excerpt
+		}
+
+		// Drop from the end of the head first, then from the start of the tail
+		if head > 0 && head >= tail {
+			head--
+		} else {
+			tail--
+		}
+	}
+}
@@ -4,30 +4,63 @@ import (
 	"fmt"
 	"os"
 	"strconv"
+	"strings"
 	"time"
+
+	"github.com/BogdanDolia/ops-butler/internal/chatops"
 )
 
 // Config holds the scheduler configuration
 type Config struct {
-	PollingInterval    time.Duration
-	MaxConcurrentTasks int
-	RedisURL           string
-	RedisPassword      string
-	RedisDB            int
-	LogLevel           string
-	LogFormat          string
+	PollingInterval         time.Duration
+	ReconcileInterval       time.Duration
+	MaxConcurrentTasks      int
+	CalendarRecheckInterval time.Duration
+	BusinessMorningHour     int
+	LogExcerptHeadLines     int
+	LogExcerptTailLines     int
+	CompletionNoticeWindow  time.Duration
+	OutboxInterval          time.Duration // how often the outbox is checked for due messages
+	OutboxLease             time.Duration // how long a claimed message is left alone before it is retried
+	OutboxRetryBase         time.Duration // delay before the first retry, doubled for each further attempt
+	OutboxRetryMax          time.Duration
+	OutboxMaxAttempts       int // attempts before a message is dead-lettered
+	RedisURL                string
+	RedisPassword           string
+	RedisDB                 int
+	LogLevel                string
+	LogFormat               string
+	SecretsKEKFile          string // see config.SecretsConfig; needed to seal secret params of workflow steps
+	SecretsPreviousKEKFiles []string
+	AgentTimeout            time.Duration // see config.GRPCConfig; agents silent for longer can't take workflow steps
+	ChatOps                 *chatops.Config
 }
 
 // NewConfig creates a new scheduler configuration from environment variables
 func NewConfig() *Config {
 	return &Config{
-		PollingInterval:    getEnvAsDuration("SCHEDULER_POLLING_INTERVAL", 30*time.Second),
-		MaxConcurrentTasks: getEnvAsInt("SCHEDULER_MAX_CONCURRENT_TASKS", 10),
-		RedisURL:           getEnv("REDIS_URL", "localhost:6379"),
-		RedisPassword:      getEnv("REDIS_PASSWORD", ""),
-		RedisDB:            getEnvAsInt("REDIS_DB", 0),
-		LogLevel:           getEnv("LOG_LEVEL", "info"),
-		LogFormat:          getEnv("LOG_FORMAT", "json"),
+		PollingInterval:         getEnvAsDuration("SCHEDULER_POLLING_INTERVAL", 30*time.Second),
+		ReconcileInterval:       getEnvAsDuration("SCHEDULER_RECONCILE_INTERVAL", 5*time.Minute),
+		MaxConcurrentTasks:      getEnvAsInt("SCHEDULER_MAX_CONCURRENT_TASKS", 10),
+		CalendarRecheckInterval: getEnvAsDuration("SCHEDULER_CALENDAR_RECHECK_INTERVAL", time.Hour),
+		BusinessMorningHour:     getEnvAsInt("SCHEDULER_BUSINESS_MORNING_HOUR", 9),
+		LogExcerptHeadLines:     getEnvAsInt("SCHEDULER_LOG_EXCERPT_HEAD_LINES", 40),
+		LogExcerptTailLines:     getEnvAsInt("SCHEDULER_LOG_EXCERPT_TAIL_LINES", 10),
+		CompletionNoticeWindow:  getEnvAsDuration("SCHEDULER_COMPLETION_NOTICE_WINDOW", 24*time.Hour),
+		OutboxInterval:          getEnvAsDuration("SCHEDULER_OUTBOX_INTERVAL", 5*time.Second),
+		OutboxLease:             getEnvAsDuration("SCHEDULER_OUTBOX_LEASE", 2*time.Minute),
+		OutboxRetryBase:         getEnvAsDuration("SCHEDULER_OUTBOX_RETRY_BASE", 15*time.Second),
+		OutboxRetryMax:          getEnvAsDuration("SCHEDULER_OUTBOX_RETRY_MAX", time.Hour),
+		OutboxMaxAttempts:       getEnvAsInt("SCHEDULER_OUTBOX_MAX_ATTEMPTS", 10),
+		RedisURL:                getEnv("REDIS_URL", "localhost:6379"),
+		RedisPassword:           getEnv("REDIS_PASSWORD", ""),
+		RedisDB:                 getEnvAsInt("REDIS_DB", 0),
+		LogLevel:                getEnv("LOG_LEVEL", "info"),
+		LogFormat:               getEnv("LOG_FORMAT", "json"),
+		SecretsKEKFile:          getEnv("SECRETS_KEK_FILE", ""),
+		SecretsPreviousKEKFiles: getEnvAsSlice("SECRETS_PREVIOUS_KEK_FILES", nil),
+		AgentTimeout:            getEnvAsDuration("GRPC_AGENT_TIMEOUT", 2*time.Minute),
+		ChatOps:                 chatops.NewConfig(),
 	}
 }
 
@@ -68,6 +101,15 @@ func getEnvAsDuration(key string, defaultValue time.Duration) time.Duration {
 
 // String returns a string representation of the config
 func (c *Config) String() string {
-	return fmt.Sprintf("Scheduler Config: PollingInterval=%s, MaxConcurrentTasks=%d, RedisURL=%s",
-		c.PollingInterval, c.MaxConcurrentTasks, c.RedisURL)
+	return fmt.Sprintf("Scheduler Config: PollingInterval=%s, ReconcileInterval=%s, MaxConcurrentTasks=%d, RedisURL=%s",
+		c.PollingInterval, c.ReconcileInterval, c.MaxConcurrentTasks, c.RedisURL)
+}
+
+// getEnvAsSlice gets an environment variable as a slice or returns a default value
+func getEnvAsSlice(key string, defaultValue []string) []string {
+	valueStr := getEnv(key, "")
+	if valueStr == "" {
+		return defaultValue
+	}
+	return strings.Split(valueStr, ",")
 }
@@ -0,0 +1,255 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/BogdanDolia/ops-butler/internal/chatops"
+	"github.com/BogdanDolia/ops-butler/internal/database"
+	"github.com/BogdanDolia/ops-butler/internal/models"
+	"github.com/BogdanDolia/ops-butler/internal/routing"
+	"github.com/BogdanDolia/ops-butler/internal/secrets"
+)
+
+// reminderPolicy returns the policy of a reminder, or nil if it has none
+func (s *Scheduler) reminderPolicy(ctx context.Context, reminder *models.Reminder) (*models.ReminderPolicy, error) {
+	if reminder.PolicyID == nil {
+		return nil, nil
+	}
+
+	policy, err := s.policies.GetByID(ctx, *reminder.PolicyID)
+	if err != nil {
+		if errors.Is(err, database.ErrNotFound) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get reminder policy: %w", err)
+	}
+
+	return policy, nil
+}
+
+// followUpReminder repeats, escalates or takes the final action on a delivered reminder nobody has
+// acted on. The messages this posts are queued in the same transaction as the reminder update.
+func (s *Scheduler) followUpReminder(ctx context.Context, reminder *models.Reminder, task *models.TaskInstance, policy *models.ReminderPolicy) error {
+	// Someone ran or cancelled the task some other way
+	if task.State != models.TaskStateScheduled || policy == nil || reminder.FirstSentAt == nil {
+		if task.State != models.TaskStateScheduled {
+			reminder.State = models.ReminderStateActioned
+		}
+		reminder.NextActionAt = nil
+		return s.reminders.Update(ctx, reminder)
+	}
+
+	var message *models.OutboxMessage
+	updates := []interface{}{reminder}
+	now := time.Now()
+	first := *reminder.FirstSentAt
+	switch {
+	case hasFinalAction(policy) && !now.Before(first.Add(minutes(policy.FinalDeadlineMinutes))):
+		note, err := s.applyFinalAction(ctx, reminder, task, policy)
+		if err != nil {
+			return err
+		}
+		message = note
+		updates = append(updates, task)
+
+	case reminder.EscalationLevel == models.ReminderLevelInitial && policy.EscalateAfterMinutes > 0 &&
+		!now.Before(first.Add(minutes(policy.EscalateAfterMinutes))):
+		s.logger.Info("Escalating reminder",
+			zap.Uint("reminder_id", reminder.ID),
+			zap.Uint("task_id", task.ID))
+		chatType, chatID, mention := escalationTarget(reminder, policy)
+		escalation, err := s.reminderMessage(ctx, task, reminder, chatType, chatID, mention, models.ReminderLevelEscalated)
+		if err != nil {
+			return fmt.Errorf("failed to prepare escalation: %w", err)
+		}
+		message = escalation
+		reminder.EscalationLevel = models.ReminderLevelEscalated
+		reminder.EscalatedAt = timePtr(now)
+		reminder.LastSentAt = timePtr(now)
+		reminder.SendCount++
+
+	case policy.RepeatEveryMinutes > 0 && reminder.LastSentAt != nil &&
+		!now.Before(reminder.LastSentAt.Add(minutes(policy.RepeatEveryMinutes))):
+		chatType, chatID, mention := reminder.ChatType, reminder.ChatID, ""
+		if reminder.EscalationLevel == models.ReminderLevelEscalated {
+			chatType, chatID, mention = escalationTarget(reminder, policy)
+		}
+		repeat, err := s.reminderMessage(ctx, task, reminder, chatType, chatID, mention, reminder.EscalationLevel)
+		if err != nil {
+			return fmt.Errorf("failed to prepare repeated reminder: %w", err)
+		}
+		message = repeat
+		reminder.LastSentAt = timePtr(now)
+		reminder.SendCount++
+	}
+
+	var messages []*models.OutboxMessage
+	if message != nil {
+		if message.IdempotencyKey == "" {
+			message.IdempotencyKey = fmt.Sprintf("reminder:%d:%d", reminder.ID, reminder.SendCount)
+		}
+		messages = append(messages, message)
+	}
+
+	reminder.NextActionAt = nextPolicyAction(reminder, policy)
+	if err := s.outbox.Enqueue(ctx, messages, updates...); err != nil {
+		return fmt.Errorf("failed to update reminder: %w", err)
+	}
+
+	return nil
+}
+
+// applyFinalAction cancels or runs a task whose reminder passed its final deadline and returns the
+// notice to post about it, if ChatOps is enabled. Changes to task are saved by the caller.
+func (s *Scheduler) applyFinalAction(ctx context.Context, reminder *models.Reminder, task *models.TaskInstance, policy *models.ReminderPolicy) (*models.OutboxMessage, error) {
+	s.logger.Info("Reminder reached final deadline",
+		zap.Uint("reminder_id", reminder.ID),
+		zap.Uint("task_id", task.ID),
+		zap.String("action", string(policy.FinalAction)))
+
+	now := time.Now()
+	var note string
+	switch policy.FinalAction {
+	case models.ReminderFinalActionCancel:
+		if err := s.CancelTask(ctx, task.ID); err != nil {
+			return nil, fmt.Errorf("failed to auto-cancel task: %w", err)
+		}
+		task.State = models.TaskStateCancelled
+		reminder.State = models.ReminderStateCancelled
+		reminder.CancelledAt = timePtr(now)
+		note = fmt.Sprintf("Task #%d was cancelled automatically because nobody acted on its reminder.", task.ID)
+
+	case models.ReminderFinalActionRun:
+		// Pending without a due time means ready for dispatch
+		task.State = models.TaskStatePending
+		task.DueAt = nil
+		if task.ChatThread == "" && reminder.ChatType != "" {
+			task.ChatThread = chatops.ThreadRef(reminder.ChatType, reminder.ChatID, reminder.MessageID)
+		}
+		reminder.State = models.ReminderStateActioned
+		note = fmt.Sprintf("Task #%d is being run automatically because nobody acted on its reminder.", task.ID)
+	}
+	reminder.EscalationLevel = models.ReminderLevelFinal
+
+	if reminder.ChatType == "" {
+		return nil, nil
+	}
+	return &models.OutboxMessage{
+		IdempotencyKey: fmt.Sprintf("reminder:%d:final", reminder.ID),
+		Kind:           models.OutboxKindMessage,
+		Platform:       reminder.ChatType,
+		Channel:        reminder.ChatID,
+		Text:           note,
+		TaskID:         &task.ID,
+		ReminderID:     &reminder.ID,
+	}, nil
+}
+
+// reminderMessage renders a reminder message for a task with the reminder's chat route, whose
+// mention is used when no other mention is given. It returns nil if ChatOps is disabled.
+func (s *Scheduler) reminderMessage(ctx context.Context, task *models.TaskInstance, reminder *models.Reminder, chatType, chatID, mention string, level int) (*models.OutboxMessage, error) {
+	if chatType == "" {
+		s.logger.Warn("ChatOps is disabled, reminder not posted", zap.Uint("task_id", task.ID))
+		return nil, nil
+	}
+
+	template, err := s.templates.GetByID(ctx, task.TemplateID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get template: %w", err)
+	}
+
+	route, err := s.router.RouteByID(ctx, reminder.RouteID)
+	if err != nil {
+		return nil, err
+	}
+	if mention == "" && level == models.ReminderLevelInitial && route != nil {
+		mention = route.Mention
+	}
+
+	data := messageData(task, template)
+	data.Escalated = level == models.ReminderLevelEscalated
+	data.Mention = mention
+	text, err := s.router.Message(ctx, route, models.MessageKindReminder, data)
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.OutboxMessage{
+		Kind:       models.OutboxKindReminder,
+		Platform:   chatType,
+		Channel:    chatID,
+		Text:       text,
+		TaskID:     &task.ID,
+		ReminderID: &reminder.ID,
+	}, nil
+}
+
+// messageData returns the message template data of a task, with secret params masked
+func messageData(task *models.TaskInstance, template *models.Template) routing.MessageData {
+	return routing.MessageData{
+		TaskID:       task.ID,
+		TemplateName: template.Name,
+		Tags:         template.Tags,
+		Params:       secrets.Redact(task.Params),
+		Origin:       string(task.Origin),
+		State:        string(task.State),
+		DueAt:        task.DueAt,
+		ExitCode:     task.ExitCode,
+	}
+}
+
+// escalationTarget returns where escalated reminders are posted
+func escalationTarget(reminder *models.Reminder, policy *models.ReminderPolicy) (string, string, string) {
+	chatType := policy.EscalationChatType
+	if chatType == "" {
+		chatType = reminder.ChatType
+	}
+	chatID := policy.EscalationChatID
+	if chatID == "" {
+		chatID = reminder.ChatID
+	}
+	return chatType, chatID, policy.EscalationMention
+}
+
+// nextPolicyAction returns when a delivered reminder next needs attention, or nil if never
+func nextPolicyAction(reminder *models.Reminder, policy *models.ReminderPolicy) *time.Time {
+	if policy == nil || reminder.FirstSentAt == nil || reminder.EscalationLevel >= models.ReminderLevelFinal {
+		return nil
+	}
+
+	var next *time.Time
+	consider := func(t time.Time) {
+		if next == nil || t.Before(*next) {
+			next = timePtr(t)
+		}
+	}
+
+	first := *reminder.FirstSentAt
+	if hasFinalAction(policy) {
+		consider(first.Add(minutes(policy.FinalDeadlineMinutes)))
+	}
+	if reminder.EscalationLevel == models.ReminderLevelInitial && policy.EscalateAfterMinutes > 0 {
+		consider(first.Add(minutes(policy.EscalateAfterMinutes)))
+	}
+	if policy.RepeatEveryMinutes > 0 && reminder.LastSentAt != nil {
+		consider(reminder.LastSentAt.Add(minutes(policy.RepeatEveryMinutes)))
+	}
+
+	return next
+}
+
+// hasFinalAction reports whether a policy takes an action at its final deadline
+func hasFinalAction(policy *models.ReminderPolicy) bool {
+	return policy.FinalDeadlineMinutes > 0 &&
+		(policy.FinalAction == models.ReminderFinalActionCancel || policy.FinalAction == models.ReminderFinalActionRun)
+}
+
+// minutes converts a number of minutes to a duration
+func minutes(n int) time.Duration {
+	return time.Duration(n) * time.Minute
+}
@@ -0,0 +1,274 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/BogdanDolia/ops-butler/internal/database"
+	"github.com/BogdanDolia/ops-butler/internal/models"
+)
+
+// reconcilePageSize is the number of rows read per query when reconciling the due queue
+const reconcilePageSize = 1000
+
+// runQueue sleeps until the earliest due time in the queue, a schedule change or the next
+// reconciliation, then fires whatever is due
+func (s *Scheduler) runQueue(ctx context.Context) {
+	defer s.wg.Done()
+
+	var nextReconcile time.Time
+	for {
+		now := time.Now()
+		if s.reconcileNow.Swap(false) || !now.Before(nextReconcile) {
+			if err := s.reconcile(ctx, now); err != nil {
+				s.logger.Error("Failed to reconcile due tasks and reminders", zap.Error(err))
+			}
+			nextReconcile = now.Add(s.reconcileInterval())
+		}
+
+		s.fireDue(ctx)
+
+		wait := time.Until(nextReconcile)
+		if at, ok := s.queue.next(); ok && time.Until(at) < wait {
+			wait = time.Until(at)
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-s.queue.wake:
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		}
+		timer.Stop()
+	}
+}
+
+// reconcileInterval returns how often the due queue is rebuilt from the database. Without
+// change notifications the queue only learns about new rows this way, so it runs as often as
+// the old polling loop did.
+func (s *Scheduler) reconcileInterval() time.Duration {
+	if s.listening.Load() {
+		return s.config.ReconcileInterval
+	}
+	return s.config.PollingInterval
+}
+
+// requestReconcile makes the queue loop reconcile on its next iteration
+func (s *Scheduler) requestReconcile() {
+	s.reconcileNow.Store(true)
+	s.queue.signal()
+}
+
+// reconcile loads every task and reminder due before the next reconciliation into the queue
+func (s *Scheduler) reconcile(ctx context.Context, now time.Time) error {
+	horizon := now.Add(s.reconcileInterval())
+
+	for offset := 0; ; offset += reconcilePageSize {
+		tasks, err := s.tasks.ListDue(ctx, horizon, offset, reconcilePageSize)
+		if err != nil {
+			return fmt.Errorf("failed to list due tasks: %w", err)
+		}
+		for _, task := range tasks {
+			s.trackTask(task)
+		}
+		if len(tasks) < reconcilePageSize {
+			break
+		}
+	}
+
+	for offset := 0; ; offset += reconcilePageSize {
+		reminders, err := s.reminders.ListDue(ctx, horizon, offset, reconcilePageSize)
+		if err != nil {
+			return fmt.Errorf("failed to list due reminders: %w", err)
+		}
+		for _, reminder := range reminders {
+			s.trackReminder(reminder)
+		}
+		if len(reminders) < reconcilePageSize {
+			break
+		}
+	}
+
+	s.logger.Debug("Reconciled due queue",
+		zap.Int("entries", s.queue.len()),
+		zap.Time("horizon", horizon))
+	return nil
+}
+
+// fireDue processes every queue entry whose time has come
+func (s *Scheduler) fireDue(ctx context.Context) {
+	for ctx.Err() == nil {
+		key, at, ok := s.queue.popDue(time.Now())
+		if !ok {
+			return
+		}
+
+		var err error
+		switch key.kind {
+		case entryTask:
+			err = s.fireTask(ctx, key.id)
+		case entryReminder:
+			err = s.fireReminder(ctx, key.id)
+		}
+		if err != nil {
+			s.logger.Error("Failed to process due entry, retrying later",
+				zap.Int("kind", int(key.kind)),
+				zap.Uint("id", key.id),
+				zap.Error(err))
+			s.queue.schedule(key, time.Now().Add(s.config.PollingInterval))
+			continue
+		}
+
+		s.logger.Debug("Processed due entry",
+			zap.Int("kind", int(key.kind)),
+			zap.Uint("id", key.id),
+			zap.Duration("delay", time.Since(at)))
+	}
+}
+
+// fireTask holds a due task outside its maintenance windows or creates its reminder
+func (s *Scheduler) fireTask(ctx context.Context, id uint) error {
+	task, err := s.tasks.GetByID(ctx, id)
+	if err != nil {
+		if errors.Is(err, database.ErrNotFound) {
+			return nil
+		}
+		return fmt.Errorf("failed to get task: %w", err)
+	}
+
+	// The entry may be stale; act only on what the task says now
+	if task.State != models.TaskStatePending || task.DueAt == nil {
+		return nil
+	}
+	if task.DueAt.After(time.Now()) {
+		s.trackTask(task)
+		return nil
+	}
+
+	held, err := s.holdOutsideWindow(ctx, task)
+	if err != nil {
+		return fmt.Errorf("failed to check maintenance calendars: %w", err)
+	}
+	if held {
+		s.trackTask(task)
+		return nil
+	}
+
+	return s.createReminder(ctx, task)
+}
+
+// fireReminder sends a due reminder or runs its next policy action
+func (s *Scheduler) fireReminder(ctx context.Context, id uint) error {
+	reminder, err := s.reminders.GetByID(ctx, id)
+	if err != nil {
+		if errors.Is(err, database.ErrNotFound) {
+			return nil
+		}
+		return fmt.Errorf("failed to get reminder: %w", err)
+	}
+
+	due := reminderDueAt(reminder)
+	if due == nil {
+		return nil
+	}
+	if due.After(time.Now()) {
+		s.trackReminder(reminder)
+		return nil
+	}
+
+	if err := s.sendReminder(ctx, reminder); err != nil {
+		return err
+	}
+	s.trackReminder(reminder)
+	return nil
+}
+
+// listenChanges feeds schedule change notifications into the queue, reconnecting after failures.
+// While notifications are unavailable the queue loop falls back to frequent reconciliation.
+func (s *Scheduler) listenChanges(ctx context.Context) {
+	defer s.wg.Done()
+
+	ready := func() {
+		s.logger.Info("Listening for schedule changes")
+		s.listening.Store(true)
+		// Changes made while nobody was listening were missed
+		s.requestReconcile()
+	}
+
+	for {
+		err := database.ListenScheduleChanges(ctx, s.db, ready, s.handleChange)
+		if ctx.Err() != nil {
+			return
+		}
+		if s.listening.Swap(false) {
+			s.requestReconcile()
+		}
+
+		s.logger.Warn("Schedule change notifications unavailable, falling back to polling",
+			zap.Duration("retry_in", s.config.PollingInterval),
+			zap.Error(err))
+
+		select {
+		case <-time.After(s.config.PollingInterval):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// handleChange applies a schedule change notification to the queue
+func (s *Scheduler) handleChange(change database.ScheduleChange) {
+	var key queueKey
+	switch change.Table {
+	case database.ScheduleTableTasks:
+		key = queueKey{kind: entryTask, id: change.ID}
+	case database.ScheduleTableReminders:
+		key = queueKey{kind: entryReminder, id: change.ID}
+	default:
+		return
+	}
+
+	if change.At == nil {
+		s.queue.remove(key)
+		return
+	}
+	s.queue.schedule(key, *change.At)
+}
+
+// trackTask puts a pending task with a due time in the queue, or drops it otherwise
+func (s *Scheduler) trackTask(task *models.TaskInstance) {
+	key := queueKey{kind: entryTask, id: task.ID}
+	if task.State != models.TaskStatePending || task.DueAt == nil {
+		s.queue.remove(key)
+		return
+	}
+	s.queue.schedule(key, *task.DueAt)
+}
+
+// trackReminder puts a reminder in the queue at the time it next needs attention, or drops it
+func (s *Scheduler) trackReminder(reminder *models.Reminder) {
+	key := queueKey{kind: entryReminder, id: reminder.ID}
+	if due := reminderDueAt(reminder); due != nil {
+		s.queue.schedule(key, *due)
+		return
+	}
+	s.queue.remove(key)
+}
+
+// reminderDueAt returns when a reminder next needs attention: its chat time while pending and
+// its next policy action once delivered
+func reminderDueAt(reminder *models.Reminder) *time.Time {
+	switch reminder.State {
+	case models.ReminderStatePending:
+		return &reminder.ChatAt
+	case models.ReminderStateDelivered:
+		return reminder.NextActionAt
+	}
+	return nil
+}
@@ -0,0 +1,225 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/BogdanDolia/ops-butler/internal/chatops"
+	"github.com/BogdanDolia/ops-butler/internal/database"
+	"github.com/BogdanDolia/ops-butler/internal/models"
+)
+
+// processOutbox delivers queued chat messages until ctx is cancelled
+func (s *Scheduler) processOutbox(ctx context.Context) {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(s.config.OutboxInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := s.deliverOutbox(ctx); err != nil {
+				s.logger.Error("Failed to deliver outbox messages", zap.Error(err))
+			}
+		case <-s.stopCh:
+			return
+		}
+	}
+}
+
+// deliverOutbox delivers the outbox messages that are due
+func (s *Scheduler) deliverOutbox(ctx context.Context) error {
+	now := time.Now()
+	messages, err := s.outbox.ClaimDue(ctx, now, s.config.OutboxLease, s.config.MaxConcurrentTasks)
+	if err != nil {
+		return fmt.Errorf("failed to claim outbox messages: %w", err)
+	}
+
+	for _, message := range messages {
+		if err := s.deliverMessage(ctx, message); err != nil {
+			s.logger.Error("Failed to record outbox delivery",
+				zap.Uint("message_id", message.ID),
+				zap.String("key", message.IdempotencyKey),
+				zap.Error(err))
+		}
+	}
+
+	return nil
+}
+
+// deliverMessage posts an outbox message and records the outcome: the message is marked sent
+// together with the reminder it delivers, or scheduled for a retry with exponential backoff until
+// it runs out of attempts and is dead-lettered. A rate-limited message is retried once the
+// platform allows, without using up an attempt.
+//
+// A message is never posted twice: the post is recorded before the message is completed, so a
+// message whose completion failed is only completed again, and a message whose delivery was
+// interrupted before that is dead-lettered rather than posted again, unless its platform
+// deduplicates deliveries by the idempotency key.
+func (s *Scheduler) deliverMessage(ctx context.Context, message *models.OutboxMessage) error {
+	interrupted := message.State == models.OutboxStateSending
+	message.State = models.OutboxStateSending
+
+	reminder, err := s.outboxReminder(ctx, message)
+	if err != nil {
+		return err
+	}
+	// A reminder that was cancelled or snoozed while queued must not be posted any more
+	if reminder != nil && reminder.State != models.ReminderStateQueued && message.SentAt == nil {
+		message.State = models.OutboxStateSkipped
+		message.LastError = fmt.Sprintf("reminder is %s", reminder.State)
+		return s.outbox.Complete(ctx, message)
+	}
+
+	if message.SentAt == nil {
+		// Updates replace a message's text, which is safe to repeat
+		if interrupted && message.Kind != models.OutboxKindUpdate && !s.chat.Deduplicates(message.Platform) {
+			message.State = models.OutboxStateDead
+			message.LastError = "an earlier delivery attempt was interrupted and may have posted the message; retry it to post anyway"
+			s.logger.Error("Outbox delivery was interrupted, not posting again",
+				zap.Uint("message_id", message.ID),
+				zap.String("key", message.IdempotencyKey))
+			return s.outbox.Update(ctx, message)
+		}
+		if err := s.postMessage(ctx, message); err != nil {
+			return err
+		}
+		if message.SentAt == nil {
+			return nil
+		}
+	}
+
+	message.State = models.OutboxStateSent
+	if reminder == nil {
+		return s.outbox.Complete(ctx, message)
+	}
+
+	policy, err := s.reminderPolicy(ctx, reminder)
+	if err != nil {
+		return err
+	}
+	markDelivered(reminder, policy, message.MessageID, *message.SentAt)
+	if err := s.outbox.Complete(ctx, message, reminder); err != nil {
+		return err
+	}
+	s.trackReminder(reminder)
+	return nil
+}
+
+// postMessage posts an outbox message and records the post, or schedules the next attempt if it
+// fails. SentAt is set once the message is posted.
+func (s *Scheduler) postMessage(ctx context.Context, message *models.OutboxMessage) error {
+	delivery := &chatops.Delivery{Key: message.IdempotencyKey, FirstID: message.MessageID, Posted: message.Parts}
+	messageID, sendErr := s.sendMessage(chatops.WithDelivery(ctx, delivery), message)
+	now := time.Now()
+	if sendErr != nil {
+		// Files posted as several messages continue after the part that failed
+		message.State = models.OutboxStatePending
+		message.MessageID, message.Parts = delivery.FirstID, delivery.Posted
+		message.LastError = sendErr.Error()
+		var limited *chatops.RateLimitError
+		if errors.As(sendErr, &limited) {
+			message.Attempts--
+			message.NextAttemptAt = now.Add(max(limited.RetryAfter, s.config.OutboxRetryBase))
+			s.logger.Warn("Outbox delivery rate limited, retrying later",
+				zap.Uint("message_id", message.ID),
+				zap.String("key", message.IdempotencyKey),
+				zap.Time("next_attempt_at", message.NextAttemptAt))
+		} else if message.Attempts >= s.config.OutboxMaxAttempts {
+			message.State = models.OutboxStateDead
+			s.logger.Error("Giving up on outbox message",
+				zap.Uint("message_id", message.ID),
+				zap.String("key", message.IdempotencyKey),
+				zap.Int("attempts", message.Attempts),
+				zap.Error(sendErr))
+		} else {
+			message.NextAttemptAt = now.Add(retryDelay(s.config.OutboxRetryBase, s.config.OutboxRetryMax, message.Attempts))
+			s.logger.Warn("Outbox delivery failed, retrying later",
+				zap.Uint("message_id", message.ID),
+				zap.String("key", message.IdempotencyKey),
+				zap.Int("attempts", message.Attempts),
+				zap.Time("next_attempt_at", message.NextAttemptAt),
+				zap.Error(sendErr))
+		}
+		return s.outbox.Update(ctx, message)
+	}
+
+	// Recorded before completing, which may fail, so that a retry doesn't post again
+	message.MessageID = messageID
+	message.SentAt = &now
+	message.LastError = ""
+	if err := s.outbox.Update(ctx, message); err != nil {
+		return fmt.Errorf("failed to record posted message: %w", err)
+	}
+	return nil
+}
+
+// outboxReminder returns the reminder an outbox message first posts, or nil if the message is
+// anything else
+func (s *Scheduler) outboxReminder(ctx context.Context, message *models.OutboxMessage) (*models.Reminder, error) {
+	if message.Kind != models.OutboxKindReminder || message.ReminderID == nil {
+		return nil, nil
+	}
+
+	reminder, err := s.reminders.GetByID(ctx, *message.ReminderID)
+	if err != nil {
+		if errors.Is(err, database.ErrNotFound) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get reminder: %w", err)
+	}
+	// Repeats and escalations of a delivered reminder don't change it
+	if reminder.State == models.ReminderStateDelivered {
+		return nil, nil
+	}
+
+	return reminder, nil
+}
+
+// sendMessage posts an outbox message through the ChatOps service and returns the message ID
+func (s *Scheduler) sendMessage(ctx context.Context, message *models.OutboxMessage) (string, error) {
+	switch message.Kind {
+	case models.OutboxKindReminder:
+		if message.TaskID == nil {
+			return "", fmt.Errorf("reminder message has no task")
+		}
+		return s.chat.SendReminderMessage(ctx, message.Platform, message.Channel, message.Text, *message.TaskID)
+	case models.OutboxKindMessage:
+		return s.chat.SendMessage(ctx, message.Platform, message.Channel, message.Text)
+	case models.OutboxKindReply:
+		return s.chat.ReplyInThread(ctx, message.Platform, message.Channel, message.Thread, message.Text)
+	case models.OutboxKindUpdate:
+		return message.Thread, s.chat.UpdateMessage(ctx, message.Platform, message.Channel, message.Thread, message.Text)
+	case models.OutboxKindFile:
+		return s.chat.UploadFile(ctx, message.Platform, message.Channel, message.Thread, message.Filename, message.Text)
+	}
+	return "", fmt.Errorf("unknown outbox message kind %q", message.Kind)
+}
+
+// markDelivered records that a reminder was posted and when its policy next needs attention
+func markDelivered(reminder *models.Reminder, policy *models.ReminderPolicy, messageID string, now time.Time) {
+	reminder.State = models.ReminderStateDelivered
+	reminder.MessageID = messageID
+	reminder.SendCount = 1
+	reminder.FirstSentAt = timePtr(now)
+	reminder.LastSentAt = timePtr(now)
+	reminder.NextActionAt = nextPolicyAction(reminder, policy)
+}
+
+// retryDelay returns the delay before the next delivery attempt: base doubled for every attempt
+// after the first, capped at max
+func retryDelay(base, max time.Duration, attempts int) time.Duration {
+	delay := base
+	for i := 1; i < attempts && delay < max; i++ {
+		delay *= 2
+	}
+	if delay > max {
+		delay = max
+	}
+	return delay
+}
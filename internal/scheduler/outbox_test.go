@@ -0,0 +1,215 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/BogdanDolia/ops-butler/internal/chatops"
+	"github.com/BogdanDolia/ops-butler/internal/database"
+	"github.com/BogdanDolia/ops-butler/internal/models"
+)
+
+func TestRetryDelay(t *testing.T) {
+	const base, max = 15 * time.Second, time.Hour
+
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{0, base},
+		{1, base},
+		{2, 30 * time.Second},
+		{3, time.Minute},
+		{5, 4 * time.Minute},
+		{8, 32 * time.Minute},
+		{9, max},
+		{10, max},
+		{1000, max},
+	}
+
+	for _, tt := range tests {
+		if got := retryDelay(base, max, tt.attempts); got != tt.want {
+			t.Errorf("retryDelay(%s, %s, %d) = %s, want %s", base, max, tt.attempts, got, tt.want)
+		}
+	}
+
+	if got := retryDelay(2*time.Hour, max, 1); got != max {
+		t.Errorf("retryDelay with base above max = %s, want %s", got, max)
+	}
+}
+
+// fakeProvider is a chat platform that fails with err, or posts messages with sequential IDs
+type fakeProvider struct {
+	chatops.ChatProvider
+	err    error
+	posted []string
+}
+
+func (p *fakeProvider) Name() string {
+	return "fake"
+}
+
+func (p *fakeProvider) SendMessage(ctx context.Context, channel, text string) (string, error) {
+	if p.err != nil {
+		return "", p.err
+	}
+	p.posted = append(p.posted, text)
+	return fmt.Sprintf("m%d", len(p.posted)), nil
+}
+
+// dedupProvider is a fakeProvider whose platform deduplicates deliveries
+type dedupProvider struct {
+	*fakeProvider
+}
+
+func (p *dedupProvider) DeduplicatesDeliveries() bool {
+	return true
+}
+
+// fakeOutbox records the messages the scheduler saves
+type fakeOutbox struct {
+	database.OutboxRepository
+	updated   []models.OutboxMessage
+	completed []models.OutboxMessage
+}
+
+func (r *fakeOutbox) Update(ctx context.Context, message *models.OutboxMessage) error {
+	r.updated = append(r.updated, *message)
+	return nil
+}
+
+func (r *fakeOutbox) Complete(ctx context.Context, message *models.OutboxMessage, updates ...interface{}) error {
+	r.completed = append(r.completed, *message)
+	return nil
+}
+
+// newOutboxScheduler creates a scheduler that delivers outbox messages through provider
+func newOutboxScheduler(t *testing.T, provider chatops.ChatProvider) (*Scheduler, *fakeOutbox) {
+	t.Helper()
+	chat, err := chatops.NewService(&chatops.Config{}, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	chat.RegisterProvider(provider)
+	outbox := &fakeOutbox{}
+	return &Scheduler{
+		config: &Config{
+			OutboxRetryBase:   15 * time.Second,
+			OutboxRetryMax:    time.Hour,
+			OutboxMaxAttempts: 3,
+		},
+		logger: zap.NewNop(),
+		outbox: outbox,
+		chat:   chat,
+	}, outbox
+}
+
+func TestDeliverMessageRateLimited(t *testing.T) {
+	provider := &fakeProvider{err: &chatops.RateLimitError{Method: "fake post", RetryAfter: 10 * time.Minute}}
+	s, outbox := newOutboxScheduler(t, provider)
+
+	message := &models.OutboxMessage{Kind: models.OutboxKindMessage, Platform: "fake", Attempts: 3}
+	message.ID = 1
+	start := time.Now()
+	if err := s.deliverMessage(context.Background(), message); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(outbox.updated) != 1 {
+		t.Fatalf("updated %d messages, want 1", len(outbox.updated))
+	}
+	got := outbox.updated[0]
+	if got.State == models.OutboxStateDead {
+		t.Error("rate-limited message was dead-lettered")
+	}
+	if got.Attempts != 2 {
+		t.Errorf("attempts = %d, want the claim not counted", got.Attempts)
+	}
+	if wait := got.NextAttemptAt.Sub(start); wait < 10*time.Minute {
+		t.Errorf("next attempt in %s, want after the platform's Retry-After", wait)
+	}
+	if got.LastError == "" {
+		t.Error("last error is not recorded")
+	}
+}
+
+func TestDeliverMessage(t *testing.T) {
+	sentAt := time.Now().Add(-time.Hour)
+
+	tests := []struct {
+		name      string
+		err       error
+		dedup     bool
+		state     models.OutboxState
+		attempts  int
+		sentAt    *time.Time
+		wantPosts int
+		wantState models.OutboxState // of the message completed, or updated last if none is
+		wantSent  bool               // recorded as posted before it is completed
+	}{
+		{name: "posted", state: models.OutboxStatePending, attempts: 1, wantPosts: 1, wantState: models.OutboxStateSent, wantSent: true},
+		{name: "failed", err: fmt.Errorf("boom"), state: models.OutboxStatePending, attempts: 1, wantState: models.OutboxStatePending},
+		{name: "failed at max attempts", err: fmt.Errorf("boom"), state: models.OutboxStatePending, attempts: 3, wantState: models.OutboxStateDead},
+		{name: "interrupted", state: models.OutboxStateSending, attempts: 2, wantState: models.OutboxStateDead},
+		{name: "interrupted on a deduplicating platform", dedup: true, state: models.OutboxStateSending, attempts: 2, wantPosts: 1, wantState: models.OutboxStateSent, wantSent: true},
+		{name: "posted before completion failed", state: models.OutboxStateSending, attempts: 2, sentAt: &sentAt, wantState: models.OutboxStateSent},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &fakeProvider{err: tt.err}
+			var provider chatops.ChatProvider = fake
+			if tt.dedup {
+				provider = &dedupProvider{fake}
+			}
+			s, outbox := newOutboxScheduler(t, provider)
+
+			message := &models.OutboxMessage{
+				Kind:           models.OutboxKindMessage,
+				Platform:       "fake",
+				IdempotencyKey: "key",
+				Text:           "hello",
+				State:          tt.state,
+				Attempts:       tt.attempts,
+				SentAt:         tt.sentAt,
+			}
+			message.ID = 1
+			if err := s.deliverMessage(context.Background(), message); err != nil {
+				t.Fatal(err)
+			}
+
+			if len(fake.posted) != tt.wantPosts {
+				t.Errorf("posted %d messages, want %d", len(fake.posted), tt.wantPosts)
+			}
+
+			var got models.OutboxMessage
+			switch {
+			case len(outbox.completed) > 0:
+				got = outbox.completed[len(outbox.completed)-1]
+			case len(outbox.updated) > 0:
+				got = outbox.updated[len(outbox.updated)-1]
+			default:
+				t.Fatal("message was neither updated nor completed")
+			}
+			if got.State != tt.wantState {
+				t.Errorf("state = %s, want %s", got.State, tt.wantState)
+			}
+			if tt.wantState == models.OutboxStateSent && got.SentAt == nil {
+				t.Error("sent message has no SentAt")
+			}
+			if tt.wantState == models.OutboxStatePending && !got.NextAttemptAt.After(time.Now()) {
+				t.Error("failed message is not retried later")
+			}
+
+			if tt.wantSent {
+				if len(outbox.updated) != 1 || outbox.updated[0].SentAt == nil || outbox.updated[0].MessageID != "m1" {
+					t.Errorf("post was not recorded before completing: updated %+v", outbox.updated)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,140 @@
+package scheduler
+
+import (
+	"container/heap"
+	"sync"
+	"time"
+)
+
+// entryKind is the kind of row a queue entry refers to
+type entryKind int
+
+const (
+	entryTask entryKind = iota
+	entryReminder
+)
+
+// queueKey identifies a task or reminder in the due queue
+type queueKey struct {
+	kind entryKind
+	id   uint
+}
+
+// queueEntry is a task or reminder and the time it next needs attention
+type queueEntry struct {
+	key   queueKey
+	at    time.Time
+	index int
+}
+
+// entryHeap is a min-heap of entries ordered by time
+type entryHeap []*queueEntry
+
+func (h entryHeap) Len() int           { return len(h) }
+func (h entryHeap) Less(i, j int) bool { return h[i].at.Before(h[j].at) }
+func (h entryHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *entryHeap) Push(x any) {
+	entry := x.(*queueEntry)
+	entry.index = len(*h)
+	*h = append(*h, entry)
+}
+
+func (h *entryHeap) Pop() any {
+	old := *h
+	n := len(old)
+	entry := old[n-1]
+	old[n-1] = nil
+	*h = old[:n-1]
+	return entry
+}
+
+// dueQueue holds upcoming due times in memory so the scheduler can sleep until the next one.
+// Entries may be stale; whoever fires them re-reads the row before acting.
+type dueQueue struct {
+	mu      sync.Mutex
+	heap    entryHeap
+	entries map[queueKey]*queueEntry
+	wake    chan struct{}
+}
+
+// newDueQueue creates an empty due queue
+func newDueQueue() *dueQueue {
+	return &dueQueue{
+		entries: make(map[queueKey]*queueEntry),
+		wake:    make(chan struct{}, 1),
+	}
+}
+
+// schedule adds an entry or moves it to a new time, waking the scheduler if it is now the earliest
+func (q *dueQueue) schedule(key queueKey, at time.Time) {
+	q.mu.Lock()
+	if entry, ok := q.entries[key]; ok {
+		entry.at = at
+		heap.Fix(&q.heap, entry.index)
+	} else {
+		entry = &queueEntry{key: key, at: at}
+		heap.Push(&q.heap, entry)
+		q.entries[key] = entry
+	}
+	earliest := q.heap[0].key == key
+	q.mu.Unlock()
+
+	if earliest {
+		q.signal()
+	}
+}
+
+// remove drops an entry from the queue
+func (q *dueQueue) remove(key queueKey) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if entry, ok := q.entries[key]; ok {
+		heap.Remove(&q.heap, entry.index)
+		delete(q.entries, key)
+	}
+}
+
+// next returns the time of the earliest entry
+func (q *dueQueue) next() (time.Time, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if len(q.heap) == 0 {
+		return time.Time{}, false
+	}
+	return q.heap[0].at, true
+}
+
+// popDue removes and returns the earliest entry if it is due at or before now
+func (q *dueQueue) popDue(now time.Time) (queueKey, time.Time, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if len(q.heap) == 0 || q.heap[0].at.After(now) {
+		return queueKey{}, time.Time{}, false
+	}
+	entry := heap.Pop(&q.heap).(*queueEntry)
+	delete(q.entries, entry.key)
+	return entry.key, entry.at, true
+}
+
+// len returns the number of entries in the queue
+func (q *dueQueue) len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.heap)
+}
+
+// signal wakes the scheduler loop without blocking
+func (q *dueQueue) signal() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
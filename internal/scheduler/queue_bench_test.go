@@ -0,0 +1,187 @@
+package scheduler
+
+import (
+	"context"
+	"sort"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/BogdanDolia/ops-butler/internal/database"
+	"github.com/BogdanDolia/ops-butler/internal/models"
+)
+
+// pendingReminders is the number of pending reminders the benchmarks run against
+const pendingReminders = 100_000
+
+// countingTasks is a task repository without any due tasks that counts its queries
+type countingTasks struct {
+	database.TaskRepository
+	queries atomic.Int64
+}
+
+func (r *countingTasks) ListDue(ctx context.Context, now time.Time, offset, limit int) ([]*models.TaskInstance, error) {
+	r.queries.Add(1)
+	return nil, nil
+}
+
+// countingReminders serves pending reminders sorted by chat time and counts its queries and
+// the rows they return
+type countingReminders struct {
+	database.ReminderRepository
+	reminders []*models.Reminder
+	queries   atomic.Int64
+	rows      atomic.Int64
+	fetched   chan uint // receives the ID of every reminder read with GetByID, if set
+}
+
+func (r *countingReminders) ListDue(ctx context.Context, now time.Time, offset, limit int) ([]*models.Reminder, error) {
+	r.queries.Add(1)
+	due := sort.Search(len(r.reminders), func(i int) bool { return r.reminders[i].ChatAt.After(now) })
+	if offset >= due {
+		return nil, nil
+	}
+	page := r.reminders[offset:min(offset+limit, due)]
+	r.rows.Add(int64(len(page)))
+	return page, nil
+}
+
+// GetByID returns an actioned reminder, so firing it reads the row but sends nothing
+func (r *countingReminders) GetByID(ctx context.Context, id uint) (*models.Reminder, error) {
+	r.queries.Add(1)
+	if r.fetched != nil {
+		r.fetched <- id
+	}
+	reminder := &models.Reminder{State: models.ReminderStateActioned}
+	reminder.ID = id
+	return reminder, nil
+}
+
+// newBenchScheduler creates a scheduler over n pending reminders spread evenly across spread,
+// starting from after now
+func newBenchScheduler(n int, now time.Time, from, spread time.Duration) (*Scheduler, *countingTasks, *countingReminders) {
+	reminders := &countingReminders{reminders: make([]*models.Reminder, n)}
+	for i := range reminders.reminders {
+		reminder := &models.Reminder{
+			State:  models.ReminderStatePending,
+			ChatAt: now.Add(from + spread*time.Duration(i)/time.Duration(n)),
+		}
+		reminder.ID = uint(i + 1)
+		reminders.reminders[i] = reminder
+	}
+	tasks := &countingTasks{}
+
+	s := &Scheduler{
+		config: &Config{
+			PollingInterval:   30 * time.Second,
+			ReconcileInterval: 5 * time.Minute,
+		},
+		logger:    zap.NewNop(),
+		tasks:     tasks,
+		reminders: reminders,
+		queue:     newDueQueue(),
+		stopCh:    make(chan struct{}),
+	}
+	s.listening.Store(true)
+	return s, tasks, reminders
+}
+
+// BenchmarkReconcile measures the database load of rebuilding the due queue with 100k pending
+// reminders, both when they are spread over a day and when all fall within one reconciliation
+func BenchmarkReconcile(b *testing.B) {
+	tests := []struct {
+		name   string
+		spread time.Duration
+	}{
+		{"spread over a day", 24 * time.Hour},
+		{"all within the horizon", time.Minute},
+	}
+
+	for _, tt := range tests {
+		b.Run(tt.name, func(b *testing.B) {
+			now := time.Now()
+			s, tasks, reminders := newBenchScheduler(pendingReminders, now, time.Minute, tt.spread)
+			ctx := context.Background()
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if err := s.reconcile(ctx, now); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.StopTimer()
+
+			queries := tasks.queries.Load() + reminders.queries.Load()
+			b.ReportMetric(float64(queries)/float64(b.N), "queries/op")
+			b.ReportMetric(float64(reminders.rows.Load())/float64(b.N), "rows/op")
+			b.ReportMetric(float64(s.queue.len()), "queued")
+		})
+	}
+}
+
+// BenchmarkFiringLatency measures how long the running queue loop takes to pick up a reminder
+// that becomes due while 100k others are waiting, and how many queries firing it costs. The
+// others are hours out, so none of them fires during the benchmark; the reconciliation interval
+// covers them so that they are all queued.
+func BenchmarkFiringLatency(b *testing.B) {
+	now := time.Now()
+	s, tasks, reminders := newBenchScheduler(pendingReminders, now, time.Hour, time.Hour)
+	s.config.ReconcileInterval = 3 * time.Hour
+	reminders.fetched = make(chan uint, 1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s.wg.Add(1)
+	go s.runQueue(ctx)
+	defer s.wg.Wait()
+
+	// Wait for the first reconciliation to fill the queue
+	for s.queue.len() < pendingReminders {
+		time.Sleep(time.Millisecond)
+	}
+	before := tasks.queries.Load() + reminders.queries.Load()
+
+	latencies := make([]time.Duration, 0, b.N)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		id := uint(pendingReminders + i + 1)
+		due := time.Now()
+		s.queue.schedule(queueKey{kind: entryReminder, id: id}, due)
+		if got := <-reminders.fetched; got != id {
+			b.Fatalf("fired reminder %d, want %d", got, id)
+		}
+		latencies = append(latencies, time.Since(due))
+	}
+	b.StopTimer()
+	cancel()
+
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	percentile := func(p float64) float64 {
+		return float64(latencies[int(p*float64(len(latencies)-1))].Microseconds())
+	}
+	queries := tasks.queries.Load() + reminders.queries.Load() - before
+	b.ReportMetric(percentile(0.5), "p50-µs")
+	b.ReportMetric(percentile(0.99), "p99-µs")
+	b.ReportMetric(float64(queries)/float64(b.N), "queries/fire")
+	b.ReportMetric(float64(s.queue.len()), "queued")
+}
+
+// BenchmarkQueue measures scheduling and popping a single entry with 100k entries queued
+func BenchmarkQueue(b *testing.B) {
+	now := time.Now()
+	q := newDueQueue()
+	for i := 0; i < pendingReminders; i++ {
+		q.schedule(queueKey{kind: entryReminder, id: uint(i + 1)}, now.Add(time.Hour+time.Duration(i)*time.Millisecond))
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		key := queueKey{kind: entryReminder, id: uint(pendingReminders + i + 1)}
+		q.schedule(key, now)
+		if got, _, ok := q.popDue(now); !ok || got != key {
+			b.Fatalf("popDue() = %v, %t, want %v", got, ok, key)
+		}
+	}
+}
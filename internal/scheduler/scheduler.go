@@ -4,31 +4,51 @@ import (
 	"context"
 	"fmt"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/redis/go-redis/v9"
 	"go.uber.org/zap"
 	"gorm.io/gorm"
 
+	"github.com/BogdanDolia/ops-butler/internal/calendar"
+	"github.com/BogdanDolia/ops-butler/internal/capabilities"
+	"github.com/BogdanDolia/ops-butler/internal/chatops"
 	"github.com/BogdanDolia/ops-butler/internal/database"
 	"github.com/BogdanDolia/ops-butler/internal/models"
+	"github.com/BogdanDolia/ops-butler/internal/routing"
+	"github.com/BogdanDolia/ops-butler/internal/secrets"
+	"github.com/BogdanDolia/ops-butler/internal/workflow"
 )
 
 // Scheduler represents a task scheduler
 type Scheduler struct {
-	config    *Config
-	logger    *zap.Logger
-	db        *gorm.DB
-	redis     *redis.Client
-	tasks     database.TaskRepository
-	reminders database.ReminderRepository
-	stopCh    chan struct{}
-	wg        sync.WaitGroup
+	config       *Config
+	logger       *zap.Logger
+	db           *gorm.DB
+	redis        *redis.Client
+	tasks        database.TaskRepository
+	reminders    database.ReminderRepository
+	templates    database.TemplateRepository
+	policies     database.ReminderPolicyRepository
+	users        database.UserRepository
+	logs         database.ExecutionLogRepository
+	outbox       database.OutboxRepository
+	chat         *chatops.Service
+	guard        *calendar.Guard
+	router       *routing.Router
+	workflows    *workflow.Engine
+	queue        *dueQueue
+	listening    atomic.Bool
+	reconcileNow atomic.Bool
+	cancel       context.CancelFunc
+	stopCh       chan struct{}
+	wg           sync.WaitGroup
 }
 
-// NewScheduler creates a new scheduler
+// NewScheduler creates a new scheduler. It doesn't connect to Redis until Start, so other
+// components can use its reminder operations (e.g. SnoozeReminder) without running the loops.
 func NewScheduler(config *Config, logger *zap.Logger, db *gorm.DB) (*Scheduler, error) {
-	// Connect to Redis
 	redisOpts := &redis.Options{
 		Addr:     config.RedisURL,
 		Password: config.RedisPassword,
@@ -36,16 +56,35 @@ func NewScheduler(config *Config, logger *zap.Logger, db *gorm.DB) (*Scheduler,
 	}
 	redisClient := redis.NewClient(redisOpts)
 
-	// Test Redis connection
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-	if err := redisClient.Ping(ctx).Err(); err != nil {
-		return nil, fmt.Errorf("failed to connect to Redis: %w", err)
+	// Create the ChatOps service used to deliver reminders
+	chat, err := chatops.NewService(config.ChatOps, logger)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create ChatOps service: %w", err)
 	}
 
 	// Create repositories
-	taskRepo := database.TaskRepository(db)
-	reminderRepo := database.ReminderRepository(db)
+	taskRepo := database.NewTaskRepository(db)
+	reminderRepo := database.NewReminderRepository(db)
+	logRepo := database.NewExecutionLogRepository(db)
+	agentRepo := database.NewAgentRepository(db)
+	templateRepo := database.NewTemplateRepository(db)
+	guard := calendar.NewGuard(database.NewCalendarRepository(db), agentRepo, templateRepo)
+	router := routing.NewRouter(logger,
+		database.NewChatRouteRepository(db),
+		database.NewMessageTemplateRepository(db),
+		agentRepo)
+	keyring, err := secrets.LoadKeyring(config.SecretsKEKFile, config.SecretsPreviousKEKFiles)
+	if err != nil {
+		return nil, err
+	}
+	engine := workflow.NewEngine(logger,
+		database.NewWorkflowRepository(db),
+		database.NewWorkflowRunRepository(db),
+		taskRepo,
+		logRepo,
+		templateRepo,
+		capabilities.NewMatcher(agentRepo, config.AgentTimeout),
+		keyring)
 
 	return &Scheduler{
 		config:    config,
@@ -54,6 +93,16 @@ func NewScheduler(config *Config, logger *zap.Logger, db *gorm.DB) (*Scheduler,
 		redis:     redisClient,
 		tasks:     taskRepo,
 		reminders: reminderRepo,
+		templates: templateRepo,
+		policies:  database.NewReminderPolicyRepository(db),
+		users:     database.NewUserRepository(db),
+		logs:      logRepo,
+		outbox:    database.NewOutboxRepository(db),
+		chat:      chat,
+		guard:     guard,
+		router:    router,
+		workflows: engine,
+		queue:     newDueQueue(),
 		stopCh:    make(chan struct{}),
 	}, nil
 }
@@ -62,13 +111,35 @@ func NewScheduler(config *Config, logger *zap.Logger, db *gorm.DB) (*Scheduler,
 func (s *Scheduler) Start() error {
 	s.logger.Info("Starting scheduler")
 
-	// Start the polling goroutine
+	// Test Redis connection
+	pingCtx, cancelPing := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancelPing()
+	if err := s.redis.Ping(pingCtx).Err(); err != nil {
+		return fmt.Errorf("failed to connect to Redis: %w", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cancel = cancel
+
+	// Start the goroutine that fires due tasks and reminders
 	s.wg.Add(1)
-	go s.pollTasks()
+	go s.runQueue(ctx)
 
-	// Start the reminder processing goroutine
+	// Start the goroutine that listens for schedule changes
 	s.wg.Add(1)
-	go s.processReminders()
+	go s.listenChanges(ctx)
+
+	// Start the workflow processing goroutine
+	s.wg.Add(1)
+	go s.processWorkflows()
+
+	// Start the goroutine that posts task outcomes to chat
+	s.wg.Add(1)
+	go s.processCompletions()
+
+	// Start the goroutine that delivers queued chat messages
+	s.wg.Add(1)
+	go s.processOutbox(ctx)
 
 	return nil
 }
@@ -79,6 +150,9 @@ func (s *Scheduler) Stop() error {
 
 	// Signal all goroutines to stop
 	close(s.stopCh)
+	if s.cancel != nil {
+		s.cancel()
+	}
 
 	// Wait for all goroutines to finish
 	s.wg.Wait()
@@ -92,64 +166,72 @@ func (s *Scheduler) Stop() error {
 	return nil
 }
 
-// pollTasks polls for tasks that need to be scheduled
-func (s *Scheduler) pollTasks() {
-	defer s.wg.Done()
-
-	ticker := time.NewTicker(s.config.PollingInterval)
-	defer ticker.Stop()
-
-	for {
-		select {
-		case <-ticker.C:
-			if err := s.checkDueTasks(); err != nil {
-				s.logger.Error("Failed to check due tasks", zap.Error(err))
-			}
-		case <-s.stopCh:
-			return
-		}
+// holdOutsideWindow reschedules a task that is blocked by a change freeze or is outside its
+// maintenance windows. It returns true if the task was held back.
+func (s *Scheduler) holdOutsideWindow(ctx context.Context, task *models.TaskInstance) (bool, error) {
+	now := time.Now()
+	decision, err := s.guard.Check(ctx, task, now)
+	if err != nil {
+		return false, err
+	}
+	if decision.Allowed {
+		return false, nil
 	}
-}
-
-// checkDueTasks checks for tasks that are due and creates reminders for them
-func (s *Scheduler) checkDueTasks() error {
-	s.logger.Debug("Checking for due tasks")
 
-	// Get tasks that are due
-	ctx := context.Background()
-	tasks, err := s.tasks.ListDue(ctx, 0, s.config.MaxConcurrentTasks)
-	if err != nil {
-		return fmt.Errorf("failed to list due tasks: %w", err)
+	// Move the due time forward so held tasks don't crowd out others in ListDue
+	next := now.Add(s.config.CalendarRecheckInterval)
+	if decision.NextAllowed != nil {
+		next = *decision.NextAllowed
 	}
 
-	// Process each task
-	for _, task := range tasks {
-		if err := s.createReminder(ctx, task); err != nil {
-			s.logger.Error("Failed to create reminder for task",
-				zap.Uint("task_id", task.ID),
-				zap.Error(err))
-			continue
-		}
+	s.logger.Info("Holding task outside allowed window",
+		zap.Uint("task_id", task.ID),
+		zap.String("calendar", decision.Calendar),
+		zap.String("reason", decision.Reason),
+		zap.Time("next_due_at", next))
+
+	task.DueAt = &next
+	if err := s.tasks.Update(ctx, task); err != nil {
+		return false, fmt.Errorf("failed to reschedule task: %w", err)
 	}
 
-	return nil
+	return true, nil
 }
 
 // createReminder creates a reminder for a task
 func (s *Scheduler) createReminder(ctx context.Context, task *models.TaskInstance) error {
 	s.logger.Info("Creating reminder for task", zap.Uint("task_id", task.ID))
 
-	// Create a reminder
+	template, err := s.templates.GetByID(ctx, task.TemplateID)
+	if err != nil {
+		return fmt.Errorf("failed to get template: %w", err)
+	}
+
+	// Create a reminder, posted where the task's chat route says or to the default channel
 	reminder := &models.Reminder{
-		TaskID: task.ID,
-		ChatAt: time.Now(),
-		State:  models.ReminderStatePending,
+		TaskID:   task.ID,
+		ChatAt:   time.Now(),
+		State:    models.ReminderStatePending,
+		ChatType: s.chat.DefaultPlatform(),
+		PolicyID: template.ReminderPolicyID,
+	}
+	route, err := s.router.Route(ctx, task, template)
+	if err != nil {
+		return err
+	}
+	if route != nil {
+		reminder.RouteID = &route.ID
+		if route.ChatType != "" {
+			reminder.ChatType = route.ChatType
+		}
+		reminder.ChatID = route.ChatID
 	}
 
 	// Save the reminder
 	if err := s.reminders.Create(ctx, reminder); err != nil {
 		return fmt.Errorf("failed to create reminder: %w", err)
 	}
+	s.trackReminder(reminder)
 
 	// Update the task state
 	task.State = models.TaskStateScheduled
@@ -160,71 +242,81 @@ func (s *Scheduler) createReminder(ctx context.Context, task *models.TaskInstanc
 	return nil
 }
 
-// processReminders processes reminders that are due
-func (s *Scheduler) processReminders() {
-	defer s.wg.Done()
+// sendReminder sends a reminder to the appropriate chat platform
+func (s *Scheduler) sendReminder(ctx context.Context, reminder *models.Reminder) error {
+	s.logger.Info("Sending reminder", zap.Uint("reminder_id", reminder.ID))
 
-	ticker := time.NewTicker(s.config.PollingInterval)
-	defer ticker.Stop()
+	task, err := s.tasks.GetByID(ctx, reminder.TaskID)
+	if err != nil {
+		return fmt.Errorf("failed to get task: %w", err)
+	}
 
-	for {
-		select {
-		case <-ticker.C:
-			if err := s.checkDueReminders(); err != nil {
-				s.logger.Error("Failed to check due reminders", zap.Error(err))
-			}
-		case <-s.stopCh:
-			return
-		}
+	policy, err := s.reminderPolicy(ctx, reminder)
+	if err != nil {
+		return err
+	}
+
+	// Delivered reminders come back here when their next policy action is due
+	if reminder.State == models.ReminderStateDelivered {
+		return s.followUpReminder(ctx, reminder, task, policy)
 	}
-}
 
-// checkDueReminders checks for reminders that are due and processes them
-func (s *Scheduler) checkDueReminders() error {
-	s.logger.Debug("Checking for due reminders")
+	// A snoozed reminder may come due after someone ran or cancelled the task meanwhile
+	if task.State != models.TaskStateScheduled {
+		s.logger.Info("Skipping reminder of a task that is no longer scheduled",
+			zap.Uint("reminder_id", reminder.ID),
+			zap.Uint("task_id", task.ID),
+			zap.String("state", string(task.State)))
+		reminder.State = models.ReminderStateActioned
+		reminder.NextActionAt = nil
+		if err := s.reminders.Update(ctx, reminder); err != nil {
+			return fmt.Errorf("failed to update reminder state: %w", err)
+		}
+		return nil
+	}
 
-	// Get reminders that are due
-	ctx := context.Background()
-	reminders, err := s.reminders.ListPending(ctx, 0, s.config.MaxConcurrentTasks)
+	message, err := s.reminderMessage(ctx, task, reminder, reminder.ChatType, reminder.ChatID, "", models.ReminderLevelInitial)
 	if err != nil {
-		return fmt.Errorf("failed to list pending reminders: %w", err)
+		return fmt.Errorf("failed to prepare reminder: %w", err)
 	}
 
-	// Process each reminder
-	for _, reminder := range reminders {
-		if reminder.ChatAt.After(time.Now()) {
-			continue
+	// Without ChatOps there is nothing to wait for
+	if message == nil {
+		markDelivered(reminder, policy, "", time.Now())
+		if err := s.reminders.Update(ctx, reminder); err != nil {
+			return fmt.Errorf("failed to update reminder state: %w", err)
 		}
+		return nil
+	}
 
-		if err := s.sendReminder(ctx, reminder); err != nil {
-			s.logger.Error("Failed to send reminder",
-				zap.Uint("reminder_id", reminder.ID),
-				zap.Error(err))
-			continue
-		}
+	// The reminder counts as delivered once the outbox has posted it
+	message.IdempotencyKey = fmt.Sprintf("reminder:%d:1", reminder.ID)
+	reminder.State = models.ReminderStateQueued
+	if err := s.outbox.Enqueue(ctx, []*models.OutboxMessage{message}, reminder); err != nil {
+		return fmt.Errorf("failed to queue reminder: %w", err)
 	}
 
 	return nil
 }
 
-// sendReminder sends a reminder to the appropriate chat platform
-func (s *Scheduler) sendReminder(ctx context.Context, reminder *models.Reminder) error {
-	s.logger.Info("Sending reminder", zap.Uint("reminder_id", reminder.ID))
+// processWorkflows advances running workflow runs
+func (s *Scheduler) processWorkflows() {
+	defer s.wg.Done()
 
-	// TODO: Implement sending reminders to chat platforms
-	// This would involve:
-	// 1. Getting the task details
-	// 2. Determining the chat platform (Slack, Google Chat)
-	// 3. Sending the message with interactive buttons
-	// 4. Updating the reminder state
-
-	// For now, just update the reminder state
-	reminder.State = models.ReminderStateDelivered
-	if err := s.reminders.Update(ctx, reminder); err != nil {
-		return fmt.Errorf("failed to update reminder state: %w", err)
-	}
+	ticker := time.NewTicker(s.config.PollingInterval)
+	defer ticker.Stop()
 
-	return nil
+	for {
+		select {
+		case <-ticker.C:
+			s.logger.Debug("Advancing workflow runs")
+			if err := s.workflows.AdvanceAll(context.Background(), s.config.MaxConcurrentTasks); err != nil {
+				s.logger.Error("Failed to advance workflow runs", zap.Error(err))
+			}
+		case <-s.stopCh:
+			return
+		}
+	}
 }
 
 // ScheduleTask schedules a task for execution at a specific time
@@ -263,16 +355,18 @@ func (s *Scheduler) CancelTask(ctx context.Context, taskID uint) error {
 		return fmt.Errorf("failed to update task: %w", err)
 	}
 
-	// Cancel any pending reminders
+	// Cancel any pending reminders and stop repeats of delivered ones
 	reminders, err := s.reminders.ListByTaskID(ctx, taskID)
 	if err != nil {
 		return fmt.Errorf("failed to list reminders: %w", err)
 	}
 
 	for _, reminder := range reminders {
-		if reminder.State == models.ReminderStatePending {
+		if reminder.State == models.ReminderStatePending || reminder.State == models.ReminderStateQueued ||
+			reminder.State == models.ReminderStateDelivered {
 			reminder.State = models.ReminderStateCancelled
 			reminder.CancelledAt = timePtr(time.Now())
+			reminder.NextActionAt = nil
 			if err := s.reminders.Update(ctx, reminder); err != nil {
 				s.logger.Error("Failed to cancel reminder",
 					zap.Uint("reminder_id", reminder.ID),
@@ -0,0 +1,181 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/BogdanDolia/ops-butler/internal/models"
+)
+
+// Snooze presets offered by chat buttons; any Go duration (e.g. "90m") is accepted as well
+const (
+	SnoozeNextBusinessMorning = "next_business_morning"
+	SnoozeTomorrow            = "tomorrow"
+)
+
+// SnoozePresets lists the durations offered to users by default
+var SnoozePresets = []string{"30m", "1h", "2h", "4h", SnoozeTomorrow, SnoozeNextBusinessMorning}
+
+var (
+	// ErrReminderNotActive is returned when snoozing a reminder that was already actioned, snoozed or cancelled
+	ErrReminderNotActive = errors.New("reminder is not active")
+	// ErrInvalidSnooze is returned when a snooze duration cannot be parsed or is not in the future
+	ErrInvalidSnooze = errors.New("invalid snooze duration")
+	// ErrSnoozeLimitReached is returned when a snooze would exceed the template's limits
+	ErrSnoozeLimitReached = errors.New("snooze limit reached")
+)
+
+// SnoozeRequest describes who snoozes a reminder and for how long
+type SnoozeRequest struct {
+	Duration string     // preset name or Go duration
+	Until    *time.Time // explicit time, takes precedence over Duration
+	UserID   *uint
+}
+
+// SnoozeReminder snoozes a reminder: the original is marked snoozed, a follow-up reminder is
+// created for the new time and the original chat message is updated to say who snoozed it until when.
+func (s *Scheduler) SnoozeReminder(ctx context.Context, reminderID uint, req SnoozeRequest) (*models.Reminder, error) {
+	reminder, err := s.reminders.GetByID(ctx, reminderID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get reminder: %w", err)
+	}
+	switch reminder.State {
+	case models.ReminderStatePending, models.ReminderStateQueued, models.ReminderStateDelivered:
+	default:
+		return nil, fmt.Errorf("%w: reminder %d is %s", ErrReminderNotActive, reminder.ID, reminder.State)
+	}
+
+	task, err := s.tasks.GetByID(ctx, reminder.TaskID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get task: %w", err)
+	}
+	template, err := s.templates.GetByID(ctx, task.TemplateID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get template: %w", err)
+	}
+
+	var user *models.User
+	loc := time.UTC
+	if req.UserID != nil {
+		user, err = s.users.GetByID(ctx, *req.UserID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get user: %w", err)
+		}
+		if user.TimeZone != "" {
+			if l, err := time.LoadLocation(user.TimeZone); err == nil {
+				loc = l
+			}
+		}
+	}
+
+	now := time.Now()
+	until, err := s.snoozeUntil(req, now.In(loc))
+	if err != nil {
+		return nil, err
+	}
+
+	count := reminder.SnoozeCount + 1
+	total := reminder.SnoozedMinutes + int(until.Sub(now).Round(time.Minute)/time.Minute)
+	if template.MaxSnoozes > 0 && count > template.MaxSnoozes {
+		return nil, fmt.Errorf("%w: template %q allows %d snoozes", ErrSnoozeLimitReached, template.Name, template.MaxSnoozes)
+	}
+	if template.MaxSnoozeMinutes > 0 && total > template.MaxSnoozeMinutes {
+		return nil, fmt.Errorf("%w: template %q allows a total delay of %d minutes", ErrSnoozeLimitReached, template.Name, template.MaxSnoozeMinutes)
+	}
+
+	followUp := &models.Reminder{
+		TaskID:         reminder.TaskID,
+		ChatAt:         until,
+		State:          models.ReminderStatePending,
+		ChatType:       reminder.ChatType,
+		ChatID:         reminder.ChatID,
+		PolicyID:       reminder.PolicyID,
+		RouteID:        reminder.RouteID,
+		SnoozedFromID:  &reminder.ID,
+		SnoozeCount:    count,
+		SnoozedMinutes: total,
+	}
+	reminder.State = models.ReminderStateSnoozed
+	reminder.SnoozedAt = timePtr(now)
+	reminder.SnoozedBy = req.UserID
+	reminder.SnoozedUntil = &until
+	reminder.NextActionAt = nil
+
+	// A reminder still queued was never posted, so its queued message is skipped instead
+	var messages []*models.OutboxMessage
+	if reminder.MessageID != "" && reminder.ChatType != "" {
+		who := "someone"
+		if user != nil {
+			who = user.Name
+		}
+		messages = append(messages, &models.OutboxMessage{
+			IdempotencyKey: fmt.Sprintf("reminder:%d:snoozed", reminder.ID),
+			Kind:           models.OutboxKindUpdate,
+			Platform:       reminder.ChatType,
+			Channel:        reminder.ChatID,
+			Thread:         reminder.MessageID,
+			Text: fmt.Sprintf("Task #%d (%s) was snoozed by %s until %s.",
+				task.ID, template.Name, who, until.In(loc).Format("Mon Jan 2 15:04 MST")),
+			TaskID:     &task.ID,
+			ReminderID: &reminder.ID,
+		})
+	}
+	if err := s.outbox.Enqueue(ctx, messages, followUp, reminder); err != nil {
+		return nil, fmt.Errorf("failed to snooze reminder: %w", err)
+	}
+
+	s.logger.Info("Reminder snoozed",
+		zap.Uint("reminder_id", reminder.ID),
+		zap.Uint("follow_up_id", followUp.ID),
+		zap.Time("until", until))
+
+	return followUp, nil
+}
+
+// snoozeUntil resolves a snooze request to a point in time; now is in the user's time zone
+func (s *Scheduler) snoozeUntil(req SnoozeRequest, now time.Time) (time.Time, error) {
+	if req.Until != nil {
+		if !req.Until.After(now) {
+			return time.Time{}, fmt.Errorf("%w: %s is not in the future", ErrInvalidSnooze, req.Until.Format(time.RFC3339))
+		}
+		return *req.Until, nil
+	}
+
+	switch req.Duration {
+	case SnoozeNextBusinessMorning:
+		return s.nextMorning(now, true), nil
+	case SnoozeTomorrow:
+		return s.nextMorning(now, false), nil
+	}
+
+	d, err := time.ParseDuration(req.Duration)
+	if err != nil || d <= 0 {
+		return time.Time{}, fmt.Errorf("%w: %q", ErrInvalidSnooze, req.Duration)
+	}
+	return now.Add(d), nil
+}
+
+// nextMorning returns the next morning at the configured hour. A business morning skips weekends
+// and may be later today; "tomorrow" is always the following day.
+func (s *Scheduler) nextMorning(now time.Time, businessDay bool) time.Time {
+	morning := func(day time.Time) time.Time {
+		return time.Date(day.Year(), day.Month(), day.Day(), s.config.BusinessMorningHour, 0, 0, 0, now.Location())
+	}
+
+	if !businessDay {
+		return morning(now.AddDate(0, 0, 1))
+	}
+
+	day := now
+	if !morning(day).After(now) {
+		day = day.AddDate(0, 0, 1)
+	}
+	for day.Weekday() == time.Saturday || day.Weekday() == time.Sunday {
+		day = day.AddDate(0, 0, 1)
+	}
+	return morning(day)
+}
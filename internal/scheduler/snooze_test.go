@@ -0,0 +1,88 @@
+package scheduler
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestSnoozeUntil(t *testing.T) {
+	s := &Scheduler{config: &Config{BusinessMorningHour: 9}}
+	// A Friday afternoon
+	now := time.Date(2024, time.March, 15, 14, 30, 0, 0, time.UTC)
+	until := now.Add(3 * time.Hour)
+	past := now.Add(-time.Minute)
+
+	tests := []struct {
+		name    string
+		req     SnoozeRequest
+		want    time.Time
+		wantErr error
+	}{
+		{name: "duration", req: SnoozeRequest{Duration: "30m"}, want: now.Add(30 * time.Minute)},
+		{name: "compound duration", req: SnoozeRequest{Duration: "1h30m"}, want: now.Add(90 * time.Minute)},
+		{name: "tomorrow", req: SnoozeRequest{Duration: SnoozeTomorrow}, want: time.Date(2024, time.March, 16, 9, 0, 0, 0, time.UTC)},
+		{name: "next business morning skips the weekend", req: SnoozeRequest{Duration: SnoozeNextBusinessMorning},
+			want: time.Date(2024, time.March, 18, 9, 0, 0, 0, time.UTC)},
+		{name: "explicit time wins over duration", req: SnoozeRequest{Duration: "30m", Until: &until}, want: until},
+		{name: "explicit time in the past", req: SnoozeRequest{Until: &past}, wantErr: ErrInvalidSnooze},
+		{name: "unparsable duration", req: SnoozeRequest{Duration: "soon"}, wantErr: ErrInvalidSnooze},
+		{name: "empty duration", req: SnoozeRequest{}, wantErr: ErrInvalidSnooze},
+		{name: "negative duration", req: SnoozeRequest{Duration: "-1h"}, wantErr: ErrInvalidSnooze},
+		{name: "zero duration", req: SnoozeRequest{Duration: "0s"}, wantErr: ErrInvalidSnooze},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := s.snoozeUntil(tt.req, now)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("snoozeUntil() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("snoozeUntil() error = %v", err)
+			}
+			if !got.Equal(tt.want) {
+				t.Errorf("snoozeUntil() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNextMorning(t *testing.T) {
+	s := &Scheduler{config: &Config{BusinessMorningHour: 9}}
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skipf("no time zone data: %v", err)
+	}
+
+	tests := []struct {
+		name        string
+		now         time.Time
+		businessDay bool
+		want        time.Time
+	}{
+		{"business morning later today", time.Date(2024, time.March, 13, 7, 0, 0, 0, time.UTC), true,
+			time.Date(2024, time.March, 13, 9, 0, 0, 0, time.UTC)},
+		{"business morning already passed", time.Date(2024, time.March, 13, 9, 0, 0, 0, time.UTC), true,
+			time.Date(2024, time.March, 14, 9, 0, 0, 0, time.UTC)},
+		{"saturday goes to monday", time.Date(2024, time.March, 16, 7, 0, 0, 0, time.UTC), true,
+			time.Date(2024, time.March, 18, 9, 0, 0, 0, time.UTC)},
+		{"tomorrow is always the next day", time.Date(2024, time.March, 13, 7, 0, 0, 0, time.UTC), false,
+			time.Date(2024, time.March, 14, 9, 0, 0, 0, time.UTC)},
+		{"tomorrow may be a weekend", time.Date(2024, time.March, 15, 18, 0, 0, 0, time.UTC), false,
+			time.Date(2024, time.March, 16, 9, 0, 0, 0, time.UTC)},
+		{"in the user's time zone", time.Date(2024, time.March, 13, 23, 0, 0, 0, berlin), true,
+			time.Date(2024, time.March, 14, 9, 0, 0, 0, berlin)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := s.nextMorning(tt.now, tt.businessDay); !got.Equal(tt.want) {
+				t.Errorf("nextMorning() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
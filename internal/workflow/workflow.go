@@ -0,0 +1,243 @@
+package workflow
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/BogdanDolia/ops-butler/internal/models"
+)
+
+// OutputPrefix marks a log line that sets a step output, e.g. "::output node=worker-1"
+const OutputPrefix = "::output "
+
+// Validate checks that a workflow forms a valid DAG
+func Validate(wf *models.Workflow) error {
+	if wf.Name == "" {
+		return fmt.Errorf("workflow name is required")
+	}
+	if len(wf.Steps) == 0 {
+		return fmt.Errorf("workflow must have at least one step")
+	}
+
+	steps := make(map[string]*models.WorkflowStep, len(wf.Steps))
+	for i := range wf.Steps {
+		step := &wf.Steps[i]
+		if step.Name == "" {
+			return fmt.Errorf("step %d: name is required", i)
+		}
+		if _, ok := steps[step.Name]; ok {
+			return fmt.Errorf("step %q: duplicate name", step.Name)
+		}
+		if step.TemplateID == 0 {
+			return fmt.Errorf("step %q: template_id is required", step.Name)
+		}
+		if _, err := parseCondition(step.Condition); err != nil {
+			return fmt.Errorf("step %q: %w", step.Name, err)
+		}
+		steps[step.Name] = step
+	}
+
+	for _, step := range steps {
+		for _, dep := range step.DependsOn {
+			target, ok := steps[dep]
+			if !ok {
+				return fmt.Errorf("step %q: unknown dependency %q", step.Name, dep)
+			}
+			if target.Compensation != step.Compensation {
+				return fmt.Errorf("step %q: compensation and regular steps cannot depend on each other", step.Name)
+			}
+		}
+	}
+
+	// Depth-first search for cycles
+	const (
+		unvisited = iota
+		visiting
+		done
+	)
+	marks := make(map[string]int, len(steps))
+	var visit func(name string) error
+	visit = func(name string) error {
+		switch marks[name] {
+		case visiting:
+			return fmt.Errorf("dependency cycle through step %q", name)
+		case done:
+			return nil
+		}
+		marks[name] = visiting
+		for _, dep := range steps[name].DependsOn {
+			if err := visit(dep); err != nil {
+				return err
+			}
+		}
+		marks[name] = done
+		return nil
+	}
+	for name := range steps {
+		if err := visit(name); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// NewRun creates a run of a workflow with all steps pending
+func NewRun(wf *models.Workflow, params models.JSONSchema, origin models.TaskOrigin, createdBy uint) *models.WorkflowRun {
+	run := &models.WorkflowRun{
+		WorkflowID: wf.ID,
+		State:      models.WorkflowRunStateRunning,
+		Params:     params,
+		Origin:     origin,
+		CreatedBy:  createdBy,
+		StartedAt:  time.Now(),
+	}
+
+	for _, step := range wf.Steps {
+		run.Steps = append(run.Steps, models.WorkflowStepRun{
+			StepID:   step.ID,
+			StepName: step.Name,
+			State:    models.StepRunStatePending,
+		})
+	}
+
+	return run
+}
+
+// condition is a parsed StepCondition
+type condition struct {
+	kind     models.StepCondition
+	exitCode int
+	negate   bool
+}
+
+// parseCondition parses a step condition; an empty condition means success
+func parseCondition(value models.StepCondition) (condition, error) {
+	switch value {
+	case "", models.StepConditionSuccess:
+		return condition{kind: models.StepConditionSuccess}, nil
+	case models.StepConditionFailure, models.StepConditionAlways:
+		return condition{kind: value}, nil
+	}
+
+	expr := strings.ReplaceAll(string(value), " ", "")
+	for _, op := range []string{"==", "!="} {
+		if !strings.HasPrefix(expr, "exit_code"+op) {
+			continue
+		}
+		code, err := strconv.Atoi(strings.TrimPrefix(expr, "exit_code"+op))
+		if err != nil {
+			break
+		}
+		return condition{kind: "exit_code", exitCode: code, negate: op == "!="}, nil
+	}
+
+	return condition{}, fmt.Errorf("invalid condition %q", value)
+}
+
+// met reports whether the condition holds for a set of finished dependencies
+func (c condition) met(deps []*models.WorkflowStepRun) bool {
+	switch c.kind {
+	case models.StepConditionAlways:
+		return true
+	case models.StepConditionFailure:
+		for _, dep := range deps {
+			if dep.State == models.StepRunStateFailed {
+				return true
+			}
+		}
+		return false
+	case models.StepConditionSuccess:
+		for _, dep := range deps {
+			if dep.State != models.StepRunStateCompleted {
+				return false
+			}
+		}
+		return true
+	default:
+		for _, dep := range deps {
+			if dep.State == models.StepRunStateSkipped || dep.ExitCode == nil {
+				return false
+			}
+			if (*dep.ExitCode == c.exitCode) == c.negate {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// renderParams renders step param templates against run params and finished steps
+func renderParams(step *models.WorkflowStep, run *models.WorkflowRun) (models.JSONSchema, error) {
+	stepsData := make(map[string]interface{}, len(run.Steps))
+	for _, sr := range run.Steps {
+		exitCode := 0
+		if sr.ExitCode != nil {
+			exitCode = *sr.ExitCode
+		}
+		outputs := map[string]interface{}(sr.Outputs)
+		if outputs == nil {
+			outputs = map[string]interface{}{}
+		}
+		stepsData[sr.StepName] = map[string]interface{}{
+			"state":     string(sr.State),
+			"exit_code": exitCode,
+			"outputs":   outputs,
+		}
+	}
+	data := map[string]interface{}{
+		"params": map[string]interface{}(run.Params),
+		"steps":  stepsData,
+	}
+
+	params := make(models.JSONSchema, len(step.Params))
+	for key, value := range step.Params {
+		text, ok := value.(string)
+		if !ok || !strings.Contains(text, "{{") {
+			params[key] = value
+			continue
+		}
+
+		tmpl, err := template.New(key).Option("missingkey=error").Parse(text)
+		if err != nil {
+			return nil, fmt.Errorf("param %q: %w", key, err)
+		}
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, data); err != nil {
+			return nil, fmt.Errorf("param %q: %w", key, err)
+		}
+		params[key] = buf.String()
+	}
+
+	return params, nil
+}
+
+// ParseOutputs extracts step outputs from execution log chunks
+func ParseOutputs(logs []*models.ExecutionLog) models.JSONSchema {
+	outputs := make(models.JSONSchema)
+
+	var buf bytes.Buffer
+	for _, log := range logs {
+		if log.Stream == "stdout" || log.Stream == "" {
+			buf.WriteString(log.Chunk)
+		}
+	}
+
+	// Lines are split without a length limit, so an output after a long line isn't lost
+	for _, line := range strings.Split(buf.String(), "\n") {
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, OutputPrefix) {
+			continue
+		}
+		kv := strings.SplitN(strings.TrimPrefix(line, OutputPrefix), "=", 2)
+		if len(kv) == 2 && kv[0] != "" {
+			outputs[strings.TrimSpace(kv[0])] = kv[1]
+		}
+	}
+
+	return outputs
+}
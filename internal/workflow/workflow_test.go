@@ -0,0 +1,70 @@
+package workflow
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/BogdanDolia/ops-butler/internal/models"
+)
+
+func TestParseOutputs(t *testing.T) {
+	tests := []struct {
+		name string
+		logs []*models.ExecutionLog
+		want models.JSONSchema
+	}{
+		{
+			name: "outputs among other lines",
+			logs: []*models.ExecutionLog{
+				{Stream: "stdout", Chunk: "draining\n::output node=worker-1\n"},
+				{Stream: "stdout", Chunk: "  ::output pods = 12\ndone\n"},
+			},
+			want: models.JSONSchema{"node": "worker-1", "pods": " 12"},
+		},
+		{
+			name: "line split across chunks",
+			logs: []*models.ExecutionLog{
+				{Stream: "stdout", Chunk: "::output ver"},
+				{Stream: "stdout", Chunk: "sion=1.2.3\n"},
+			},
+			want: models.JSONSchema{"version": "1.2.3"},
+		},
+		{
+			name: "stderr is ignored",
+			logs: []*models.ExecutionLog{{Stream: "stderr", Chunk: "::output node=worker-1\n"}},
+			want: models.JSONSchema{},
+		},
+		{
+			name: "value may contain equals signs",
+			logs: []*models.ExecutionLog{{Stream: "stdout", Chunk: "::output query=a=b\n"}},
+			want: models.JSONSchema{"query": "a=b"},
+		},
+		{
+			name: "later output wins",
+			logs: []*models.ExecutionLog{{Stream: "stdout", Chunk: "::output n=1\n::output n=2\n"}},
+			want: models.JSONSchema{"n": "2"},
+		},
+		{
+			name: "output after a line longer than 64 KiB",
+			logs: []*models.ExecutionLog{
+				{Stream: "stdout", Chunk: strings.Repeat("x", 256*1024) + "\n"},
+				{Stream: "stdout", Chunk: "::output node=worker-2\n"},
+			},
+			want: models.JSONSchema{"node": "worker-2"},
+		},
+		{
+			name: "empty key is ignored",
+			logs: []*models.ExecutionLog{{Stream: "stdout", Chunk: "::output =value\n"}},
+			want: models.JSONSchema{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ParseOutputs(tt.logs); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseOutputs() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}